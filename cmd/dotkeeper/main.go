@@ -7,15 +7,24 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/diogo/dotkeeper/internal/cli"
+	"github.com/diogo/dotkeeper/internal/config"
 	"github.com/diogo/dotkeeper/internal/tui"
 )
 
 const version = "0.1.0"
 
 func main() {
+	// --profile applies to every command, so it may appear anywhere
+	profile, arguments, err := cli.ExtractProfileFlag(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	config.SelectProfile(profile)
+
 	helpFlag := flag.Bool("help", false, "Show help message")
 	versionFlag := flag.Bool("version", false, "Show version")
-	flag.Parse()
+	_ = flag.CommandLine.Parse(arguments)
 
 	if *helpFlag {
 		printHelp()
@@ -49,14 +58,28 @@ func main() {
 		exitCode = cli.RestoreCommand(args)
 	case "list":
 		exitCode = cli.ListCommand(args)
+	case "ls":
+		exitCode = cli.LsCommand(args)
 	case "delete":
 		exitCode = cli.DeleteCommand(args)
+	case "tag":
+		exitCode = cli.TagCommand(args)
+	case "untag":
+		exitCode = cli.UntagCommand(args)
+	case "pin":
+		exitCode = cli.PinCommand(args)
+	case "unpin":
+		exitCode = cli.UnpinCommand(args)
+	case "key":
+		exitCode = cli.KeyCommand(args)
 	case "config":
 		exitCode = cli.ConfigCommand(args)
 	case "history":
 		exitCode = cli.HistoryCommand(args)
 	case "schedule":
 		exitCode = cli.ScheduleCommand(args)
+	case "scan":
+		exitCode = cli.ScanCommand(args)
 	case "help":
 		printHelp()
 		exitCode = 0
@@ -79,20 +102,30 @@ Commands:
   backup      Create a backup of dotfiles
   restore     Restore dotfiles from backup
   list        List available backups
+  ls          List the files in a backup
   delete      Delete a backup
+  tag         Tag a backup or set its note (untag removes tags)
+  pin         Protect a backup from deletion (unpin releases it)
+  key         Manage the passwords, keyfiles and recovery keys of the backups
   config      Manage configuration
   history     Show operation history
   schedule    Manage automated backup scheduling
+  scan        Check dotfiles for secrets
   help        Show this help message
 
 Options:
-  --help      Show this help message
-  --version   Show version information
+  --profile NAME  Use the named profile from the config (any command)
+  --help          Show this help message
+  --version       Show version information
 
 Examples:
   dotkeeper backup
   dotkeeper restore --backup-id <id>
   dotkeeper list
+  dotkeeper backup --tag before-hyprland --note "last X11 setup"
+  dotkeeper list --tag before-hyprland
+  dotkeeper --profile work backup
+  dotkeeper key add recovery
   dotkeeper schedule enable`
 	fmt.Println(help)
 }
@@ -2,6 +2,7 @@ package e2e
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"os"
@@ -213,7 +214,7 @@ func TestEncryptedBackupIntegrity(t *testing.T) {
 	}
 
 	// Backup
-	result, err := backup.Backup(cfg, testPassword)
+	result, err := backup.Backup(context.Background(), cfg, testPassword)
 	if err != nil {
 		t.Fatalf("backup failed: %v", err)
 	}
@@ -236,7 +237,7 @@ func TestEncryptedBackupIntegrity(t *testing.T) {
 		Force:     true,
 	}
 
-	_, err = restore.Restore(result.BackupPath, testPassword, opts)
+	_, err = restore.Restore(context.Background(), result.BackupPath, testPassword, opts)
 	if err != nil {
 		t.Fatalf("restore failed: %v", err)
 	}
@@ -337,7 +338,7 @@ func TestBackupValidationWithWrongPassword(t *testing.T) {
 	}
 
 	// Create backup with correct password
-	result, err := backup.Backup(cfg, "correct-password")
+	result, err := backup.Backup(context.Background(), cfg, "correct-password")
 	if err != nil {
 		t.Fatalf("backup failed: %v", err)
 	}
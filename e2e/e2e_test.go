@@ -11,6 +11,7 @@
 package e2e
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -68,7 +69,7 @@ func TestBackupRestoreCycle(t *testing.T) {
 
 	// Step 1: Run backup
 	t.Log("Step 1: Running backup...")
-	result, err := backup.Backup(cfg, testPassword)
+	result, err := backup.Backup(context.Background(), cfg, testPassword)
 	if err != nil {
 		t.Fatalf("backup failed: %v", err)
 	}
@@ -80,8 +81,8 @@ func TestBackupRestoreCycle(t *testing.T) {
 	if _, err := os.Stat(result.BackupPath); os.IsNotExist(err) {
 		t.Errorf("backup file not created: %s", result.BackupPath)
 	}
-	if _, err := os.Stat(result.MetadataPath); os.IsNotExist(err) {
-		t.Errorf("metadata file not created: %s", result.MetadataPath)
+	if !backup.IsContainer(result.BackupPath) {
+		t.Errorf("backup is not a single-file backup: %s", result.BackupPath)
 	}
 
 	t.Logf("Backup created: %s (%d files, %d bytes)",
@@ -109,7 +110,7 @@ func TestBackupRestoreCycle(t *testing.T) {
 		Force:     true,
 	}
 
-	restoreResult, err := restore.Restore(result.BackupPath, testPassword, opts)
+	restoreResult, err := restore.Restore(context.Background(), result.BackupPath, testPassword, opts)
 	if err != nil {
 		t.Fatalf("restore failed: %v", err)
 	}
@@ -176,7 +177,7 @@ func TestBackupRestoreWithSubdirectories(t *testing.T) {
 	}
 
 	// Backup
-	result, err := backup.Backup(cfg, testPassword)
+	result, err := backup.Backup(context.Background(), cfg, testPassword)
 	if err != nil {
 		t.Fatalf("backup failed: %v", err)
 	}
@@ -191,7 +192,7 @@ func TestBackupRestoreWithSubdirectories(t *testing.T) {
 		Force:     true,
 	}
 
-	_, err = restore.Restore(result.BackupPath, testPassword, opts)
+	_, err = restore.Restore(context.Background(), result.BackupPath, testPassword, opts)
 	if err != nil {
 		t.Fatalf("restore failed: %v", err)
 	}
@@ -245,7 +246,7 @@ func TestBackupValidation(t *testing.T) {
 	}
 
 	// Create backup
-	result, err := backup.Backup(cfg, testPassword)
+	result, err := backup.Backup(context.Background(), cfg, testPassword)
 	if err != nil {
 		t.Fatalf("backup failed: %v", err)
 	}
@@ -293,7 +294,7 @@ func TestDryRun(t *testing.T) {
 	}
 
 	// Create backup
-	result, err := backup.Backup(cfg, testPassword)
+	result, err := backup.Backup(context.Background(), cfg, testPassword)
 	if err != nil {
 		t.Fatalf("backup failed: %v", err)
 	}
@@ -304,7 +305,7 @@ func TestDryRun(t *testing.T) {
 		DryRun:    true,
 	}
 
-	restoreResult, err := restore.Restore(result.BackupPath, testPassword, opts)
+	restoreResult, err := restore.Restore(context.Background(), result.BackupPath, testPassword, opts)
 	if err != nil {
 		t.Fatalf("dry-run restore failed: %v", err)
 	}
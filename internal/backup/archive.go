@@ -2,61 +2,271 @@ package backup
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"time"
+
+	"github.com/diogo/dotkeeper/internal/compression"
+	"github.com/diogo/dotkeeper/internal/progress"
 )
 
+// readAttempts is how often a file that changes while it is read is read
+// before what was read last is stored
+const readAttempts = 3
+
+// maxSpoolSize is the most of a file kept in memory while it is copied
+// before being stored, so that a read overlapping a change can be retried.
+// Larger files are copied to a temporary file instead. It also bounds how
+// much a file may grow while it is read; growth beyond it is left for the
+// next backup.
+const maxSpoolSize = 8 << 20
+
+// stored describes the content written for one file, which may differ
+// from what the collector saw if the file changed in between
+type stored struct {
+	sha256  string
+	size    int64
+	modTime int64
+	// changed is set when the file kept changing while it was read, so
+	// the copy may mix old and new content
+	changed bool
+	// snapshot is set for the files of a SQLite database stored as a
+	// consistent snapshot
+	snapshot bool
+	// gone is set for a database log removed before the database was
+	// read; nothing was stored for it
+	gone bool
+}
+
 func CreateArchive(files []FileInfo, writer io.Writer) error {
-	gzw := gzip.NewWriter(writer)
-	defer gzw.Close()
+	_, err := writeArchive(context.Background(), files, writer, compression.Default, progress.NewTracker("", nil))
+	return err
+}
 
-	tw := tar.NewWriter(gzw)
+// writeArchive writes files to a compressed tar stream and returns what was
+// stored for every regular file, keyed by path. Each file is reported to t
+// as it is read; reads stop once ctx is done.
+func writeArchive(ctx context.Context, files []FileInfo, writer io.Writer, setting compression.Setting, t *progress.Tracker) (map[string]stored, error) {
+	cw, err := compression.NewWriter(writer, setting)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compressor: %w", err)
+	}
+	defer cw.Close()
+
+	tw := tar.NewWriter(cw)
 	defer tw.Close()
 
+	written := make(map[string]stored, len(files))
+	snapshots := newSQLiteSnapshots(files)
+	defer snapshots.close()
 	for _, fileInfo := range files {
-		if err := addFileToArchive(tw, fileInfo); err != nil {
-			return fmt.Errorf("failed to add %s to archive: %w", fileInfo.Path, err)
+		if fileInfo.Dir {
+			if err := addDirToArchive(tw, fileInfo); err != nil {
+				return nil, fmt.Errorf("failed to add %s to archive: %w", fileInfo.Path, err)
+			}
+			continue
+		}
+		copied, state, err := snapshots.take(ctx, fileInfo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to archive: %w", fileInfo.Path, err)
+		}
+		if state == sqliteGone {
+			written[fileInfo.Path] = stored{gone: true}
+			continue
+		}
+
+		t.Start(fileInfo.Path, fileInfo.Size)
+		var content stored
+		if copied != nil {
+			content, err = writeCopy(ctx, tw, fileInfo, copied, t)
+			content.snapshot = state == sqliteSnapshot
+			content.changed = state == sqliteInconsistent
+		} else {
+			content, err = addFileToArchive(ctx, tw, fileInfo, t)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to archive: %w", fileInfo.Path, err)
+		}
+		t.Done()
+		if content.sha256 != "" {
+			written[fileInfo.Path] = content
 		}
 	}
 
-	return nil
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := cw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return written, nil
+}
+
+// addDirToArchive writes a directory entry carrying the directory's mode,
+// mtime, ownership and extended attributes
+func addDirToArchive(tw *tar.Writer, fileInfo FileInfo) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     fileInfo.ArchiveName(),
+		Mode:     int64(fileInfo.Mode),
+		ModTime:  time.Unix(fileInfo.ModTime, 0),
+	}
+	fileInfo.Meta.SetHeader(header)
+	return tw.WriteHeader(header)
 }
 
-func addFileToArchive(tw *tar.Writer, fileInfo FileInfo) error {
+// addFileToArchive writes one entry and returns what was stored (nothing
+// for symlinks). Ownership and extended attributes are stored as PAX
+// records.
+//
+// The file is copied before it is stored, so the entry is sized by the
+// copy, not the collection: a file that grew or shrank since is stored
+// whole. A file that changes while it is copied is copied again, up to
+// readAttempts times, and stored as copied last with changed set.
+func addFileToArchive(ctx context.Context, tw *tar.Writer, fileInfo FileInfo, t *progress.Tracker) (stored, error) {
 	if fileInfo.LinkTarget != "" {
 		header := &tar.Header{
 			Typeflag: tar.TypeSymlink,
-			Name:     fileInfo.Path,
+			Name:     fileInfo.ArchiveName(),
 			Linkname: fileInfo.LinkTarget,
 			Mode:     int64(fileInfo.Mode),
 			ModTime:  time.Unix(fileInfo.ModTime, 0),
 		}
-		return tw.WriteHeader(header)
+		fileInfo.Meta.SetHeader(header)
+		return stored{}, tw.WriteHeader(header)
 	}
 
 	file, err := os.Open(fileInfo.Path)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return stored{}, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return stored{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	var copied spool
+	defer copied.close()
+	info, changed, err := readStable(ctx, file, info, &copied)
+	if err != nil {
+		return stored{}, err
+	}
+	body, err := copied.reader()
+	if err != nil {
+		return stored{}, err
+	}
+
+	content, err := writeFileEntry(ctx, tw, fileInfo, info, body, t)
+	if err != nil {
+		return stored{}, err
+	}
+	content.changed = changed
+	return content, nil
+}
+
+// writeCopy writes the entry of a file copied with its SQLite database and
+// releases the copy
+func writeCopy(ctx context.Context, tw *tar.Writer, fileInfo FileInfo, copied *sqliteFile, t *progress.Tracker) (stored, error) {
+	defer copied.close()
+	body, err := copied.content.reader()
+	if err != nil {
+		return stored{}, err
+	}
+	return writeFileEntry(ctx, tw, fileInfo, copied.info, body, t)
+}
+
+// writeFileEntry writes a regular file entry sized and dated by info, with
+// its content read from body, which must hold exactly info.Size() bytes.
+func writeFileEntry(ctx context.Context, tw *tar.Writer, fileInfo FileInfo, info fs.FileInfo, body io.Reader, t *progress.Tracker) (stored, error) {
 	header := &tar.Header{
-		Name:    fileInfo.Path,
-		Size:    fileInfo.Size,
+		Name:    fileInfo.ArchiveName(),
+		Size:    info.Size(),
 		Mode:    int64(fileInfo.Mode),
-		ModTime: time.Unix(fileInfo.ModTime, 0),
+		ModTime: time.Unix(info.ModTime().Unix(), 0),
 	}
+	fileInfo.Meta.SetHeader(header)
 
 	if err := tw.WriteHeader(header); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+		return stored{}, fmt.Errorf("failed to write header: %w", err)
 	}
 
-	if _, err := io.Copy(tw, file); err != nil {
-		return fmt.Errorf("failed to copy file content: %w", err)
+	hasher := sha256.New()
+	w := io.MultiWriter(tw, hasher)
+	_, err := io.CopyN(w, t.Reader(ctx, body), header.Size)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return stored{}, fmt.Errorf("failed to copy file content: %w", err)
 	}
 
-	return nil
+	return stored{
+		sha256:  hex.EncodeToString(hasher.Sum(nil)),
+		size:    header.Size,
+		modTime: header.ModTime.Unix(),
+	}, nil
 }
+
+// readStable copies file into copied until a copy matches the stat taken
+// before it and the one taken after it. It returns a stat matching the
+// length of the copy made last, and whether the file never held still.
+func readStable(ctx context.Context, file *os.File, before fs.FileInfo, copied *spool) (fs.FileInfo, bool, error) {
+	for attempt := 1; ; attempt++ {
+		if err := copied.reset(); err != nil {
+			return nil, false, err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, false, fmt.Errorf("failed to read file: %w", err)
+		}
+		limit := before.Size() + maxSpoolSize
+		if _, err := io.Copy(copied, contextReader{ctx, io.LimitReader(file, limit)}); err != nil {
+			return nil, false, fmt.Errorf("failed to read file: %w", err)
+		}
+		after, err := file.Stat()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to stat file: %w", err)
+		}
+
+		if copied.Len() == before.Size() && sameContent(before, after) {
+			return after, false, nil
+		}
+		if attempt == readAttempts {
+			return sizedInfo{after, copied.Len()}, true, nil
+		}
+		before = after
+	}
+}
+
+// contextReader stops reading once ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// sameContent reports whether two stats of a file describe the same content
+func sameContent(a, b fs.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// sizedInfo overrides the size of a stat to match the content read
+type sizedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i sizedInfo) Size() int64 { return i.size }
@@ -4,11 +4,15 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/diogo/dotkeeper/internal/compression"
+	"github.com/diogo/dotkeeper/internal/progress"
 )
 
 func TestCreateArchive_Basic(t *testing.T) {
@@ -226,3 +230,213 @@ func verifyArchive(t *testing.T, buf *bytes.Buffer, expectedFiles []FileInfo) {
 		t.Errorf("Expected %d files, got %d", len(expectedFiles), fileCount)
 	}
 }
+
+func TestCreateArchive_LogicalNames(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	paths := []string{
+		filepath.Join(home, ".zshrc"),
+		filepath.Join(home, ".config", "git", "config"),
+	}
+	for _, p := range paths {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := CollectFiles(paths, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := CreateArchive(files, &buf); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+
+	gzr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gzr.Close()
+
+	var names []string
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+
+	want := []string{"$HOME/.zshrc", "$XDG_CONFIG_HOME/git/config"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("archive names = %v, want %v", names, want)
+	}
+}
+
+func TestCreateArchive_FileChangedSinceCollection(t *testing.T) {
+	tmpDir := t.TempDir()
+	grown := filepath.Join(tmpDir, "history")
+	shrunk := filepath.Join(tmpDir, "state")
+	if err := os.WriteFile(grown, []byte("ls\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(shrunk, []byte("a long line of state"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := CollectFiles([]string{grown, shrunk}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both change between the walk and the archive being written
+	if err := os.WriteFile(grown, []byte("ls\ncd /tmp\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(shrunk, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := CreateArchive(files, &buf); err != nil {
+		t.Fatalf("CreateArchive failed: %v", err)
+	}
+
+	got := readArchive(t, &buf)
+	if got[grown] != "ls\ncd /tmp\n" || got[shrunk] != "short" {
+		t.Errorf("archive holds %q, want the current content", got)
+	}
+}
+
+func TestWriteArchive_FileAppendedDuringBackup(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "busy.log")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 1<<16), 0600); err != nil {
+		t.Fatal(err)
+	}
+	files, err := CollectFiles([]string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep appending while the archive is written
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				f.Write([]byte("more\n"))
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	written, err := writeArchive(context.Background(), files, &buf, compression.Setting{Algorithm: compression.Gzip}, progress.NewTracker("", nil))
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatalf("writeArchive failed: %v", err)
+	}
+
+	content := readArchive(t, &buf)[path]
+	if int64(len(content)) != written[path].size || len(content) < 1<<16 {
+		t.Errorf("stored %d bytes, recorded %d", len(content), written[path].size)
+	}
+}
+
+func TestWriteArchive_LargeFileRewrittenDuringBackup(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "places.json")
+	long := bytes.Repeat([]byte("a"), maxSpoolSize+1<<20)
+	short := bytes.Repeat([]byte("b"), 4096)
+	if err := os.WriteFile(path, long, 0600); err != nil {
+		t.Fatal(err)
+	}
+	files, err := CollectFiles([]string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep replacing the file with shorter and longer content
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			content := long
+			if i%2 == 0 {
+				content = short
+			}
+			os.WriteFile(path, content, 0600)
+		}
+	}()
+
+	var buf bytes.Buffer
+	written, err := writeArchive(context.Background(), files, &buf, compression.Setting{Algorithm: compression.Gzip}, progress.NewTracker("", nil))
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatalf("writeArchive failed: %v", err)
+	}
+
+	content := readArchive(t, &buf)[path]
+	if int64(len(content)) != written[path].size {
+		t.Errorf("stored %d bytes, recorded %d", len(content), written[path].size)
+	}
+	if bytes.IndexByte([]byte(content), 0) >= 0 {
+		t.Error("stored content is padded with zeros")
+	}
+}
+
+// readArchive returns the content of every regular file in a gzip
+// compressed archive, keyed by name
+func readArchive(t *testing.T, buf *bytes.Buffer) map[string]string {
+	t.Helper()
+	gzr, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gzr.Close()
+
+	entries := make(map[string]string)
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar header: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = string(content)
+	}
+	return entries
+}
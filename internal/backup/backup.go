@@ -1,132 +1,413 @@
 package backup
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"time"
 
+	"github.com/diogo/dotkeeper/internal/compression"
 	"github.com/diogo/dotkeeper/internal/config"
 	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/hooks"
+	"github.com/diogo/dotkeeper/internal/progress"
+	"github.com/diogo/dotkeeper/internal/secrets"
 )
 
 // BackupResult contains information about a completed backup
 type BackupResult struct {
-	BackupPath   string
-	MetadataPath string
-	BackupName   string
-	FileCount    int
-	TotalSize    int64
-	Duration     time.Duration
-	Checksum     string
+	BackupPath string
+	BackupName string
+	FileCount  int
+	TotalSize  int64
+	Duration   time.Duration
+	Checksum   string
+	// AddedSize is the amount of new data written to the repository
+	// (repository mode only; unchanged chunks are not stored again)
+	AddedSize int64
+	// Incremental results only store ChangedFiles; FileCount and TotalSize
+	// still describe the full tree
+	Incremental  bool
+	Parent       string
+	ChangedFiles int
+	DeletedFiles int
+	// Hooks holds the results of the pre and post backup hooks
+	Hooks []hooks.Result
+	// CaptureErrors lists captures left out because their command failed
+	CaptureErrors []string
+	// Secrets lists what the secret scanner found in the collected files
+	Secrets []secrets.Finding
+	// ExcludedSecrets lists the files left out because of their findings
+	ExcludedSecrets []string
+	// UnconfirmedSecrets lists the files backed up although their findings
+	// needed confirmation, because nobody could be asked
+	UnconfirmedSecrets []string
+	// ChangedDuringBackup lists the files that kept changing while they
+	// were read; their stored copy may mix old and new content
+	ChangedDuringBackup []string
+	// Snapshots lists the SQLite databases stored as consistent snapshots
+	// together with their logs
+	Snapshots []string
+	// Unchanged is set when nothing changed since the newest backup, so
+	// none was written; BackupName and BackupPath name that backup
+	Unchanged bool
 }
 
-// Backup performs a full backup: collect files → create archive → encrypt → save
-func Backup(cfg *config.Config, password string) (*BackupResult, error) {
-	start := time.Now()
+// BackupOptions configures a backup run
+type BackupOptions struct {
+	// Incremental stores only entries that changed since the newest backup
+	// and records that backup as the parent
+	Incremental bool
 
-	// Generate backup name with timestamp
-	backupName := fmt.Sprintf("backup-%s.tar.gz.enc", time.Now().Format("2006-01-02-150405"))
-	backupPath := filepath.Join(cfg.BackupDir, backupName)
-	metadataPath := backupPath + ".meta.json"
+	// ConfirmSecret decides whether a file whose secret findings need
+	// confirmation is backed up; when nil the unattended action of the
+	// secrets config decides
+	ConfirmSecret func(path string, findings []secrets.Finding) bool
+
+	// Force writes a backup even when nothing changed since the newest one
+	Force bool
+
+	// Progress receives progress events as the backup runs
+	Progress progress.Func
+
+	// Tags and Note label the new backup. When nothing changed they are
+	// added to the newest backup instead, which holds the same files.
+	Tags []string
+	Note string
+}
+
+// Backup performs a full backup: collect files → create archive → encrypt → save.
+// The archive is streamed through compression and encryption in a single pass.
+// In repository mode the files are stored as a deduplicated snapshot instead.
+func Backup(ctx context.Context, cfg *config.Config, password string) (*BackupResult, error) {
+	return BackupWithOptions(ctx, cfg, password, BackupOptions{})
+}
+
+// BackupWithOptions performs a backup configured by opts. The pre_backup
+// hooks run first and abort the backup if one fails; the post_backup hooks
+// run once the backup has been written, or once it was found unchanged.
+//
+// Unless opts.Force is set, the collected files are compared with the
+// newest backup by path, size, mtime and, when the mtime moved, content
+// hash. When nothing changed no backup is written and the result has
+// Unchanged set.
+//
+// Cancelling ctx stops the backup between files or in the middle of one;
+// the partly written archive is removed and nothing is left in the backup
+// directory but chunks a later snapshot may reuse.
+func BackupWithOptions(ctx context.Context, cfg *config.Config, password string, opts BackupOptions) (*BackupResult, error) {
+	for _, tag := range opts.Tags {
+		if err := ValidateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	env := map[string]string{
+		"DOTKEEPER_OPERATION":  "backup",
+		"DOTKEEPER_BACKUP_DIR": cfg.BackupDir,
+		"DOTKEEPER_PROFILE":    cfg.Profile(),
+	}
+
+	pre, err := hooks.Run(hooks.PreBackup, cfg.Hooks.PreBackup, env)
+	if err != nil {
+		return nil, fmt.Errorf("backup aborted: %w", err)
+	}
+
+	t := progress.NewTracker("backup", opts.Progress)
+	result, err := runBackup(ctx, cfg, password, opts, t)
+	if err != nil {
+		return nil, err
+	}
+
+	env["DOTKEEPER_BACKUP_PATH"] = result.BackupPath
+	env["DOTKEEPER_BACKUP_NAME"] = result.BackupName
+	env["DOTKEEPER_FILE_COUNT"] = strconv.Itoa(result.FileCount)
+	env["DOTKEEPER_BACKUP_STATUS"] = "created"
+	if result.Unchanged {
+		env["DOTKEEPER_BACKUP_STATUS"] = "unchanged"
+	}
+	// The backup is already written, so a failing post hook is only
+	// recorded in the results
+	post, _ := hooks.Run(hooks.PostBackup, cfg.Hooks.PostBackup, env)
+	result.Hooks = append(pre, post...)
+	t.Finish()
+	return result, nil
+}
+
+// runBackup collects, archives and stores the files
+func runBackup(ctx context.Context, cfg *config.Config, password string, opts BackupOptions, t *progress.Tracker) (*BackupResult, error) {
+	start := time.Now()
 
 	// Ensure backup directory exists
 	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create backup directory: %w", err)
 	}
 
+	// The newest backup is read ahead of the walk, so that the collector's
+	// workers hash the files the comparison with it decides by content
+	t.Phase(progress.Collecting, 0, 0)
+	var latest *Info
+	var previous []ManifestEntry
+	if !opts.Force {
+		var err error
+		latest, previous, err = latestTree(cfg.BackupDir, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Collect all files using active (non-disabled) paths with exclusion patterns
 	allPaths := append(cfg.ActiveFiles(), cfg.ActiveFolders()...)
-	files, err := CollectFiles(allPaths, cfg.Exclude)
+	files := collect(allPaths, cfg.Exclude, cfg.Symlinks, hashCandidates(previous)).Files
+	if err := cancelled(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	// Command outputs are stored alongside the files; the collection is
+	// shared with Scan, so they go into a new slice
+	captured, captureErrors, cleanup, err := collectCaptures(cfg.Captures, start)
 	if err != nil {
-		return nil, fmt.Errorf("failed to collect files: %w", err)
+		return nil, err
+	}
+	defer cleanup()
+	files = slices.Concat(files, captured)
+
+	// Keep secrets out of the files and captures according to the scanner
+	// rules
+	t.Phase(progress.Scanning, countFiles(files), 0)
+	findings := FindSecrets(files, secrets.New(cfg.Secrets))
+	files, excludedSecrets, unconfirmedSecrets, err := screenSecrets(files, findings, opts.ConfirmSecret, cfg.Secrets.UnattendedAction())
+	if err := cancelled(ctx, err); err != nil {
+		return nil, err
 	}
 
-	if len(files) == 0 {
+	// SQLite databases are stored with their logs, read at the same instant
+	files = markSQLite(files, cfg.SQLite)
+
+	if countFiles(files) == 0 {
 		return nil, fmt.Errorf("no files to backup")
 	}
 
-	// Create temp file for archive
-	tempFile, err := os.CreateTemp("", "dotkeeper-backup-*.tar.gz")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	// Skip the backup when the newest one already holds this tree
+	var diff *incrementalPlan
+	if !opts.Force {
+		t.Phase(progress.Comparing, countFiles(files), 0)
+		if latest != nil {
+			diff, err = diffTree(latest.Name, previous, files)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := cancelled(ctx, nil); err != nil {
+			return nil, err
+		}
+		if diff != nil && diff.empty() {
+			if len(opts.Tags) > 0 || opts.Note != "" {
+				if _, err := Tag(*latest, password, opts.Tags, opts.Note); err != nil {
+					return nil, fmt.Errorf("failed to label %s: %w", latest.Name, err)
+				}
+			}
+			return &BackupResult{
+				BackupPath:         latest.Path,
+				BackupName:         latest.Name,
+				FileCount:          countFiles(files),
+				TotalSize:          totalSize(files),
+				Duration:           time.Since(start),
+				CaptureErrors:      captureErrors,
+				Secrets:            findings,
+				ExcludedSecrets:    excludedSecrets,
+				UnconfirmedSecrets: unconfirmedSecrets,
+				Unchanged:          true,
+			}, nil
+		}
+	}
+
+	if cfg.Repository {
+		if opts.Incremental {
+			return nil, fmt.Errorf("incremental backups are not available in repository mode (snapshots are already deduplicated)")
+		}
+		t.Phase(progress.Writing, countFiles(files), totalSize(files))
+		result, err := backupToRepository(ctx, cfg, password, files, opts, start, t)
+		if err != nil {
+			return nil, cancelled(ctx, err)
+		}
+		result.CaptureErrors = captureErrors
+		result.Secrets = findings
+		result.ExcludedSecrets = excludedSecrets
+		result.UnconfirmedSecrets = unconfirmedSecrets
+		return result, nil
 	}
-	defer os.Remove(tempFile.Name())
 
-	// Create archive
-	if err := CreateArchive(files, tempFile); err != nil {
-		tempFile.Close()
-		return nil, fmt.Errorf("failed to create archive: %w", err)
+	// Work out what changed since the parent; without a usable parent this
+	// falls back to a full backup
+	var plan *incrementalPlan
+	toStore := files
+	if opts.Incremental {
+		// The comparison with the newest backup doubles as the plan
+		// when that backup is an archive
+		if diff != nil && !latest.Snapshot {
+			plan = diff
+		} else {
+			var err error
+			plan, err = planIncremental(cfg.BackupDir, password, files)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if plan != nil {
+			toStore = plan.changed
+		}
 	}
-	tempFile.Close()
 
-	// Read archive for encryption
-	archiveData, err := os.ReadFile(tempFile.Name())
+	setting, err := compression.Parse(cfg.Compression)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read archive: %w", err)
+		return nil, fmt.Errorf("invalid compression setting: %w", err)
 	}
 
-	// Calculate checksum of plaintext
-	checksum := sha256.Sum256(archiveData)
-	checksumHex := hex.EncodeToString(checksum[:])
+	// Generate backup name with timestamp
+	backupName := fmt.Sprintf("backup-%s%s", start.Format(nameTimeLayout), compression.Ext(setting.Algorithm))
+	backupPath := filepath.Join(cfg.BackupDir, backupName)
+	if plan != nil && plan.parent == backupName {
+		return nil, fmt.Errorf("backup %s already exists; wait a second before taking an incremental backup", backupName)
+	}
 
-	// Generate salt and derive key
+	// Open the key slots of the backup directory and generate the data key;
+	// the salt in the stream header is unused with key slots
+	keys, err := loadKeys(cfg.BackupDir, password)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
 	salt, err := crypto.GenerateSalt()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	key := crypto.DeriveKey(password, salt)
+	// Create metadata; what is only known once the archive is written is
+	// filled in before it is sealed behind the payload
+	metadata := crypto.EncryptionMetadata{
+		Version:          crypto.StreamVersion,
+		Algorithm:        "AES-256-GCM",
+		ChunkSize:        crypto.StreamChunkSize,
+		Compression:      setting.Algorithm,
+		CompressionLevel: setting.Level,
+		Tags:             opts.Tags,
+		Note:             opts.Note,
+	}
+	if plan != nil {
+		metadata.Incremental = true
+		metadata.Parent = plan.parent
+		metadata.DependsOn = plan.dependsOn()
+	}
 
-	// Encrypt
-	encrypted, err := crypto.Encrypt(archiveData, key, salt)
+	// Stream collect → tar → compress → encrypt straight into the backup
+	// file, written next to the destination and moved into place once
+	// complete, so memory use does not depend on the backup size
+	out, err := createContainer(backupPath, &metadata, keys, key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt: %w", err)
+		return nil, err
 	}
+	defer out.abort()
 
-	// Write encrypted backup
-	if err := os.WriteFile(backupPath, encrypted, 0600); err != nil {
-		return nil, fmt.Errorf("failed to write backup: %w", err)
+	encrypted, err := crypto.NewEncryptWriter(out, key, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
 	}
 
-	// Create metadata
-	metadata := crypto.EncryptionMetadata{
-		Version:      crypto.Version,
-		Algorithm:    "AES-256-GCM",
-		KDF:          "Argon2id",
-		Salt:         salt,
-		KDFTime:      crypto.Argon2Time,
-		KDFMemory:    crypto.Argon2Memory,
-		KDFThreads:   crypto.Argon2Threads,
-		Timestamp:    time.Now(),
-		OriginalSize: int64(len(archiveData)),
-	}
-
-	metadataJSON, err := json.MarshalIndent(metadata, "", "  ")
+	// Checksum and size are computed over the plaintext archive as it streams by
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	t.Phase(progress.Writing, countFiles(toStore), totalSize(toStore))
+	written, err := writeArchive(ctx, toStore, io.MultiWriter(encrypted, hasher, counter), setting, t)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		return nil, cancelled(ctx, fmt.Errorf("failed to create archive: %w", err))
+	}
+	if err := encrypted.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
 	}
 
-	if err := os.WriteFile(metadataPath, metadataJSON, 0644); err != nil {
-		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	checksumHex := hex.EncodeToString(hasher.Sum(nil))
+	manifest := buildManifest(files, written, plan)
+	metadata.Timestamp = time.Now()
+	metadata.OriginalSize = counter.n
+	if err := out.commit(&metadata, manifest, key); err != nil {
+		return nil, err
 	}
 
-	// Calculate total size
-	var totalSize int64
+	result := &BackupResult{
+		BackupPath:         backupPath,
+		BackupName:         backupName,
+		FileCount:          countFiles(files),
+		TotalSize:          totalSize(files),
+		Duration:           time.Since(start),
+		Checksum:           checksumHex,
+		ChangedFiles:       countFiles(toStore),
+		CaptureErrors:      captureErrors,
+		Secrets:            findings,
+		ExcludedSecrets:    excludedSecrets,
+		UnconfirmedSecrets: unconfirmedSecrets,
+	}
+	for _, f := range toStore {
+		if written[f.Path].changed {
+			result.ChangedDuringBackup = append(result.ChangedDuringBackup, f.Path)
+		}
+		if f.SQLite && written[f.Path].snapshot {
+			result.Snapshots = append(result.Snapshots, f.Path)
+		}
+	}
+	if plan != nil {
+		result.Incremental = true
+		result.Parent = plan.parent
+		result.DeletedFiles = len(plan.deleted)
+	}
+	return result, nil
+}
+
+// countFiles returns the number of files and symlinks among files, leaving
+// out directories
+func countFiles(files []FileInfo) int {
+	n := 0
+	for _, f := range files {
+		if !f.Dir {
+			n++
+		}
+	}
+	return n
+}
+
+// totalSize returns the combined size of files
+func totalSize(files []FileInfo) int64 {
+	var total int64
 	for _, f := range files {
-		totalSize += f.Size
-	}
-
-	return &BackupResult{
-		BackupPath:   backupPath,
-		MetadataPath: metadataPath,
-		BackupName:   backupName,
-		FileCount:    len(files),
-		TotalSize:    totalSize,
-		Duration:     time.Since(start),
-		Checksum:     checksumHex,
-	}, nil
+		total += f.Size
+	}
+	return total
+}
+
+// cancelled returns a cancellation error once ctx is done, and err
+// otherwise, so that errors caused by the cancellation read as such
+func cancelled(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("backup cancelled: %w", ctxErr)
+	}
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
 }
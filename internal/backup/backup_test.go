@@ -1,14 +1,22 @@
 package backup
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/diogo/dotkeeper/internal/capture"
 	"github.com/diogo/dotkeeper/internal/config"
 	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/hooks"
+	"github.com/diogo/dotkeeper/internal/progress"
 )
 
 func TestBackup(t *testing.T) {
@@ -37,7 +45,7 @@ func TestBackup(t *testing.T) {
 	password := "test-password-123"
 
 	// Run backup
-	result, err := Backup(cfg, password)
+	result, err := Backup(context.Background(), cfg, password)
 	if err != nil {
 		t.Fatalf("Backup failed: %v", err)
 	}
@@ -68,9 +76,9 @@ func TestBackup(t *testing.T) {
 		t.Errorf("Backup file does not exist: %s", result.BackupPath)
 	}
 
-	// Verify metadata file exists
-	if _, err := os.Stat(result.MetadataPath); os.IsNotExist(err) {
-		t.Errorf("Metadata file does not exist: %s", result.MetadataPath)
+	// Verify the backup is a single file
+	if _, err := os.Stat(result.BackupPath + MetadataExt); !os.IsNotExist(err) {
+		t.Errorf("Metadata sidecar written next to %s", result.BackupPath)
 	}
 
 	// Verify backup name format
@@ -82,30 +90,30 @@ func TestBackup(t *testing.T) {
 	}
 
 	// Verify metadata content
-	metadataBytes, err := os.ReadFile(result.MetadataPath)
+	container, err := OpenContainer(result.BackupPath)
 	if err != nil {
-		t.Fatalf("Failed to read metadata: %v", err)
+		t.Fatalf("Failed to open backup: %v", err)
 	}
-
-	var metadata crypto.EncryptionMetadata
-	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
-		t.Fatalf("Failed to parse metadata: %v", err)
+	key, err := container.Unlock(password)
+	if err != nil {
+		t.Fatalf("Failed to unlock backup: %v", err)
+	}
+	metadata, _, err := container.Decrypt(key)
+	if err != nil {
+		t.Fatalf("Failed to decrypt metadata: %v", err)
 	}
 
-	if metadata.Version != crypto.Version {
-		t.Errorf("Expected version %d, got %d", crypto.Version, metadata.Version)
+	if metadata.Version != crypto.StreamVersion {
+		t.Errorf("Expected version %d, got %d", crypto.StreamVersion, metadata.Version)
 	}
 
 	if metadata.Algorithm != "AES-256-GCM" {
 		t.Errorf("Expected algorithm AES-256-GCM, got %s", metadata.Algorithm)
 	}
 
-	if metadata.KDF != "Argon2id" {
-		t.Errorf("Expected KDF Argon2id, got %s", metadata.KDF)
-	}
-
-	if len(metadata.Salt) == 0 {
-		t.Error("Expected non-empty salt")
+	slots := container.Slots()
+	if len(slots) != 1 || slots[0].KDF != "Argon2id" || len(slots[0].Salt) == 0 {
+		t.Errorf("Expected one Argon2id key slot with a salt, got %+v", slots)
 	}
 
 	if metadata.OriginalSize == 0 {
@@ -123,13 +131,12 @@ func TestBackup(t *testing.T) {
 	}
 
 	// Verify we can decrypt the backup
-	encryptedData, err := os.ReadFile(result.BackupPath)
+	reader, encryptedFile, err := container.OpenPayload(key)
 	if err != nil {
-		t.Fatalf("Failed to read encrypted backup: %v", err)
+		t.Fatalf("Failed to decrypt backup: %v", err)
 	}
-
-	key := crypto.DeriveKey(password, metadata.Salt)
-	decrypted, err := crypto.Decrypt(encryptedData, key)
+	defer encryptedFile.Close()
+	decrypted, err := io.ReadAll(reader)
 	if err != nil {
 		t.Fatalf("Failed to decrypt backup: %v", err)
 	}
@@ -151,7 +158,7 @@ func TestBackup_NoFiles(t *testing.T) {
 
 	password := "test-password-123"
 
-	result, err := Backup(cfg, password)
+	result, err := Backup(context.Background(), cfg, password)
 
 	if err == nil {
 		t.Error("Expected error when no files to backup")
@@ -188,7 +195,7 @@ func TestBackup_InvalidBackupDir(t *testing.T) {
 
 	password := "test-password-123"
 
-	result, err := Backup(cfg, password)
+	result, err := Backup(context.Background(), cfg, password)
 
 	if err == nil {
 		t.Error("Expected error when backup directory cannot be created")
@@ -224,7 +231,7 @@ func TestBackupCleanupOnFailure(t *testing.T) {
 
 	password := ""
 
-	_, _ = Backup(cfg, password)
+	_, _ = Backup(context.Background(), cfg, password)
 
 	entries, err := os.ReadDir(isolatedTmp)
 	if err != nil {
@@ -238,6 +245,93 @@ func TestBackupCleanupOnFailure(t *testing.T) {
 	}
 }
 
+func TestBackup_Progress(t *testing.T) {
+	tmpDir := t.TempDir()
+	file1 := filepath.Join(tmpDir, "file1.txt")
+	file2 := filepath.Join(tmpDir, "file2.txt")
+	if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file2, []byte("content22"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		BackupDir: filepath.Join(tmpDir, "backups"),
+		Files:     []string{file1, file2},
+	}
+
+	var phases []progress.Phase
+	var last progress.Event
+	_, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{
+		Progress: func(e progress.Event) {
+			if len(phases) == 0 || phases[len(phases)-1] != e.Phase {
+				phases = append(phases, e.Phase)
+			}
+			last = e
+		},
+	})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	want := []progress.Phase{progress.Collecting, progress.Scanning, progress.Comparing, progress.Writing, progress.Done}
+	if strings.Join(phaseNames(phases), ",") != strings.Join(phaseNames(want), ",") {
+		t.Errorf("phases = %v, want %v", phases, want)
+	}
+	if last.Operation != "backup" || last.FilesDone != 2 || last.FilesTotal != 2 || last.BytesDone != 17 || last.BytesTotal != 17 {
+		t.Errorf("last event = %+v, want 2/2 files and 17/17 bytes", last)
+	}
+}
+
+func phaseNames(phases []progress.Phase) []string {
+	names := make([]string, len(phases))
+	for i, p := range phases {
+		names[i] = string(p)
+	}
+	return names
+}
+
+func TestBackup_Cancelled(t *testing.T) {
+	for _, repo := range []bool{false, true} {
+		t.Run(fmt.Sprintf("repository=%v", repo), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			backupDir := filepath.Join(tmpDir, "backups")
+			file1 := filepath.Join(tmpDir, "file1.txt")
+			if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{BackupDir: backupDir, Files: []string{file1}, Repository: repo}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, err := BackupWithOptions(ctx, cfg, "pw", BackupOptions{
+				Progress: func(e progress.Event) {
+					if e.Phase == progress.Writing {
+						cancel()
+					}
+				},
+			})
+			if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "backup cancelled") {
+				t.Fatalf("Backup() error = %v, want a cancellation", err)
+			}
+
+			backups, err := List(backupDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 0 {
+				t.Errorf("cancelled backup left %d backups", len(backups))
+			}
+			entries, _ := os.ReadDir(backupDir)
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".dotkeeper-backup-") {
+					t.Errorf("found leftover temp file %s", entry.Name())
+				}
+			}
+		})
+	}
+}
+
 func TestBackup_WithFolder(t *testing.T) {
 	tmpDir := t.TempDir()
 	backupDir := filepath.Join(tmpDir, "backups")
@@ -266,7 +360,7 @@ func TestBackup_WithFolder(t *testing.T) {
 
 	password := "test-password-123"
 
-	result, err := Backup(cfg, password)
+	result, err := Backup(context.Background(), cfg, password)
 	if err != nil {
 		t.Fatalf("Backup failed: %v", err)
 	}
@@ -275,3 +369,79 @@ func TestBackup_WithFolder(t *testing.T) {
 		t.Errorf("Expected 2 files from folder, got %d", result.FileCount)
 	}
 }
+
+func TestBackup_Hooks(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupDir := filepath.Join(tmpDir, "backups")
+	testFile := filepath.Join(tmpDir, "test.txt")
+	if err := os.WriteFile(testFile, []byte("test content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	postLog := filepath.Join(tmpDir, "post.log")
+
+	cfg := &config.Config{
+		BackupDir: backupDir,
+		Files:     []string{testFile},
+		Hooks: hooks.Config{
+			PreBackup:  []hooks.Hook{{Command: "echo dumping"}},
+			PostBackup: []hooks.Hook{{Command: `echo "$DOTKEEPER_BACKUP_NAME $DOTKEEPER_FILE_COUNT" > ` + postLog}},
+		},
+	}
+
+	result, err := Backup(context.Background(), cfg, "test-password-123")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if len(result.Hooks) != 2 || result.Hooks[0].Output != "dumping\n" {
+		t.Errorf("Hooks = %+v", result.Hooks)
+	}
+	logged, err := os.ReadFile(postLog)
+	if err != nil {
+		t.Fatalf("post_backup hook did not run: %v", err)
+	}
+	if want := result.BackupName + " 1\n"; string(logged) != want {
+		t.Errorf("post_backup hook saw %q, want %q", logged, want)
+	}
+
+	// A failing pre_backup hook aborts the backup
+	cfg.BackupDir = filepath.Join(tmpDir, "aborted")
+	cfg.Hooks.PreBackup = []hooks.Hook{{Command: "exit 1"}}
+	if _, err := Backup(context.Background(), cfg, "test-password-123"); err == nil || !strings.Contains(err.Error(), "backup aborted") {
+		t.Fatalf("Backup() error = %v, want abort", err)
+	}
+	if _, err := os.Stat(cfg.BackupDir); !os.IsNotExist(err) {
+		t.Error("aborted backup should not create the backup directory")
+	}
+}
+
+func TestBackup_Captures(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		BackupDir: filepath.Join(tmpDir, "backups"),
+		Captures: []capture.Capture{
+			{Name: "packages", Command: "printf 'git\\nvim\\n'"},
+			{Name: "broken", Command: "exit 1"},
+		},
+	}
+
+	result, err := Backup(context.Background(), cfg, "test-password-123")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if result.FileCount != 1 {
+		t.Errorf("FileCount = %d, want 1", result.FileCount)
+	}
+	if len(result.CaptureErrors) != 1 || !strings.Contains(result.CaptureErrors[0], `"broken"`) {
+		t.Errorf("CaptureErrors = %v", result.CaptureErrors)
+	}
+
+	manifest, err := ReadManifest(result.BackupPath, "test-password-123")
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+	sum := sha256.Sum256([]byte("git\nvim\n"))
+	if len(manifest.Entries) != 1 || manifest.Entries[0].Path != "captures/packages" ||
+		manifest.Entries[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("manifest entries = %+v", manifest.Entries)
+	}
+}
@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/capture"
+)
+
+// collectCaptures runs the configured captures and returns their outputs as
+// virtual files stored under captures/. Captures whose command fails are
+// left out and reported as problems. The outputs live in a temporary
+// directory that cleanup removes.
+func collectCaptures(captures []capture.Capture, start time.Time) (files []FileInfo, problems []string, cleanup func(), err error) {
+	cleanup = func() {}
+	if len(captures) == 0 {
+		return nil, nil, cleanup, nil
+	}
+
+	dir, err := os.MkdirTemp("", "dotkeeper-captures-*")
+	if err != nil {
+		return nil, nil, cleanup, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	for _, out := range capture.Run(captures, dir) {
+		if out.Err != nil {
+			problems = append(problems, out.Err.Error())
+			continue
+		}
+		info, err := os.Stat(out.Path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("capture %q: %v", out.Capture.Name, err))
+			continue
+		}
+		files = append(files, FileInfo{
+			Path:    out.Path,
+			Name:    out.Capture.ArchiveName(),
+			Size:    info.Size(),
+			Mode:    0600,
+			ModTime: start.Unix(),
+		})
+	}
+	return files, problems, cleanup, nil
+}
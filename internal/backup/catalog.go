@@ -0,0 +1,235 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/compression"
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// MetadataExt is appended to a backup archive path to name its plaintext
+// metadata sidecar in the legacy layout. Legacy archives are discovered by
+// their sidecar and single-file backups by their magic, so the archive's
+// own extension only reflects its compression.
+const MetadataExt = ".meta.json"
+
+// nameTimeLayout formats the time in backup names
+const nameTimeLayout = "2006-01-02-150405"
+
+// Info describes a backup in a backup directory: either a standalone
+// archive or a snapshot in the repository
+type Info struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Created      time.Time `json:"created"`
+	OriginalSize int64     `json:"original_size,omitempty"`
+	Snapshot     bool      `json:"snapshot,omitempty"`
+	Incremental  bool      `json:"incremental,omitempty"`
+	Parent       string    `json:"parent,omitempty"`
+	DependsOn    []string  `json:"depends_on,omitempty"`
+	Labels
+}
+
+// ErrHasDependents is returned when deleting a backup that incremental
+// backups still need
+var ErrHasDependents = errors.New("backup is needed by incremental backups")
+
+// ID returns the backup name without its file extension
+func (i Info) ID() string {
+	if i.Snapshot {
+		return strings.TrimSuffix(i.Name, repository.SnapshotExt)
+	}
+	return compression.TrimExt(i.Name)
+}
+
+// List returns all backups in backupDir, newest first. For snapshots, Size
+// is the amount of data the snapshot added to the repository.
+func List(backupDir string) ([]Info, error) {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var backups []Info
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ManifestExt) {
+			continue
+		}
+		if !strings.HasSuffix(name, MetadataExt) {
+			if b, ok := containerInfo(filepath.Join(backupDir, name)); ok {
+				backups = append(backups, b)
+			}
+			continue
+		}
+
+		// Metadata left behind by a removed archive is not a backup
+		name = strings.TrimSuffix(name, MetadataExt)
+		path := filepath.Join(backupDir, name)
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		b := Info{
+			Name:    name,
+			Path:    path,
+			Size:    info.Size(),
+			Created: info.ModTime(),
+		}
+
+		// Try to read metadata for more info
+		if metadataData, err := os.ReadFile(path + MetadataExt); err == nil {
+			var metadata crypto.EncryptionMetadata
+			if err := json.Unmarshal(metadataData, &metadata); err == nil {
+				b.OriginalSize = metadata.OriginalSize
+				b.Created = metadata.Timestamp
+				b.Incremental = metadata.Incremental
+				b.Parent = metadata.Parent
+				b.DependsOn = metadata.DependsOn
+				b.Labels = Labels{Tags: metadata.Tags, Note: metadata.Note, Pinned: metadata.Pinned}
+			}
+		}
+
+		backups = append(backups, b)
+	}
+
+	snapshots, err := repository.ListSnapshots(repository.Dir(backupDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range snapshots {
+		backups = append(backups, Info{
+			Name:         snap.Name + repository.SnapshotExt,
+			Path:         snap.Path,
+			Size:         snap.AddedSize,
+			Created:      snap.Created,
+			OriginalSize: snap.OriginalSize,
+			Snapshot:     true,
+			Labels:       Labels{Tags: snap.Tags, Note: snap.Note, Pinned: snap.Pinned},
+		})
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Created.After(backups[j].Created)
+	})
+	return backups, nil
+}
+
+// containerInfo describes the single-file backup at path from its plaintext
+// parts. The creation time comes from the backup name, since the timestamp
+// and original size are encrypted. ok is false for other files.
+func containerInfo(path string) (Info, bool) {
+	if !IsContainer(path) {
+		return Info{}, false
+	}
+	c, err := OpenContainer(path)
+	if err != nil {
+		return Info{}, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Info{}, false
+	}
+
+	b := Info{
+		Name:    filepath.Base(path),
+		Path:    path,
+		Size:    info.Size(),
+		Created: info.ModTime(),
+		Labels:  c.Labels(),
+	}
+	if created, err := time.ParseInLocation(nameTimeLayout, strings.TrimPrefix(b.ID(), "backup-"), time.Local); err == nil {
+		b.Created = created
+	}
+	metadata := c.Metadata()
+	b.Incremental = metadata.Incremental
+	b.Parent = metadata.Parent
+	b.DependsOn = metadata.DependsOn
+	return b, true
+}
+
+// Resolve finds a backup by name, with or without its file extension
+func Resolve(backupDir, name string) (Info, error) {
+	backups, err := List(backupDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Info{}, err
+	}
+
+	for _, b := range backups {
+		if b.Name == name || b.ID() == name {
+			return b, nil
+		}
+	}
+	return Info{}, fmt.Errorf("backup not found: %s", name)
+}
+
+// Dependents returns the names of the incremental backups that need
+// content stored in b
+func Dependents(b Info) ([]string, error) {
+	if b.Snapshot {
+		return nil, nil
+	}
+
+	backups, err := List(filepath.Dir(b.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	var dependents []string
+	for _, other := range backups {
+		for _, dep := range other.DependsOn {
+			if dep == b.Name {
+				dependents = append(dependents, other.Name)
+				break
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// Delete removes a backup. Deleting a snapshot also garbage-collects the
+// repository chunks that no other snapshot references; the number of
+// chunks removed is returned. The pin is checked with the key password
+// opens: pinned backups, and those whose labels do not verify, are refused
+// with ErrPinned, and backups that incremental backups depend on with
+// ErrHasDependents.
+func Delete(b Info, password string) (int, error) {
+	labels, ok, err := checkedLabels(b, password)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: %s (its labels were not set with the password; unpin it to confirm)", ErrPinned, b.Name)
+	}
+	if labels.Pinned {
+		return 0, fmt.Errorf("%w: %s (unpin it first)", ErrPinned, b.Name)
+	}
+	if b.Snapshot {
+		return repository.DeleteSnapshot(repository.DirFromSnapshot(b.Path), b.ID())
+	}
+
+	dependents, err := Dependents(b)
+	if err != nil {
+		return 0, err
+	}
+	if len(dependents) > 0 {
+		return 0, fmt.Errorf("%w: %s (delete those first)", ErrHasDependents, strings.Join(dependents, ", "))
+	}
+
+	if err := os.Remove(b.Path); err != nil {
+		return 0, fmt.Errorf("failed to delete backup: %w", err)
+	}
+	os.Remove(b.Path + MetadataExt)
+	os.Remove(ManifestPath(b.Path))
+	return 0, nil
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+func TestCatalog_ListResolveDelete(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	file := filepath.Join(tmpDir, "file.txt")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{BackupDir: backupDir, Files: []string{file}}
+	archive, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Repository = true
+	snapshot, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := List(backupDir)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %d", len(backups))
+	}
+
+	var kinds = map[bool]Info{}
+	for _, b := range backups {
+		kinds[b.Snapshot] = b
+	}
+	if kinds[false].Path != archive.BackupPath {
+		t.Errorf("archive path = %s, want %s", kinds[false].Path, archive.BackupPath)
+	}
+	if kinds[true].Path != snapshot.BackupPath {
+		t.Errorf("snapshot path = %s, want %s", kinds[true].Path, snapshot.BackupPath)
+	}
+	if kinds[true].OriginalSize != int64(len("content")) {
+		t.Errorf("snapshot original size = %d", kinds[true].OriginalSize)
+	}
+
+	// Names resolve with or without their extension
+	snap := kinds[true]
+	for _, name := range []string{snap.Name, snap.ID()} {
+		got, err := Resolve(backupDir, name)
+		if err != nil {
+			t.Fatalf("Resolve(%q) failed: %v", name, err)
+		}
+		if got.Path != snap.Path {
+			t.Errorf("Resolve(%q) = %s, want %s", name, got.Path, snap.Path)
+		}
+	}
+	if _, err := Resolve(backupDir, "backup-missing"); err == nil {
+		t.Error("expected error resolving a missing backup")
+	}
+
+	removed, err := Delete(snap, "pw")
+	if err != nil {
+		t.Fatalf("Delete snapshot failed: %v", err)
+	}
+	if removed == 0 {
+		t.Error("expected unreferenced chunks to be collected")
+	}
+	chunks, _ := filepath.Glob(filepath.Join(repository.Dir(backupDir), "chunks", "*", "*"))
+	if len(chunks) != 0 {
+		t.Errorf("expected no chunks left, got %d", len(chunks))
+	}
+
+	if _, err := Delete(kinds[false], "pw"); err != nil {
+		t.Fatalf("Delete archive failed: %v", err)
+	}
+	if _, err := os.Stat(archive.BackupPath); !os.IsNotExist(err) {
+		t.Error("expected archive to be deleted")
+	}
+
+	backups, err = List(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 0 {
+		t.Errorf("expected no backups left, got %d", len(backups))
+	}
+}
@@ -2,12 +2,18 @@ package backup
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/fsmeta"
 	"github.com/diogo/dotkeeper/internal/pathutil"
 )
 
@@ -18,122 +24,443 @@ type FileInfo struct {
 	Mode       fs.FileMode // File permissions
 	ModTime    int64       // Modification time (Unix timestamp)
 	LinkTarget string      // Symlink target (empty for regular files)
+	Dir        bool        // Directory entry, stored for its mode and metadata
+	// SQLite marks a database stored as a consistent snapshot together
+	// with its write-ahead log or journal
+	SQLite bool
+	// Meta holds ownership and extended attributes, including ACLs
+	Meta fsmeta.Metadata
+	// Name overrides the name the file is stored under; captures use it
+	// to store their output, read from a temporary file, as captures/<name>
+	Name string
+	// SHA256 is the hex digest of the content, set for the files selected
+	// by Collector.Hash
+	SHA256 string
 }
 
-func shouldExclude(path string, baseName string, isDir bool, patterns []string) bool {
-	for _, pattern := range patterns {
-		if pattern == "" {
-			continue
-		}
+// ArchiveName is the name the file is stored under: its path relative to
+// the logical root containing it, such as $HOME/.zshrc, so that restores
+// follow the current user's directories
+func (f FileInfo) ArchiveName() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return pathutil.ToLogical(f.Path)
+}
 
-		if strings.HasSuffix(pattern, "/") && isDir {
-			dirPattern := strings.TrimSuffix(pattern, "/")
-			if matched, _ := filepath.Match(dirPattern, baseName); matched {
-				return true
-			}
-		}
+// DefaultWorkers is the number of paths the collector works on at once
+var DefaultWorkers = max(4, runtime.NumCPU())
 
-		if matched, _ := filepath.Match(pattern, baseName); matched {
-			return true
-		}
+// maxSymlinkDepth is how many directory symlinks may be followed on the
+// way to a single path
+const maxSymlinkDepth = 20
 
-		if matched, _ := filepath.Match(pattern, path); matched {
-			return true
-		}
-	}
-	return false
+// Collection is the result of walking the configured paths
+type Collection struct {
+	// Files lists everything to back up, in walk order. With
+	// Collector.Dirs it includes the directories, each ahead of its
+	// entries.
+	Files []FileInfo
+	// Roots holds one entry per input path, in input order
+	Roots []RootStats
+}
+
+// RootStats summarises what was found under one input path
+type RootStats struct {
+	Path   string // Path as given, before expansion
+	Exists bool   // For a glob, whether anything matched
+	// IsDir is also set for globs, which like directories stand for any
+	// number of files
+	IsDir     bool
+	FileCount int   // Files collected under the path
+	Size      int64 // Total bytes of those files
+	Errors    int   // Entries skipped because they could not be read
+}
+
+// Collector walks backup paths with a bounded pool of workers. Entries are
+// stat'ed, filtered, read-checked and hashed in parallel, but the result is put
+// together in walk order, so the same tree always yields the same list and
+// archives stay reproducible.
+type Collector struct {
+	// Exclude holds gitignore-style patterns; see pathutil.Matcher.
+	// .dotkeeperignore files found during the walk add to them.
+	Exclude []string
+	// Workers bounds the concurrent filesystem work; zero means DefaultWorkers
+	Workers int
+	// Dirs also collects the directories, so their modes and metadata can
+	// be stored
+	Dirs bool
+	// Symlinks sets how symlinks are stored under each input path; by
+	// default they are stored as links
+	Symlinks config.SymlinkPolicies
+	// Hash selects the regular files whose content the workers hash into
+	// FileInfo.SHA256; nil hashes none
+	Hash func(FileInfo) bool
+
+	// tracked holds the real paths of the resolved inputs, which
+	// follow-external links are compared against
+	tracked []string
+}
+
+// node is one path visited by the collector. Children of a directory are
+// kept in directory order and flattened once every worker has finished.
+type node struct {
+	path string
+	// ignore holds the exclude rules that apply to the path
+	ignore *pathutil.Matcher
+	root   bool
+	// policy is the symlink policy of the input the path belongs to
+	policy config.SymlinkPolicy
+	// chain holds the real paths of the input and of every directory
+	// symlink followed on the way to the path, to detect loops
+	chain []string
+	// followed is set below a followed symlink, where files are told
+	// apart by their path rather than their real path
+	followed bool
+	file     *FileInfo
+	realPath string
+	children []*node
+	err      error
 }
 
 // CollectFiles collects file information from the given paths.
-// It follows symlinks and copies content (doesn't preserve as links).
-// Detects and prevents circular symlinks (max depth 20).
-// Skips unreadable files with warning.
+// Symlinks are stored as links. Files reached through several paths are
+// only collected once. Skips unreadable files with warning.
 // Files matching any excludePatterns are skipped.
+// Paths may use ~, environment variables and globs, which are resolved
+// again on every call.
 func CollectFiles(paths []string, excludePatterns []string) ([]FileInfo, error) {
-	var files []FileInfo
-	visited := make(map[string]bool)
+	c := &Collector{Exclude: excludePatterns}
+	collection := c.Collect(paths)
+	return collection.Files, nil
+}
 
+// Collect walks paths and returns the files to back up together with
+// per-path statistics. Each path is expanded and, when it is a glob,
+// matched against the filesystem before the walk; see pathutil.Resolve.
+// Unreadable entries are skipped with a warning.
+//
+// Symlinks are handled by the policy of their input path. Followed links
+// are stored as what they point to, under the link's own path; a link
+// leading back into a directory it was reached from, or past
+// maxSymlinkDepth followed links, is stored as a link instead.
+func (c *Collector) Collect(paths []string) *Collection {
+	workers := c.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	matcher := pathutil.NewMatcher(c.Exclude)
+	var inputs []input
+	c.tracked = nil
 	for _, path := range paths {
 		trimmed := strings.TrimSpace(path)
 		if trimmed == "" {
 			continue
 		}
-		expanded := pathutil.ExpandHome(trimmed)
-		if err := collectPath(expanded, &files, visited, 0, excludePatterns); err != nil {
-			// Log error but continue with other paths
-			log.Printf("Warning: skipping %s: %v", expanded, err)
+		in := input{path: trimmed, glob: pathutil.IsGlobPattern(trimmed)}
+		policy := c.Symlinks.For(trimmed)
+		// Exclude patterns are applied to the roots by visit
+		resolved, err := pathutil.Resolve(trimmed, nil)
+		if err != nil {
+			in.err = err
+		}
+		for _, p := range resolved {
+			// A root that is itself a symlink is tracked where it is,
+			// not where it points
+			real := filepath.Join(realPath(filepath.Dir(p)), filepath.Base(p))
+			c.tracked = append(c.tracked, real)
+			in.roots = append(in.roots, &node{path: p, ignore: matcher, root: true, policy: policy, chain: []string{real}})
 		}
+		inputs = append(inputs, in)
+	}
+	// Every input is resolved before the walk starts, so links can be
+	// compared against all of them
+	var roots []*node
+	for _, in := range inputs {
+		roots = append(roots, in.roots...)
 	}
+	c.walk(roots, workers)
 
-	return files, nil
+	collection := &Collection{}
+	visited := make(map[string]bool)
+	for _, in := range inputs {
+		stats := RootStats{Path: in.path, IsDir: in.glob}
+		if in.err != nil {
+			log.Printf("Warning: skipping %s: %v", in.path, in.err)
+			stats.Errors++
+		}
+		for _, root := range in.roots {
+			if info, err := os.Stat(root.path); err == nil {
+				stats.Exists = true
+				stats.IsDir = stats.IsDir || info.IsDir()
+			}
+			collection.flatten(root, visited, &stats)
+		}
+		collection.Roots = append(collection.Roots, stats)
+	}
+	return collection
 }
 
-func collectPath(path string, files *[]FileInfo, visited map[string]bool, depth int, excludePatterns []string) error {
-	linfo, err := os.Lstat(path)
+// walk visits roots and every node found under them with a fixed pool of
+// workers taking nodes from a shared queue. The queue is worked from its
+// end, so the walk goes depth first and stays short.
+func (c *Collector) walk(roots []*node, workers int) {
+	var (
+		mu    sync.Mutex
+		ready = sync.NewCond(&mu)
+		queue = slices.Clone(roots)
+		// pending counts the nodes queued or being visited
+		pending = len(roots)
+	)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			for {
+				for len(queue) == 0 && pending > 0 {
+					ready.Wait()
+				}
+				if pending == 0 {
+					return
+				}
+				n := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+
+				mu.Unlock()
+				c.visit(n)
+				mu.Lock()
+
+				queue = append(queue, n.children...)
+				pending += len(n.children) - 1
+				ready.Broadcast()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// input is one configured path and the roots it resolved to
+type input struct {
+	path  string
+	glob  bool
+	roots []*node
+	err   error
+}
+
+// visit stats a single path. Directories are listed so their entries can
+// be visited in turn; regular files are checked for readability.
+func (c *Collector) visit(n *node) {
+	linfo, err := os.Lstat(n.path)
 	if err != nil {
-		return fmt.Errorf("lstat failed: %w", err)
+		n.err = fmt.Errorf("lstat failed: %w", err)
+		return
 	}
 
-	baseName := filepath.Base(path)
-	if shouldExclude(path, baseName, linfo.IsDir(), excludePatterns) {
-		return nil
+	// Children are only visited when their directory was not excluded,
+	// but a configured path may lie inside an excluded directory
+	excluded := n.ignore.Match(n.path, linfo.IsDir())
+	if n.root {
+		excluded = n.ignore.Excluded(n.path, linfo.IsDir())
+	}
+	if excluded {
+		return
 	}
 
+	// info and metaPath describe what the path stands for: the entry
+	// itself, or the target of a followed symlink
+	info, metaPath := linfo, n.path
+	chain := n.chain
 	if linfo.Mode()&os.ModeSymlink != 0 {
-		target, err := os.Readlink(path)
+		target, err := os.Readlink(n.path)
 		if err != nil {
-			return fmt.Errorf("readlink failed: %w", err)
+			n.err = fmt.Errorf("readlink failed: %w", err)
+			return
 		}
 
-		*files = append(*files, FileInfo{
-			Path:       path,
-			Mode:       linfo.Mode().Perm(),
-			ModTime:    linfo.ModTime().Unix(),
-			LinkTarget: target,
-		})
-		return nil
+		real, targetInfo, ok := c.follow(n)
+		if !ok {
+			n.file = &FileInfo{
+				Path:       n.path,
+				Mode:       linfo.Mode().Perm(),
+				ModTime:    linfo.ModTime().Unix(),
+				LinkTarget: target,
+				Meta:       fsmeta.Read(n.path, linfo),
+			}
+			return
+		}
+		info, metaPath = targetInfo, real
+		n.followed = true
+		if info.IsDir() {
+			chain = append(slices.Clone(chain), real)
+		}
 	}
 
-	if linfo.IsDir() {
-		entries, err := os.ReadDir(path)
+	if info.IsDir() {
+		entries, err := os.ReadDir(n.path)
+		if err != nil {
+			n.err = fmt.Errorf("read dir failed: %w", err)
+			return
+		}
+
+		ignore := n.ignore
+		patterns, err := pathutil.ReadIgnoreFile(n.path)
 		if err != nil {
-			return fmt.Errorf("read dir failed: %w", err)
+			log.Printf("Warning: ignoring %s: %v", filepath.Join(n.path, pathutil.IgnoreFile), err)
+		} else if len(patterns) > 0 {
+			ignore = ignore.With(n.path, patterns)
 		}
 
-		for _, entry := range entries {
-			entryPath := filepath.Join(path, entry.Name())
-			if err := collectPath(entryPath, files, visited, depth, excludePatterns); err != nil {
-				log.Printf("Warning: skipping %s: %v", entryPath, err)
+		if c.Dirs {
+			n.realPath = n.path
+			if !n.followed {
+				n.realPath = realPath(n.path)
+			}
+			n.file = &FileInfo{
+				Path:    n.path,
+				Mode:    info.Mode().Perm(),
+				ModTime: info.ModTime().Unix(),
+				Dir:     true,
+				Meta:    fsmeta.Read(metaPath, info),
 			}
 		}
-		return nil
+
+		n.children = make([]*node, len(entries))
+		for i, entry := range entries {
+			n.children[i] = &node{
+				path:     filepath.Join(n.path, entry.Name()),
+				ignore:   ignore,
+				policy:   n.policy,
+				chain:    chain,
+				followed: n.followed,
+			}
+		}
+		return
 	}
 
-	if !linfo.Mode().IsRegular() {
-		return fmt.Errorf("not a regular file")
+	if !info.Mode().IsRegular() {
+		n.err = fmt.Errorf("not a regular file")
+		return
 	}
 
-	file, err := os.Open(path)
+	file, err := os.Open(n.path)
 	if err != nil {
-		return fmt.Errorf("file not readable: %w", err)
+		n.err = fmt.Errorf("file not readable: %w", err)
+		return
 	}
+	magic := make([]byte, len(sqliteMagic))
+	read, _ := io.ReadFull(file, magic)
 	file.Close()
 
-	realPath, err := filepath.EvalSymlinks(path)
+	// A followed link stands for its own copy of the target, so only
+	// the same path reached twice is a duplicate
+	n.realPath = n.path
+	if !n.followed {
+		n.realPath = realPath(n.path)
+	}
+
+	n.file = &FileInfo{
+		Path:    n.path,
+		Size:    info.Size(),
+		Mode:    info.Mode().Perm(),
+		ModTime: info.ModTime().Unix(),
+		SQLite:  IsSQLite(magic[:read]),
+		Meta:    fsmeta.Read(metaPath, info),
+	}
+	// A file that cannot be hashed here is hashed again by whoever needs
+	// the digest, which reports the error
+	if c.Hash != nil && c.Hash(*n.file) {
+		n.file.SHA256, _ = hashFile(n.path)
+	}
+}
+
+// follow decides whether the symlink at n is followed under its policy and
+// returns the real path and info of its target when it is. Dangling links
+// and links that would loop are not followed.
+func (c *Collector) follow(n *node) (string, fs.FileInfo, bool) {
+	if n.policy != config.SymlinksFollow && n.policy != config.SymlinksFollowExternal {
+		return "", nil, false
+	}
+	real, err := filepath.EvalSymlinks(n.path)
 	if err != nil {
-		realPath = path
+		return "", nil, false
 	}
-	if visited[realPath] {
-		return nil
+	if n.policy == config.SymlinksFollowExternal && c.isTracked(real) {
+		return "", nil, false
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", nil, false
+	}
+	if !info.IsDir() {
+		return real, info, true
 	}
-	visited[realPath] = true
 
-	*files = append(*files, FileInfo{
-		Path:    path,
-		Size:    linfo.Size(),
-		Mode:    linfo.Mode().Perm(),
-		ModTime: linfo.ModTime().Unix(),
-	})
+	if len(n.chain) > maxSymlinkDepth {
+		log.Printf("Warning: not following %s: more than %d symlinks deep", n.path, maxSymlinkDepth)
+		return "", nil, false
+	}
+	// Following a link to a directory containing the link, or one the
+	// walk already came through, would never end
+	for _, dir := range append(n.chain, realPath(filepath.Dir(n.path))) {
+		if _, inside := pathutil.CutPathPrefix(dir, real); inside {
+			log.Printf("Warning: not following %s: it loops back to %s", n.path, real)
+			return "", nil, false
+		}
+	}
+	return real, info, true
+}
+
+// isTracked reports whether path lies within one of the inputs
+func (c *Collector) isTracked(path string) bool {
+	for _, root := range c.tracked {
+		if _, inside := pathutil.CutPathPrefix(path, root); inside {
+			return true
+		}
+	}
+	return false
+}
+
+// realPath returns path with every symlink resolved, or path itself when
+// that fails
+func realPath(path string) string {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return real
+}
+
+// flatten appends the files under n in walk order, a collected directory
+// ahead of its entries. Warnings are logged here rather than by the workers so they
+// come out in a stable order, and duplicates are dropped here so the first
+// path in walk order wins.
+func (col *Collection) flatten(n *node, visited map[string]bool, stats *RootStats) {
+	if n.err != nil {
+		log.Printf("Warning: skipping %s: %v", n.path, n.err)
+		stats.Errors++
+		return
+	}
+
+	if n.file != nil {
+		if n.realPath != "" {
+			if visited[n.realPath] {
+				return
+			}
+			visited[n.realPath] = true
+		}
+		col.Files = append(col.Files, *n.file)
+		if !n.file.Dir {
+			stats.FileCount++
+			stats.Size += n.file.Size
+		}
+	}
 
-	return nil
+	for _, child := range n.children {
+		col.flatten(child, visited, stats)
+	}
 }
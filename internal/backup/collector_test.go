@@ -1,10 +1,18 @@
 package backup
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"testing"
+
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/pathutil"
 )
 
 func TestCollectFiles_Basic(t *testing.T) {
@@ -249,6 +257,139 @@ func TestCollectFiles_DuplicatePathsViaSymlinks(t *testing.T) {
 	}
 }
 
+func TestCollector_SymlinkPolicies(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A stow-style tree: the tracked folder links into a store outside it
+	store := filepath.Join(tmpDir, "store", "nvim")
+	if err := os.MkdirAll(store, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store, "init.lua"), []byte("vim"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmpDir, "dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "local.txt"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(store, filepath.Join(dir, "nvim")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "local.txt"), filepath.Join(dir, "alias.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	collect := func(policy config.SymlinkPolicy) map[string]FileInfo {
+		c := &Collector{Symlinks: config.SymlinkPolicies{dir: policy}}
+		files := make(map[string]FileInfo)
+		for _, f := range c.Collect([]string{dir}).Files {
+			files[strings.TrimPrefix(f.Path, dir+"/")] = f
+		}
+		return files
+	}
+
+	files := collect(config.SymlinksPreserve)
+	if len(files) != 3 || files["nvim"].LinkTarget != store || files["alias.txt"].LinkTarget == "" {
+		t.Errorf("preserve: expected links stored as links, got %v", files)
+	}
+
+	files = collect(config.SymlinksFollow)
+	if len(files) != 3 {
+		t.Fatalf("follow: expected 3 files, got %v", files)
+	}
+	initLua := files["nvim/init.lua"]
+	if initLua.LinkTarget != "" || initLua.Size != 3 || initLua.Mode != 0600 {
+		t.Errorf("follow: expected nvim/init.lua stored as the store's file, got %+v", initLua)
+	}
+	if alias := files["alias.txt"]; alias.LinkTarget != "" || alias.Size != 5 {
+		t.Errorf("follow: expected alias.txt stored as a copy of local.txt, got %+v", alias)
+	}
+
+	files = collect(config.SymlinksFollowExternal)
+	if len(files) != 3 {
+		t.Fatalf("follow-external: expected 3 files, got %v", files)
+	}
+	if files["nvim/init.lua"].LinkTarget != "" {
+		t.Error("follow-external: expected the external link to be followed")
+	}
+	if files["alias.txt"].LinkTarget == "" {
+		t.Error("follow-external: expected the link inside the tracked folder to be preserved")
+	}
+}
+
+func TestCollector_FollowedRootAndLoops(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	store := filepath.Join(tmpDir, "store")
+	if err := os.MkdirAll(filepath.Join(store, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store, "sub", "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A link back up the tree would otherwise be walked forever
+	if err := os.Symlink(store, filepath.Join(store, "sub", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(tmpDir, "root")
+	if err := os.Symlink(store, root); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Collector{Symlinks: config.SymlinkPolicies{root: config.SymlinksFollowExternal}}
+	files := c.Collect([]string{root}).Files
+
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, strings.TrimPrefix(f.Path, root+"/"))
+	}
+	sort.Strings(paths)
+	if want := []string{"sub/file.txt", "sub/loop"}; !slices.Equal(paths, want) {
+		t.Fatalf("Expected %v, got %v", want, paths)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Path, "loop") && f.LinkTarget != store {
+			t.Errorf("Expected the loop to be stored as a link, got %+v", f)
+		}
+	}
+}
+
+func TestCollector_Dirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.Chmod(tmpDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "empty"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "ssh", "config"), []byte("Host *"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	collection := (&Collector{Dirs: true}).Collect([]string{tmpDir})
+
+	var got []string
+	for _, f := range collection.Files {
+		name := strings.TrimPrefix(strings.TrimPrefix(f.Path, tmpDir), "/")
+		if f.Dir {
+			name += fmt.Sprintf("/ %o", f.Mode)
+		}
+		got = append(got, name)
+	}
+	if want := []string{"/ 700", "empty/ 750", "ssh/ 700", "ssh/config"}; !slices.Equal(got, want) {
+		t.Errorf("collected %v, want %v", got, want)
+	}
+	if collection.Roots[0].FileCount != 1 {
+		t.Errorf("FileCount = %d, want 1", collection.Roots[0].FileCount)
+	}
+}
+
 func TestCollectFilesWithExclusions(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -303,30 +444,223 @@ func TestCollectFilesNoExclusions(t *testing.T) {
 	}
 }
 
-func TestShouldExclude(t *testing.T) {
+func TestExcludeMatch(t *testing.T) {
 	tests := []struct {
 		name     string
 		path     string
-		baseName string
 		isDir    bool
 		patterns []string
 		want     bool
 	}{
-		{"empty patterns", "/foo/bar.log", "bar.log", false, nil, false},
-		{"match base name", "/foo/bar.log", "bar.log", false, []string{"*.log"}, true},
-		{"no match", "/foo/bar.txt", "bar.txt", false, []string{"*.log"}, false},
-		{"dir pattern on dir", "/foo/node_modules", "node_modules", true, []string{"node_modules/"}, true},
-		{"dir pattern on file (should fail if match)", "/foo/node_modules", "node_modules", false, []string{"node_modules/"}, false},
-		{"empty pattern skipped", "/foo/bar.log", "bar.log", false, []string{""}, false},
+		{"empty patterns", "/foo/bar.log", false, nil, false},
+		{"match base name", "/foo/bar.log", false, []string{"*.log"}, true},
+		{"no match", "/foo/bar.txt", false, []string{"*.log"}, false},
+		{"dir pattern on dir", "/foo/node_modules", true, []string{"node_modules/"}, true},
+		{"dir pattern on file (should fail if match)", "/foo/node_modules", false, []string{"node_modules/"}, false},
+		{"empty pattern skipped", "/foo/bar.log", false, []string{""}, false},
+		{"absolute path", "/foo/bar.log", false, []string{"/foo/bar.log"}, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := shouldExclude(tt.path, tt.baseName, tt.isDir, tt.patterns)
+			got := pathutil.NewMatcher(tt.patterns).Match(tt.path, tt.isDir)
 			if got != tt.want {
-				t.Errorf("shouldExclude(%q, %q, %v, %v) = %v, want %v",
-					tt.path, tt.baseName, tt.isDir, tt.patterns, got, tt.want)
+				t.Errorf("Match(%q, %v) with %v = %v, want %v",
+					tt.path, tt.isDir, tt.patterns, got, tt.want)
 			}
 		})
 	}
 }
+
+func TestCollectFiles_Gitignore(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := []string{
+		"keep.txt",
+		"debug.log",
+		"important.log",
+		"build/out.bin",
+		"nvim/plugin/packer.lua",
+		"nvim/init.lua",
+		"nvim/lazy/cache.json",
+		"nvim/lazy/lock.json",
+		"deep/a/b/node_modules/x.js",
+	}
+	for _, name := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rules in a .dotkeeperignore are anchored to its directory
+	ignore := "plugin/\nlazy/*\n!lazy/lock.json\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "nvim", pathutil.IgnoreFile), []byte(ignore), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	collected, err := CollectFiles([]string{tmpDir}, []string{
+		"*.log",
+		"!important.log",
+		filepath.Join(tmpDir, "build"),
+		"**/node_modules/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, f := range collected {
+		rel, _ := filepath.Rel(tmpDir, f.Path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(got)
+	want := []string{"important.log", "keep.txt", "nvim/.dotkeeperignore", "nvim/init.lua", "nvim/lazy/lock.json"}
+	if !slices.Equal(got, want) {
+		t.Errorf("collected %v, want %v", got, want)
+	}
+
+	// A configured path inside an excluded directory stays excluded
+	for _, pattern := range []string{"build/", filepath.Join(tmpDir, "build") + "/"} {
+		collected, _ = CollectFiles([]string{filepath.Join(tmpDir, "build", "out.bin")}, []string{pattern})
+		if len(collected) != 0 {
+			t.Errorf("file under a directory excluded by %q was collected: %v", pattern, collected)
+		}
+	}
+}
+
+func TestCollector_DeterministicOrder(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := 0; i < 8; i++ {
+		dir := filepath.Join(tmpDir, fmt.Sprintf("dir%d", i), "nested")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 10; j++ {
+			name := filepath.Join(dir, fmt.Sprintf("file%02d", j))
+			if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	single := filepath.Join(tmpDir, "single.txt")
+	if err := os.WriteFile(single, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{single, tmpDir}
+	serial := (&Collector{Workers: 1}).Collect(paths)
+	if len(serial.Files) != 81 {
+		t.Fatalf("expected 81 files, got %d", len(serial.Files))
+	}
+	if serial.Files[0].Path != single {
+		t.Errorf("first file = %s, want the first input path", serial.Files[0].Path)
+	}
+
+	for run := 0; run < 5; run++ {
+		parallel := (&Collector{Workers: 16}).Collect(paths)
+		if len(parallel.Files) != len(serial.Files) {
+			t.Fatalf("run %d: got %d files, want %d", run, len(parallel.Files), len(serial.Files))
+		}
+		for i := range serial.Files {
+			if parallel.Files[i].Path != serial.Files[i].Path {
+				t.Fatalf("run %d: file %d = %s, want %s", run, i, parallel.Files[i].Path, serial.Files[i].Path)
+			}
+		}
+	}
+}
+
+func TestCollector_Hash(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &Collector{Workers: 4, Hash: func(f FileInfo) bool { return filepath.Base(f.Path) == "a.txt" }}
+	files := c.Collect([]string{tmpDir}).Files
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+	want := sha256.Sum256([]byte("a.txt"))
+	for _, f := range files {
+		switch filepath.Base(f.Path) {
+		case "a.txt":
+			if f.SHA256 != hex.EncodeToString(want[:]) {
+				t.Errorf("a.txt hashed as %q", f.SHA256)
+			}
+		default:
+			if f.SHA256 != "" {
+				t.Errorf("%s hashed although not selected", f.Path)
+			}
+		}
+	}
+}
+
+func TestCollector_RootStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	dir := filepath.Join(tmpDir, "dir")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a"), make([]byte, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b"), make([]byte, 20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmpDir, "missing")
+
+	collection := (&Collector{}).Collect([]string{dir, missing, "  "})
+	if len(collection.Roots) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(collection.Roots))
+	}
+
+	root := collection.Roots[0]
+	if !root.Exists || !root.IsDir || root.FileCount != 2 || root.Size != 30 {
+		t.Errorf("unexpected stats for dir: %+v", root)
+	}
+	if gone := collection.Roots[1]; gone.Exists || gone.Errors != 1 {
+		t.Errorf("unexpected stats for missing path: %+v", gone)
+	}
+}
+
+func TestCollector_PatternEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("DOTKEEPER_TEST_CONFIG", tmpDir)
+	for _, app := range []string{"code", "zed", "other"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, app), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rel := range []string{"code/settings.json", "other/keys.json"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, rel), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths := []string{"$DOTKEEPER_TEST_CONFIG/*/settings.json", "${DOTKEEPER_TEST_CONFIG}/other/keys.json"}
+	collection := (&Collector{}).Collect(paths)
+	if len(collection.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(collection.Files))
+	}
+	if glob := collection.Roots[0]; glob.Path != paths[0] || !glob.Exists || !glob.IsDir || glob.FileCount != 1 {
+		t.Errorf("unexpected stats for glob: %+v", glob)
+	}
+
+	// The glob is matched again on the next walk and finds the new file
+	if err := os.WriteFile(filepath.Join(tmpDir, "zed", "settings.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	collection = (&Collector{}).Collect(paths)
+	if got := collection.Roots[0].FileCount; got != 2 {
+		t.Errorf("expected the glob to match 2 files after adding one, got %d", got)
+	}
+
+	collection = (&Collector{}).Collect([]string{filepath.Join(tmpDir, "*", "none")})
+	if glob := collection.Roots[0]; glob.Exists || glob.FileCount != 0 {
+		t.Errorf("unexpected stats for a glob without matches: %+v", glob)
+	}
+}
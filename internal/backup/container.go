@@ -0,0 +1,964 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+)
+
+// Single-file backup layout (ContainerVersion):
+//
+//	[magic(8)][version(2)][header length(4)][header]
+//	[metadata offset(8)][metadata length(4)]
+//	[slot area size(4)][key slots area][key slots area]
+//	[label area size(4)][labels area][labels area]
+//	[payload]
+//	[nonce(12)][sealed metadata]
+//
+// The header is plaintext JSON holding the backup's data key, wrapped by
+// the master key of the backup directory, and the links of an incremental
+// chain, which name backups that are visible in the directory anyway. The
+// key slots are plaintext JSON and wrap the master key, one per password,
+// keyfile or recovery key; every backup of a directory carries the same
+// slots, so each one opens on its own. The payload is the encrypted
+// archive stream, as written by crypto.NewEncryptWriter with the data key,
+// straight into the backup file. The metadata block holds the timestamp,
+// sizes, compression and manifest, which are only known once the payload
+// is written, so it follows the payload and the fixed-size offset ahead of
+// the key slots points at it; until it is written the offset is zero. It
+// is sealed with AES-256-GCM under a subkey of the data key, with the
+// magic, version and header as associated data, so a tampered header fails
+// to decrypt. Key slots and labels can change without touching the rest:
+// each is held in two areas of the same size, with a generation and a
+// checksum, so that a change overwrites the older area in place and a torn
+// write leaves the newer one to read. Slots are sealed on their own, and
+// labels are plaintext JSON carrying a MAC made with a subkey of the data
+// key, so that they can be listed without the password but not changed
+// without it.
+//
+// Version 2 backups have the metadata block ahead of the key slots and
+// labels, which they hold once, the slots padded with spaces, and are
+// rewritten when either changes. Version 1 backups have no key slots either: their header holds
+// the salt and key derivation parameters, and the data key is derived from
+// the password.
+
+// ContainerVersion is the format version of single-file backups
+const ContainerVersion = 3
+
+// containerMagic starts every single-file backup
+var containerMagic = []byte("DKBACKUP")
+
+// maxContainerSection bounds the header, metadata, key slots and labels
+// read into memory, so a damaged length field cannot exhaust it
+const maxContainerSection = 256 << 20
+
+// slotAreaSize is the size of each key slots area in new backups, room for
+// about twenty slots; areas grow when a backup is rewritten for more
+const slotAreaSize = 4 << 10
+
+// containerIndexSize is the size of the metadata offset and length
+// following the header (version 3)
+const containerIndexSize = 8 + 4
+
+// labelAreaSize is the size of each labels area in new backups, room for a
+// note of a few lines
+const labelAreaSize = 4 << 10
+
+// areaHeaderSize is the generation, length and checksum ahead of the data
+// in an area
+const areaHeaderSize = 8 + 4 + sha256.Size
+
+// containerMetadataPurpose names the subkey the metadata block is sealed with
+const containerMetadataPurpose = "container-metadata"
+
+// containerLabelsPurpose names the subkey the labels are authenticated with
+const containerLabelsPurpose = "container-labels"
+
+// ErrNotContainer is returned when opening a file that is not a single-file backup
+var ErrNotContainer = errors.New("not a single-file backup")
+
+// containerHeader is the plaintext header of a single-file backup
+type containerHeader struct {
+	Algorithm string `json:"algorithm"`
+	// WrappedKey is the data key sealed with the master key (version 2)
+	WrappedKey []byte `json:"wrapped_key,omitempty"`
+	// KDF, Salt and the costs derive the data key from the password
+	// (version 1)
+	KDF         string   `json:"kdf,omitempty"`
+	Salt        []byte   `json:"salt,omitempty"`
+	KDFTime     int      `json:"kdf_time,omitempty"`
+	KDFMemory   int      `json:"kdf_memory,omitempty"`
+	KDFThreads  int      `json:"kdf_threads,omitempty"`
+	ChunkSize   int      `json:"chunk_size"`
+	Incremental bool     `json:"incremental,omitempty"`
+	Parent      string   `json:"parent,omitempty"`
+	DependsOn   []string `json:"depends_on,omitempty"`
+}
+
+// containerMetadata is the content of the encrypted metadata block
+type containerMetadata struct {
+	Timestamp        time.Time `json:"timestamp"`
+	OriginalSize     int64     `json:"original_size"`
+	Compression      string    `json:"compression,omitempty"`
+	CompressionLevel int       `json:"compression_level,omitempty"`
+	Manifest         *Manifest `json:"manifest"`
+}
+
+// containerLabels is the labels section of a single-file backup
+type containerLabels struct {
+	Labels
+	MAC []byte `json:"mac,omitempty"`
+}
+
+// Container is a single-file backup opened for reading. Only its plaintext
+// parts are read on open; Decrypt and OpenPayload need the data key.
+type Container struct {
+	path    string
+	version int
+	header  containerHeader
+	slots   []crypto.KeySlot
+	labels  Labels
+	// labelsMAC authenticates labels with a subkey of the data key
+	labelsMAC []byte
+	// aad is the magic, version and header, the associated data of the
+	// metadata block
+	aad    []byte
+	sealed []byte
+	// sealedAt and sealedLen locate the metadata block in the file
+	sealedAt  int64
+	sealedLen int
+	// slotAreas and labelAreas locate the key slots and labels in the file
+	// (version 3)
+	slotAreas  areaPair
+	labelAreas areaPair
+	// payload and payloadLen locate the encrypted archive stream
+	payload    int64
+	payloadLen int64
+}
+
+// IsContainer reports whether the file at path is a single-file backup
+func IsContainer(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(containerMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, containerMagic)
+}
+
+// OpenContainer reads the plaintext parts of a single-file backup: the
+// header, key slots and labels. The metadata block is only read by
+// Decrypt. Files in the legacy layout return an error wrapping
+// ErrNotContainer.
+func OpenContainer(path string) (*Container, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	prefix := make([]byte, len(containerMagic)+2)
+	if _, err := io.ReadFull(r, prefix); err != nil || !bytes.Equal(prefix[:len(containerMagic)], containerMagic) {
+		return nil, fmt.Errorf("%w: %s", ErrNotContainer, filepath.Base(path))
+	}
+	c := &Container{path: path, version: int(binary.BigEndian.Uint16(prefix[len(containerMagic):]))}
+	if c.version < 1 || c.version > ContainerVersion {
+		return nil, fmt.Errorf("unsupported backup format version: %d", c.version)
+	}
+
+	headerData, err := readSection(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup header: %w", err)
+	}
+	if err := json.Unmarshal(headerData, &c.header); err != nil {
+		return nil, fmt.Errorf("failed to parse backup header: %w", err)
+	}
+	if c.version == 1 && len(c.header.Salt) != crypto.SaltLength {
+		return nil, fmt.Errorf("invalid salt length in backup header: %d", len(c.header.Salt))
+	}
+	c.aad = appendSection(prefix, headerData)
+	size := int64(len(c.aad))
+
+	if c.version >= 3 {
+		var index [containerIndexSize]byte
+		if _, err := io.ReadFull(r, index[:]); err != nil {
+			return nil, fmt.Errorf("failed to read backup header: %w", err)
+		}
+		c.sealedAt = int64(binary.BigEndian.Uint64(index[:]))
+		c.sealedLen = int(binary.BigEndian.Uint32(index[8:]))
+		if c.sealedAt == 0 {
+			return nil, fmt.Errorf("backup %s is incomplete: its metadata was never written", filepath.Base(path))
+		}
+		if c.sealedLen > maxContainerSection {
+			return nil, fmt.Errorf("failed to read backup metadata: section too large: %d bytes", c.sealedLen)
+		}
+		size += containerIndexSize
+	} else {
+		// The metadata block is skipped; Decrypt reads it
+		var length [4]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return nil, fmt.Errorf("failed to read backup metadata: %w", err)
+		}
+		c.sealedAt, c.sealedLen = size+4, int(binary.BigEndian.Uint32(length[:]))
+		if c.sealedLen > maxContainerSection {
+			return nil, fmt.Errorf("failed to read backup metadata: section too large: %d bytes", c.sealedLen)
+		}
+		size += 4 + int64(c.sealedLen)
+		if _, err := f.Seek(size, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to read backup file: %w", err)
+		}
+		r.Reset(f)
+	}
+
+	if c.version >= 2 {
+		var slotData []byte
+		if c.version >= 3 {
+			slotData, c.slotAreas, err = readAreas(r, size)
+			size += 4 + 2*int64(c.slotAreas.size)
+		} else {
+			slotData, err = readSection(r)
+			size += 4 + int64(len(slotData))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key slots: %w", err)
+		}
+		if err := json.Unmarshal(slotData, &c.slots); err != nil {
+			return nil, fmt.Errorf("failed to parse key slots: %w", err)
+		}
+	}
+
+	var labelData []byte
+	if c.version >= 3 {
+		labelData, c.labelAreas, err = readAreas(r, size)
+		size += 4 + 2*int64(c.labelAreas.size)
+	} else {
+		labelData, err = readSection(r)
+		size += 4 + int64(len(labelData))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup labels: %w", err)
+	}
+	if len(labelData) > 0 {
+		var section containerLabels
+		if err := json.Unmarshal(labelData, &section); err != nil {
+			return nil, fmt.Errorf("failed to parse backup labels: %w", err)
+		}
+		c.labels, c.labelsMAC = section.Labels, section.MAC
+	}
+	c.payload = size
+
+	if c.version >= 3 {
+		c.payloadLen = c.sealedAt - c.payload
+	} else {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup file: %w", err)
+		}
+		c.payloadLen = info.Size() - c.payload
+	}
+	if c.payloadLen < 0 {
+		return nil, fmt.Errorf("backup %s is truncated", filepath.Base(path))
+	}
+	return c, nil
+}
+
+// Metadata returns what the container holds in plaintext: the incremental
+// chain, the labels and, for version 1 backups, the key derivation
+// parameters and salt. The timestamp, sizes and compression are only known
+// after Decrypt.
+func (c *Container) Metadata() *crypto.EncryptionMetadata {
+	return &crypto.EncryptionMetadata{
+		Version:     crypto.StreamVersion,
+		Algorithm:   c.header.Algorithm,
+		KDF:         c.header.KDF,
+		Salt:        c.header.Salt,
+		KDFTime:     c.header.KDFTime,
+		KDFMemory:   c.header.KDFMemory,
+		KDFThreads:  c.header.KDFThreads,
+		ChunkSize:   c.header.ChunkSize,
+		Incremental: c.header.Incremental,
+		Parent:      c.header.Parent,
+		DependsOn:   c.header.DependsOn,
+		Tags:        c.labels.Tags,
+		Note:        c.labels.Note,
+		Pinned:      c.labels.Pinned,
+	}
+}
+
+// Labels returns the tags, note and pin of the backup as stored, without
+// checking them; see LabelsAuthentic
+func (c *Container) Labels() Labels {
+	return c.labels
+}
+
+// LabelsAuthentic reports whether the labels were set with the data key.
+// Labels edited without it, or written before labels were authenticated,
+// fail the check.
+func (c *Container) LabelsAuthentic(dataKey []byte) bool {
+	data, err := json.Marshal(c.labels)
+	return err == nil && crypto.VerifyMAC(dataKey, containerLabelsPurpose, data, c.labelsMAC)
+}
+
+// Slots returns the key slots of the backup; version 1 backups have none
+func (c *Container) Slots() []crypto.KeySlot {
+	return c.slots
+}
+
+// DataKey unwraps the data key of a backup with key slots from the master
+// key its slots open
+func (c *Container) DataKey(master []byte) ([]byte, error) {
+	if c.version < 2 {
+		return nil, fmt.Errorf("backup %s has no key slots", filepath.Base(c.path))
+	}
+	return crypto.OpenKey(master, c.header.WrappedKey)
+}
+
+// Unlock returns the data key of the backup: unwrapped through the key
+// slot that password (or a keyfile or recovery key) opens, or derived from
+// the password for version 1 backups
+func (c *Container) Unlock(password string) ([]byte, error) {
+	if c.version < 2 {
+		params := c.Metadata().KDFParams()
+		if err := params.Validate(); err != nil {
+			return nil, err
+		}
+		return crypto.DeriveKeyWithParams(password, c.header.Salt, params), nil
+	}
+
+	master, _, err := crypto.OpenKeySlots(c.slots, password)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong password?): %w", err)
+	}
+	return c.DataKey(master)
+}
+
+// Decrypt opens the metadata block with the data key and returns the
+// complete metadata and the manifest. It fails when the key is wrong or
+// the header was modified.
+func (c *Container) Decrypt(key []byte) (*crypto.EncryptionMetadata, *Manifest, error) {
+	if err := c.readSealed(); err != nil {
+		return nil, nil, err
+	}
+	aead, err := metadataAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(c.sealed) < aead.NonceSize() {
+		return nil, nil, fmt.Errorf("backup metadata is truncated")
+	}
+	nonce, sealed := c.sealed[:aead.NonceSize()], c.sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, sealed, c.aad)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decryption failed (wrong password or corrupted data): %w", err)
+	}
+
+	var inner containerMetadata
+	if err := json.Unmarshal(data, &inner); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse backup metadata: %w", err)
+	}
+	if inner.Manifest == nil {
+		return nil, nil, fmt.Errorf("backup metadata holds no manifest")
+	}
+	if inner.Manifest.Version != ManifestVersion {
+		return nil, nil, fmt.Errorf("unsupported manifest version: %d", inner.Manifest.Version)
+	}
+
+	metadata := c.Metadata()
+	metadata.Timestamp = inner.Timestamp
+	metadata.OriginalSize = inner.OriginalSize
+	metadata.Compression = inner.Compression
+	metadata.CompressionLevel = inner.CompressionLevel
+	return metadata, inner.Manifest, nil
+}
+
+// readSealed reads the metadata block from the file unless it was read
+// before
+func (c *Container) readSealed() error {
+	if c.sealed != nil {
+		return nil
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup file: %w", err)
+	}
+	defer f.Close()
+	sealed := make([]byte, c.sealedLen)
+	if _, err := f.ReadAt(sealed, c.sealedAt); err != nil {
+		return fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+	c.sealed = sealed
+	return nil
+}
+
+// OpenPayload returns a reader over the decrypted, still compressed tar
+// stream of the backup and the file to close once done
+func (c *Container) OpenPayload(key []byte) (io.Reader, io.Closer, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup file: %w", err)
+	}
+	r, err := crypto.NewDecryptReader(io.NewSectionReader(f, c.payload, c.payloadLen), key)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("decryption failed (wrong password?): %w", err)
+	}
+	return r, f, nil
+}
+
+// containerWriter writes a new single-file backup to a temp file next to
+// its path. The payload is written through it as it is encrypted; commit
+// seals the metadata behind it and moves the file into place, so that a
+// failed write leaves no partial backup behind.
+type containerWriter struct {
+	c    *Container
+	file *os.File
+	w    *bufio.Writer
+	// n counts the payload bytes written
+	n int64
+}
+
+// createContainer starts the single-file backup at path: the header built
+// from metadata with dataKey wrapped by the master key, the key slots and
+// the labels. The metadata block is sealed by commit.
+func createContainer(path string, metadata *crypto.EncryptionMetadata, keys *Keys, dataKey []byte) (*containerWriter, error) {
+	wrapped, err := crypto.SealKey(keys.Master, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	c := &Container{
+		path:    path,
+		version: ContainerVersion,
+		header: containerHeader{
+			Algorithm:   metadata.Algorithm,
+			WrappedKey:  wrapped,
+			ChunkSize:   metadata.ChunkSize,
+			Incremental: metadata.Incremental,
+			Parent:      metadata.Parent,
+			DependsOn:   metadata.DependsOn,
+		},
+		slots: keys.Slots,
+	}
+	if err := c.authenticateLabels(Labels{Tags: metadata.Tags, Note: metadata.Note, Pinned: metadata.Pinned}, dataKey); err != nil {
+		return nil, err
+	}
+	if err := c.encodeHeader(); err != nil {
+		return nil, err
+	}
+	head, err := c.head()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".dotkeeper-backup-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	cw := &containerWriter{c: c, file: file, w: bufio.NewWriter(file)}
+	if _, err := cw.w.Write(head); err != nil {
+		cw.abort()
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	c.payload = int64(len(head))
+	return cw, nil
+}
+
+// Write writes payload bytes
+func (cw *containerWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// commit seals metadata and manifest behind the payload, points the header
+// at them and moves the backup into place
+func (cw *containerWriter) commit(metadata *crypto.EncryptionMetadata, manifest *Manifest, dataKey []byte) error {
+	defer cw.abort()
+	c := cw.c
+	inner := containerMetadata{
+		Timestamp:        metadata.Timestamp,
+		OriginalSize:     metadata.OriginalSize,
+		Compression:      metadata.Compression,
+		CompressionLevel: metadata.CompressionLevel,
+		Manifest:         manifest,
+	}
+	if err := c.seal(inner, dataKey); err != nil {
+		return err
+	}
+	c.payloadLen = cw.n
+	c.sealedAt, c.sealedLen = c.payload+c.payloadLen, len(c.sealed)
+
+	if _, err := cw.w.Write(c.sealed); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := cw.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if _, err := cw.file.WriteAt(c.index(), int64(len(c.aad))); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := cw.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := cw.file.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(cw.file.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	cw.file = nil
+	return nil
+}
+
+// abort removes the temp file of a backup not committed
+func (cw *containerWriter) abort() {
+	if cw.file != nil {
+		cw.file.Close()
+		os.Remove(cw.file.Name())
+		cw.file = nil
+	}
+}
+
+// encodeHeader builds the magic, version and header bytes
+func (c *Container) encodeHeader() error {
+	headerData, err := json.Marshal(c.header)
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup header: %w", err)
+	}
+	prefix := append([]byte{}, containerMagic...)
+	prefix = binary.BigEndian.AppendUint16(prefix, uint16(c.version))
+	c.aad = appendSection(prefix, headerData)
+	return nil
+}
+
+// seal builds the header bytes and seals inner behind them with dataKey
+func (c *Container) seal(inner containerMetadata, dataKey []byte) error {
+	if err := c.encodeHeader(); err != nil {
+		return err
+	}
+	innerData, err := json.Marshal(inner)
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup metadata: %w", err)
+	}
+
+	aead, err := metadataAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	c.sealed = aead.Seal(nonce, nonce, innerData, c.aad)
+	return nil
+}
+
+// head returns the parts of the container ahead of the payload. The
+// metadata offset of version 3 is left as it stands; see index.
+func (c *Container) head() ([]byte, error) {
+	labelData, err := json.Marshal(containerLabels{Labels: c.labels, MAC: c.labelsMAC})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup labels: %w", err)
+	}
+
+	head := append([]byte{}, c.aad...)
+	if c.version >= 3 {
+		head = append(head, c.index()...)
+	} else {
+		head = appendSection(head, c.sealed)
+	}
+	if c.version >= 2 {
+		slotData, err := json.Marshal(c.slots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal key slots: %w", err)
+		}
+		if c.version >= 3 {
+			head = appendAreas(head, slotData, c.slotAreas.sizeFor(slotData, slotAreaSize))
+		} else {
+			head = appendSection(head, padSection(slotData, slotAreaSize))
+		}
+	}
+	if c.version >= 3 {
+		return appendAreas(head, labelData, c.labelAreas.sizeFor(labelData, labelAreaSize)), nil
+	}
+	return appendSection(head, labelData), nil
+}
+
+// index returns the offset and length of the metadata block
+func (c *Container) index() []byte {
+	index := binary.BigEndian.AppendUint64(nil, uint64(c.sealedAt))
+	return binary.BigEndian.AppendUint32(index, uint32(c.sealedLen))
+}
+
+// write writes the container as it now stands to w, copying its payload,
+// c.payloadLen bytes, from payload
+func (c *Container) write(w io.Writer, payload io.Reader) error {
+	head, err := c.head()
+	if err != nil {
+		return err
+	}
+	if c.version >= 3 {
+		c.sealedAt, c.sealedLen = int64(len(head))+c.payloadLen, len(c.sealed)
+		copy(head[len(c.aad):], c.index())
+	}
+	if _, err := w.Write(head); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if _, err := io.CopyN(w, payload, c.payloadLen); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if c.version >= 3 {
+		if _, err := w.Write(c.sealed); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// upgrade turns the container into one of the current version holding
+// keys' slots, and the metadata is sealed again behind the new header. The
+// data key of a version 1 backup, derived from the password, gets wrapped
+// by the master key. Nothing is written; see rewrite.
+func (c *Container) upgrade(dataKey []byte, keys *Keys) error {
+	metadata, manifest, err := c.Decrypt(dataKey)
+	if err != nil {
+		return err
+	}
+	if c.version < 2 {
+		wrapped, err := crypto.SealKey(keys.Master, dataKey)
+		if err != nil {
+			return fmt.Errorf("failed to wrap data key: %w", err)
+		}
+		c.header.WrappedKey = wrapped
+		c.header.KDF, c.header.Salt = "", nil
+		c.header.KDFTime, c.header.KDFMemory, c.header.KDFThreads = 0, 0, 0
+	}
+
+	c.version = ContainerVersion
+	c.slots = keys.Slots
+	return c.seal(containerMetadata{
+		Timestamp:        metadata.Timestamp,
+		OriginalSize:     metadata.OriginalSize,
+		Compression:      metadata.Compression,
+		CompressionLevel: metadata.CompressionLevel,
+		Manifest:         manifest,
+	}, dataKey)
+}
+
+// rewrite writes the container as it now stands to a temp file next to it,
+// copying the payload from the file and keeping its mode and modification
+// time, and returns the temp file's path. The caller moves it into place.
+func (c *Container) rewrite() (string, error) {
+	if err := c.readSealed(); err != nil {
+		return "", err
+	}
+	src, err := os.Open(c.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+	if _, err := src.Seek(c.payload, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(c.path), ".dotkeeper-backup-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	fail := func(err error) (string, error) {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return "", err
+	}
+
+	w := bufio.NewWriter(tempFile)
+	if err := c.write(w, src); err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(fmt.Errorf("failed to write backup: %w", err))
+	}
+	if err := tempFile.Chmod(info.Mode().Perm()); err != nil {
+		return fail(fmt.Errorf("failed to write backup: %w", err))
+	}
+	if err := tempFile.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to write backup: %w", err))
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Chtimes(tempPath, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	return tempPath, nil
+}
+
+// authenticateLabels sets the labels of the container and their MAC,
+// made with dataKey
+func (c *Container) authenticateLabels(labels Labels, dataKey []byte) error {
+	mac, err := labelsMAC(labels, dataKey)
+	if err != nil {
+		return err
+	}
+	c.labels, c.labelsMAC = labels, mac
+	return nil
+}
+
+// setLabels replaces the labels of the container, authenticated with
+// dataKey. They are written over the older labels area in place, like key
+// slots; backups of earlier versions and labels that outgrow their areas
+// take a replace.
+func (c *Container) setLabels(labels Labels, dataKey []byte) error {
+	if err := c.authenticateLabels(labels, dataKey); err != nil {
+		return err
+	}
+	data, err := json.Marshal(containerLabels{Labels: c.labels, MAC: c.labelsMAC})
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup labels: %w", err)
+	}
+	if c.version < 3 || !c.labelAreas.fits(data) {
+		return c.replace()
+	}
+	return c.overwrite(func(f *os.File) error { return c.labelAreas.write(f, data) })
+}
+
+// replace rewrites the container as it now stands and moves it into place
+func (c *Container) replace() error {
+	tempPath, err := c.rewrite()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, c.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// updateSlots writes slots over the older of the two key slots areas and
+// syncs the file, keeping its modification time, so that a write torn by
+// a crash leaves the backup with its previous slots. It returns false,
+// having written nothing, for backups of earlier versions and when the
+// slots do not fit an area; those are upgraded and replaced.
+func (c *Container) updateSlots(slots []crypto.KeySlot) (bool, error) {
+	data, err := json.Marshal(slots)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal key slots: %w", err)
+	}
+	if c.version < 3 || !c.slotAreas.fits(data) {
+		return false, nil
+	}
+	if err := c.overwrite(func(f *os.File) error { return c.slotAreas.write(f, data) }); err != nil {
+		return false, err
+	}
+	c.slots = slots
+	return true, nil
+}
+
+// overwrite opens the backup file for writing in place, applies write and
+// syncs the file, keeping its modification time
+func (c *Container) overwrite(write func(*os.File) error) error {
+	f, err := os.OpenFile(c.path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Chtimes(c.path, info.ModTime(), info.ModTime()); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// metadataAEAD returns the cipher sealing the metadata block of a backup
+func metadataAEAD(key []byte) (cipher.AEAD, error) {
+	subkey, err := crypto.DeriveSubkey(key, containerMetadataPurpose)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(subkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// appendSection appends data to b behind its 4-byte big endian length
+func appendSection(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// padSection pads JSON data with trailing spaces to size bytes; data
+// longer than size is returned as it is
+func padSection(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	return append(data, bytes.Repeat([]byte(" "), size-len(data))...)
+}
+
+// readSection reads a length-prefixed section
+func readSection(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxContainerSection {
+		return nil, fmt.Errorf("section too large: %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// areaPair locates a section held in two areas of the same size. Each area
+// holds a generation, the length of its data and a SHA-256 checksum of
+// both and the data; the newer area whose checksum holds is current.
+type areaPair struct {
+	// at is the offset of the first area
+	at   int64
+	size int
+	// current is the index of the current area
+	current    int
+	generation uint64
+}
+
+// fits reports whether data fits an area
+func (p areaPair) fits(data []byte) bool {
+	return len(data) <= p.size-areaHeaderSize
+}
+
+// write writes data over the area that is not current, with the next
+// generation; until the write is complete, the current area is read
+func (p *areaPair) write(f *os.File, data []byte) error {
+	next := 1 - p.current
+	if _, err := f.WriteAt(buildArea(p.generation+1, data, p.size), p.at+int64(next*p.size)); err != nil {
+		return err
+	}
+	p.current, p.generation = next, p.generation+1
+	return nil
+}
+
+// sizeFor returns the area size for data when the areas are written anew:
+// their size, or size for new areas, doubled until data fits
+func (p areaPair) sizeFor(data []byte, size int) int {
+	if p.size > 0 {
+		size = p.size
+	}
+	for len(data) > size-areaHeaderSize {
+		size *= 2
+	}
+	return size
+}
+
+// appendAreas appends data held in two areas of size bytes behind their
+// 4-byte big endian size; both areas start out holding it
+func appendAreas(b, data []byte, size int) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, buildArea(1, data, size)...)
+	return append(b, buildArea(0, data, size)...)
+}
+
+// buildArea returns an area of size bytes holding data with generation
+func buildArea(generation uint64, data []byte, size int) []byte {
+	area := make([]byte, 0, size)
+	area = binary.BigEndian.AppendUint64(area, generation)
+	area = binary.BigEndian.AppendUint32(area, uint32(len(data)))
+	area = append(area, areaChecksum(generation, data)...)
+	area = append(area, data...)
+	return area[:size]
+}
+
+// readAreas reads a section held in two areas, starting at offset at, and
+// returns the data of the current area
+func readAreas(r io.Reader, at int64) ([]byte, areaPair, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, areaPair{}, err
+	}
+	p := areaPair{at: at + 4, size: int(binary.BigEndian.Uint32(size[:])), current: -1}
+	if p.size < areaHeaderSize || p.size > maxContainerSection {
+		return nil, areaPair{}, fmt.Errorf("invalid area size: %d bytes", p.size)
+	}
+
+	var data []byte
+	area := make([]byte, p.size)
+	for i := range 2 {
+		if _, err := io.ReadFull(r, area); err != nil {
+			return nil, areaPair{}, err
+		}
+		generation := binary.BigEndian.Uint64(area)
+		n := int(binary.BigEndian.Uint32(area[8:]))
+		if n > p.size-areaHeaderSize {
+			continue
+		}
+		content := area[areaHeaderSize : areaHeaderSize+n]
+		if !bytes.Equal(area[12:areaHeaderSize], areaChecksum(generation, content)) {
+			continue
+		}
+		if p.current < 0 || generation > p.generation {
+			p.current, p.generation, data = i, generation, bytes.Clone(content)
+		}
+	}
+	if p.current < 0 {
+		return nil, areaPair{}, fmt.Errorf("both copies are damaged")
+	}
+	return data, p, nil
+}
+
+// areaChecksum returns the checksum of an area holding data with generation
+func areaChecksum(generation uint64, data []byte) []byte {
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint64(nil, generation))
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data))))
+	h.Write(data)
+	return h.Sum(nil)
+}
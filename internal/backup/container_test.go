@@ -0,0 +1,359 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/crypto"
+)
+
+// backupFile takes a backup of a single file and returns its result
+func backupFile(t *testing.T, tmpDir string) *BackupResult {
+	t.Helper()
+	file := filepath.Join(tmpDir, ".vimrc")
+	if err := os.WriteFile(file, []byte("set number"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Files: []string{file}}
+	result, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	return result
+}
+
+// splitContainer rewrites a single-file backup in the legacy layout: the
+// stream encrypted with a key derived from the password, a plaintext
+// metadata sidecar and a manifest sidecar
+func splitContainer(t *testing.T, path, password string) {
+	t.Helper()
+	c, err := OpenContainer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := c.Unlock(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, manifest, err := c.Decrypt(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, f, err := c.OpenPayload(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.DeriveKey(password, salt)
+	metadata.KDF, metadata.Salt = "Argon2id", salt
+	metadata.KDFTime, metadata.KDFMemory, metadata.KDFThreads = crypto.Argon2Time, crypto.Argon2Memory, crypto.Argon2Threads
+
+	var stream bytes.Buffer
+	w, err := crypto.NewEncryptWriter(&stream, key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, stream.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+MetadataExt, metadataJSON, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := crypto.Encrypt(manifestJSON, key, metadata.Salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ManifestPath(path), encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	result := backupFile(t, t.TempDir())
+
+	entries, err := os.ReadDir(filepath.Dir(result.BackupPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("backup directory holds %d files, want the backup alone", len(entries))
+	}
+
+	c, err := OpenContainer(result.BackupPath)
+	if err != nil {
+		t.Fatalf("OpenContainer failed: %v", err)
+	}
+	if slots := c.Slots(); len(slots) != 1 || slots[0].Type != crypto.SlotPassword {
+		t.Errorf("key slots = %+v, want one password slot", slots)
+	}
+	plain := c.Metadata()
+	if !plain.Timestamp.IsZero() || plain.OriginalSize != 0 || plain.Compression != "" {
+		t.Errorf("timestamp, size or compression readable without the password: %+v", plain)
+	}
+
+	key, err := c.Unlock("pw")
+	if err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	metadata, manifest, err := c.Decrypt(key)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if metadata.OriginalSize == 0 || metadata.Timestamp.IsZero() || metadata.Compression != "gzip" {
+		t.Errorf("metadata = %+v", metadata)
+	}
+	if len(manifest.Entries) != 1 {
+		t.Errorf("manifest holds %d entries, want 1", len(manifest.Entries))
+	}
+	if _, err := c.Unlock("wrong"); err == nil {
+		t.Error("expected the wrong password to fail")
+	}
+
+	// The header is authenticated with the metadata block
+	data, err := os.ReadFile(result.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte(`"algorithm":"AES-256-GCM"`), []byte(`"algorithm":"AES-256-GCX"`), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("header not found")
+	}
+	tamperedPath := filepath.Join(t.TempDir(), "tampered.tar.gz.enc")
+	if err := os.WriteFile(tamperedPath, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tc, err := OpenContainer(tamperedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tc.Decrypt(key); err == nil {
+		t.Error("expected a tampered header to fail authentication")
+	}
+
+	r, f, err := c.OpenPayload(key)
+	if err != nil {
+		t.Fatalf("OpenPayload failed: %v", err)
+	}
+	defer f.Close()
+	if payload, err := io.ReadAll(r); err != nil || int64(len(payload)) != metadata.OriginalSize {
+		t.Errorf("payload: %d bytes, err %v; want %d bytes", len(payload), err, metadata.OriginalSize)
+	}
+
+	// The metadata block ends the file, right behind the payload
+	if c.sealedAt != c.payload+c.payloadLen || c.sealedAt+int64(c.sealedLen) != int64(len(data)) {
+		t.Errorf("metadata at %d+%d, payload at %d+%d, file of %d bytes", c.sealedAt, c.sealedLen, c.payload, c.payloadLen, len(data))
+	}
+
+	// A backup whose metadata was never written does not open
+	incomplete := bytes.Clone(data)
+	copy(incomplete[len(c.aad):], make([]byte, containerIndexSize))
+	incompletePath := filepath.Join(t.TempDir(), "incomplete.tar.gz.enc")
+	if err := os.WriteFile(incompletePath, incomplete, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenContainer(incompletePath); err == nil {
+		t.Error("expected a backup without metadata to fail to open")
+	}
+}
+
+func TestContainer_Labels(t *testing.T) {
+	result := backupFile(t, t.TempDir())
+	before, err := os.Stat(result.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Resolve(filepath.Dir(result.BackupPath), result.BackupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Tag(b, "pw", []string{"laptop"}, "before the upgrade"); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	after, err := os.Stat(result.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) || after.Mode() != before.Mode() {
+		t.Errorf("relabelling changed mtime or mode: %v %v, was %v %v", after.ModTime(), after.Mode(), before.ModTime(), before.Mode())
+	}
+	// The labels were overwritten in place, without copying the file
+	if !os.SameFile(before, after) {
+		t.Error("relabelling replaced the backup file")
+	}
+
+	c, err := OpenContainer(result.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := c.Labels(); !slices.Equal(labels.Tags, []string{"laptop"}) || labels.Note != "before the upgrade" {
+		t.Errorf("labels = %+v", labels)
+	}
+	if _, err := ReadManifest(result.BackupPath, "pw"); err != nil {
+		t.Errorf("backup no longer decrypts after relabelling: %v", err)
+	}
+
+	// A note outgrowing the labels areas rewrites the backup with larger ones
+	note := strings.Repeat("long note ", labelAreaSize/10)
+	if _, err := Tag(b, "pw", nil, note); err != nil {
+		t.Fatalf("Tag with a long note failed: %v", err)
+	}
+	c, err = OpenContainer(result.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := c.Unlock("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Labels().Note != note || !c.LabelsAuthentic(key) {
+		t.Errorf("long note not stored: %d bytes, authentic %v", len(c.Labels().Note), c.LabelsAuthentic(key))
+	}
+	if _, err := ReadManifest(result.BackupPath, "pw"); err != nil {
+		t.Errorf("backup no longer decrypts after growing its labels: %v", err)
+	}
+}
+
+func TestList_LegacyLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	result := backupFile(t, tmpDir)
+	splitContainer(t, result.BackupPath, "pw")
+
+	backups, err := List(filepath.Dir(result.BackupPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != result.BackupName {
+		t.Fatalf("List = %+v, want %s", backups, result.BackupName)
+	}
+	if backups[0].OriginalSize == 0 {
+		t.Error("original size not read from the sidecar")
+	}
+	if IsContainer(result.BackupPath) {
+		t.Error("legacy archive detected as a single-file backup")
+	}
+	if _, err := ReadManifest(result.BackupPath, "pw"); err != nil {
+		t.Errorf("ReadManifest failed: %v", err)
+	}
+	if _, err := Tag(backups[0], "pw", []string{"old"}, ""); err != nil {
+		t.Errorf("Tag failed: %v", err)
+	}
+	if metadata, err := ReadMetadata(result.BackupPath); err != nil || !slices.Equal(metadata.Tags, []string{"old"}) {
+		t.Errorf("tag not stored in the sidecar: %+v, %v", metadata, err)
+	}
+	if _, err := Tag(backups[0], "wrong", []string{"old"}, ""); err == nil {
+		t.Error("expected tagging with a wrong password to fail")
+	}
+
+	// The sidecar labels carry a MAC made with the key the password derives
+	pinned, err := SetPinned(backups[0], "pw", true)
+	if err != nil {
+		t.Fatalf("SetPinned failed: %v", err)
+	}
+	if metadata, err := ReadMetadata(result.BackupPath); err != nil || !metadata.Pinned || len(metadata.LabelsMAC) == 0 {
+		t.Errorf("pin not stored in the sidecar: %+v, %v", metadata, err)
+	}
+	if _, err := Delete(pinned, "pw"); !errors.Is(err, ErrPinned) {
+		t.Errorf("Delete of a pinned legacy backup = %v, want ErrPinned", err)
+	}
+
+	// Unpinned without the password, the backup stays pinned
+	sidecar := result.BackupPath + MetadataExt
+	data, err := os.ReadFile(sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte(`"pinned": true`), []byte(`"pinned": false`), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("pin not found in the sidecar")
+	}
+	if err := os.WriteFile(sidecar, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := Resolve(filepath.Dir(result.BackupPath), result.BackupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Delete(b, "pw"); !errors.Is(err, ErrPinned) {
+		t.Errorf("Delete after tampering = %v, want ErrPinned", err)
+	}
+
+	if b, err = SetPinned(b, "pw", false); err != nil || b.Pinned {
+		t.Fatalf("SetPinned(false) = %+v, %v", b, err)
+	}
+	if _, err := Delete(b, "pw"); err != nil {
+		t.Errorf("Delete of the unpinned legacy backup failed: %v", err)
+	}
+}
+
+func TestList_SkipsMetadataBlock(t *testing.T) {
+	for _, version := range []int{ContainerVersion, 2} {
+		result := backupFile(t, t.TempDir())
+		resealContainer(t, result.BackupPath, "pw", func(c *Container) { c.version = version })
+		b, err := Resolve(filepath.Dir(result.BackupPath), result.BackupName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Tag(b, "pw", []string{"laptop"}, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		// Listings read the header, key slots and labels only: a damaged
+		// metadata block shows once the backup is decrypted
+		c, err := OpenContainer(result.BackupPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(result.BackupPath, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(make([]byte, c.sealedLen), c.sealedAt); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		backups, err := List(filepath.Dir(result.BackupPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) != 1 || !backups[0].HasTag("laptop") {
+			t.Fatalf("version %d: List = %+v, want the tagged backup", version, backups)
+		}
+		if slots, err := KeySlots(filepath.Dir(result.BackupPath)); err != nil || len(slots) != 1 {
+			t.Errorf("version %d: KeySlots = %+v, %v", version, slots, err)
+		}
+		if _, err := ReadManifest(result.BackupPath, "pw"); err == nil {
+			t.Errorf("version %d: expected the damaged metadata block to fail to decrypt", version)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/diogo/dotkeeper/internal/pathutil"
+)
+
+// incrementalPlan is the difference between the collected files and the
+// tree of the parent backup
+type incrementalPlan struct {
+	parent  string
+	changed []FileInfo
+	// unchanged maps paths to their parent entries, with Source pointing
+	// at the backup that holds the content
+	unchanged map[string]ManifestEntry
+	deleted   []string
+}
+
+// dependsOn returns the backups holding the content of unchanged files,
+// as the manifest built from the plan lists them
+func (p *incrementalPlan) dependsOn() []string {
+	m := &Manifest{}
+	for _, e := range p.unchanged {
+		m.Entries = append(m.Entries, e)
+	}
+	return m.DependsOn()
+}
+
+// planIncremental compares files with the manifest of the newest archive
+// backup. Files are unchanged when size and mtime match; when only the
+// mtime differs the content hash decides. It returns nil when there is no
+// parent with a manifest, in which case a full backup is made.
+func planIncremental(backupDir, password string, files []FileInfo) (*incrementalPlan, error) {
+	backups, err := List(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var parent *Info
+	for i := range backups {
+		if !backups[i].Snapshot {
+			parent = &backups[i]
+			break
+		}
+	}
+	if parent == nil {
+		return nil, nil
+	}
+
+	manifest, err := ReadManifest(parent.Path, password)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %s: %w", parent.Name, err)
+	}
+	return diffTree(parent.Name, manifest.Entries, files)
+}
+
+// treeIndex maps the entries of the backup named parent by archive name
+func treeIndex(parent string, entries []ManifestEntry) map[string]ManifestEntry {
+	previous := make(map[string]ManifestEntry, len(entries))
+	for _, e := range entries {
+		if e.Source == "" {
+			e.Source = parent
+		}
+		// Entries of backups made before logical roots still carry the
+		// absolute path, which is also the name in their archive
+		previous[pathutil.ToLogical(e.Path)] = e
+	}
+	return previous
+}
+
+// hashCandidates returns the Collector.Hash filter selecting the files the
+// comparison with entries decides by content, so that the collector's
+// workers hash them during the walk
+func hashCandidates(entries []ManifestEntry) func(FileInfo) bool {
+	if entries == nil {
+		return nil
+	}
+	previous := treeIndex("", entries)
+	return func(f FileInfo) bool {
+		prev, ok := previous[f.ArchiveName()]
+		return ok && needsHash(f, prev)
+	}
+}
+
+// needsHash reports whether a file is compared with its parent entry by
+// content: size and mode match but the mtime moved
+func needsHash(f FileInfo, prev ManifestEntry) bool {
+	return !unchanged(f, prev) && prev.LinkTarget == "" && f.LinkTarget == "" && !prev.Dir && !f.Dir &&
+		prev.Size == f.Size && prev.Mode == int64(f.Mode)
+}
+
+// diffTree compares files with the tree of the backup named parent
+func diffTree(parent string, entries []ManifestEntry, files []FileInfo) (*incrementalPlan, error) {
+	previous := treeIndex(parent, entries)
+
+	plan := &incrementalPlan{
+		parent:    parent,
+		unchanged: make(map[string]ManifestEntry),
+	}
+	// Files whose size and mode match but whose mtime moved are compared
+	// by content. The collector's workers hash them during the walk when
+	// given hashCandidates; the rest are hashed in parallel here.
+	var candidates []FileInfo
+	for _, f := range files {
+		if prev, ok := previous[f.ArchiveName()]; ok && needsHash(f, prev) && f.SHA256 == "" {
+			candidates = append(candidates, f)
+		}
+	}
+	sums, err := hashFiles(candidates)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.SHA256 != "" {
+			sums[f.Path] = f.SHA256
+		}
+	}
+
+	current := make(map[string]bool, len(files))
+	same := make([]bool, len(files))
+	for i, f := range files {
+		name := f.ArchiveName()
+		current[name] = true
+
+		prev, ok := previous[name]
+		same[i] = ok && unchanged(f, prev)
+		if sum, hashed := sums[f.Path]; hashed {
+			same[i] = sum == prev.SHA256
+		}
+	}
+	// A SQLite database and its logs are only consistent with each other,
+	// so they are stored together when any of them changed
+	for i, f := range files {
+		if !f.SQLite {
+			continue
+		}
+		group := i + 1
+		for group < len(files) && isSQLiteLog(files[group].Path, f.Path) {
+			group++
+		}
+		if slices.Contains(same[i:group], false) {
+			clear(same[i:group])
+		}
+	}
+
+	for i, f := range files {
+		if same[i] {
+			prev := previous[f.ArchiveName()]
+			prev.ModTime = f.ModTime
+			plan.unchanged[f.Path] = prev
+		} else {
+			plan.changed = append(plan.changed, f)
+		}
+	}
+
+	for path := range previous {
+		if !current[path] {
+			plan.deleted = append(plan.deleted, path)
+		}
+	}
+	sort.Strings(plan.deleted)
+
+	return plan, nil
+}
+
+// empty reports whether nothing changed since the parent
+func (p *incrementalPlan) empty() bool {
+	return len(p.changed) == 0 && len(p.deleted) == 0
+}
+
+// unchanged reports whether a file matches its entry in the parent manifest
+// by metadata alone. Directories are compared by mode only: their mtime
+// moves with every file created and removed in them.
+func unchanged(f FileInfo, prev ManifestEntry) bool {
+	if f.Dir || prev.Dir {
+		return f.Dir == prev.Dir && int64(f.Mode) == prev.Mode
+	}
+	if f.LinkTarget != "" || prev.LinkTarget != "" {
+		return f.LinkTarget == prev.LinkTarget && int64(f.Mode) == prev.Mode
+	}
+	return f.Size == prev.Size && f.ModTime == prev.ModTime && int64(f.Mode) == prev.Mode
+}
+
+// buildManifest describes the full tree: stored files as they were written
+// plus the unchanged entries carried over from the plan
+func buildManifest(files []FileInfo, written map[string]stored, plan *incrementalPlan) *Manifest {
+	manifest := &Manifest{
+		Version: ManifestVersion,
+		Entries: make([]ManifestEntry, 0, len(files)),
+	}
+	if plan != nil {
+		manifest.Parent = plan.parent
+		manifest.Deleted = plan.deleted
+	}
+
+	for _, f := range files {
+		if plan != nil {
+			if prev, ok := plan.unchanged[f.Path]; ok {
+				manifest.Entries = append(manifest.Entries, prev)
+				continue
+			}
+		}
+		entry := ManifestEntry{
+			Path:       f.ArchiveName(),
+			Size:       f.Size,
+			Mode:       int64(f.Mode),
+			ModTime:    f.ModTime,
+			LinkTarget: f.LinkTarget,
+			Dir:        f.Dir,
+		}
+		content, ok := written[f.Path]
+		if content.gone {
+			continue
+		}
+		if ok {
+			entry.Size = content.size
+			entry.ModTime = content.modTime
+			entry.SHA256 = content.sha256
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	return manifest
+}
+
+// hashFiles hashes files with a bounded pool of workers and returns the
+// hex digests by path
+func hashFiles(files []FileInfo) (map[string]string, error) {
+	sums := make([]string, len(files))
+	errs := make([]error, len(files))
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for range DefaultWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sums[i], errs[i] = hashFile(files[i].Path)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := make(map[string]string, len(files))
+	for i, f := range files {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", f.Path, errs[i])
+		}
+		result[f.Path] = sums[i]
+	}
+	return result, nil
+}
+
+// hashFile returns the hex SHA-256 of a file's content
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestBackup_Incremental(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupDir := filepath.Join(tmpDir, "backups")
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("same.txt", "unchanged")
+	write("touched.txt", "same content")
+	write("modified.txt", "before")
+	write("removed.txt", "going away")
+
+	cfg := &config.Config{BackupDir: backupDir, Folders: []string{src}}
+
+	// Incremental without any parent falls back to a full backup
+	full, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Incremental: true})
+	if err != nil {
+		t.Fatalf("full backup failed: %v", err)
+	}
+	if full.Incremental {
+		t.Error("first backup should be a full backup")
+	}
+	if _, err := ReadManifest(full.BackupPath, "pw"); err != nil {
+		t.Errorf("expected manifest in full backup: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	write("modified.txt", "after!")
+	write("added.txt", "new file")
+	if err := os.Remove(filepath.Join(src, "removed.txt")); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "touched.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	incr, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Incremental: true})
+	if err != nil {
+		t.Fatalf("incremental backup failed: %v", err)
+	}
+	if !incr.Incremental || incr.Parent != full.BackupName {
+		t.Errorf("expected incremental with parent %s, got %+v", full.BackupName, incr)
+	}
+	if incr.ChangedFiles != 2 {
+		t.Errorf("expected 2 changed files (modified + added), got %d", incr.ChangedFiles)
+	}
+	if incr.DeletedFiles != 1 {
+		t.Errorf("expected 1 deleted file, got %d", incr.DeletedFiles)
+	}
+	if incr.FileCount != 4 {
+		t.Errorf("expected the tree to hold 4 files, got %d", incr.FileCount)
+	}
+
+	metadata, err := ReadMetadata(incr.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.Incremental || metadata.Parent != full.BackupName {
+		t.Errorf("metadata does not record the parent: %+v", metadata)
+	}
+	if len(metadata.DependsOn) != 1 || metadata.DependsOn[0] != full.BackupName {
+		t.Errorf("DependsOn = %v, want [%s]", metadata.DependsOn, full.BackupName)
+	}
+
+	manifest, err := ReadManifest(incr.BackupPath, "pw")
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+	sources := make(map[string]string)
+	for _, e := range manifest.Entries {
+		sources[filepath.Base(e.Path)] = e.Source
+	}
+	want := map[string]string{
+		"same.txt":     full.BackupName,
+		"touched.txt":  full.BackupName,
+		"modified.txt": "",
+		"added.txt":    "",
+	}
+	for name, source := range want {
+		if got, ok := sources[name]; !ok || got != source {
+			t.Errorf("%s: source = %q (present %v), want %q", name, got, ok, source)
+		}
+	}
+	if len(manifest.Deleted) != 1 || filepath.Base(manifest.Deleted[0]) != "removed.txt" {
+		t.Errorf("Deleted = %v", manifest.Deleted)
+	}
+
+	if _, err := ReadManifest(incr.BackupPath, "wrong"); err == nil {
+		t.Error("expected wrong password to fail reading the manifest")
+	}
+}
+
+func TestDelete_RefusesBackupWithDependents(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupDir := filepath.Join(tmpDir, "backups")
+	file := filepath.Join(tmpDir, "file.txt")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{BackupDir: backupDir, Files: []string{file}}
+	full, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	incr, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Incremental: true, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := Resolve(backupDir, full.BackupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Delete(parent, "pw"); !errors.Is(err, ErrHasDependents) {
+		t.Fatalf("expected ErrHasDependents, got %v", err)
+	}
+
+	child, err := Resolve(backupDir, incr.BackupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Delete(child, "pw"); err != nil {
+		t.Fatalf("deleting the incremental failed: %v", err)
+	}
+	if _, err := os.Stat(incr.BackupPath); !os.IsNotExist(err) {
+		t.Error("expected the incremental backup to be deleted")
+	}
+	if _, err := Delete(parent, "pw"); err != nil {
+		t.Errorf("parent should be deletable once nothing depends on it: %v", err)
+	}
+}
+
+func TestBackup_IncrementalRepositoryMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file.txt")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Files: []string{file}, Repository: true}
+	if _, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Incremental: true}); err == nil {
+		t.Error("expected incremental to be rejected in repository mode")
+	}
+}
@@ -0,0 +1,296 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// Keys are the key slots of a backup directory and the master key they
+// wrap. Every backup in the directory carries the same slots; the master
+// key wraps each backup's own random data key and is the master key of a
+// repository created with it.
+type Keys struct {
+	Master []byte
+	Slots  []crypto.KeySlot
+	// opened is the index of the slot the keys were opened with
+	opened int
+}
+
+// KeyChange reports what a key change rewrote
+type KeyChange struct {
+	// Slot is the slot the change added, if any
+	Slot *crypto.KeySlot
+	// Slots are the key slots every updated backup now carries
+	Slots []crypto.KeySlot
+	// Updated names the backups rewritten with the new slots
+	Updated []string
+	// Skipped lists the backups left as they were
+	Skipped []SkippedBackup
+}
+
+// SkippedBackup names a backup a key change left alone and why
+type SkippedBackup struct {
+	Name   string
+	Reason string
+}
+
+// KeySlots returns the key slots of a backup directory: those of its
+// newest backup that has any, or of its repository. It returns nil when no
+// backup has key slots yet.
+func KeySlots(backupDir string) ([]crypto.KeySlot, error) {
+	backups, err := List(backupDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, b := range backups {
+		if b.Snapshot || !IsContainer(b.Path) {
+			continue
+		}
+		c, err := OpenContainer(b.Path)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Slots()) > 0 {
+			return c.Slots(), nil
+		}
+	}
+
+	config, err := repository.LoadConfig(repository.Dir(backupDir))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if config != nil {
+		return config.KeySlots, nil
+	}
+	return nil, nil
+}
+
+// loadKeys opens the key slots of backupDir with password. When no backup
+// has key slots yet, it creates a master key and a slot for password.
+func loadKeys(backupDir, password string) (*Keys, error) {
+	slots, err := KeySlots(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(slots) == 0 {
+		master, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		slot, err := crypto.NewKeySlot(crypto.SlotPassword, password, master)
+		if err != nil {
+			return nil, err
+		}
+		return &Keys{Master: master, Slots: []crypto.KeySlot{slot}}, nil
+	}
+
+	master, opened, err := crypto.OpenKeySlots(slots, password)
+	if err != nil {
+		return nil, fmt.Errorf("the password does not open the backups in %s: %w", backupDir, err)
+	}
+	return &Keys{Master: master, Slots: slots, opened: opened}, nil
+}
+
+// ChangePassword replaces the password slot that password opens with one
+// opened by newPassword. When password is a keyfile or recovery key, every
+// password slot is replaced, so a forgotten password can be reset.
+func ChangePassword(backupDir, password, newPassword string) (*KeyChange, error) {
+	return changeKeys(backupDir, password, func(keys *Keys) (*crypto.KeySlot, error) {
+		slot, err := crypto.NewKeySlot(crypto.SlotPassword, newPassword, keys.Master)
+		if err != nil {
+			return nil, err
+		}
+		opened := keys.Slots[keys.opened]
+		keys.Slots = slices.DeleteFunc(keys.Slots, func(s crypto.KeySlot) bool {
+			if opened.Type == crypto.SlotPassword {
+				return s.ID == opened.ID
+			}
+			return s.Type == crypto.SlotPassword
+		})
+		keys.Slots = append(keys.Slots, slot)
+		return &slot, nil
+	})
+}
+
+// AddKeySlot adds a slot of slotType opened by secret: a password, the
+// content of a keyfile or a recovery key
+func AddKeySlot(backupDir, password, slotType, secret string) (*KeyChange, error) {
+	return changeKeys(backupDir, password, func(keys *Keys) (*crypto.KeySlot, error) {
+		slot, err := crypto.NewKeySlot(slotType, secret, keys.Master)
+		if err != nil {
+			return nil, err
+		}
+		keys.Slots = append(keys.Slots, slot)
+		return &slot, nil
+	})
+}
+
+// RemoveKeySlot removes the slot with id. The last slot cannot be removed,
+// since nothing would open the backups any more.
+func RemoveKeySlot(backupDir, password, id string) (*KeyChange, error) {
+	return changeKeys(backupDir, password, func(keys *Keys) (*crypto.KeySlot, error) {
+		i := slices.IndexFunc(keys.Slots, func(s crypto.KeySlot) bool { return s.ID == id })
+		if i < 0 {
+			return nil, fmt.Errorf("no key slot with id %s", id)
+		}
+		if len(keys.Slots) == 1 {
+			return nil, fmt.Errorf("cannot remove the last key slot")
+		}
+		keys.Slots = slices.Delete(slices.Clone(keys.Slots), i, i+1)
+		return nil, nil
+	})
+}
+
+// changeKeys opens the keys of backupDir with password, applies update to
+// its slots and writes them to every backup, one at a time. Single-file
+// backups get the older of their two key slots areas overwritten in place;
+// those whose areas the slots do not fit are rewritten through a temp
+// file, like backups of earlier versions and those made before key slots
+// existed, which get their key wrapped by the master key without
+// re-encrypting their content, and the repository.
+//
+// Each backup is updated atomically: after a crash it holds either its old
+// slots or the new ones. The backup directory as a whole is not. The
+// change stops at the first backup that fails to update and returns what
+// it updated so far with the error; the backups it did not reach still
+// open with the old slots only.
+func changeKeys(backupDir, password string, update func(*Keys) (*crypto.KeySlot, error)) (*KeyChange, error) {
+	keys, err := loadKeys(backupDir, password)
+	if err != nil {
+		return nil, err
+	}
+	updated := &Keys{Master: keys.Master, Slots: slices.Clone(keys.Slots), opened: keys.opened}
+	slot, err := update(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := List(backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &KeyChange{Slot: slot, Slots: updated.Slots}
+	stop := func(name string, err error) (*KeyChange, error) {
+		if len(change.Updated) == 0 {
+			return nil, fmt.Errorf("failed to update %s: %w", name, err)
+		}
+		return change, fmt.Errorf("failed to update %s: %w (%d backup(s) already carry the new key slots; run the change again with them to update the rest)", name, err, len(change.Updated))
+	}
+
+	var snapshots []string
+	for _, b := range backups {
+		if b.Snapshot {
+			snapshots = append(snapshots, b.Name)
+			continue
+		}
+		if !IsContainer(b.Path) {
+			change.Skipped = append(change.Skipped, SkippedBackup{b.Name, "made before key slots; it still opens with the password it was made with"})
+			continue
+		}
+
+		c, err := OpenContainer(b.Path)
+		if err != nil {
+			return stop(b.Name, err)
+		}
+		if len(c.Slots()) > 0 {
+			dataKey, err := c.DataKey(keys.Master)
+			if err != nil {
+				change.Skipped = append(change.Skipped, SkippedBackup{b.Name, "its key slots open a different master key"})
+				continue
+			}
+			written, err := c.updateSlots(updated.Slots)
+			if err != nil {
+				return stop(b.Name, err)
+			}
+			if written {
+				change.Updated = append(change.Updated, b.Name)
+				continue
+			}
+			if err := c.upgrade(dataKey, updated); err != nil {
+				return stop(b.Name, err)
+			}
+		} else {
+			dataKey, err := c.Unlock(password)
+			if err == nil {
+				err = c.upgrade(dataKey, updated)
+			}
+			if err != nil {
+				change.Skipped = append(change.Skipped, SkippedBackup{b.Name, "the password does not open it"})
+				continue
+			}
+		}
+
+		if err := c.replace(); err != nil {
+			return stop(b.Name, err)
+		}
+		change.Updated = append(change.Updated, b.Name)
+	}
+
+	if len(snapshots) > 0 {
+		repo, err := repository.Open(repository.Dir(backupDir), password)
+		if err != nil {
+			for _, name := range snapshots {
+				change.Skipped = append(change.Skipped, SkippedBackup{name, "the repository does not open with the password"})
+			}
+		} else {
+			if err := writeRepositoryKeys(repo, updated); err != nil {
+				return stop("the repository", err)
+			}
+			change.Updated = append(change.Updated, snapshots...)
+		}
+	}
+
+	if len(change.Updated) == 0 {
+		return nil, fmt.Errorf("no backups in %s to store key slots in", backupDir)
+	}
+	return change, nil
+}
+
+// writeRepositoryKeys replaces the repository configuration with one
+// holding keys' slots, through a temp file
+func writeRepositoryKeys(repo *repository.Repository, keys *Keys) error {
+	data, err := repo.KeySlotConfig(keys.Slots, keys.Master)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(repo.Path(), ".dotkeeper-repo-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	fail := func(err error) error {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write repository config: %w", err)
+	}
+
+	if _, err := tempFile.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := tempFile.Chmod(0600); err != nil {
+		return fail(err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write repository config: %w", err)
+	}
+	if err := os.Rename(tempPath, repository.ConfigPath(repo.Path())); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write repository config: %w", err)
+	}
+	return nil
+}
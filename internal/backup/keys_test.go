@@ -0,0 +1,467 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// downgradeContainer rewrites a backup as a version 1 single-file backup,
+// whose data key is derived from the password
+func downgradeContainer(t *testing.T, path, password string) {
+	t.Helper()
+	c, err := OpenContainer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := c.Unlock(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, manifest, err := c.Decrypt(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, f, err := c.OpenPayload(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.DeriveKey(password, salt)
+	var payload bytes.Buffer
+	w, err := crypto.NewEncryptWriter(&payload, key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	v1 := &Container{
+		version: 1,
+		header: containerHeader{
+			Algorithm:  metadata.Algorithm,
+			KDF:        "Argon2id",
+			Salt:       salt,
+			KDFTime:    crypto.Argon2Time,
+			KDFMemory:  crypto.Argon2Memory,
+			KDFThreads: crypto.Argon2Threads,
+			ChunkSize:  metadata.ChunkSize,
+		},
+		labels:     c.Labels(),
+		payloadLen: int64(payload.Len()),
+	}
+	inner := containerMetadata{
+		Timestamp:    metadata.Timestamp,
+		OriginalSize: metadata.OriginalSize,
+		Compression:  metadata.Compression,
+		Manifest:     manifest,
+	}
+	if err := v1.seal(inner, key); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := v1.write(&out, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// backupTwice takes two backups of a file, changing it in between, and
+// returns their paths
+func backupTwice(t *testing.T, tmpDir string) (*config.Config, []string) {
+	t.Helper()
+	file := filepath.Join(tmpDir, ".vimrc")
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Files: []string{file}}
+
+	var paths []string
+	for i, content := range []string{"set number", "set number\nsyntax on"} {
+		if i > 0 {
+			time.Sleep(1100 * time.Millisecond)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		result, err := Backup(context.Background(), cfg, "pw")
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		paths = append(paths, result.BackupPath)
+	}
+	return cfg, paths
+}
+
+func TestChangePassword(t *testing.T) {
+	cfg, paths := backupTwice(t, t.TempDir())
+	before, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	beforeInfo, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change, err := ChangePassword(cfg.BackupDir, "pw", "new")
+	if err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	if len(change.Updated) != 2 || len(change.Skipped) != 0 {
+		t.Errorf("change = %+v, want both backups updated", change)
+	}
+
+	// The slots were overwritten in place, without copying the file
+	afterInfo, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(beforeInfo, afterInfo) || !afterInfo.ModTime().Equal(beforeInfo.ModTime()) {
+		t.Error("changing the password replaced the backup file or its modification time")
+	}
+
+	for _, path := range paths {
+		if _, err := ReadManifest(path, "new"); err != nil {
+			t.Errorf("%s does not open with the new password: %v", filepath.Base(path), err)
+		}
+		if _, err := ReadManifest(path, "pw"); err == nil {
+			t.Errorf("%s still opens with the old password", filepath.Base(path))
+		}
+	}
+
+	// Only the key slots changed: the payload is the same
+	after, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenContainer(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(before, after[c.payload:]) {
+		t.Error("changing the password re-encrypted the payload")
+	}
+
+	// New backups carry the new slots
+	if _, err := Backup(context.Background(), cfg, "pw"); err == nil {
+		t.Error("expected a backup with the old password to fail")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if err := os.WriteFile(cfg.Files[0], []byte("set nonumber"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Backup(context.Background(), cfg, "new")
+	if err != nil {
+		t.Fatalf("Backup with the new password failed: %v", err)
+	}
+	if _, err := ReadManifest(result.BackupPath, "new"); err != nil {
+		t.Errorf("new backup does not open with the new password: %v", err)
+	}
+}
+
+func TestAddAndRemoveKeySlot(t *testing.T) {
+	cfg, paths := backupTwice(t, t.TempDir())
+
+	recovery, err := crypto.GenerateRecoveryKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := AddKeySlot(cfg.BackupDir, "pw", crypto.SlotRecovery, recovery)
+	if err != nil {
+		t.Fatalf("AddKeySlot failed: %v", err)
+	}
+	if change.Slot == nil || change.Slot.Type != crypto.SlotRecovery || len(change.Slots) != 2 {
+		t.Fatalf("change = %+v", change)
+	}
+	typed := strings.ToLower(strings.ReplaceAll(recovery, "-", " "))
+	if _, err := ReadManifest(paths[0], typed); err != nil {
+		t.Errorf("backup does not open with the recovery key as typed: %v", err)
+	}
+
+	// A backup made afterwards with the password opens with the recovery key
+	time.Sleep(1100 * time.Millisecond)
+	if err := os.WriteFile(cfg.Files[0], []byte("set nonumber"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(result.BackupPath, recovery); err != nil {
+		t.Errorf("new backup does not open with the recovery key: %v", err)
+	}
+
+	// Reset a forgotten password with the recovery key
+	if _, err := ChangePassword(cfg.BackupDir, recovery, "new"); err != nil {
+		t.Fatalf("ChangePassword with the recovery key failed: %v", err)
+	}
+	if _, err := ReadManifest(paths[1], "pw"); err == nil {
+		t.Error("old password still opens the backups")
+	}
+	slots, err := KeySlots(cfg.BackupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 {
+		t.Fatalf("key slots = %+v, want a password and a recovery key", slots)
+	}
+
+	if _, err := RemoveKeySlot(cfg.BackupDir, "new", "nosuchid"); err == nil {
+		t.Error("expected an unknown slot id to fail")
+	}
+	if _, err := RemoveKeySlot(cfg.BackupDir, "new", change.Slot.ID); err != nil {
+		t.Fatalf("RemoveKeySlot failed: %v", err)
+	}
+	if _, err := ReadManifest(paths[0], recovery); err == nil {
+		t.Error("removed recovery key still opens the backups")
+	}
+	slots, err = KeySlots(cfg.BackupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveKeySlot(cfg.BackupDir, "new", slots[0].ID); err == nil || !strings.Contains(err.Error(), "last key slot") {
+		t.Errorf("expected removing the last slot to fail, got %v", err)
+	}
+	if _, err := AddKeySlot(cfg.BackupDir, "wrong", crypto.SlotPassword, "other"); err == nil {
+		t.Error("expected a wrong password to fail")
+	}
+}
+
+func TestChangePassword_UpgradesVersion1(t *testing.T) {
+	cfg, paths := backupTwice(t, t.TempDir())
+	downgradeContainer(t, paths[0], "pw")
+	if _, err := ReadManifest(paths[0], "pw"); err != nil {
+		t.Fatalf("version 1 backup does not open: %v", err)
+	}
+
+	change, err := ChangePassword(cfg.BackupDir, "pw", "new")
+	if err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	if len(change.Updated) != 2 {
+		t.Errorf("updated %v, want both backups", change.Updated)
+	}
+
+	c, err := OpenContainer(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.version != ContainerVersion || len(c.Slots()) != 1 {
+		t.Errorf("backup not upgraded: version %d, %d key slots", c.version, len(c.Slots()))
+	}
+	key, err := c.Unlock("new")
+	if err != nil {
+		t.Fatalf("upgraded backup does not open with the new password: %v", err)
+	}
+	r, f, err := c.OpenPayload(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Errorf("upgraded payload does not decrypt: %v", err)
+	}
+}
+
+// resealContainer applies change to the backup at path, seals its metadata
+// again and writes it back
+func resealContainer(t *testing.T, path, password string, change func(*Container)) {
+	t.Helper()
+	c, err := OpenContainer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := c.Unlock(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, manifest, err := c.Decrypt(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change(c)
+	inner := containerMetadata{
+		Timestamp:    metadata.Timestamp,
+		OriginalSize: metadata.OriginalSize,
+		Compression:  metadata.Compression,
+		Manifest:     manifest,
+	}
+	if err := c.seal(inner, dataKey); err != nil {
+		t.Fatal(err)
+	}
+	tempPath, err := c.rewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangePassword_OutgrownSlotAreas(t *testing.T) {
+	cfg, paths := backupTwice(t, t.TempDir())
+
+	// Areas that the slots fill have no room for another in place
+	var full int
+	resealContainer(t, paths[0], "pw", func(c *Container) {
+		data, err := json.Marshal(c.slots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full = len(data) + areaHeaderSize
+		c.slotAreas.size = full
+	})
+
+	recovery, err := crypto.GenerateRecoveryKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := AddKeySlot(cfg.BackupDir, "pw", crypto.SlotRecovery, recovery)
+	if err != nil {
+		t.Fatalf("AddKeySlot failed: %v", err)
+	}
+	if len(change.Updated) != 2 {
+		t.Errorf("updated %v, want both backups", change.Updated)
+	}
+
+	c, err := OpenContainer(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Slots()) != 2 || c.slotAreas.size <= full {
+		t.Errorf("rewritten backup has %d key slots in %d byte areas", len(c.Slots()), c.slotAreas.size)
+	}
+	if _, err := ReadManifest(paths[0], recovery); err != nil {
+		t.Errorf("rewritten backup does not open with the recovery key: %v", err)
+	}
+}
+
+func TestChangePassword_UpgradesVersion2(t *testing.T) {
+	cfg, paths := backupTwice(t, t.TempDir())
+	resealContainer(t, paths[0], "pw", func(c *Container) { c.version = 2 })
+	if c, err := OpenContainer(paths[0]); err != nil || c.version != 2 {
+		t.Fatalf("version 2 backup not written: %v", err)
+	}
+	if _, err := ReadManifest(paths[0], "pw"); err != nil {
+		t.Fatalf("version 2 backup does not open: %v", err)
+	}
+
+	if _, err := ChangePassword(cfg.BackupDir, "pw", "new"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	c, err := OpenContainer(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.version != ContainerVersion {
+		t.Errorf("backup not upgraded: version %d", c.version)
+	}
+	if _, err := ReadManifest(paths[0], "new"); err != nil {
+		t.Errorf("upgraded backup does not open with the new password: %v", err)
+	}
+}
+
+func TestChangePassword_TornSlotWrite(t *testing.T) {
+	cfg, paths := backupTwice(t, t.TempDir())
+	if _, err := ChangePassword(cfg.BackupDir, "pw", "new"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+
+	// Damage the area the change wrote, as a crash halfway through it would
+	c, err := OpenContainer(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(paths[0], os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := c.slotAreas.at + int64(c.slotAreas.current*c.slotAreas.size) + areaHeaderSize + 100
+	if _, err := f.WriteAt(make([]byte, 200), at); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err := ReadManifest(paths[0], "pw"); err != nil {
+		t.Errorf("backup with a torn slot write does not open with its previous slots: %v", err)
+	}
+	if _, err := ReadManifest(paths[0], "new"); err == nil {
+		t.Error("torn slots still read")
+	}
+}
+
+func TestChangePassword_SkipsLegacyLayout(t *testing.T) {
+	cfg, paths := backupTwice(t, t.TempDir())
+	splitContainer(t, paths[0], "pw")
+
+	change, err := ChangePassword(cfg.BackupDir, "pw", "new")
+	if err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	if len(change.Updated) != 1 || len(change.Skipped) != 1 || change.Skipped[0].Name != filepath.Base(paths[0]) {
+		t.Errorf("change = %+v, want the legacy backup skipped", change)
+	}
+	if _, err := ReadManifest(paths[0], "pw"); err != nil {
+		t.Errorf("legacy backup no longer opens with its password: %v", err)
+	}
+}
+
+func TestChangePassword_Repository(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, ".vimrc")
+	if err := os.WriteFile(file, []byte("set number"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Files: []string{file}, Repository: true}
+	dir := repository.Dir(cfg.BackupDir)
+
+	// A repository whose key is derived from the password
+	if _, err := repository.Init(dir, "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Backup(context.Background(), cfg, "pw"); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	change, err := ChangePassword(cfg.BackupDir, "pw", "new")
+	if err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	if len(change.Updated) != 1 {
+		t.Errorf("updated %v, want the snapshot", change.Updated)
+	}
+	if _, err := repository.Open(dir, "new"); err != nil {
+		t.Errorf("repository does not open with the new password: %v", err)
+	}
+	if _, err := repository.Open(dir, "pw"); err == nil {
+		t.Error("repository still opens with the old password")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if err := os.WriteFile(file, []byte("set number\nsyntax on"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Backup(context.Background(), cfg, "new"); err != nil {
+		t.Errorf("Backup with the new password failed: %v", err)
+	}
+}
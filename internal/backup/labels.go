@@ -0,0 +1,307 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// ErrPinned is returned when deleting a pinned backup
+var ErrPinned = errors.New("backup is pinned")
+
+// Labels are what the user attached to a backup to tell it apart from the
+// others. Pinned backups are kept: they cannot be deleted until unpinned.
+// Labels are stored in plaintext, so that listings need no password, next
+// to a MAC made with the backup's key; changing them takes the password.
+// Labels whose MAC does not verify were changed without it, or written
+// before labels were authenticated, and are taken as pinned until they are
+// set again with the password. Legacy archives whose sidecar has no MAC
+// predate it, and their labels are taken as listed.
+type Labels struct {
+	Tags   []string `json:"tags,omitempty"`
+	Note   string   `json:"note,omitempty"`
+	Pinned bool     `json:"pinned,omitempty"`
+}
+
+// HasTag reports whether the backup carries tag
+func (l Labels) HasTag(tag string) bool {
+	return slices.Contains(l.Tags, tag)
+}
+
+// ValidateTag reports whether tag can label a backup. Tags are listed
+// comma separated, so they hold neither commas nor whitespace.
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("tag must not be empty")
+	}
+	if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		return fmt.Errorf("invalid tag %q: tags cannot contain commas or whitespace", tag)
+	}
+	return nil
+}
+
+// FilterByTag returns the backups carrying tag, in their order
+func FilterByTag(backups []Info, tag string) []Info {
+	var tagged []Info
+	for _, b := range backups {
+		if b.HasTag(tag) {
+			tagged = append(tagged, b)
+		}
+	}
+	return tagged
+}
+
+// Tag adds tags to a backup and sets its note when note is not empty
+func Tag(b Info, password string, tags []string, note string) (Info, error) {
+	for _, tag := range tags {
+		if err := ValidateTag(tag); err != nil {
+			return b, err
+		}
+	}
+	return updateLabels(b, password, func(l *Labels) {
+		for _, tag := range tags {
+			if !l.HasTag(tag) {
+				l.Tags = append(l.Tags, tag)
+			}
+		}
+		if note != "" {
+			l.Note = note
+		}
+	})
+}
+
+// Untag removes tags from a backup; tags it does not carry are ignored
+func Untag(b Info, password string, tags []string) (Info, error) {
+	return updateLabels(b, password, func(l *Labels) {
+		l.Tags = slices.DeleteFunc(l.Tags, func(tag string) bool {
+			return slices.Contains(tags, tag)
+		})
+	})
+}
+
+// SetPinned pins or unpins a backup
+func SetPinned(b Info, password string, pinned bool) (Info, error) {
+	return updateLabels(b, password, func(l *Labels) {
+		l.Pinned = pinned
+	})
+}
+
+// updateLabels applies update to the labels of a backup and stores them
+// with the key password opens: in the labels block of a single-file
+// backup, the sidecar of a legacy archive or the index of a snapshot.
+// Labels that do not verify are updated from a pinned state. It returns
+// the backup with its new labels.
+func updateLabels(b Info, password string, update func(*Labels)) (Info, error) {
+	if b.Snapshot {
+		repo, err := repository.Open(repository.DirFromSnapshot(b.Path), password)
+		if err != nil {
+			return b, err
+		}
+		err = repo.UpdateSnapshotInfo(b.ID(), func(info *repository.SnapshotInfo) {
+			labels := Labels{Tags: info.Tags, Note: info.Note, Pinned: info.Pinned || !repo.LabelsAuthentic(*info)}
+			update(&labels)
+			info.Tags, info.Note, info.Pinned = labels.Tags, labels.Note, labels.Pinned
+			b.Labels = labels
+		})
+		return b, err
+	}
+
+	if IsContainer(b.Path) {
+		c, key, err := unlockContainer(b.Path, password)
+		if err != nil {
+			return b, err
+		}
+		labels := c.Labels()
+		labels.Pinned = labels.Pinned || !c.LabelsAuthentic(key)
+		update(&labels)
+		if err := c.setLabels(labels, key); err != nil {
+			return b, err
+		}
+		b.Labels = labels
+		return b, nil
+	}
+
+	metadata, key, err := unlockLegacy(b.Path, password)
+	if err != nil {
+		return b, err
+	}
+	labels := Labels{Tags: metadata.Tags, Note: metadata.Note, Pinned: metadata.Pinned}
+	labels.Pinned = labels.Pinned || !legacyLabelsAuthentic(metadata, key)
+	update(&labels)
+	mac, err := labelsMAC(labels, key)
+	if err != nil {
+		return b, err
+	}
+	metadata.Tags, metadata.Note, metadata.Pinned, metadata.LabelsMAC = labels.Tags, labels.Note, labels.Pinned, mac
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return b, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	if err := writeMetadata(b.Path+MetadataExt, data); err != nil {
+		return b, err
+	}
+	b.Labels = labels
+	return b, nil
+}
+
+// checkedLabels reads the labels of a backup and checks them with the key
+// password opens. ok is false when they do not verify. The labels of a
+// legacy archive whose sidecar has no MAC are taken as listed, and a
+// snapshot without an index has none.
+func checkedLabels(b Info, password string) (labels Labels, ok bool, err error) {
+	if b.Snapshot {
+		repo, err := repository.Open(repository.DirFromSnapshot(b.Path), password)
+		if err != nil {
+			return Labels{}, false, err
+		}
+		info, err := repo.ReadSnapshotInfo(b.ID())
+		if errors.Is(err, os.ErrNotExist) {
+			return Labels{}, true, nil
+		}
+		if err != nil {
+			return Labels{}, false, err
+		}
+		return Labels{Tags: info.Tags, Note: info.Note, Pinned: info.Pinned}, repo.LabelsAuthentic(*info), nil
+	}
+
+	if IsContainer(b.Path) {
+		c, key, err := unlockContainer(b.Path, password)
+		if err != nil {
+			return Labels{}, false, err
+		}
+		return c.Labels(), c.LabelsAuthentic(key), nil
+	}
+
+	data, err := os.ReadFile(b.Path + MetadataExt)
+	if err != nil {
+		return Labels{}, false, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	var metadata crypto.EncryptionMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil || len(metadata.LabelsMAC) == 0 {
+		return b.Labels, true, nil
+	}
+	_, key, err := unlockLegacy(b.Path, password)
+	if err != nil {
+		return Labels{}, false, err
+	}
+	return Labels{Tags: metadata.Tags, Note: metadata.Note, Pinned: metadata.Pinned}, legacyLabelsAuthentic(&metadata, key), nil
+}
+
+// unlockContainer opens the single-file backup at path and returns it with
+// its data key, checked against the sealed metadata
+func unlockContainer(path, password string) (*Container, []byte, error) {
+	c, err := OpenContainer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := c.Unlock(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, _, err := c.Decrypt(key); err != nil {
+		return nil, nil, err
+	}
+	return c, key, nil
+}
+
+// unlockLegacy reads the sidecar of the legacy archive at path and returns
+// it with the key derived from password and its salt, checked against the
+// manifest or, for archives without one, the start of the archive
+func unlockLegacy(path, password string) (*crypto.EncryptionMetadata, []byte, error) {
+	metadata, err := ReadMetadata(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	params := metadata.KDFParams()
+	if err := params.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if len(metadata.Salt) != crypto.SaltLength {
+		return nil, nil, fmt.Errorf("invalid salt length in metadata: %d", len(metadata.Salt))
+	}
+	key := crypto.DeriveKeyWithParams(password, metadata.Salt, params)
+
+	_, err = ReadManifestWithKey(path, key)
+	if errors.Is(err, os.ErrNotExist) {
+		err = checkStreamKey(path, key)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("decryption failed (wrong password?): %w", err)
+	}
+	return metadata, key, nil
+}
+
+// checkStreamKey decrypts the first chunk of the encrypted archive at path
+// with key
+func checkStreamKey(path string, key []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup file: %w", err)
+	}
+	defer f.Close()
+	r, err := crypto.NewDecryptReader(f, key)
+	if err != nil {
+		return err
+	}
+	_, err = r.Read(make([]byte, 1))
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// labelsMAC returns the MAC of labels made with key
+func labelsMAC(labels Labels, key []byte) ([]byte, error) {
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup labels: %w", err)
+	}
+	return crypto.MAC(key, containerLabelsPurpose, data)
+}
+
+// legacyLabelsAuthentic reports whether the labels of a legacy sidecar
+// were set with key. Sidecars without a MAC predate it and pass.
+func legacyLabelsAuthentic(metadata *crypto.EncryptionMetadata, key []byte) bool {
+	if len(metadata.LabelsMAC) == 0 {
+		return true
+	}
+	data, err := json.Marshal(Labels{Tags: metadata.Tags, Note: metadata.Note, Pinned: metadata.Pinned})
+	return err == nil && crypto.VerifyMAC(key, containerLabelsPurpose, data, metadata.LabelsMAC)
+}
+
+// writeMetadata replaces a metadata sidecar through a temp file, so that a
+// failed write never leaves the backup without one
+func writeMetadata(path string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".dotkeeper-meta-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := tempFile.Chmod(0644); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestLabels(t *testing.T) {
+	for _, repository := range []bool{false, true} {
+		tmpDir := t.TempDir()
+		file := filepath.Join(tmpDir, ".zshrc")
+		if err := os.WriteFile(file, []byte("export EDITOR=vim"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &config.Config{
+			BackupDir:  filepath.Join(tmpDir, "backups"),
+			Files:      []string{file},
+			Repository: repository,
+		}
+
+		result, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{
+			Tags: []string{"before-hyprland"},
+			Note: "last X11 setup",
+		})
+		if err != nil {
+			t.Fatalf("repository=%v: Backup failed: %v", repository, err)
+		}
+		b, err := Resolve(cfg.BackupDir, result.BackupName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(b.Tags, []string{"before-hyprland"}) || b.Note != "last X11 setup" || b.Pinned {
+			t.Errorf("repository=%v: labels = %+v", repository, b.Labels)
+		}
+
+		if _, err := Tag(b, "pw", []string{"x11", "before-hyprland"}, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Untag(b, "pw", []string{"before-hyprland"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SetPinned(b, "pw", true); err != nil {
+			t.Fatal(err)
+		}
+		backups, err := List(cfg.BackupDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FilterByTag(backups, "x11"); len(got) != 1 || !got[0].Pinned || got[0].Note != "last X11 setup" {
+			t.Errorf("repository=%v: tagged x11 = %+v", repository, got)
+		}
+		if got := FilterByTag(backups, "before-hyprland"); len(got) != 0 {
+			t.Errorf("repository=%v: untagged backup still listed: %+v", repository, got)
+		}
+
+		pinned, err := Resolve(cfg.BackupDir, result.BackupName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Delete(pinned, "pw"); !errors.Is(err, ErrPinned) {
+			t.Errorf("repository=%v: Delete of a pinned backup = %v, want ErrPinned", repository, err)
+		}
+		if _, err := os.Stat(pinned.Path); err != nil {
+			t.Errorf("repository=%v: pinned backup removed: %v", repository, err)
+		}
+
+		unpinned, err := SetPinned(pinned, "pw", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Delete(unpinned, "pw"); err != nil {
+			t.Errorf("repository=%v: Delete after unpinning: %v", repository, err)
+		}
+	}
+}
+
+func TestLabels_Tampered(t *testing.T) {
+	for _, repository := range []bool{false, true} {
+		tmpDir := t.TempDir()
+		file := filepath.Join(tmpDir, ".zshrc")
+		if err := os.WriteFile(file, []byte("export EDITOR=vim"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &config.Config{
+			BackupDir:  filepath.Join(tmpDir, "backups"),
+			Files:      []string{file},
+			Repository: repository,
+		}
+		result, err := Backup(context.Background(), cfg, "pw")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Resolve(cfg.BackupDir, result.BackupName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SetPinned(b, "pw", true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SetPinned(b, "wrong", false); err == nil {
+			t.Errorf("repository=%v: unpinned with a wrong password", repository)
+		}
+
+		// Unpin the backup without the password
+		if repository {
+			path := strings.TrimSuffix(b.Path, ".snap") + ".refs.json"
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = bytes.Replace(data, []byte(`"pinned": true`), []byte(`"pinned": false`), 1)
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			c, err := OpenContainer(b.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.labels = Labels{}
+			tempPath, err := c.rewrite()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(tempPath, b.Path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tampered, err := Resolve(cfg.BackupDir, result.BackupName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tampered.Pinned {
+			t.Fatalf("repository=%v: labels not changed by the test", repository)
+		}
+		if _, err := Delete(tampered, "wrong"); err == nil {
+			t.Errorf("repository=%v: Delete with a wrong password succeeded", repository)
+		}
+		if _, err := Delete(tampered, "pw"); !errors.Is(err, ErrPinned) {
+			t.Errorf("repository=%v: Delete after tampering = %v, want ErrPinned", repository, err)
+		}
+		if _, err := os.Stat(b.Path); err != nil {
+			t.Fatalf("repository=%v: tampered backup removed: %v", repository, err)
+		}
+
+		// Labels that do not verify stay pinned until set with the password
+		tagged, err := Tag(tampered, "pw", []string{"x11"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tagged.Pinned {
+			t.Errorf("repository=%v: tagging dropped the pin: %+v", repository, tagged.Labels)
+		}
+		unpinned, err := SetPinned(tagged, "pw", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Delete(unpinned, "pw"); err != nil {
+			t.Errorf("repository=%v: Delete after unpinning with the password: %v", repository, err)
+		}
+	}
+}
+
+func TestBackup_LabelsUnchanged(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, ".zshrc")
+	if err := os.WriteFile(file, []byte("export EDITOR=vim"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Files: []string{file}}
+
+	first, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changed, so the newest backup gets the labels
+	result, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Tags: []string{"known-good"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Unchanged || result.BackupName != first.BackupName {
+		t.Fatalf("expected no new backup, got %+v", result)
+	}
+	b, err := Resolve(cfg.BackupDir, first.BackupName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.HasTag("known-good") {
+		t.Errorf("newest backup not tagged: %+v", b.Labels)
+	}
+
+	if _, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Tags: []string{"a,b"}}); err == nil {
+		t.Error("expected an invalid tag to be refused")
+	}
+}
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+)
+
+// ManifestVersion is the format version of backup manifests
+const ManifestVersion = 1
+
+// ManifestExt is appended to a backup path to name its manifest sidecar
+const ManifestExt = ".manifest"
+
+// Manifest lists the complete tree a backup represents, including entries
+// whose content is held by an earlier backup in an incremental chain. It is
+// stored in the encrypted metadata block of a single-file backup, or in an
+// encrypted sidecar next to archives in the legacy layout.
+type Manifest struct {
+	Version int             `json:"version"`
+	Parent  string          `json:"parent,omitempty"`
+	Entries []ManifestEntry `json:"entries"`
+	// Deleted lists the paths present in the parent but gone since
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// ManifestEntry describes one file, symlink or directory in a backup's tree
+type ManifestEntry struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Mode       int64  `json:"mode"`
+	ModTime    int64  `json:"mtime"`
+	SHA256     string `json:"sha256,omitempty"`
+	LinkTarget string `json:"link_target,omitempty"`
+	Dir        bool   `json:"dir,omitempty"`
+	// Source names the backup holding the content; empty means this backup
+	Source string `json:"source,omitempty"`
+}
+
+// ManifestPath returns the manifest sidecar path of a legacy backup
+func ManifestPath(backupPath string) string {
+	return backupPath + ManifestExt
+}
+
+// DependsOn returns the other backups holding content of the manifest's tree
+func (m *Manifest) DependsOn() []string {
+	seen := make(map[string]bool)
+	var sources []string
+	for _, e := range m.Entries {
+		if e.Source != "" && !seen[e.Source] {
+			seen[e.Source] = true
+			sources = append(sources, e.Source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// ReadMetadata reads the plaintext metadata of a backup archive: the header
+// and labels of a single-file backup, whose timestamp, sizes and
+// compression stay encrypted, or the sidecar of a legacy archive
+func ReadMetadata(backupPath string) (*crypto.EncryptionMetadata, error) {
+	if IsContainer(backupPath) {
+		c, err := OpenContainer(backupPath)
+		if err != nil {
+			return nil, err
+		}
+		return c.Metadata(), nil
+	}
+
+	data, err := os.ReadFile(backupPath + MetadataExt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata file: %w", err)
+	}
+
+	var metadata crypto.EncryptionMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	return &metadata, nil
+}
+
+// ReadManifest decrypts the manifest of a backup archive. Backups made
+// before manifests existed return an error wrapping os.ErrNotExist.
+func ReadManifest(backupPath, password string) (*Manifest, error) {
+	if IsContainer(backupPath) {
+		c, err := OpenContainer(backupPath)
+		if err != nil {
+			return nil, err
+		}
+		key, err := c.Unlock(password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+		}
+		_, manifest, err := c.Decrypt(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+		}
+		return manifest, nil
+	}
+
+	metadata, err := ReadMetadata(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	params := metadata.KDFParams()
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return ReadManifestWithKey(backupPath, crypto.DeriveKeyWithParams(password, metadata.Salt, params))
+}
+
+// ReadManifestWithKey decrypts the manifest of a backup archive with its
+// data key: unwrapped through its key slots, or derived from the password
+// and the backup's salt for backups without them
+func ReadManifestWithKey(backupPath string, key []byte) (*Manifest, error) {
+	if IsContainer(backupPath) {
+		c, err := OpenContainer(backupPath)
+		if err != nil {
+			return nil, err
+		}
+		_, manifest, err := c.Decrypt(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+		}
+		return manifest, nil
+	}
+
+	encrypted, err := os.ReadFile(ManifestPath(backupPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	data, err := crypto.Decrypt(encrypted, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if manifest.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version: %d", manifest.Version)
+	}
+	return &manifest, nil
+}
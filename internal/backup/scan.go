@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/pathutil"
+)
+
+// scanMaxAge is how long Scan reuses an earlier walk of the same paths
+const scanMaxAge = 30 * time.Second
+
+// lastWalk remembers the most recent collection so the dashboard scanner
+// and the backup share a walk instead of each walking the tree
+var lastWalk struct {
+	sync.Mutex
+	key        string
+	at         time.Time
+	collection *Collection
+}
+
+// walkKey identifies a walk by its paths, exclude patterns and symlink
+// policies
+func walkKey(paths, exclude []string, symlinks config.SymlinkPolicies) string {
+	// fmt prints maps with sorted keys
+	return strings.Join(paths, "\x00") + "\x01" + strings.Join(exclude, "\x00") + "\x01" + fmt.Sprint(symlinks)
+}
+
+// collect walks paths for a backup, directories included, and remembers
+// the result for Scan. The files hash selects are hashed during the walk.
+func collect(paths, exclude []string, symlinks config.SymlinkPolicies, hash func(FileInfo) bool) *Collection {
+	collection := (&Collector{Exclude: exclude, Dirs: true, Symlinks: symlinks, Hash: hash}).Collect(paths)
+
+	lastWalk.Lock()
+	lastWalk.key = walkKey(paths, exclude, symlinks)
+	lastWalk.at = time.Now()
+	lastWalk.collection = collection
+	lastWalk.Unlock()
+
+	return collection
+}
+
+// Scan reports what a backup of files and folders would collect. A walk
+// of the same paths made in the last scanMaxAge, by a backup or an earlier
+// scan, is reused rather than walking the tree again.
+func Scan(files, folders, exclude []string, symlinks config.SymlinkPolicies) pathutil.ScanResult {
+	paths := append(append([]string{}, files...), folders...)
+	key := walkKey(paths, exclude, symlinks)
+
+	lastWalk.Lock()
+	collection := lastWalk.collection
+	if lastWalk.key != key || time.Since(lastWalk.at) > scanMaxAge {
+		collection = nil
+	}
+	lastWalk.Unlock()
+
+	if collection == nil {
+		collection = collect(paths, exclude, symlinks, nil)
+	}
+
+	result := pathutil.ScanResult{TotalFiles: countFiles(collection.Files)}
+	for _, f := range collection.Files {
+		result.TotalSize += f.Size
+	}
+	for _, root := range collection.Roots {
+		stat := pathutil.PathStat{
+			Path:   root.Path,
+			Exists: root.Exists,
+			IsDir:  root.IsDir,
+			Size:   root.Size,
+		}
+		if root.IsDir {
+			stat.FileCount = root.FileCount
+		}
+		if !root.Exists || root.Errors > 0 {
+			result.BrokenPaths = append(result.BrokenPaths, root.Path)
+		}
+		result.PathStats = append(result.PathStats, stat)
+	}
+	return result
+}
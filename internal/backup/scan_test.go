@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Create structure:
+	// tmpDir/
+	//   file1.txt (10 bytes)
+	//   folder1/
+	//     file2.txt (20 bytes)
+	//     file3.log (30 bytes)
+	//   folder2/ (empty)
+	//   file4.tmp (excluded)
+
+	file1 := filepath.Join(tmpDir, "file1.txt")
+	if err := os.WriteFile(file1, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	folder1 := filepath.Join(tmpDir, "folder1")
+	if err := os.Mkdir(folder1, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file2 := filepath.Join(folder1, "file2.txt")
+	if err := os.WriteFile(file2, make([]byte, 20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file3 := filepath.Join(folder1, "file3.log")
+	if err := os.WriteFile(file3, make([]byte, 30), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	folder2 := filepath.Join(tmpDir, "folder2")
+	if err := os.Mkdir(folder2, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	file4 := filepath.Join(folder1, "file4.tmp")
+	if err := os.WriteFile(file4, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{file1, filepath.Join(tmpDir, "nonexistent.txt")}
+	folders := []string{folder1, folder2, filepath.Join(tmpDir, "nonexistent_folder")}
+	exclude := []string{"*.tmp"}
+
+	result := Scan(files, folders, exclude, nil)
+
+	// file1(10) + file2(20) + file3(30) = 60 bytes
+	expectedSize := int64(60)
+	expectedFiles := 3
+	expectedBroken := 2 // nonexistent.txt + nonexistent_folder
+
+	if result.TotalSize != expectedSize {
+		t.Errorf("TotalSize: want %d, got %d", expectedSize, result.TotalSize)
+	}
+	if result.TotalFiles != expectedFiles {
+		t.Errorf("TotalFiles: want %d, got %d", expectedFiles, result.TotalFiles)
+	}
+	if len(result.BrokenPaths) != expectedBroken {
+		t.Errorf("BrokenPaths: want %d, got %d", expectedBroken, len(result.BrokenPaths))
+	}
+
+	// Verify stats for folder1
+	foundFolder1 := false
+	for _, stat := range result.PathStats {
+		if stat.Path == folder1 {
+			foundFolder1 = true
+			if stat.FileCount != 2 {
+				t.Errorf("Folder1 FileCount: want 2, got %d", stat.FileCount)
+			}
+			if stat.Size != 50 {
+				t.Errorf("Folder1 Size: want 50, got %d", stat.Size)
+			}
+		}
+	}
+	if !foundFolder1 {
+		t.Error("Folder1 stats not found")
+	}
+}
+
+func TestScan_ReusesRecentWalk(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "a.txt")
+	if err := os.WriteFile(file, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	collect([]string{file, tmpDir}, nil, nil, nil)
+
+	// A file added after the walk is not seen while the walk is fresh
+	if err := os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result := Scan([]string{file}, []string{tmpDir}, nil, nil)
+	if result.TotalFiles != 1 {
+		t.Errorf("expected the cached walk with 1 file, got %d", result.TotalFiles)
+	}
+
+	// Different paths walk again
+	result = Scan(nil, []string{tmpDir}, nil, nil)
+	if result.TotalFiles != 2 {
+		t.Errorf("expected a fresh walk with 2 files, got %d", result.TotalFiles)
+	}
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/diogo/dotkeeper/internal/secrets"
+)
+
+// FindSecrets scans the regular files among files with a bounded pool of
+// workers and returns the findings in file order. Files that cannot be
+// read are skipped with a warning. Captures are scanned and reported
+// under the name they are stored as, not their temporary file.
+func FindSecrets(files []FileInfo, scanner *secrets.Scanner) []secrets.Finding {
+	results := make([][]secrets.Finding, len(files))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, DefaultWorkers)
+	for i, f := range files {
+		if f.LinkTarget != "" || f.Dir {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			findings, err := scanFile(scanner, f)
+			if err != nil {
+				log.Printf("Warning: not scanned for secrets: %v", err)
+				return
+			}
+			results[i] = findings
+		}()
+	}
+	wg.Wait()
+
+	var all []secrets.Finding
+	for _, findings := range results {
+		all = append(all, findings...)
+	}
+	return all
+}
+
+// scanFile scans f under the path its findings are reported by
+func scanFile(scanner *secrets.Scanner, f FileInfo) ([]secrets.Finding, error) {
+	if f.Name == "" {
+		return scanner.ScanFile(f.Path)
+	}
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer file.Close()
+	return scanner.Scan(f.Name, file)
+}
+
+// secretPath is the path the findings of f are reported by: its own, or
+// for captures the name they are stored as
+func secretPath(f FileInfo) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Path
+}
+
+// ErrUnconfirmedSecrets is returned by backups that find files needing
+// confirmation without anyone to confirm them, when the secrets config
+// sets unattended to confirm
+var ErrUnconfirmedSecrets = errors.New("files with possible secrets need confirmation")
+
+// screenSecrets drops the files whose findings call for exclusion, and
+// those needing confirmation that confirm does not approve. Without a
+// confirm function nobody can approve, and unattended decides: Warn backs
+// those files up and lists them as unconfirmed, Exclude leaves them out
+// and Confirm fails with ErrUnconfirmedSecrets naming them. It returns the
+// files to back up, the paths left out and the paths backed up
+// unconfirmed.
+func screenSecrets(files []FileInfo, findings []secrets.Finding, confirm func(path string, findings []secrets.Finding) bool, unattended secrets.Action) ([]FileInfo, []string, []string, error) {
+	byPath := make(map[string][]secrets.Finding)
+	for _, f := range findings {
+		byPath[f.Path] = append(byPath[f.Path], f)
+	}
+
+	kept := make([]FileInfo, 0, len(files))
+	var excluded, unconfirmed []string
+	for _, f := range files {
+		path := secretPath(f)
+		found := byPath[path]
+		switch secrets.Strongest(found) {
+		case secrets.Exclude:
+			excluded = append(excluded, path)
+			continue
+		case secrets.Confirm:
+			if confirm == nil {
+				if unattended == secrets.Exclude {
+					excluded = append(excluded, path)
+					continue
+				}
+				unconfirmed = append(unconfirmed, path)
+				if unattended == secrets.Confirm {
+					continue
+				}
+				break
+			}
+			if !confirm(path, found) {
+				excluded = append(excluded, path)
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+	if len(unconfirmed) > 0 && unattended == secrets.Confirm {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrUnconfirmedSecrets, strings.Join(unconfirmed, ", "))
+	}
+	return kept, excluded, unconfirmed, nil
+}
@@ -0,0 +1,196 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/progress"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// backupToRepository stores files as a new snapshot in the repository inside
+// the backup directory. Only chunks the repository does not hold yet are
+// written. Each file is reported to t as it is read; reads stop once ctx is
+// done, leaving the chunks stored so far for a later snapshot to reuse.
+func backupToRepository(ctx context.Context, cfg *config.Config, password string, files []FileInfo, opts BackupOptions, start time.Time, t *progress.Tracker) (*BackupResult, error) {
+	repo, err := openRepository(cfg.BackupDir, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	snap := &repository.Snapshot{
+		Name:    fmt.Sprintf("backup-%s", start.Format(nameTimeLayout)),
+		Created: start,
+		Files:   make([]repository.File, 0, len(files)),
+		Tags:    opts.Tags,
+		Note:    opts.Note,
+	}
+
+	var totalSize, addedSize int64
+	var changed, databases []string
+	snapshots := newSQLiteSnapshots(files)
+	defer snapshots.close()
+	for _, f := range files {
+		entry := repository.File{
+			Path:       f.ArchiveName(),
+			Size:       f.Size,
+			Mode:       int64(f.Mode),
+			ModTime:    f.ModTime,
+			LinkTarget: f.LinkTarget,
+			Dir:        f.Dir,
+			Meta:       f.Meta,
+		}
+		if f.Dir {
+			snap.Files = append(snap.Files, entry)
+			continue
+		}
+
+		copied, state, err := snapshots.take(ctx, f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to repository: %w", f.Path, err)
+		}
+		if state == sqliteGone {
+			continue
+		}
+
+		t.Start(f.Path, f.Size)
+		if f.LinkTarget == "" {
+			var content stored
+			var chunks []string
+			var added int64
+			if copied != nil {
+				content, chunks, added, err = storeCopy(ctx, repo, copied, t)
+				content.changed = state == sqliteInconsistent
+				if f.SQLite && state == sqliteSnapshot {
+					databases = append(databases, f.Path)
+				}
+			} else {
+				content, chunks, added, err = storeFile(ctx, repo, f.Path, t)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to add %s to repository: %w", f.Path, err)
+			}
+			entry.Chunks = chunks
+			entry.SHA256 = content.sha256
+			entry.Size = content.size
+			entry.ModTime = content.modTime
+			addedSize += added
+			if content.changed {
+				changed = append(changed, f.Path)
+			}
+		}
+
+		snap.Files = append(snap.Files, entry)
+		totalSize += entry.Size
+		t.Done()
+	}
+
+	snapPath, checksum, err := repo.SaveSnapshot(snap, addedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BackupResult{
+		BackupPath:          snapPath,
+		BackupName:          snap.Name + repository.SnapshotExt,
+		FileCount:           countFiles(files),
+		TotalSize:           totalSize,
+		Duration:            time.Since(start),
+		Checksum:            checksum,
+		AddedSize:           addedSize,
+		ChangedDuringBackup: changed,
+		Snapshots:           databases,
+	}, nil
+}
+
+// storeCopy chunks the copy of a file taken with its SQLite database into
+// the repository and releases the copy
+func storeCopy(ctx context.Context, repo *repository.Repository, copied *sqliteFile, t *progress.Tracker) (stored, []string, int64, error) {
+	defer copied.close()
+	body, err := copied.content.reader()
+	if err != nil {
+		return stored{}, nil, 0, err
+	}
+	hasher := sha256.New()
+	chunks, added, err := repo.WriteFile(io.TeeReader(t.Reader(ctx, body), hasher))
+	if err != nil {
+		return stored{}, nil, 0, err
+	}
+	return stored{
+		sha256:  hex.EncodeToString(hasher.Sum(nil)),
+		size:    copied.content.Len(),
+		modTime: copied.info.ModTime().Unix(),
+	}, chunks, added, nil
+}
+
+// storeFile chunks a file into the repository and returns what was stored,
+// its chunk IDs and the number of bytes newly stored. As for archives, a
+// file that changes while it is read is read again, up to readAttempts
+// times; the chunks of earlier reads are left for garbage collection.
+func storeFile(ctx context.Context, repo *repository.Repository, path string, t *progress.Tracker) (stored, []string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return stored{}, nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	before, err := file.Stat()
+	if err != nil {
+		return stored{}, nil, 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	var added int64
+	for attempt := 1; ; attempt++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return stored{}, nil, 0, fmt.Errorf("failed to read file: %w", err)
+		}
+		hasher := sha256.New()
+		counter := &countingWriter{}
+		chunks, n, err := repo.WriteFile(io.TeeReader(t.Reader(ctx, file), io.MultiWriter(hasher, counter)))
+		if err != nil {
+			return stored{}, nil, 0, err
+		}
+		added += n
+
+		after, err := file.Stat()
+		if err != nil {
+			return stored{}, nil, 0, fmt.Errorf("failed to stat file: %w", err)
+		}
+		content := stored{
+			sha256:  hex.EncodeToString(hasher.Sum(nil)),
+			size:    counter.n,
+			modTime: after.ModTime().Unix(),
+		}
+		if counter.n == before.Size() && sameContent(before, after) {
+			return content, chunks, added, nil
+		}
+		if attempt == readAttempts {
+			content.changed = true
+			return content, chunks, added, nil
+		}
+		before = after
+	}
+}
+
+// openRepository opens the repository of backupDir, creating it on first
+// use with the key slots of the directory
+func openRepository(backupDir, password string) (*repository.Repository, error) {
+	dir := repository.Dir(backupDir)
+	repo, err := repository.Open(dir, password)
+	if !errors.Is(err, repository.ErrNotFound) {
+		return repo, err
+	}
+
+	keys, err := loadKeys(backupDir, password)
+	if err != nil {
+		return nil, err
+	}
+	return repository.InitWithKeys(dir, keys.Master, keys.Slots)
+}
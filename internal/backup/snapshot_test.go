@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+func TestBackup_RepositoryMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupDir := filepath.Join(tmpDir, "backups")
+
+	file1 := filepath.Join(tmpDir, "file1.txt")
+	file2 := filepath.Join(tmpDir, "file2.txt")
+	if err := os.WriteFile(file1, []byte(strings.Repeat("content1\n", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmpDir, "link")
+	if err := os.Symlink(file2, link); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		BackupDir:  backupDir,
+		Files:      []string{file1, file2, link},
+		Repository: true,
+	}
+
+	first, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if !repository.IsSnapshotPath(first.BackupPath) {
+		t.Errorf("expected a snapshot path, got %s", first.BackupPath)
+	}
+	if !strings.HasSuffix(first.BackupName, repository.SnapshotExt) {
+		t.Errorf("expected snapshot name, got %s", first.BackupName)
+	}
+	if first.FileCount != 3 || first.Checksum == "" {
+		t.Errorf("unexpected result: %+v", first)
+	}
+	if first.AddedSize == 0 {
+		t.Error("expected first snapshot to store data")
+	}
+
+	repo, err := repository.Open(repository.Dir(backupDir), "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := repo.LoadSnapshot(first.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range snap.Files {
+		if f.Path == link && (f.LinkTarget != file2 || len(f.Chunks) != 0) {
+			t.Errorf("symlink should be stored as a link, got %+v", f)
+		}
+	}
+
+	// No standalone archive should be written in repository mode
+	archives, _ := filepath.Glob(filepath.Join(backupDir, "*"+MetadataExt))
+	if len(archives) != 0 {
+		t.Errorf("expected no archives, got %v", archives)
+	}
+
+	// Snapshot names have one-second resolution
+	time.Sleep(1100 * time.Millisecond)
+
+	second, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatalf("second Backup failed: %v", err)
+	}
+	if second.AddedSize != 0 {
+		t.Errorf("expected unchanged files to add no data, added %d bytes", second.AddedSize)
+	}
+
+	if _, err := Backup(context.Background(), cfg, "wrong"); err == nil {
+		t.Error("expected a wrong password to be rejected by the existing repository")
+	}
+}
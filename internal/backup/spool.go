@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+)
+
+// spool holds a copy of a file's content while it is checked and stored:
+// in memory up to maxSpoolSize, and beyond that in a temporary file only
+// the current user can read. The file is encrypted with AES-CTR under a
+// key that lives in memory only, so a copy left behind by a killed process
+// cannot be read. The zero value is an empty spool; close removes the
+// temporary file.
+type spool struct {
+	mem  bytes.Buffer
+	file *os.File
+	// block holds the key of the file and iv the counter it starts from,
+	// drawn anew each time the file is reset
+	block  cipher.Block
+	iv     []byte
+	stream cipher.Stream
+	buf    []byte
+	size   int64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && int64(s.mem.Len()+len(p)) > maxSpoolSize {
+		if err := s.createFile(); err != nil {
+			return 0, err
+		}
+		if err := s.writeFile(s.mem.Bytes()); err != nil {
+			return 0, err
+		}
+		s.mem = bytes.Buffer{}
+	}
+
+	if s.file != nil {
+		if err := s.writeFile(p); err != nil {
+			return 0, err
+		}
+		s.size += int64(len(p))
+		return len(p), nil
+	}
+	n, err := s.mem.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+// createFile creates the temporary file and its key
+func (s *spool) createFile() error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+	f, err := os.CreateTemp("", "dotkeeper-spool-*")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	s.file, s.block = f, block
+	return s.restartStream()
+}
+
+// restartStream draws a new counter for the file, written from its start
+func (s *spool) restartStream() error {
+	s.iv = make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, s.iv); err != nil {
+		return fmt.Errorf("failed to generate spool counter: %w", err)
+	}
+	s.stream = cipher.NewCTR(s.block, s.iv)
+	return nil
+}
+
+// writeFile encrypts p to the file, a buffer at a time
+func (s *spool) writeFile(p []byte) error {
+	if s.buf == nil {
+		s.buf = make([]byte, 32<<10)
+	}
+	for len(p) > 0 {
+		n := min(len(p), len(s.buf))
+		s.stream.XORKeyStream(s.buf[:n], p[:n])
+		if _, err := s.file.Write(s.buf[:n]); err != nil {
+			return fmt.Errorf("failed to write spool file: %w", err)
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// Len returns the number of bytes held
+func (s *spool) Len() int64 {
+	return s.size
+}
+
+// reset empties the spool for another copy
+func (s *spool) reset() error {
+	s.mem.Reset()
+	s.size = 0
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to reset spool file: %w", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to reset spool file: %w", err)
+	}
+	return s.restartStream()
+}
+
+// reader returns a reader over the content held. It is valid until the
+// spool is written to, reset or closed.
+func (s *spool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return bytes.NewReader(s.mem.Bytes()), nil
+	}
+	return cipher.StreamReader{
+		S: cipher.NewCTR(s.block, s.iv),
+		R: io.NewSectionReader(s.file, 0, s.size),
+	}, nil
+}
+
+// close releases the spool and removes its temporary file
+func (s *spool) close() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+	s.mem = bytes.Buffer{}
+	s.block, s.iv, s.stream, s.buf = nil, nil, nil, nil
+	s.size = 0
+}
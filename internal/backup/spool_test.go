@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	var s spool
+	defer s.close()
+
+	small := []byte("set number\n")
+	if _, err := s.Write(small); err != nil {
+		t.Fatal(err)
+	}
+	if s.file != nil {
+		t.Fatal("small content should stay in memory")
+	}
+	checkSpool(t, &s, small)
+
+	// Outgrowing maxSpoolSize moves the content to a private temporary file
+	large := bytes.Repeat([]byte("0123456789abcdef"), maxSpoolSize/16+1)
+	if err := s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(large[:100]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(large[100:]); err != nil {
+		t.Fatal(err)
+	}
+	if s.file == nil {
+		t.Fatal("large content should move to a file")
+	}
+	info, err := os.Stat(s.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("spool file mode = %v, want 0600", info.Mode().Perm())
+	}
+	// The file holds the content encrypted
+	onDisk, err := os.ReadFile(s.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(onDisk)) != s.Len() || bytes.Contains(onDisk, large[:64]) {
+		t.Error("spool file holds the content in plaintext")
+	}
+	checkSpool(t, &s, large)
+
+	// A reset spool holds only what is written after it
+	if err := s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(small); err != nil {
+		t.Fatal(err)
+	}
+	checkSpool(t, &s, small)
+
+	name := s.file.Name()
+	s.close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spool file left behind: %v", err)
+	}
+}
+
+func checkSpool(t *testing.T, s *spool, want []byte) {
+	t.Helper()
+	if s.Len() != int64(len(want)) {
+		t.Errorf("Len() = %d, want %d", s.Len(), len(want))
+	}
+	r, err := s.reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("spool holds %d bytes, want %d", len(got), len(want))
+	}
+}
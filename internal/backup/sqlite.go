@@ -0,0 +1,329 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/diogo/dotkeeper/internal/fsmeta"
+	"github.com/diogo/dotkeeper/internal/pathutil"
+)
+
+// sqliteMagic starts every SQLite database file
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// sqliteLogs are the suffixes of the files holding changes not yet in a
+// database: the write-ahead log and the rollback journal. They are stored
+// with the database, read at the same instant.
+var sqliteLogs = []string{"-wal", "-journal"}
+
+// sqliteShm is the suffix of the shared-memory index of a write-ahead log.
+// SQLite rebuilds it from the log, so it is never stored.
+const sqliteShm = "-shm"
+
+// maxSQLiteSnapshot is the largest database, logs included, copied to take
+// a snapshot; as for other files, a copy is kept in memory only up to
+// maxSpoolSize and in a temporary file beyond it. Larger databases are
+// stored like any other file.
+const maxSQLiteSnapshot = 256 << 20
+
+// IsSQLite reports whether header is the start of a SQLite database
+func IsSQLite(header []byte) bool {
+	return bytes.Equal(header, sqliteMagic)
+}
+
+// markSQLite returns files with the SQLite databases flagged: those the
+// collector recognised by their header and those matching patterns. The
+// logs of each database are moved right behind it, and added when they
+// were not collected, since the database is not complete without them;
+// the shared-memory index is left out. files is not modified.
+func markSQLite(files []FileInfo, patterns []string) []FileInfo {
+	matcher := pathutil.NewMatcher(patterns)
+	databases := make(map[string]bool)
+	for _, f := range files {
+		if f.Dir || f.LinkTarget != "" || f.Name != "" {
+			continue
+		}
+		if f.SQLite || matcher.Excluded(f.Path, false) {
+			databases[f.Path] = true
+		}
+	}
+	if len(databases) == 0 {
+		return files
+	}
+
+	byPath := make(map[string]FileInfo, len(files))
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	marked := make([]FileInfo, 0, len(files))
+	for _, f := range files {
+		if db, ok := strings.CutSuffix(f.Path, sqliteShm); ok && databases[db] {
+			continue
+		}
+		if db, ok := sqliteDatabase(f.Path); ok && databases[db] {
+			continue
+		}
+		if !databases[f.Path] {
+			marked = append(marked, f)
+			continue
+		}
+		f.SQLite = true
+		marked = append(marked, f)
+		for _, suffix := range sqliteLogs {
+			path := f.Path + suffix
+			if log, ok := byPath[path]; ok {
+				marked = append(marked, log)
+			} else if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				marked = append(marked, FileInfo{
+					Path:    path,
+					Size:    info.Size(),
+					Mode:    info.Mode().Perm(),
+					ModTime: info.ModTime().Unix(),
+					Meta:    fsmeta.Read(path, info),
+				})
+			}
+		}
+	}
+	return marked
+}
+
+// sqliteDatabase returns the database a log belongs to by its name
+func sqliteDatabase(path string) (string, bool) {
+	for _, suffix := range sqliteLogs {
+		if db, ok := strings.CutSuffix(path, suffix); ok {
+			return db, true
+		}
+	}
+	return "", false
+}
+
+// isSQLiteLog reports whether path is a log of the database at db
+func isSQLiteLog(path, db string) bool {
+	owner, ok := sqliteDatabase(path)
+	return ok && owner == db
+}
+
+// sqliteFile is one file of a database snapshot. Its entry is sized by
+// the copy, should that differ from the stat taken before it.
+type sqliteFile struct {
+	content *spool
+	info    fs.FileInfo
+}
+
+// close releases the copy
+func (f *sqliteFile) close() {
+	if f != nil {
+		f.content.close()
+	}
+}
+
+// closeFiles releases the copies in files
+func closeFiles(files map[string]sqliteFile) {
+	for _, f := range files {
+		f.close()
+	}
+}
+
+// snapshotSQLite copies the database at path together with its log or
+// journal, keyed by path, and retries until none of them changed while
+// they were copied, so that the copies form a consistent database. When
+// they kept changing for readAttempts copies, the last ones are returned
+// with false. Databases larger than maxSQLiteSnapshot return nil and are
+// stored file by file. The caller closes the copies.
+func snapshotSQLite(ctx context.Context, path string) (map[string]sqliteFile, bool, error) {
+	paths := []string{path}
+	for _, suffix := range sqliteLogs {
+		paths = append(paths, path+suffix)
+	}
+
+	var files map[string]sqliteFile
+	for range readAttempts {
+		closeFiles(files)
+		files = nil
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		before, size, err := statFiles(paths)
+		if err != nil {
+			return nil, false, err
+		}
+		if size > maxSQLiteSnapshot {
+			return nil, false, nil
+		}
+
+		files = make(map[string]sqliteFile, len(before))
+		for p, info := range before {
+			content, err := copyFile(ctx, p, info)
+			if errors.Is(err, fs.ErrNotExist) {
+				// A journal is removed once its transaction is done
+				continue
+			}
+			if err != nil {
+				closeFiles(files)
+				return nil, false, fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			files[p] = sqliteFile{content: content, info: sizedInfo{info, content.Len()}}
+		}
+
+		after, _, err := statFiles(paths)
+		if err != nil {
+			closeFiles(files)
+			return nil, false, err
+		}
+		if sameFiles(before, after, files) {
+			return files, true, nil
+		}
+	}
+	if _, ok := files[path]; !ok {
+		closeFiles(files)
+		return nil, false, fmt.Errorf("failed to read %s: %w", path, fs.ErrNotExist)
+	}
+	return files, false, nil
+}
+
+// copyFile copies the file at path, last seen as info, into a new spool.
+// As for other files, growth while it is copied is bounded by maxSpoolSize.
+func copyFile(ctx context.Context, path string, info fs.FileInfo) (*spool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	content := &spool{}
+	limit := info.Size() + maxSpoolSize
+	if _, err := io.Copy(content, contextReader{ctx, io.LimitReader(file, limit)}); err != nil {
+		content.close()
+		return nil, err
+	}
+	return content, nil
+}
+
+// sqliteState says how the content stored for a file was read
+type sqliteState int
+
+const (
+	// sqliteNone is a file read on its own, as usual
+	sqliteNone sqliteState = iota
+	// sqliteSnapshot is part of a consistent database snapshot
+	sqliteSnapshot
+	// sqliteInconsistent was read with its database, but the files kept
+	// changing or a journal appeared that is not being stored
+	sqliteInconsistent
+	// sqliteGone is a log that was removed before its database was read,
+	// which happens when a transaction ends; it is left out
+	sqliteGone
+)
+
+// sqliteSnapshots hands out the copies of the databases among a list of
+// files, and of their logs, as the list is stored in order
+type sqliteSnapshots struct {
+	listed map[string]bool
+	// logs holds the copies of the logs of the databases read so far,
+	// with the state of their snapshot
+	logs map[string]sqliteLog
+}
+
+// sqliteLog is a log copied with its database
+type sqliteLog struct {
+	copy  *sqliteFile
+	state sqliteState
+}
+
+func newSQLiteSnapshots(files []FileInfo) *sqliteSnapshots {
+	listed := make(map[string]bool, len(files))
+	for _, f := range files {
+		listed[f.Path] = true
+	}
+	return &sqliteSnapshots{listed: listed, logs: make(map[string]sqliteLog)}
+}
+
+// close releases the copies of logs that were never taken, as when the
+// backup stopped early
+func (s *sqliteSnapshots) close() {
+	for path, log := range s.logs {
+		log.copy.close()
+		delete(s.logs, path)
+	}
+}
+
+// take returns the copy to store for f, reading a database and its logs
+// together when f is a database. A nil copy means f is read as usual.
+// Logs follow their database in the list; see markSQLite. The caller
+// closes the copy once it is stored.
+func (s *sqliteSnapshots) take(ctx context.Context, f FileInfo) (*sqliteFile, sqliteState, error) {
+	if log, ok := s.logs[f.Path]; ok {
+		delete(s.logs, f.Path)
+		return log.copy, log.state, nil
+	}
+	if !f.SQLite {
+		return nil, sqliteNone, nil
+	}
+
+	files, ok, err := snapshotSQLite(ctx, f.Path)
+	if err != nil || files == nil {
+		return nil, sqliteNone, err
+	}
+	state := sqliteSnapshot
+	for path := range files {
+		if !ok || !s.listed[path] {
+			state = sqliteInconsistent
+		}
+	}
+	for _, suffix := range sqliteLogs {
+		path := f.Path + suffix
+		if !s.listed[path] {
+			continue
+		}
+		if copied, found := files[path]; found {
+			s.logs[path] = sqliteLog{copy: &copied, state: state}
+			delete(files, path)
+		} else {
+			s.logs[path] = sqliteLog{state: sqliteGone}
+		}
+	}
+	db := files[f.Path]
+	delete(files, f.Path)
+	// Logs that are not being stored are not kept
+	closeFiles(files)
+	return &db, state, nil
+}
+
+// statFiles stats the paths that exist and returns their combined size
+func statFiles(paths []string) (map[string]fs.FileInfo, int64, error) {
+	infos := make(map[string]fs.FileInfo, len(paths))
+	var size int64
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to stat %s: %w", p, err)
+		}
+		infos[p] = info
+		size += info.Size()
+	}
+	return infos, size, nil
+}
+
+// sameFiles reports whether the same files exist before and after reading
+// them, unchanged and read whole
+func sameFiles(before, after map[string]fs.FileInfo, files map[string]sqliteFile) bool {
+	if len(before) != len(after) || len(files) != len(before) {
+		return false
+	}
+	for p, info := range before {
+		later, ok := after[p]
+		if !ok || !sameContent(info, later) || files[p].content.Len() != info.Size() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/compression"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/progress"
+)
+
+// writeSQLite writes a file starting with the SQLite header
+func writeSQLite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, append(slices.Clone(sqliteMagic), content...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarkSQLite(t *testing.T) {
+	tmpDir := t.TempDir()
+	db := filepath.Join(tmpDir, "history.db")
+	writeSQLite(t, db, "pages")
+	for name, content := range map[string]string{
+		"history.db-wal": "frames",
+		"history.db-shm": "index",
+		"other.txt":      "plain",
+		"cache.sqlite":   "no header",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The log is excluded and sorts before the database; it is still
+	// stored, right behind it
+	collected := (&Collector{Exclude: []string{"*-wal"}}).Collect([]string{tmpDir}).Files
+	files := markSQLite(collected, []string{"*.sqlite"})
+
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f.Path))
+	}
+	want := []string{"cache.sqlite", "history.db", "history.db-wal", "other.txt"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+	for _, f := range files {
+		wantSQLite := f.Path == db || filepath.Base(f.Path) == "cache.sqlite"
+		if f.SQLite != wantSQLite {
+			t.Errorf("%s: SQLite = %v, want %v", f.Path, f.SQLite, wantSQLite)
+		}
+	}
+	for _, f := range collected {
+		if f.Path == filepath.Join(tmpDir, "cache.sqlite") && f.SQLite {
+			t.Error("markSQLite modified the collected files")
+		}
+	}
+}
+
+func TestWriteArchive_SQLiteSnapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+	db := filepath.Join(tmpDir, "places.db")
+	writeSQLite(t, db, "pages")
+	if err := os.WriteFile(db+"-wal", []byte("frames"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gone := filepath.Join(tmpDir, "cookies.db")
+	writeSQLite(t, gone, "pages")
+	if err := os.WriteFile(gone+"-journal", []byte("rollback"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := markSQLite((&Collector{}).Collect([]string{tmpDir}).Files, nil)
+	// The transaction ends between collection and backup
+	if err := os.Remove(gone + "-journal"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	written, err := writeArchive(context.Background(), files, &buf, compression.Setting{Algorithm: compression.Gzip}, progress.NewTracker("", nil))
+	if err != nil {
+		t.Fatalf("writeArchive failed: %v", err)
+	}
+
+	entries := readArchive(t, &buf)
+	if entries[db+"-wal"] != "frames" {
+		t.Errorf("log stored as %q", entries[db+"-wal"])
+	}
+	if _, ok := entries[gone+"-journal"]; ok {
+		t.Error("journal removed before the backup was stored")
+	}
+	if !written[db].snapshot || !written[db+"-wal"].snapshot || !written[gone].snapshot {
+		t.Errorf("databases not stored as snapshots: %+v", written)
+	}
+	if !written[gone+"-journal"].gone {
+		t.Error("removed journal not recorded as gone")
+	}
+
+	manifest := buildManifest(files, written, nil)
+	for _, e := range manifest.Entries {
+		if e.Path == gone+"-journal" {
+			t.Error("removed journal listed in the manifest")
+		}
+	}
+}
+
+func TestWriteArchive_SQLiteJournalNotListed(t *testing.T) {
+	tmpDir := t.TempDir()
+	db := filepath.Join(tmpDir, "state.db")
+	writeSQLite(t, db, "pages")
+
+	files := markSQLite((&Collector{}).Collect([]string{tmpDir}).Files, nil)
+	// A transaction starts after collection; the database alone is not
+	// consistent without its journal
+	if err := os.WriteFile(db+"-journal", []byte("rollback"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	written, err := writeArchive(context.Background(), files, &buf, compression.Setting{Algorithm: compression.Gzip}, progress.NewTracker("", nil))
+	if err != nil {
+		t.Fatalf("writeArchive failed: %v", err)
+	}
+	if written[db].snapshot || !written[db].changed {
+		t.Errorf("database stored as %+v, want changed and no snapshot", written[db])
+	}
+}
+
+func TestSnapshotSQLite_Spooled(t *testing.T) {
+	tmpDir := t.TempDir()
+	db := filepath.Join(tmpDir, "places.sqlite")
+	pages := strings.Repeat("p", maxSpoolSize+1<<20)
+	writeSQLite(t, db, pages)
+	if err := os.WriteFile(db+"-wal", []byte("frames"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, ok, err := snapshotSQLite(context.Background(), db)
+	if err != nil || !ok {
+		t.Fatalf("snapshotSQLite() = %v, %v", ok, err)
+	}
+	defer closeFiles(files)
+
+	// The database outgrows memory and is copied to a private file; the
+	// log stays in memory
+	spooled := files[db].content.file
+	if spooled == nil || files[db+"-wal"].content.file != nil {
+		t.Fatal("want the database spooled to a file and the log in memory")
+	}
+	if info, err := os.Stat(spooled.Name()); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("spool file: %v, %v", info, err)
+	}
+	checkSpool(t, files[db].content, append(slices.Clone(sqliteMagic), pages...))
+	checkSpool(t, files[db+"-wal"].content, []byte("frames"))
+
+	closeFiles(files)
+	if _, err := os.Stat(spooled.Name()); !os.IsNotExist(err) {
+		t.Errorf("spool file left behind: %v", err)
+	}
+}
+
+func TestDiffTree_SQLiteStoredTogether(t *testing.T) {
+	tmpDir := t.TempDir()
+	db := filepath.Join(tmpDir, "app.db")
+	writeSQLite(t, db, "pages")
+	if err := os.WriteFile(db+"-wal", []byte("frames"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := markSQLite((&Collector{}).Collect([]string{tmpDir}).Files, nil)
+
+	var entries []ManifestEntry
+	for _, f := range files {
+		entries = append(entries, ManifestEntry{Path: f.ArchiveName(), Size: f.Size, Mode: int64(f.Mode), ModTime: f.ModTime})
+	}
+	// Only the log changed since the parent
+	entries[1].Size++
+
+	plan, err := diffTree("parent", entries, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.changed) != 2 || len(plan.unchanged) != 0 {
+		t.Errorf("changed %d, unchanged %d; want the database stored with its log", len(plan.changed), len(plan.unchanged))
+	}
+}
+
+func TestBackup_SQLiteSnapshots(t *testing.T) {
+	for _, repository := range []bool{false, true} {
+		tmpDir := t.TempDir()
+		src := filepath.Join(tmpDir, "src")
+		if err := os.MkdirAll(src, 0755); err != nil {
+			t.Fatal(err)
+		}
+		db := filepath.Join(src, "history.db")
+		writeSQLite(t, db, "pages")
+		if err := os.WriteFile(db+"-wal", []byte("frames"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(db+"-shm", []byte("index"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := &config.Config{
+			BackupDir:  filepath.Join(tmpDir, "backups"),
+			Folders:    []string{src},
+			Repository: repository,
+		}
+		result, err := Backup(context.Background(), cfg, "pw")
+		if err != nil {
+			t.Fatalf("repository=%v: Backup failed: %v", repository, err)
+		}
+		if !slices.Equal(result.Snapshots, []string{db}) {
+			t.Errorf("repository=%v: Snapshots = %v, want %v", repository, result.Snapshots, []string{db})
+		}
+		if result.FileCount != 2 {
+			t.Errorf("repository=%v: FileCount = %d, want the database and its log", repository, result.FileCount)
+		}
+	}
+}
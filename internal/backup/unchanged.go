@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"errors"
+	"os"
+
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// latestTree returns the newest backup, archive or snapshot, and the tree
+// it represents. It returns nil when there is no backup yet, or when the
+// newest one cannot be compared because it predates manifests or cannot be
+// read; a damaged backup is all the more reason to write a new one.
+func latestTree(backupDir, password string) (*Info, []ManifestEntry, error) {
+	backups, err := List(backupDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if len(backups) == 0 {
+		return nil, nil, nil
+	}
+	latest := backups[0]
+
+	entries, err := treeOf(latest, password)
+	if err != nil {
+		return nil, nil, nil
+	}
+	return &latest, entries, nil
+}
+
+// treeOf returns the entries of the tree a backup represents
+func treeOf(b Info, password string) ([]ManifestEntry, error) {
+	if !b.Snapshot {
+		manifest, err := ReadManifest(b.Path, password)
+		if err != nil {
+			return nil, err
+		}
+		return manifest.Entries, nil
+	}
+
+	repo, err := repository.Open(repository.DirFromSnapshot(b.Path), password)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := repo.LoadSnapshot(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ManifestEntry, 0, len(snap.Files))
+	for _, f := range snap.Files {
+		entries = append(entries, ManifestEntry{
+			Path:       f.Path,
+			Size:       f.Size,
+			Mode:       f.Mode,
+			ModTime:    f.ModTime,
+			SHA256:     f.SHA256,
+			LinkTarget: f.LinkTarget,
+			Dir:        f.Dir,
+		})
+	}
+	return entries, nil
+}
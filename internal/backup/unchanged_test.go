@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestBackup_SkipsUnchanged(t *testing.T) {
+	for _, repositoryMode := range []bool{false, true} {
+		name := "archive"
+		if repositoryMode {
+			name = "repository"
+		}
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			backupDir := filepath.Join(tmpDir, "backups")
+			file := filepath.Join(tmpDir, "file.txt")
+			if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{BackupDir: backupDir, Files: []string{file}, Repository: repositoryMode}
+
+			first, err := Backup(context.Background(), cfg, "pw")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.Unchanged {
+				t.Fatal("first backup should not be unchanged")
+			}
+
+			// A new mtime with the same content is still unchanged
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(file, later, later); err != nil {
+				t.Fatal(err)
+			}
+			again, err := Backup(context.Background(), cfg, "pw")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !again.Unchanged || again.BackupName != first.BackupName || again.FileCount != 1 {
+				t.Errorf("second backup = %+v, want unchanged against %s", again, first.BackupName)
+			}
+			if backups, _ := List(backupDir); len(backups) != 1 {
+				t.Errorf("expected no new backup, got %d backups", len(backups))
+			}
+
+			time.Sleep(1100 * time.Millisecond)
+			forced, err := BackupWithOptions(context.Background(), cfg, "pw", BackupOptions{Force: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if forced.Unchanged || forced.BackupName == first.BackupName {
+				t.Errorf("forced backup = %+v, want a new backup", forced)
+			}
+
+			time.Sleep(1100 * time.Millisecond)
+			if err := os.WriteFile(file, []byte("changed"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			changed, err := Backup(context.Background(), cfg, "pw")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed.Unchanged {
+				t.Error("backup after a change should not be unchanged")
+			}
+		})
+	}
+}
+
+func TestBackup_UnchangedDetectsDeletion(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Folders: []string{src}}
+	if _, err := Backup(context.Background(), cfg, "pw"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if err := os.Remove(filepath.Join(src, "b")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged {
+		t.Error("a deleted file should count as a change")
+	}
+}
@@ -0,0 +1,200 @@
+// Package capture records the output of commands as virtual files in a
+// backup. Much of a system's state is not a file, such as the list of
+// installed packages or a dconf dump; a capture runs the command that
+// prints it and stores the output under captures/<name>. An optional apply
+// command puts the state back, reading the captured output on stdin.
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix is the directory captures are stored under in a backup
+const Prefix = "captures/"
+
+// DefaultTimeout bounds captures that do not set a timeout
+const DefaultTimeout = time.Minute
+
+// Capture is a command whose standard output is backed up
+type Capture struct {
+	// Name is the file name the output is stored under
+	Name string `yaml:"name"`
+	// Command is run with sh -c; its standard output is captured
+	Command string `yaml:"command"`
+	// Apply is run with sh -c and the captured output on stdin to restore
+	// the state, e.g. "sudo pacman -S --needed -"
+	Apply   string        `yaml:"apply,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// UnmarshalYAML reads the timeout as a duration string such as "30s"
+func (c *Capture) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Name    string `yaml:"name"`
+		Command string `yaml:"command"`
+		Apply   string `yaml:"apply"`
+		Timeout string `yaml:"timeout"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*c = Capture{Name: raw.Name, Command: raw.Command, Apply: raw.Apply}
+	if raw.Timeout != "" {
+		timeout, err := time.ParseDuration(raw.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid capture timeout %q: %w", raw.Timeout, err)
+		}
+		c.Timeout = timeout
+	}
+	return nil
+}
+
+// MarshalYAML writes the timeout the way UnmarshalYAML reads it
+func (c Capture) MarshalYAML() (interface{}, error) {
+	raw := struct {
+		Name    string `yaml:"name"`
+		Command string `yaml:"command"`
+		Apply   string `yaml:"apply,omitempty"`
+		Timeout string `yaml:"timeout,omitempty"`
+	}{Name: c.Name, Command: c.Command, Apply: c.Apply}
+	if c.Timeout != 0 {
+		raw.Timeout = c.Timeout.String()
+	}
+	return raw, nil
+}
+
+// ArchiveName is the name the capture's output is stored under
+func (c Capture) ArchiveName() string {
+	return Prefix + c.Name
+}
+
+// Name returns the capture name of an archive entry, or false when the
+// entry is not a capture
+func Name(archiveName string) (string, bool) {
+	name, ok := strings.CutPrefix(archiveName, Prefix)
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+// Validate checks that every capture has a command and a unique name that
+// is usable as a file name
+func Validate(captures []Capture) error {
+	seen := make(map[string]bool, len(captures))
+	for _, c := range captures {
+		if c.Name == "" || c.Name == "." || c.Name == ".." || strings.ContainsAny(c.Name, `/\`) {
+			return fmt.Errorf("invalid capture name %q", c.Name)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate capture name %q", c.Name)
+		}
+		seen[c.Name] = true
+		if strings.TrimSpace(c.Command) == "" {
+			return fmt.Errorf("capture %q has no command", c.Name)
+		}
+	}
+	return nil
+}
+
+// Find returns the capture with the given name
+func Find(captures []Capture, name string) (Capture, bool) {
+	for _, c := range captures {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Capture{}, false
+}
+
+// Output is a capture whose command has run
+type Output struct {
+	Capture Capture
+	// Path is the file holding the captured standard output
+	Path string
+	// Err is set when the command failed; Path is then empty
+	Err error
+}
+
+// Run runs every capture and writes each output to a file in dir. A failing
+// command does not stop the others; its Output carries the error.
+func Run(captures []Capture, dir string) []Output {
+	outputs := make([]Output, 0, len(captures))
+	for _, c := range captures {
+		path := filepath.Join(dir, c.Name)
+		err := c.run(path)
+		if err != nil {
+			os.Remove(path)
+			path = ""
+		}
+		outputs = append(outputs, Output{Capture: c, Path: path, Err: err})
+	}
+	return outputs
+}
+
+// run runs the command with its standard output going to path
+func (c Capture) run(path string) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create capture file: %w", err)
+	}
+	defer out.Close()
+
+	if err := c.exec(c.Command, nil, out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// ApplyOutput runs the capture's apply command with content on stdin and
+// returns the command's combined output
+func (c Capture) ApplyOutput(content io.Reader) (string, error) {
+	if c.Apply == "" {
+		return "", fmt.Errorf("capture %q has no apply command", c.Name)
+	}
+	var output bytes.Buffer
+	err := c.exec(c.Apply, content, &output)
+	return output.String(), err
+}
+
+// exec runs command with the capture's timeout. Standard error is included
+// in the error message so that failures can be diagnosed.
+func (c Capture) exec(command string, stdin io.Reader, stdout io.Writer) error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), "DOTKEEPER_CAPTURE="+c.Name)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("capture %q timed out after %s", c.Name, timeout)
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("capture %q failed: %w: %s", c.Name, err, msg)
+	}
+	return fmt.Errorf("capture %q failed: %w", c.Name, err)
+}
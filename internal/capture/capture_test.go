@@ -0,0 +1,145 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	outputs := Run([]Capture{
+		{Name: "packages", Command: "printf 'git\\nvim\\n'; echo noise >&2"},
+		{Name: "broken", Command: "echo missing >&2; exit 127"},
+		{Name: "slow", Command: "sleep 5", Timeout: 100 * time.Millisecond},
+	}, dir)
+
+	if len(outputs) != 3 {
+		t.Fatalf("len(outputs) = %d, want 3", len(outputs))
+	}
+
+	if outputs[0].Err != nil {
+		t.Fatalf("packages capture failed: %v", outputs[0].Err)
+	}
+	got, err := os.ReadFile(outputs[0].Path)
+	if err != nil || string(got) != "git\nvim\n" {
+		t.Errorf("captured output = %q, %v; want only stdout", got, err)
+	}
+
+	if outputs[1].Err == nil || !strings.Contains(outputs[1].Err.Error(), "missing") {
+		t.Errorf("broken capture error = %v, want stderr in the message", outputs[1].Err)
+	}
+	if outputs[1].Path != "" {
+		t.Errorf("failed capture should have no output file, got %s", outputs[1].Path)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken")); !os.IsNotExist(err) {
+		t.Error("failed capture output should be removed")
+	}
+
+	if outputs[2].Err == nil || !strings.Contains(outputs[2].Err.Error(), "timed out") {
+		t.Errorf("slow capture error = %v, want timeout", outputs[2].Err)
+	}
+}
+
+func TestApplyOutput(t *testing.T) {
+	c := Capture{Name: "packages", Apply: `echo "$DOTKEEPER_CAPTURE"; wc -l`}
+	output, err := c.ApplyOutput(strings.NewReader("git\nvim\n"))
+	if err != nil {
+		t.Fatalf("ApplyOutput() error = %v", err)
+	}
+	if fields := strings.Fields(output); len(fields) != 2 || fields[0] != "packages" || fields[1] != "2" {
+		t.Errorf("output = %q, want the name and the line count", output)
+	}
+
+	if _, err := (Capture{Name: "x"}).ApplyOutput(strings.NewReader("")); err == nil {
+		t.Error("capture without apply command should fail")
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		archiveName string
+		want        string
+		wantOK      bool
+	}{
+		{"captures/pacman", "pacman", true},
+		{"captures/", "", false},
+		{"captures/a/b", "", false},
+		{"$HOME/captures/pacman", "", false},
+		{"$HOME/.zshrc", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Name(tt.archiveName)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Name(%q) = %q, %v; want %q, %v", tt.archiveName, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		captures []Capture
+		wantErr  string
+	}{
+		{"valid", []Capture{{Name: "a", Command: "true"}, {Name: "b", Command: "true"}}, ""},
+		{"empty name", []Capture{{Command: "true"}}, "invalid capture name"},
+		{"slash", []Capture{{Name: "a/b", Command: "true"}}, "invalid capture name"},
+		{"dot dot", []Capture{{Name: "..", Command: "true"}}, "invalid capture name"},
+		{"duplicate", []Capture{{Name: "a", Command: "true"}, {Name: "a", Command: "true"}}, "duplicate"},
+		{"no command", []Capture{{Name: "a", Command: " "}}, "no command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.captures)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCapture_YAML(t *testing.T) {
+	input := `
+- name: pacman
+  command: pacman -Qqe
+  apply: sudo pacman -S --needed -
+  timeout: 2m
+- name: crontab
+  command: crontab -l
+`
+	var captures []Capture
+	if err := yaml.Unmarshal([]byte(input), &captures); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := []Capture{
+		{Name: "pacman", Command: "pacman -Qqe", Apply: "sudo pacman -S --needed -", Timeout: 2 * time.Minute},
+		{Name: "crontab", Command: "crontab -l"},
+	}
+	if len(captures) != len(want) || captures[0] != want[0] || captures[1] != want[1] {
+		t.Fatalf("captures = %+v, want %+v", captures, want)
+	}
+
+	out, err := yaml.Marshal(captures)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var again []Capture
+	if err := yaml.Unmarshal(out, &again); err != nil || len(again) != 2 || again[0] != want[0] || again[1] != want[1] {
+		t.Errorf("round trip = %+v, %v\n%s", again, err, out)
+	}
+
+	if err := yaml.Unmarshal([]byte("- name: x\n  command: y\n  timeout: later\n"), &captures); err == nil {
+		t.Error("invalid timeout should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -10,27 +11,52 @@ import (
 	"github.com/diogo/dotkeeper/internal/config"
 	"github.com/diogo/dotkeeper/internal/history"
 	"github.com/diogo/dotkeeper/internal/keyring"
+	"github.com/diogo/dotkeeper/internal/lock"
 	"github.com/diogo/dotkeeper/internal/notify"
+	"github.com/diogo/dotkeeper/internal/pathutil"
 )
 
+// ExitUnchanged is the exit code of a backup skipped because nothing
+// changed since the newest backup, so schedulers can tell it apart from
+// both a new backup and a failure
+const ExitUnchanged = 3
+
 // BackupCommand handles the backup subcommand
 func BackupCommand(args []string) int {
 	fs := flag.NewFlagSet("backup", flag.ExitOnError)
 	passwordFile := fs.String("password-file", "", "Path to file containing password")
 	notifyPtr := fs.Bool("notify", false, "Send desktop notifications on completion (default: from config)")
+	incremental := fs.Bool("incremental", false, "Only store files changed since the last backup")
+	allowSecrets := fs.Bool("allow-secrets", false, "Back up files whose secret findings need confirmation without asking")
+	force := fs.Bool("force", false, "Create a backup even when nothing changed since the last one")
+	progressFormat := fs.String("progress", "", progressUsage)
+	var tags tagFlag
+	fs.Var(&tags, "tag", "Tag the backup, e.g. before-hyprland (repeatable)")
+	note := fs.String("note", "", "Attach a note to the backup")
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: dotkeeper backup [--password-file PATH] [--notify]\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper backup [--password-file PATH] [--notify] [--incremental] [--allow-secrets] [--force] [--tag TAG]... [--note TEXT] [--progress json]\n\n")
 		fmt.Fprintf(os.Stderr, "Create a backup of dotfiles.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		fs.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
 		fmt.Fprintf(os.Stderr, "  DOTKEEPER_PASSWORD    Password for encryption (non-interactive mode)\n")
+		fmt.Fprintf(os.Stderr, "\nFiles with secrets that need confirmation are backed up with a warning and an\n")
+		fmt.Fprintf(os.Stderr, "urgent notification when there is no terminal to ask on; set secrets.unattended\n")
+		fmt.Fprintf(os.Stderr, "to exclude or confirm in the config to leave them out or fail the backup.\n")
+		fmt.Fprintf(os.Stderr, "\nWhen nothing changed since the last backup, no backup is created and the\n")
+		fmt.Fprintf(os.Stderr, "exit code is %d; tags and note are then added to that backup.\n", ExitUnchanged)
+		fmt.Fprintf(os.Stderr, "\nAn interrupted backup removes its partly written archive.\n")
 	}
 
 	if err := fs.Parse(args); err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
 		return 1
 	}
+	report, err := progressFunc(*progressFormat, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
 
 	// Load config
 	cfg, err := config.Load()
@@ -55,7 +81,7 @@ func BackupCommand(args []string) int {
 	})
 
 	// Get password from various sources
-	password, err := getPassword(*passwordFile)
+	password, err := getPassword(*passwordFile, cfg.Keyring)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error getting password: %v\n", err)
 		return 1
@@ -64,11 +90,36 @@ func BackupCommand(args []string) int {
 	// Create history store once (best-effort)
 	store, storeErr := history.NewStore()
 
+	// Backups run alone on the backup directory
+	l, err := cfg.Lock(lock.Exclusive, "backup")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+		if notifyFlag {
+			notify.SendError(err)
+		}
+		logHistory(store, storeErr, history.EntryFromBackupError(err))
+		return 1
+	}
+	defer l.Release()
+
 	// Perform backup
 	fmt.Println("Starting backup...")
-	result, err := backup.Backup(cfg, password)
+	ctx, stop := interruptContext()
+	defer stop()
+	result, err := backup.BackupWithOptions(ctx, cfg, password, backup.BackupOptions{
+		Incremental:   *incremental,
+		ConfirmSecret: confirmSecret(*allowSecrets),
+		Force:         *force,
+		Progress:      report,
+		Tags:          tags,
+		Note:          *note,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Backup failed: %v\n", err)
+		if errors.Is(err, backup.ErrUnconfirmedSecrets) {
+			fmt.Fprintf(os.Stderr, "Run the backup in a terminal to confirm them, pass --allow-secrets, or set\n")
+			fmt.Fprintf(os.Stderr, "secrets.unattended to warn or exclude in the config.\n")
+		}
 		if notifyFlag {
 			notify.SendError(err)
 		}
@@ -77,13 +128,52 @@ func BackupCommand(args []string) int {
 		return 1
 	}
 
+	if result.Unchanged {
+		fmt.Printf("✓ Nothing changed since %s; no backup created (use --force to create one)\n", result.BackupName)
+		fmt.Printf("  Files checked: %d\n", result.FileCount)
+		if len(tags) > 0 || *note != "" {
+			fmt.Printf("  Labels added to %s\n", result.BackupName)
+		}
+		printSecretFindings(result.Secrets, result.ExcludedSecrets, result.UnconfirmedSecrets)
+		for _, problem := range result.CaptureErrors {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+		}
+		warnFailedHooks(result.Hooks)
+		logHistory(store, storeErr, history.EntryFromBackupResult(result))
+		return ExitUnchanged
+	}
+
 	// Print results
 	fmt.Printf("✓ Backup completed successfully\n")
 	fmt.Printf("  Files backed up: %d\n", result.FileCount)
 	fmt.Printf("  Total size: %d bytes\n", result.TotalSize)
 	fmt.Printf("  Duration: %v\n", result.Duration)
 	fmt.Printf("  Backup file: %s\n", result.BackupPath)
+	if cfg.Repository {
+		fmt.Printf("  New data stored: %s\n", pathutil.FormatSize(result.AddedSize))
+	}
+	if result.Incremental {
+		fmt.Printf("  Incremental against: %s\n", result.Parent)
+		fmt.Printf("  Changed files stored: %d\n", result.ChangedFiles)
+		fmt.Printf("  Deleted since parent: %d\n", result.DeletedFiles)
+	} else if *incremental {
+		fmt.Printf("  No previous backup with a manifest; created a full backup\n")
+	}
 	fmt.Printf("  Checksum: %s\n", result.Checksum)
+	if len(tags) > 0 {
+		fmt.Printf("  Tags: %s\n", strings.Join(tags, ", "))
+	}
+	for _, path := range result.Snapshots {
+		fmt.Printf("  SQLite snapshot: %s\n", path)
+	}
+	printSecretFindings(result.Secrets, result.ExcludedSecrets, result.UnconfirmedSecrets)
+	for _, problem := range result.CaptureErrors {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+	}
+	for _, path := range result.ChangedDuringBackup {
+		fmt.Fprintf(os.Stderr, "Warning: %s changed during backup; the stored copy may be inconsistent\n", path)
+	}
+	warnFailedHooks(result.Hooks)
 
 	if notifyFlag {
 		notify.SendSuccess(result.BackupName, result.Duration)
@@ -95,8 +185,9 @@ func BackupCommand(args []string) int {
 	return 0
 }
 
-// getPassword retrieves password from file, env var, or keyring
-func getPassword(passwordFile string) (string, error) {
+// getPassword retrieves password from file, env var, or the named keyring
+// entry (the default entry when empty)
+func getPassword(passwordFile, keyringEntry string) (string, error) {
 	// Priority 1: Password file
 	if passwordFile != "" {
 		data, err := os.ReadFile(passwordFile)
@@ -114,7 +205,7 @@ func getPassword(passwordFile string) (string, error) {
 	}
 
 	// Priority 3: System keyring
-	password, err := keyring.Retrieve()
+	password, err := keyring.RetrieveEntry(keyringEntry)
 	if err != nil {
 		return "", fmt.Errorf("no password provided and keyring access failed: %w", err)
 	}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/diogo/dotkeeper/internal/compression"
 	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/keyring"
 )
 
 // ConfigCommand handles the config subcommand
@@ -24,6 +27,12 @@ func ConfigCommand(args []string) int {
 		fmt.Fprintf(os.Stderr, "  git_remote     Git remote URL\n")
 		fmt.Fprintf(os.Stderr, "  schedule       Backup schedule (cron format)\n")
 		fmt.Fprintf(os.Stderr, "  notifications  Enable/disable notifications (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  repository     Store backups as deduplicated snapshots (true/false)\n")
+		fmt.Fprintf(os.Stderr, "  compression    Archive compression: zstd, gzip or none, with optional level (e.g. zstd:19)\n")
+		fmt.Fprintf(os.Stderr, "  keyring        Keyring entry holding the backup password\n")
+		fmt.Fprintf(os.Stderr, "  lock_wait      How long to wait for another operation on the backup directory (e.g. 5m)\n")
+		fmt.Fprintf(os.Stderr, "\nWith --profile NAME, keys are read from and written to that profile;\n")
+		fmt.Fprintf(os.Stderr, "setting a key on a profile that does not exist yet creates it.\n")
 	}
 
 	if err := fs.Parse(args); err != nil {
@@ -92,10 +101,10 @@ func configSet(args []string) int {
 	value := args[1]
 
 	// Load or create config
-	cfg, err := config.Load()
+	cfg, err := loadConfigForSet()
 	if err != nil {
-		// Create default config if it doesn't exist
-		cfg = &config.Config{}
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
 	}
 
 	// Set value
@@ -125,12 +134,22 @@ func configList() int {
 
 	// Print all values
 	fmt.Println("Configuration:")
+	if name := cfg.Profile(); name != "" {
+		fmt.Printf("  profile:        %s\n", name)
+	}
 	fmt.Printf("  backup_dir:     %s\n", cfg.BackupDir)
 	fmt.Printf("  git_remote:     %s\n", cfg.GitRemote)
 	fmt.Printf("  schedule:       %s\n", cfg.Schedule)
 	fmt.Printf("  notifications:  %t\n", cfg.Notifications)
+	fmt.Printf("  repository:     %t\n", cfg.Repository)
+	fmt.Printf("  compression:    %s\n", compressionSetting(cfg))
 	fmt.Printf("  files:          %v\n", cfg.Files)
 	fmt.Printf("  folders:        %v\n", cfg.Folders)
+	fmt.Printf("  keyring:        %s\n", keyringEntry(cfg))
+	fmt.Printf("  lock_wait:      %s\n", lockWait(cfg))
+	if names := cfg.ProfileNames(); len(names) > 0 {
+		fmt.Printf("  profiles:       %v\n", names)
+	}
 
 	return 0
 }
@@ -148,6 +167,14 @@ func getConfigValue(cfg *config.Config, key string) (string, error) {
 		return cfg.Schedule, nil
 	case "notifications":
 		return fmt.Sprintf("%t", cfg.Notifications), nil
+	case "repository":
+		return fmt.Sprintf("%t", cfg.Repository), nil
+	case "compression":
+		return compressionSetting(cfg), nil
+	case "keyring":
+		return keyringEntry(cfg), nil
+	case "lock_wait":
+		return lockWait(cfg), nil
 	case "files":
 		return strings.Join(cfg.Files, ","), nil
 	case "folders":
@@ -169,14 +196,33 @@ func setConfigValue(cfg *config.Config, key, value string) error {
 	case "schedule":
 		cfg.Schedule = value
 	case "notifications":
-		switch strings.ToLower(value) {
-		case "true", "yes", "1", "on":
-			cfg.Notifications = true
-		case "false", "no", "0", "off":
-			cfg.Notifications = false
-		default:
-			return fmt.Errorf("invalid boolean value: %s (use true/false)", value)
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		cfg.Notifications = b
+	case "repository":
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		cfg.Repository = b
+	case "compression":
+		setting, err := compression.Parse(value)
+		if err != nil {
+			return err
 		}
+		cfg.Compression = setting.String()
+	case "keyring":
+		cfg.Keyring = value
+	case "lock_wait":
+		if value != "" {
+			wait, err := time.ParseDuration(value)
+			if err != nil || wait < 0 {
+				return fmt.Errorf("invalid lock_wait: %s (use a duration such as 30s or 5m)", value)
+			}
+		}
+		cfg.LockWait = value
 	case "files":
 		if value == "" {
 			cfg.Files = []string{}
@@ -196,9 +242,72 @@ func setConfigValue(cfg *config.Config, key, value string) error {
 	return nil
 }
 
+// parseBool parses the boolean spellings accepted by config set
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "1", "on":
+		return true, nil
+	case "false", "no", "0", "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean value: %s (use true/false)", value)
+	}
+}
+
 // normalizeKey normalizes a config key (converts to lowercase, replaces - with _)
 func normalizeKey(key string) string {
 	key = strings.ToLower(key)
 	key = strings.ReplaceAll(key, "-", "_")
 	return key
 }
+
+// lockWait returns the configured lock wait, showing the default of not
+// waiting when none is set
+func lockWait(cfg *config.Config) string {
+	if cfg.LockWait == "" {
+		return "0s"
+	}
+	return cfg.LockWait
+}
+
+// compressionSetting returns the configured compression, showing the
+// default when none is set
+func compressionSetting(cfg *config.Config) string {
+	setting, err := compression.Parse(cfg.Compression)
+	if err != nil {
+		return cfg.Compression
+	}
+	return setting.String()
+}
+
+// keyringEntry returns the configured keyring entry, showing the default
+// when none is set
+func keyringEntry(cfg *config.Config) string {
+	if cfg.Keyring == "" {
+		return keyring.DefaultEntry
+	}
+	return cfg.Keyring
+}
+
+// loadConfigForSet loads the config to modify, starting from an empty one
+// when it cannot be read. A selected profile that does not exist yet is created,
+// so "dotkeeper --profile work config set ..." sets up a new profile.
+func loadConfigForSet() (*config.Config, error) {
+	configPath, err := config.GetConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.LoadFromPath(configPath)
+	if err != nil {
+		// Create default config if it doesn't exist
+		cfg = &config.Config{}
+	}
+
+	name := config.SelectedProfile()
+	if name == "" {
+		return cfg, nil
+	}
+	cfg.AddProfile(name)
+	return cfg.UseProfile(name)
+}
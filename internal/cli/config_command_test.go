@@ -457,3 +457,33 @@ func TestConfigCommand_NoSubcommand(t *testing.T) {
 		t.Fatalf("stderr = %q", stderr)
 	}
 }
+
+func TestConfigSet_Compression(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmp)
+	writeCLIConfig(t, tmp, &config.Config{BackupDir: "/tmp/backups", Files: []string{".zshrc"}})
+
+	var exit int
+	_, stderr := captureStdoutStderr(t, func() {
+		exit = configSet([]string{"compression", "ZSTD:19"})
+	})
+	if exit != 0 {
+		t.Fatalf("exit = %d, stderr=%s", exit, stderr)
+	}
+
+	stdout, _ := captureStdoutStderr(t, func() {
+		exit = configGet([]string{"compression"})
+	})
+	if exit != 0 || strings.TrimSpace(stdout) != "zstd:19" {
+		t.Fatalf("compression = %q (exit %d), want zstd:19", stdout, exit)
+	}
+
+	for _, bad := range []string{"lz4", "gzip:12", "none:3", "zstd:fast"} {
+		_, stderr := captureStdoutStderr(t, func() {
+			exit = configSet([]string{"compression", bad})
+		})
+		if exit != 1 || stderr == "" {
+			t.Errorf("set compression %q: exit = %d, stderr=%q", bad, exit, stderr)
+		}
+	}
+}
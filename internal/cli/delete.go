@@ -4,18 +4,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 
+	"github.com/diogo/dotkeeper/internal/backup"
 	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/lock"
 )
 
 func DeleteCommand(args []string) int {
 	fs := flag.NewFlagSet("delete", flag.ExitOnError)
 	force := fs.Bool("force", false, "Skip confirmation prompt")
+	passwordFile := fs.String("password-file", "", "Path to file containing the password")
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: dotkeeper delete <backup-name> [--force]\n\n")
-		fmt.Fprintf(os.Stderr, "Delete a backup and its metadata.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper delete [options] <backup-name>\n\n")
+		fmt.Fprintf(os.Stderr, "Delete a backup and its metadata. Deleting a repository snapshot\n")
+		fmt.Fprintf(os.Stderr, "also removes the chunks no other snapshot uses. The password is\n")
+		fmt.Fprintf(os.Stderr, "needed to check that the backup is not pinned.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		fs.PrintDefaults()
 	}
@@ -39,18 +42,14 @@ func DeleteCommand(args []string) int {
 		return 1
 	}
 
-	if !strings.HasSuffix(name, ".tar.gz.enc") {
-		name = name + ".tar.gz.enc"
-	}
-
-	encPath := filepath.Join(cfg.BackupDir, name)
-	if _, err := os.Stat(encPath); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: backup not found: %s\n", name)
+	target, err := backup.Resolve(cfg.BackupDir, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return 1
 	}
 
 	if !*force {
-		fmt.Printf("Delete %s? [y/N] ", name)
+		fmt.Printf("Delete %s? [y/N] ", target.Name)
 		var answer string
 		fmt.Scanln(&answer)
 		if answer != "y" && answer != "Y" {
@@ -59,14 +58,28 @@ func DeleteCommand(args []string) int {
 		}
 	}
 
-	if err := os.Remove(encPath); err != nil {
-		fmt.Fprintf(os.Stderr, "Error deleting backup: %v\n", err)
+	password, err := getPassword(*passwordFile, cfg.Keyring)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting password: %v\n", err)
+		return 1
+	}
+
+	l, err := cfg.Lock(lock.Exclusive, "delete")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return 1
 	}
+	defer l.Release()
 
-	metaPath := encPath + ".meta.json"
-	os.Remove(metaPath)
+	removedChunks, err := backup.Delete(target, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error deleting backup: %v\n", err)
+		return 1
+	}
 
-	fmt.Printf("Deleted %s\n", name)
+	fmt.Printf("Deleted %s\n", target.Name)
+	if target.Snapshot {
+		fmt.Printf("Removed %d unreferenced chunk(s) from the repository\n", removedChunks)
+	}
 	return 0
 }
@@ -1,10 +1,15 @@
 package cli
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/repository"
 )
 
 func setupDeleteTest(t *testing.T) string {
@@ -13,6 +18,7 @@ func setupDeleteTest(t *testing.T) string {
 
 	// Set up config with backup dir
 	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+	t.Setenv("DOTKEEPER_PASSWORD", "pw")
 	configDir := filepath.Join(tmpDir, "dotkeeper")
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		t.Fatalf("Failed to create config dir: %v", err)
@@ -143,3 +149,49 @@ func TestDeleteCommand_MetadataOnly(t *testing.T) {
 		t.Errorf("expected 'backup not found' error, got: %s", stderr)
 	}
 }
+
+func TestDeleteCommand_Snapshot(t *testing.T) {
+	tmpDir := setupDeleteTest(t)
+
+	source := filepath.Join(t.TempDir(), "bashrc")
+	if err := os.WriteFile(source, []byte("export EDITOR=vim\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := backup.Backup(context.Background(), &config.Config{
+		BackupDir:  tmpDir,
+		Files:      []string{source},
+		Repository: true,
+	}, "pw")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	var exit int
+	stdout, stderr := captureStdoutStderr(t, func() {
+		exit = ListCommand([]string{})
+	})
+	if exit != 0 || !strings.Contains(stdout, result.BackupName) {
+		t.Fatalf("expected snapshot in list, exit=%d stdout=%s stderr=%s", exit, stdout, stderr)
+	}
+
+	stdout, stderr = captureStdoutStderr(t, func() {
+		exit = DeleteCommand([]string{"--force", strings.TrimSuffix(result.BackupName, repository.SnapshotExt)})
+	})
+	if exit != 0 {
+		t.Fatalf("exit = %d, stderr=%s", exit, stderr)
+	}
+	if !strings.Contains(stdout, "Deleted "+result.BackupName) {
+		t.Errorf("expected deletion message in stdout: %s", stdout)
+	}
+	if !strings.Contains(stdout, "Removed 1 unreferenced chunk(s)") {
+		t.Errorf("expected garbage collection summary in stdout: %s", stdout)
+	}
+	if _, err := os.Stat(result.BackupPath); !os.IsNotExist(err) {
+		t.Error("snapshot should be deleted")
+	}
+
+	// The archive backup from setup is untouched
+	if _, err := os.Stat(filepath.Join(tmpDir, "backup-2025-01-01-120000.tar.gz.enc")); err != nil {
+		t.Errorf("archive backup should remain: %v", err)
+	}
+}
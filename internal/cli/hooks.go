@@ -0,0 +1,17 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/diogo/dotkeeper/internal/hooks"
+)
+
+// warnFailedHooks reports hooks that failed after the operation completed
+func warnFailedHooks(results []hooks.Result) {
+	for _, r := range results {
+		if r.Failed() {
+			fmt.Fprintf(os.Stderr, "Warning: %s hook %q failed: %s\n", r.Stage, r.Command, r.Error)
+		}
+	}
+}
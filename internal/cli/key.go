@@ -0,0 +1,301 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/keyring"
+	"github.com/diogo/dotkeeper/internal/lock"
+)
+
+// keyfileSize is the number of random bytes in a generated keyfile
+const keyfileSize = 32
+
+// KeyCommand handles the key subcommand, which manages the key slots that
+// open the backups
+func KeyCommand(args []string) int {
+	fs := flag.NewFlagSet("key", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper key <subcommand> [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Manage the passwords, keyfiles and recovery keys that open the backups.\n")
+		fmt.Fprintf(os.Stderr, "Changes rewrite only the key slots of each backup, never its content. Each backup\n")
+		fmt.Fprintf(os.Stderr, "is updated atomically, but the backups one after another: a change that stops\n")
+		fmt.Fprintf(os.Stderr, "halfway leaves the rest with the old slots until it is run again.\n\n")
+		fmt.Fprintf(os.Stderr, "Subcommands:\n")
+		fmt.Fprintf(os.Stderr, "  list                    List the key slots\n")
+		fmt.Fprintf(os.Stderr, "  change-password         Replace the password\n")
+		fmt.Fprintf(os.Stderr, "  add password            Add another password\n")
+		fmt.Fprintf(os.Stderr, "  add keyfile PATH        Add a keyfile, generating it if PATH does not exist\n")
+		fmt.Fprintf(os.Stderr, "  add recovery            Add a recovery key and print it\n")
+		fmt.Fprintf(os.Stderr, "  remove ID               Remove a key slot\n\n")
+		fmt.Fprintf(os.Stderr, "The current password is read like for backups: --password-file, DOTKEEPER_PASSWORD\n")
+		fmt.Fprintf(os.Stderr, "or the keyring. A keyfile or recovery key can be given in its place. New passwords\n")
+		fmt.Fprintf(os.Stderr, "are read from --new-password-file or DOTKEEPER_NEW_PASSWORD.\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 1
+	}
+
+	subcommand, rest := fs.Arg(0), fs.Args()[1:]
+	switch subcommand {
+	case "list":
+		return keyList(rest)
+	case "change-password":
+		return keyChangePassword(rest)
+	case "add":
+		return keyAdd(rest)
+	case "remove":
+		return keyRemove(rest)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", subcommand)
+		fs.Usage()
+		return 1
+	}
+}
+
+func keyList(args []string) int {
+	fs := flag.NewFlagSet("key list", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+	slots, err := backup.KeySlots(cfg.BackupDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(slots) == 0 {
+		fmt.Println("No key slots yet; the next backup stores one for its password")
+		return 0
+	}
+
+	fmt.Printf("%-10s %s\n", "ID", "TYPE")
+	for _, slot := range slots {
+		fmt.Printf("%-10s %s\n", slot.ID, slot.Type)
+	}
+	return 0
+}
+
+func keyChangePassword(args []string) int {
+	fs := flag.NewFlagSet("key change-password", flag.ExitOnError)
+	passwordFile := fs.String("password-file", "", "Path to file containing the current password")
+	newPasswordFile := fs.String("new-password-file", "", "Path to file containing the new password")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+
+	newPassword, err := getNewPassword(*newPasswordFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting new password: %v\n", err)
+		return 1
+	}
+
+	return changeKeys("change-password", *passwordFile, func(cfg *config.Config, password string) (*backup.KeyChange, error) {
+		change, err := backup.ChangePassword(cfg.BackupDir, password, newPassword)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the keyring in step when the old password came from it
+		if *passwordFile == "" && os.Getenv("DOTKEEPER_PASSWORD") == "" {
+			if err := keyring.StoreEntry(cfg.Keyring, newPassword); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to store the new password in the keyring: %v\n", err)
+			}
+		}
+		return change, nil
+	})
+}
+
+func keyAdd(args []string) int {
+	fs := flag.NewFlagSet("key add", flag.ExitOnError)
+	passwordFile := fs.String("password-file", "", "Path to file containing the current password")
+	newPasswordFile := fs.String("new-password-file", "", "Path to file containing the password to add")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper key add [options] password|keyfile PATH|recovery\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+	if fs.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Error: key slot type required\n")
+		fs.Usage()
+		return 1
+	}
+
+	var secret, announce, generated string
+	slotType := fs.Arg(0)
+	switch slotType {
+	case crypto.SlotPassword:
+		password, err := getNewPassword(*newPasswordFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting new password: %v\n", err)
+			return 1
+		}
+		secret = password
+	case crypto.SlotKeyfile:
+		if fs.NArg() != 2 {
+			fmt.Fprintf(os.Stderr, "Error: keyfile path required\n")
+			fs.Usage()
+			return 1
+		}
+		keyfile, created, err := loadKeyfile(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		secret = keyfile
+		if created {
+			generated = fs.Arg(1)
+			announce = fmt.Sprintf("Generated keyfile %s; keep a copy away from the backups", fs.Arg(1))
+		}
+	case crypto.SlotRecovery:
+		recovery, err := crypto.GenerateRecoveryKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		secret = recovery
+		announce = fmt.Sprintf("Recovery key: %s\nWrite it down and keep it safe; it is not shown again.", recovery)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown key slot type: %s\n", slotType)
+		fs.Usage()
+		return 1
+	}
+
+	// Backups updated before a failure open with the new key too, so it is
+	// kept and shown then as well
+	var stored bool
+	exit := changeKeys("add-key", *passwordFile, func(cfg *config.Config, password string) (*backup.KeyChange, error) {
+		change, err := backup.AddKeySlot(cfg.BackupDir, password, slotType, secret)
+		stored = change != nil
+		return change, err
+	})
+	if !stored && generated != "" {
+		os.Remove(generated)
+	}
+	if stored && announce != "" {
+		fmt.Println(announce)
+	}
+	return exit
+}
+
+func keyRemove(args []string) int {
+	fs := flag.NewFlagSet("key remove", flag.ExitOnError)
+	passwordFile := fs.String("password-file", "", "Path to file containing the current password")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: key slot id required\n")
+		fmt.Fprintf(os.Stderr, "Use 'dotkeeper key list' to see the key slots\n")
+		return 1
+	}
+
+	id := fs.Arg(0)
+	return changeKeys("remove-key", *passwordFile, func(cfg *config.Config, password string) (*backup.KeyChange, error) {
+		return backup.RemoveKeySlot(cfg.BackupDir, password, id)
+	})
+}
+
+// changeKeys runs a key change under the backup directory lock and prints
+// what it updated
+func changeKeys(operation, passwordFile string, change func(*config.Config, string) (*backup.KeyChange, error)) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+
+	password, err := getPassword(passwordFile, cfg.Keyring)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting password: %v\n", err)
+		return 1
+	}
+
+	l, err := cfg.Lock(lock.Exclusive, operation)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer l.Release()
+
+	// A change that stops part way still reports what it updated
+	result, err := change(cfg, password)
+	if result != nil {
+		if result.Slot != nil {
+			fmt.Printf("Added %s key slot %s\n", result.Slot.Type, result.Slot.ID)
+		}
+		fmt.Printf("Updated the key slots of %d backup(s)\n", len(result.Updated))
+		for _, skipped := range result.Skipped {
+			fmt.Printf("  Skipped %s: %s\n", skipped.Name, skipped.Reason)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// getNewPassword reads a password to store from newPasswordFile or
+// DOTKEEPER_NEW_PASSWORD
+func getNewPassword(newPasswordFile string) (string, error) {
+	if newPasswordFile != "" {
+		data, err := os.ReadFile(newPasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		return strings.TrimSuffix(string(data), "\n"), nil
+	}
+	if password := os.Getenv("DOTKEEPER_NEW_PASSWORD"); password != "" {
+		return password, nil
+	}
+	return "", fmt.Errorf("no new password provided: use --new-password-file or DOTKEEPER_NEW_PASSWORD")
+}
+
+// loadKeyfile reads the keyfile at path, generating a random one when it
+// does not exist. Its content is read the way --password-file reads it, so
+// the keyfile can be passed there to open the backups.
+func loadKeyfile(path string) (string, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSuffix(string(data), "\n"), false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", false, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	raw := make([]byte, keyfileSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", false, fmt.Errorf("failed to generate keyfile: %w", err)
+	}
+	key := hex.EncodeToString(raw)
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return "", false, fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	return key, true, nil
+}
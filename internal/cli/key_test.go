@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestKeyCommand(t *testing.T) {
+	tmp := t.TempDir()
+	source := filepath.Join(tmp, "a.txt")
+	if err := os.WriteFile(source, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	backupDir := filepath.Join(tmp, "backups")
+	setupBackupCommandConfig(t, &config.Config{BackupDir: backupDir, Files: []string{source}})
+	t.Setenv("DOTKEEPER_PASSWORD", "pw")
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	var exit int
+	stdout, _ := captureStdoutStderr(t, func() {
+		exit = KeyCommand([]string{"list"})
+	})
+	if exit != 0 || !strings.Contains(stdout, "No key slots yet") {
+		t.Errorf("key list before any backup: exit %d\n%s", exit, stdout)
+	}
+
+	captureStdoutStderr(t, func() {
+		exit = BackupCommand(nil)
+	})
+	if exit != 0 {
+		t.Fatalf("backup exit = %d", exit)
+	}
+	matches, err := filepath.Glob(filepath.Join(backupDir, "*.tar.gz.enc"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("backups: %v, %v", matches, err)
+	}
+
+	stdout, stderr := captureStdoutStderr(t, func() {
+		exit = KeyCommand([]string{"add", "recovery"})
+	})
+	if exit != 0 {
+		t.Fatalf("key add recovery exit = %d, stderr=%s", exit, stderr)
+	}
+	recovery := regexp.MustCompile(`Recovery key: (\S+)`).FindStringSubmatch(stdout)
+	if recovery == nil || !strings.Contains(stdout, "Updated the key slots of 1 backup(s)") {
+		t.Fatalf("key add recovery output:\n%s", stdout)
+	}
+
+	keyfile := filepath.Join(tmp, "dotkeeper.key")
+	stdout, stderr = captureStdoutStderr(t, func() {
+		exit = KeyCommand([]string{"add", "keyfile", keyfile})
+	})
+	if exit != 0 || !strings.Contains(stdout, "Generated keyfile") {
+		t.Fatalf("key add keyfile: exit %d, stdout=%s stderr=%s", exit, stdout, stderr)
+	}
+	if info, err := os.Stat(keyfile); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("keyfile: %v, %v", info, err)
+	}
+
+	stdout, _ = captureStdoutStderr(t, func() {
+		exit = KeyCommand([]string{"list"})
+	})
+	for _, want := range []string{"password", "recovery", "keyfile"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("key list missing %s:\n%s", want, stdout)
+		}
+	}
+
+	// The keyfile opens the backups in place of the password
+	t.Setenv("DOTKEEPER_PASSWORD", "")
+	t.Setenv("DOTKEEPER_NEW_PASSWORD", "new")
+	_, stderr = captureStdoutStderr(t, func() {
+		exit = KeyCommand([]string{"change-password", "--password-file", keyfile})
+	})
+	if exit != 0 {
+		t.Fatalf("key change-password exit = %d, stderr=%s", exit, stderr)
+	}
+	if _, err := backup.ReadManifest(matches[0], "new"); err != nil {
+		t.Errorf("backup does not open with the new password: %v", err)
+	}
+	if _, err := backup.ReadManifest(matches[0], "pw"); err == nil {
+		t.Error("backup still opens with the old password")
+	}
+	if _, err := backup.ReadManifest(matches[0], recovery[1]); err != nil {
+		t.Errorf("backup does not open with the recovery key: %v", err)
+	}
+
+	t.Setenv("DOTKEEPER_PASSWORD", "new")
+	_, stderr = captureStdoutStderr(t, func() {
+		exit = KeyCommand([]string{"remove", "nosuchid"})
+	})
+	if exit == 0 || !strings.Contains(stderr, "no key slot") {
+		t.Errorf("key remove of an unknown id: exit %d, stderr=%s", exit, stderr)
+	}
+
+	_, stderr = captureStdoutStderr(t, func() {
+		exit = KeyCommand([]string{"frobnicate"})
+	})
+	if exit == 0 || !strings.Contains(stderr, "Unknown subcommand") {
+		t.Errorf("unknown subcommand: exit %d, stderr=%s", exit, stderr)
+	}
+}
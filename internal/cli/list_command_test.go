@@ -119,6 +119,9 @@ func TestListCommand(t *testing.T) {
 		if err := os.WriteFile(filepath.Join(backupDir, "backup-2026-01-01-010101.tar.gz.enc"), []byte("x"), 0600); err != nil {
 			t.Fatal(err)
 		}
+		if err := os.WriteFile(filepath.Join(backupDir, "backup-2026-01-01-010101.tar.gz.enc.meta.json"), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
 
 		var exit int
 		stdout, stderr := captureStdoutStderr(t, func() {
@@ -141,6 +144,9 @@ func TestListCommand(t *testing.T) {
 		if err := os.WriteFile(filepath.Join(backupDir, name), []byte("xyz"), 0600); err != nil {
 			t.Fatal(err)
 		}
+		if err := os.WriteFile(filepath.Join(backupDir, name+".meta.json"), []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
 
 		var exit int
 		stdout, stderr := captureStdoutStderr(t, func() {
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/pathutil"
+	"github.com/diogo/dotkeeper/internal/restore"
+)
+
+// LsCommand handles the ls subcommand
+func LsCommand(args []string) int {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	passwordFile := fs.String("password-file", "", "Path to file containing password")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper ls [options] <backup-name>\n\n")
+		fmt.Fprintf(os.Stderr, "List the files in a backup without restoring it.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
+		fmt.Fprintf(os.Stderr, "  DOTKEEPER_PASSWORD    Password for decryption (non-interactive mode)\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		// flag.ContinueOnError already printed the error
+		return 1
+	}
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Error: backup name required\n")
+		fmt.Fprintf(os.Stderr, "Use 'dotkeeper list' to see available backups\n")
+		return 1
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+
+	target, err := backup.Resolve(cfg.BackupDir, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	password, err := getPassword(*passwordFile, cfg.Keyring)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting password: %v\n", err)
+		return 1
+	}
+
+	session, err := restore.OpenSession(target.Path, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	entries, err := session.Entries()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *jsonOutput {
+		if entries == nil {
+			entries = []backup.ManifestEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+
+	printEntries(entries)
+	return 0
+}
+
+// printEntries prints backup entries in an ls -l style listing
+func printEntries(entries []backup.ManifestEntry) {
+	files := 0
+	for _, e := range entries {
+		mode := os.FileMode(e.Mode).Perm()
+		switch {
+		case e.Dir:
+			mode |= os.ModeDir
+		case e.LinkTarget != "":
+			mode |= os.ModeSymlink
+		}
+		if !e.Dir {
+			files++
+		}
+		modified := time.Unix(e.ModTime, 0).Format("2006-01-02 15:04")
+
+		line := fmt.Sprintf("%s %10s %s %s", mode, pathutil.FormatSize(e.Size), modified, e.Path)
+		if e.LinkTarget != "" {
+			line += " -> " + e.LinkTarget
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("\nTotal: %d file(s)\n", files)
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/compression"
+)
+
+func setupLsTest(t *testing.T) (string, string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	setupTestConfig(t, tmpDir)
+
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+		"a.conf": "alpha",
+		"b.conf": "bravo!",
+	})
+
+	pwFile := filepath.Join(tmpDir, "password")
+	if err := os.WriteFile(pwFile, []byte(password), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Base(backupPath), pwFile
+}
+
+func TestLsCommand(t *testing.T) {
+	backupName, pwFile := setupLsTest(t)
+
+	var exitCode int
+	stdout, stderr := captureStdoutStderr(t, func() {
+		exitCode = LsCommand([]string{"--password-file", pwFile, compression.TrimExt(backupName)})
+	})
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", exitCode, stderr)
+	}
+	for _, want := range []string{"a.conf", "b.conf", "-rw-r--r--", "Total: 2 file(s)"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("output missing %q:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestLsCommand_JSON(t *testing.T) {
+	backupName, pwFile := setupLsTest(t)
+
+	var exitCode int
+	stdout, stderr := captureStdoutStderr(t, func() {
+		exitCode = LsCommand([]string{"--json", "--password-file", pwFile, backupName})
+	})
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", exitCode, stderr)
+	}
+
+	var entries []backup.ManifestEntry
+	if err := json.Unmarshal([]byte(stdout), &entries); err != nil {
+		t.Fatalf("invalid JSON output: %v\n%s", err, stdout)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+	for _, e := range entries {
+		if e.SHA256 == "" {
+			t.Errorf("entry %s has no hash", e.Path)
+		}
+	}
+}
+
+func TestLsCommand_Errors(t *testing.T) {
+	backupName, pwFile := setupLsTest(t)
+
+	wrongPw := filepath.Join(t.TempDir(), "wrong")
+	if err := os.WriteFile(wrongPw, []byte("wrong-password"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"no arguments", []string{}, "backup name required"},
+		{"missing backup", []string{"--password-file", pwFile, "backup-missing"}, "backup not found"},
+		{"wrong password", []string{"--password-file", wrongPw, backupName}, "Error:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var exitCode int
+			_, stderr := captureStdoutStderr(t, func() {
+				exitCode = LsCommand(tt.args)
+			})
+			if exitCode != 1 {
+				t.Errorf("exit code = %d, want 1", exitCode)
+			}
+			if !strings.Contains(stderr, tt.wantErr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantErr)
+			}
+		})
+	}
+}
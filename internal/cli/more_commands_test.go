@@ -15,7 +15,7 @@ func TestGetPassword(t *testing.T) {
 		if err := os.WriteFile(pwFile, []byte("secret\n"), 0600); err != nil {
 			t.Fatal(err)
 		}
-		got, err := getPassword(pwFile)
+		got, err := getPassword(pwFile, "")
 		if err != nil {
 			t.Fatalf("getPassword error: %v", err)
 		}
@@ -30,7 +30,7 @@ func TestGetPassword(t *testing.T) {
 		if err := os.WriteFile(pwFile, []byte("from-file"), 0600); err != nil {
 			t.Fatal(err)
 		}
-		got, err := getPassword(pwFile)
+		got, err := getPassword(pwFile, "")
 		if err != nil {
 			t.Fatalf("getPassword error: %v", err)
 		}
@@ -41,7 +41,7 @@ func TestGetPassword(t *testing.T) {
 
 	t.Run("env fallback", func(t *testing.T) {
 		t.Setenv("DOTKEEPER_PASSWORD", "from-env")
-		got, err := getPassword("")
+		got, err := getPassword("", "")
 		if err != nil {
 			t.Fatalf("getPassword error: %v", err)
 		}
@@ -51,7 +51,7 @@ func TestGetPassword(t *testing.T) {
 	})
 
 	t.Run("file read error", func(t *testing.T) {
-		_, err := getPassword(filepath.Join(t.TempDir(), "missing"))
+		_, err := getPassword(filepath.Join(t.TempDir(), "missing"), "")
 		if err == nil {
 			t.Fatal("expected error")
 		}
@@ -99,6 +99,7 @@ func TestDeleteCommand(t *testing.T) {
 	t.Run("deletes backup and metadata", func(t *testing.T) {
 		backupDir := t.TempDir()
 		setup(t, backupDir)
+		t.Setenv("DOTKEEPER_PASSWORD", "pw")
 		name := "backup-2026-01-01-010101.tar.gz.enc"
 		encPath := filepath.Join(backupDir, name)
 		metaPath := encPath + ".meta.json"
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExtractProfileFlag removes the global --profile flag from args so it can
+// be given before or after the command name, as in
+// "dotkeeper --profile work backup" or "dotkeeper backup --profile=work".
+// Arguments after "--" are left alone.
+func ExtractProfileFlag(args []string) (string, []string, error) {
+	var profile string
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "profile" {
+			rest = append(rest, arg)
+			continue
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("flag needs an argument: --profile")
+			}
+			i++
+			value = args[i]
+		}
+		if value == "" {
+			return "", nil, fmt.Errorf("profile name must not be empty")
+		}
+		profile = value
+	}
+
+	return profile, rest, nil
+}
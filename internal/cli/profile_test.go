@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestExtractProfileFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		profile string
+		rest    []string
+		wantErr bool
+	}{
+		{"none", []string{"backup", "--notify"}, "", []string{"backup", "--notify"}, false},
+		{"before command", []string{"--profile", "work", "backup"}, "work", []string{"backup"}, false},
+		{"after command", []string{"list", "--json", "-profile=work"}, "work", []string{"list", "--json"}, false},
+		{"after terminator", []string{"restore", "--", "--profile"}, "", []string{"restore", "--", "--profile"}, false},
+		{"missing value", []string{"backup", "--profile"}, "", nil, true},
+		{"empty value", []string{"--profile=", "backup"}, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, rest, err := ExtractProfileFlag(tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if profile != tt.profile || !reflect.DeepEqual(rest, tt.rest) {
+				t.Errorf("got %q %v, want %q %v", profile, rest, tt.profile, tt.rest)
+			}
+		})
+	}
+}
+
+func TestConfigCommand_Profile(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmp)
+	t.Cleanup(func() { config.SelectProfile("") })
+	writeCLIConfig(t, tmp, &config.Config{BackupDir: "/backups", Files: []string{".bashrc"}})
+
+	// Setting a key on a new profile creates it
+	config.SelectProfile("work")
+	var exit int
+	_, stderr := captureStdoutStderr(t, func() {
+		exit = configSet([]string{"files", ".kube/config"})
+	})
+	if exit != 0 {
+		t.Fatalf("configSet exit = %d, stderr=%s", exit, stderr)
+	}
+
+	stdout, _ := captureStdoutStderr(t, func() {
+		exit = configList()
+	})
+	for _, want := range []string{"profile:        work", "/backups/work", "[.kube/config]", "backup-password-work"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("list output missing %q:\n%s", want, stdout)
+		}
+	}
+
+	config.SelectProfile("")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Files, []string{".bashrc"}) {
+		t.Errorf("top-level files changed: %v", cfg.Files)
+	}
+	if p := cfg.Profiles["work"]; p == nil || !reflect.DeepEqual(p.Files, []string{".kube/config"}) {
+		t.Errorf("work profile = %+v", p)
+	}
+}
+
+func TestInstallUnit_Profile(t *testing.T) {
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "dotkeeper.timer")
+	if err := os.WriteFile(src, []byte("Requires=dotkeeper.service\nExecStart=dotkeeper backup\nOnCalendar=daily\nOnCalendar=*-*-* 02:00:00\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	service, timer := unitNames("work")
+	if service != "dotkeeper-work.service" || timer != "dotkeeper-work.timer" {
+		t.Fatalf("unitNames = %s %s", service, timer)
+	}
+
+	dst := filepath.Join(tmp, timer)
+	if err := installUnit(src, dst, "work", "30 9 * * 1-5"); err != nil {
+		t.Fatalf("installUnit failed: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Requires=dotkeeper-work.service\nExecStart=dotkeeper --profile work backup\nOnCalendar=Mon,Tue,Wed,Thu,Fri *-*-* 09:30:00\n"
+	if string(data) != want {
+		t.Errorf("unit = %q, want %q", data, want)
+	}
+
+	if err := installUnit(src, dst, "work", "every day"); err == nil {
+		t.Error("expected an invalid schedule to fail")
+	}
+}
+
+func TestOnCalendar(t *testing.T) {
+	tests := []struct {
+		schedule string
+		want     string
+	}{
+		{"0 2 * * *", "*-*-* 02:00:00"},
+		{"*/15 * * * *", "*-*-* *:00/15:00"},
+		{"0 9-17 * * 1-5", "Mon,Tue,Wed,Thu,Fri *-*-* 09..17:00:00"},
+		{"0 3 1,15 * *", "*-*-01,15 03:00:00"},
+		{"0 4 * 6 sun", "Sun *-06-* 04:00:00"},
+		{"0 4 * * 0-6", "*-*-* 04:00:00"},
+		{"0 5 * * 6-7", "Sun,Sat *-*-* 05:00:00"},
+		{"@weekly", "Sun *-*-* 00:00:00"},
+		{"@daily", "daily"},
+	}
+	for _, tt := range tests {
+		got, err := onCalendar(tt.schedule)
+		if err != nil || got != tt.want {
+			t.Errorf("onCalendar(%q) = %q, %v; want %q", tt.schedule, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "0 2 * *", "60 2 * * *", "0 2 1 * 1", "0 2 * * 8", "0 5-2 * * *", "@reboot"} {
+		if _, err := onCalendar(bad); err == nil {
+			t.Errorf("onCalendar(%q) succeeded, want an error", bad)
+		}
+	}
+}
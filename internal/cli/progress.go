@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/diogo/dotkeeper/internal/progress"
+)
+
+// progressUsage describes the --progress flag
+const progressUsage = "Report progress as it runs: json writes one event per line to stderr"
+
+// progressFunc returns the progress reporter for a --progress value, or
+// nil when progress is not reported
+func progressFunc(format string, w io.Writer) (progress.Func, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case "json":
+		enc := json.NewEncoder(w)
+		return func(e progress.Event) {
+			_ = enc.Encode(e)
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid --progress %q (use json)", format)
+	}
+}
+
+// interruptContext returns a context cancelled on SIGINT or SIGTERM, so an
+// interrupted backup or restore cleans up before exiting
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
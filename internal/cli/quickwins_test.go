@@ -150,6 +150,17 @@ func TestSetConfigValue(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:  "lock_wait",
+			key:   "lock-wait",
+			value: "5m",
+			assertion: func(t *testing.T, cfg *config.Config) {
+				if cfg.LockWait != "5m" {
+					t.Fatalf("LockWait = %q", cfg.LockWait)
+				}
+			},
+		},
+		{name: "invalid lock_wait", key: "lock_wait", value: "soon", wantErr: true},
 		{name: "invalid bool", key: "notifications", value: "maybe", wantErr: true},
 		{name: "unknown key", key: "not_a_key", value: "x", wantErr: true},
 	}
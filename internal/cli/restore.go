@@ -1,14 +1,20 @@
 package cli
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/capture"
 	"github.com/diogo/dotkeeper/internal/config"
 	"github.com/diogo/dotkeeper/internal/history"
+	"github.com/diogo/dotkeeper/internal/lock"
 	"github.com/diogo/dotkeeper/internal/restore"
 )
 
@@ -20,6 +26,14 @@ func RestoreCommand(args []string) int {
 	passwordFile := fs.String("password-file", "", "Path to file containing password")
 	dryRun := fs.Bool("dry-run", false, "Preview restore without making changes")
 	showDiff := fs.Bool("diff", false, "Show differences between backup and current files")
+	var mappings mappingFlag
+	fs.Var(&mappings, "map", "Restore entries under FROM to TO instead, e.g. $HOME=/home/bob (repeatable)")
+	var captures captureFlag
+	fs.Var(&captures, "capture", "Extract the named command capture instead of the files (repeatable)")
+	captureDir := fs.String("capture-dir", ".", "Directory extracted captures are written to")
+	apply := fs.Bool("apply", false, "Run each extracted capture's apply command with its output on stdin")
+	listCaptures := fs.Bool("list-captures", false, "List the command captures in the backup")
+	progressFormat := fs.String("progress", "", progressUsage)
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: dotkeeper restore [options] <backup-name>\n\n")
 		fmt.Fprintf(os.Stderr, "Restore dotfiles from a backup.\n\n")
@@ -27,12 +41,25 @@ func RestoreCommand(args []string) int {
 		fs.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
 		fmt.Fprintf(os.Stderr, "  DOTKEEPER_PASSWORD    Password for decryption (non-interactive mode)\n")
+		fmt.Fprintf(os.Stderr, "\nFiles under the home and config directories are restored into the current\n")
+		fmt.Fprintf(os.Stderr, "user's $HOME and $XDG_CONFIG_HOME; use --map for anything else.\n")
+		fmt.Fprintf(os.Stderr, "Command captures are only extracted with --capture.\n")
+		fmt.Fprintf(os.Stderr, "An interrupted restore leaves files already restored in place.\n")
 	}
 
 	if err := fs.Parse(args); err != nil {
 		// flag.ContinueOnError already printed the error
 		return 1
 	}
+	report, err := progressFunc(*progressFormat, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *apply && len(captures) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: --apply needs --capture\n")
+		return 1
+	}
 
 	// Load config
 	cfg, err := config.Load()
@@ -54,25 +81,33 @@ func RestoreCommand(args []string) int {
 		return 1
 	}
 
-	// Construct backup path
-	backupPath := filepath.Join(cfg.BackupDir, backupName)
-	if !strings.HasSuffix(backupPath, ".tar.gz.enc") {
-		backupPath += ".tar.gz.enc"
-	}
-
-	// Check if backup exists
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: backup not found: %s\n", backupPath)
+	// Find the backup archive or snapshot
+	target, err := backup.Resolve(cfg.BackupDir, backupName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return 1
 	}
+	backupPath := target.Path
 
 	// Get password
-	password, err := getPassword(*passwordFile)
+	password, err := getPassword(*passwordFile, cfg.Keyring)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error getting password: %v\n", err)
 		return 1
 	}
 
+	// Restores may run together, but not alongside a backup or delete
+	l, err := cfg.Lock(lock.Shared, "restore")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer l.Release()
+
+	if *listCaptures {
+		return printCaptures(backupPath, password)
+	}
+
 	// Perform restore
 	fmt.Printf("Restoring from %s...\n", backupName)
 
@@ -80,12 +115,27 @@ func RestoreCommand(args []string) int {
 		Force:    *force,
 		DryRun:   *dryRun,
 		ShowDiff: *showDiff,
+		Mappings: mappings,
+		Progress: report,
+		Hooks:    cfg.Hooks,
+		HookEnv: map[string]string{
+			"DOTKEEPER_BACKUP_DIR": cfg.BackupDir,
+			"DOTKEEPER_PROFILE":    cfg.Profile(),
+		},
 	}
 	if *showDiff {
 		opts.DiffWriter = os.Stdout
 	}
+	if len(captures) > 0 {
+		opts.CaptureDir = *captureDir
+		for _, name := range captures {
+			opts.SelectedFiles = append(opts.SelectedFiles, capture.Prefix+name)
+		}
+	}
 
-	result, err := restore.Restore(backupPath, password, opts)
+	ctx, stop := interruptContext()
+	defer stop()
+	result, err := restoreBackup(ctx, backupPath, password, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
 		// Log error to history (best-effort, don't fail if logging fails)
@@ -103,6 +153,21 @@ func RestoreCommand(args []string) int {
 		return 0
 	}
 
+	for _, problem := range result.MetadataErrors {
+		fmt.Fprintf(os.Stderr, "Warning: metadata not restored: %s\n", problem)
+	}
+	warnFailedHooks(result.Hooks)
+
+	if len(captures) > 0 {
+		if missing := missingCaptures(captures, *captureDir, result); len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: capture not found in backup: %s\n", strings.Join(missing, ", "))
+			result.FilesSkipped += len(missing)
+		}
+		if *apply && !applyCaptures(cfg.Captures, result.Captures) {
+			result.FilesSkipped++
+		}
+	}
+
 	if result.FilesSkipped > 0 {
 		fmt.Printf("⚠ Restore completed with warnings\n")
 		fmt.Printf("  Files restored: %d\n", result.FilesRestored)
@@ -120,3 +185,122 @@ func RestoreCommand(args []string) int {
 	logHistory(store, storeErr, history.EntryFromRestoreResult(result, backupPath))
 	return 0
 }
+
+// restoreBackup opens a backup session and restores from it
+func restoreBackup(ctx context.Context, backupPath, password string, opts restore.RestoreOptions) (*restore.RestoreResult, error) {
+	session, err := restore.OpenSession(backupPath, password)
+	if err != nil {
+		return nil, err
+	}
+	return session.Restore(ctx, opts)
+}
+
+// mappingFlag collects repeated --map FROM=TO flags
+type mappingFlag []restore.Mapping
+
+func (f *mappingFlag) String() string {
+	parts := make([]string, len(*f))
+	for i, m := range *f {
+		parts[i] = m.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *mappingFlag) Set(value string) error {
+	m, err := restore.ParseMapping(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
+
+// captureFlag collects repeated --capture NAME flags
+type captureFlag []string
+
+func (f *captureFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *captureFlag) Set(value string) error {
+	if value == "" || strings.Contains(value, "/") {
+		return fmt.Errorf("invalid capture name %q", value)
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// printCaptures lists the command captures stored in a backup
+func printCaptures(backupPath, password string) int {
+	session, err := restore.OpenSession(backupPath, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	names, err := session.Captures()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(names) == 0 {
+		fmt.Println("No captures in this backup")
+		return 0
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return 0
+}
+
+// missingCaptures returns the requested captures the backup does not hold:
+// those neither written nor skipped by the restore
+func missingCaptures(names []string, dir string, result *restore.RestoreResult) []string {
+	var missing []string
+	for _, name := range names {
+		_, restored := result.Captures[name]
+		if !restored && !slices.Contains(result.SkippedFiles, filepath.Join(dir, name)) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// applyCaptures runs the configured apply command of every restored capture
+// with the captured output on stdin. It reports whether all of them
+// succeeded.
+func applyCaptures(configured []capture.Capture, restored map[string]string) bool {
+	names := make([]string, 0, len(restored))
+	for name := range restored {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ok := true
+	for _, name := range names {
+		c, found := capture.Find(configured, name)
+		if !found || c.Apply == "" {
+			fmt.Fprintf(os.Stderr, "Warning: capture %s has no apply command configured\n", name)
+			ok = false
+			continue
+		}
+
+		f, err := os.Open(restored[name])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to open capture %s: %v\n", name, err)
+			ok = false
+			continue
+		}
+		output, err := c.ApplyOutput(f)
+		f.Close()
+		if output != "" {
+			fmt.Print(output)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			ok = false
+			continue
+		}
+		fmt.Printf("  Applied capture: %s\n", name)
+	}
+	return ok
+}
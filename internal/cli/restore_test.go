@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/capture"
 	"github.com/diogo/dotkeeper/internal/config"
 )
 
@@ -43,7 +45,7 @@ func createTestBackup(t *testing.T, tmpDir string, files map[string]string) (str
 
 	password := "test-password-123"
 
-	result, err := backup.Backup(cfg, password)
+	result, err := backup.Backup(context.Background(), cfg, password)
 	if err != nil {
 		t.Fatalf("Failed to create test backup: %v", err)
 	}
@@ -514,3 +516,117 @@ func TestRestoreCommand_MissingBackupName(t *testing.T) {
 		t.Errorf("Expected 'backup name required' in error message, got: %s", output)
 	}
 }
+
+func TestRestoreCommand_Map(t *testing.T) {
+	tmpDir := t.TempDir()
+	setupTestConfig(t, tmpDir)
+
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{"mapped.txt": "mapped"})
+	pwFile := filepath.Join(tmpDir, "password")
+	if err := os.WriteFile(pwFile, []byte(password), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(tmpDir, "elsewhere")
+	var exitCode int
+	_, stderr := captureStdoutStderr(t, func() {
+		exitCode = RestoreCommand([]string{
+			"--password-file", pwFile,
+			"--map", filepath.Join(tmpDir, "source") + "=" + target,
+			filepath.Base(backupPath),
+		})
+	})
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", exitCode, stderr)
+	}
+	if got, err := os.ReadFile(filepath.Join(target, "mapped.txt")); err != nil || string(got) != "mapped" {
+		t.Errorf("mapped file = %q, %v", got, err)
+	}
+
+	_, stderr = captureStdoutStderr(t, func() {
+		exitCode = RestoreCommand([]string{"--map", "no-equals", filepath.Base(backupPath)})
+	})
+	if exitCode != 1 || !strings.Contains(stderr, "invalid mapping") {
+		t.Errorf("exit code = %d, stderr = %q", exitCode, stderr)
+	}
+}
+
+func TestRestoreCommand_Captures(t *testing.T) {
+	tmpDir := t.TempDir()
+	applied := filepath.Join(tmpDir, "applied.txt")
+	cfg := &config.Config{
+		BackupDir: filepath.Join(tmpDir, "backups"),
+		Captures: []capture.Capture{
+			{Name: "packages", Command: "printf 'git\\nvim\\n'", Apply: "cat > " + applied},
+			{Name: "crontab", Command: "echo '@daily true'"},
+		},
+	}
+	configDir := filepath.Join(tmpDir, "config")
+	if err := cfg.SaveToPath(filepath.Join(configDir, "dotkeeper", "config.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+
+	password := "test-password-123"
+	result, err := backup.Backup(context.Background(), cfg, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwFile := filepath.Join(tmpDir, "password")
+	if err := os.WriteFile(pwFile, []byte(password), 0600); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Base(result.BackupPath)
+
+	var exitCode int
+	stdout, stderr := captureStdoutStderr(t, func() {
+		exitCode = RestoreCommand([]string{"--password-file", pwFile, "--list-captures", name})
+	})
+	if exitCode != 0 || stdout != "packages\ncrontab\n" {
+		t.Fatalf("exit code = %d, stdout = %q, stderr = %s", exitCode, stdout, stderr)
+	}
+
+	captureDir := filepath.Join(tmpDir, "out")
+	stdout, stderr = captureStdoutStderr(t, func() {
+		exitCode = RestoreCommand([]string{
+			"--password-file", pwFile,
+			"--capture", "packages",
+			"--capture-dir", captureDir,
+			"--apply",
+			name,
+		})
+	})
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d, stderr: %s", exitCode, stderr)
+	}
+	if !strings.Contains(stdout, "Applied capture: packages") {
+		t.Errorf("stdout = %q", stdout)
+	}
+	if got, err := os.ReadFile(applied); err != nil || string(got) != "git\nvim\n" {
+		t.Errorf("apply command read %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(captureDir, "crontab")); !os.IsNotExist(err) {
+		t.Error("unselected capture should not be extracted")
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		wantErr  string
+	}{
+		{"apply without capture", []string{"--apply", name}, 1, "--apply needs --capture"},
+		{"missing capture", []string{"--password-file", pwFile, "--capture", "nope", "--capture-dir", captureDir, name}, 2, "capture not found in backup: nope"},
+		{"no apply command", []string{"--password-file", pwFile, "--force", "--capture", "crontab", "--capture-dir", captureDir, "--apply", name}, 2, "has no apply command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stderr := captureStdoutStderr(t, func() {
+				exitCode = RestoreCommand(tt.args)
+			})
+			if exitCode != tt.wantCode || !strings.Contains(stderr, tt.wantErr) {
+				t.Errorf("exit code = %d, stderr = %q; want %d and %q", exitCode, stderr, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/notify"
+	"github.com/diogo/dotkeeper/internal/secrets"
+)
+
+// ScanCommand handles the scan subcommand, which runs the secret scanner
+// over the configured paths, or the given ones, without backing up
+func ScanCommand(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	jsonOutput := fs.Bool("json", false, "Print findings as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper scan [--json] [path...]\n\n")
+		fmt.Fprintf(os.Stderr, "Check files for private keys, credentials and tokens. Without paths the\n")
+		fmt.Fprintf(os.Stderr, "configured files and folders are scanned. Exits with 2 when secrets are found.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+	if err := cfg.Secrets.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = append(cfg.ActiveFiles(), cfg.ActiveFolders()...)
+	}
+	collector := &backup.Collector{Exclude: cfg.Exclude, Symlinks: cfg.Symlinks}
+	files := collector.Collect(paths).Files
+	findings := backup.FindSecrets(files, secrets.New(cfg.Secrets))
+
+	if *jsonOutput {
+		if findings == nil {
+			findings = []secrets.Finding{}
+		}
+		data, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+		fmt.Printf("Scanned %d file(s), %d finding(s)\n", len(files), len(findings))
+	}
+
+	if len(findings) > 0 {
+		return 2
+	}
+	return 0
+}
+
+// confirmSecret returns the backup's confirmation callback: everything is
+// approved with --allow-secrets and the user is asked when stdin is a
+// terminal. Otherwise there is none, and the unattended action of the
+// secrets config decides about files needing confirmation.
+func confirmSecret(allow bool) func(string, []secrets.Finding) bool {
+	if allow {
+		return func(string, []secrets.Finding) bool { return true }
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return func(path string, findings []secrets.Finding) bool {
+		fmt.Printf("Possible secrets in %s:\n", path)
+		for _, f := range findings {
+			fmt.Printf("  - %s\n", f)
+		}
+		fmt.Printf("Back it up anyway? [y/N] ")
+		var answer string
+		fmt.Scanln(&answer)
+		return answer == "y" || answer == "Y"
+	}
+}
+
+// printSecretFindings reports the scanner's findings after a backup. Files
+// backed up although nobody could confirm them also raise an urgent desktop
+// notification, whatever the notifications setting, since scheduled runs
+// have no other way to reach the user.
+func printSecretFindings(findings []secrets.Finding, excluded, unconfirmed []string) {
+	for _, f := range findings {
+		if !slices.Contains(excluded, f.Path) && !slices.Contains(unconfirmed, f.Path) {
+			fmt.Fprintf(os.Stderr, "Warning: possible secret backed up: %s\n", f)
+		}
+	}
+	for _, path := range excluded {
+		fmt.Fprintf(os.Stderr, "Warning: left out because of secrets: %s\n", path)
+	}
+	for _, path := range unconfirmed {
+		fmt.Fprintf(os.Stderr, "Warning: backed up without confirmation because of secrets: %s\n", path)
+	}
+	if len(unconfirmed) > 0 {
+		notify.SendUnconfirmedSecrets(unconfirmed)
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/diogo/dotkeeper/internal/config"
 )
 
 const (
@@ -43,15 +47,23 @@ func EnableSchedule() error {
 		return fmt.Errorf("failed to create systemd directory: %w", err)
 	}
 
+	// The timer runs on the schedule of the selected profile
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	profile := cfg.Profile()
+	service, timer := unitNames(profile)
+
 	// Copy service file
-	destServiceFile := filepath.Join(userSystemdDir, serviceName)
-	if err := copyFile(serviceFile, destServiceFile); err != nil {
+	destServiceFile := filepath.Join(userSystemdDir, service)
+	if err := installUnit(serviceFile, destServiceFile, profile, cfg.Schedule); err != nil {
 		return fmt.Errorf("failed to copy service file: %w", err)
 	}
 
 	// Copy timer file
-	destTimerFile := filepath.Join(userSystemdDir, timerName)
-	if err := copyFile(timerFile, destTimerFile); err != nil {
+	destTimerFile := filepath.Join(userSystemdDir, timer)
+	if err := installUnit(timerFile, destTimerFile, profile, cfg.Schedule); err != nil {
 		return fmt.Errorf("failed to copy timer file: %w", err)
 	}
 
@@ -61,11 +73,11 @@ func EnableSchedule() error {
 	}
 
 	// Enable and start the timer
-	if err := runSystemctl("enable", timerName); err != nil {
+	if err := runSystemctl("enable", timer); err != nil {
 		return fmt.Errorf("failed to enable timer: %w", err)
 	}
 
-	if err := runSystemctl("start", timerName); err != nil {
+	if err := runSystemctl("start", timer); err != nil {
 		return fmt.Errorf("failed to start timer: %w", err)
 	}
 
@@ -73,9 +85,9 @@ func EnableSchedule() error {
 	fmt.Printf("  Service file: %s\n", destServiceFile)
 	fmt.Printf("  Timer file: %s\n", destTimerFile)
 	fmt.Println("\nTo check timer status, run:")
-	fmt.Printf("  systemctl --user status %s\n", timerName)
+	fmt.Printf("  systemctl --user status %s\n", timer)
 	fmt.Println("\nTo view timer schedule, run:")
-	fmt.Printf("  systemctl --user list-timers %s\n", timerName)
+	fmt.Printf("  systemctl --user list-timers %s\n", timer)
 
 	return nil
 }
@@ -87,12 +99,14 @@ func DisableSchedule() error {
 		return fmt.Errorf("systemd is not available on this system")
 	}
 
+	service, timer := unitNames(config.SelectedProfile())
+
 	// Stop and disable the timer
-	if err := runSystemctl("stop", timerName); err != nil {
+	if err := runSystemctl("stop", timer); err != nil {
 		fmt.Printf("Warning: failed to stop timer: %v\n", err)
 	}
 
-	if err := runSystemctl("disable", timerName); err != nil {
+	if err := runSystemctl("disable", timer); err != nil {
 		fmt.Printf("Warning: failed to disable timer: %v\n", err)
 	}
 
@@ -103,8 +117,8 @@ func DisableSchedule() error {
 	}
 
 	// Remove service and timer files
-	destServiceFile := filepath.Join(userSystemdDir, serviceName)
-	destTimerFile := filepath.Join(userSystemdDir, timerName)
+	destServiceFile := filepath.Join(userSystemdDir, service)
+	destTimerFile := filepath.Join(userSystemdDir, timer)
 
 	if err := os.Remove(destServiceFile); err != nil && !os.IsNotExist(err) {
 		fmt.Printf("Warning: failed to remove service file: %v\n", err)
@@ -131,8 +145,10 @@ func StatusSchedule() error {
 		return fmt.Errorf("systemd is not available on this system")
 	}
 
+	_, timer := unitNames(config.SelectedProfile())
+
 	// Show timer status
-	cmd := exec.Command("systemctl", "--user", "status", timerName)
+	cmd := exec.Command("systemctl", "--user", "status", timer)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -144,7 +160,7 @@ func StatusSchedule() error {
 	fmt.Println("\n--- Timer Schedule ---")
 
 	// Show timer schedule
-	cmd = exec.Command("systemctl", "--user", "list-timers", timerName)
+	cmd = exec.Command("systemctl", "--user", "list-timers", timer)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -235,3 +251,218 @@ func copyFile(src, dst string) error {
 	}
 	return os.WriteFile(dst, data, 0644)
 }
+
+// unitNames returns the systemd service and timer names for a profile.
+// Each profile gets its own pair so their schedules run independently.
+func unitNames(profile string) (string, string) {
+	if profile == "" {
+		return serviceName, timerName
+	}
+	name := "dotkeeper-" + profile
+	return name + ".service", name + ".timer"
+}
+
+// installUnit copies a unit file, rewriting it for a profile so the service
+// backs up that profile and the timer requires the profile's service. A
+// non-empty schedule, in cron format, replaces the timer's OnCalendar.
+func installUnit(src, dst, profile, schedule string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	unit := string(data)
+
+	if profile != "" {
+		service, _ := unitNames(profile)
+		unit = strings.ReplaceAll(unit, "dotkeeper backup", "dotkeeper --profile "+profile+" backup")
+		unit = strings.ReplaceAll(unit, "Requires="+serviceName, "Requires="+service)
+	}
+
+	if schedule != "" {
+		calendar, err := onCalendar(schedule)
+		if err != nil {
+			return fmt.Errorf("invalid schedule %q: %w", schedule, err)
+		}
+		var lines []string
+		replaced := false
+		for _, line := range strings.SplitAfter(unit, "\n") {
+			if strings.HasPrefix(line, "OnCalendar=") {
+				if replaced {
+					continue
+				}
+				line = "OnCalendar=" + calendar + "\n"
+				replaced = true
+			}
+			lines = append(lines, line)
+		}
+		unit = strings.Join(lines, "")
+	}
+	return os.WriteFile(dst, []byte(unit), 0644)
+}
+
+// cronMacros maps the cron shorthands to OnCalendar expressions
+var cronMacros = map[string]string{
+	"@hourly":   "hourly",
+	"@daily":    "daily",
+	"@midnight": "daily",
+	"@weekly":   "Sun *-*-* 00:00:00",
+	"@monthly":  "monthly",
+	"@yearly":   "yearly",
+	"@annually": "yearly",
+}
+
+// weekdays names the cron days of the week, 0 and 7 both being Sunday
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// onCalendar converts a cron schedule, five fields or a shorthand such as
+// @daily, into a systemd OnCalendar expression. Fields may be *, numbers,
+// lists, ranges and steps; cron runs a job when either a restricted day of
+// month or day of week matches, which systemd cannot express, so only one
+// of them may be restricted.
+func onCalendar(schedule string) (string, error) {
+	schedule = strings.TrimSpace(schedule)
+	if strings.HasPrefix(schedule, "@") {
+		calendar, ok := cronMacros[schedule]
+		if !ok {
+			return "", fmt.Errorf("unsupported shorthand %s", schedule)
+		}
+		return calendar, nil
+	}
+
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return "", fmt.Errorf("want 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+	minute, err := cronField(fields[0], 0, 59)
+	if err != nil {
+		return "", fmt.Errorf("minute: %w", err)
+	}
+	hour, err := cronField(fields[1], 0, 23)
+	if err != nil {
+		return "", fmt.Errorf("hour: %w", err)
+	}
+	day, err := cronField(fields[2], 1, 31)
+	if err != nil {
+		return "", fmt.Errorf("day of month: %w", err)
+	}
+	month, err := cronField(fields[3], 1, 12)
+	if err != nil {
+		return "", fmt.Errorf("month: %w", err)
+	}
+	weekday, err := cronWeekday(fields[4])
+	if err != nil {
+		return "", fmt.Errorf("day of week: %w", err)
+	}
+	if day != "*" && weekday != "" {
+		return "", fmt.Errorf("restricting both day of month and day of week is not supported")
+	}
+
+	calendar := fmt.Sprintf("*-%s-%s %s:%s:00", month, day, hour, minute)
+	if weekday != "" {
+		calendar = weekday + " " + calendar
+	}
+	return calendar, nil
+}
+
+// cronField converts one numeric cron field to its OnCalendar form
+func cronField(field string, lo, hi int) (string, error) {
+	if field == "*" {
+		return "*", nil
+	}
+	parts := strings.Split(field, ",")
+	for i, part := range parts {
+		base, step, hasStep := strings.Cut(part, "/")
+		if hasStep {
+			n, err := strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return "", fmt.Errorf("invalid step in %q", part)
+			}
+			if base == "*" {
+				base = strconv.Itoa(lo)
+			}
+			start, err := cronNumber(base, lo, hi)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = fmt.Sprintf("%02d/%d", start, n)
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := cronNumber(from, lo, hi)
+		if err != nil {
+			return "", err
+		}
+		if !isRange {
+			parts[i] = fmt.Sprintf("%02d", start)
+			continue
+		}
+		end, err := cronNumber(to, lo, hi)
+		if err != nil {
+			return "", err
+		}
+		if end < start {
+			return "", fmt.Errorf("invalid range %q", part)
+		}
+		parts[i] = fmt.Sprintf("%02d..%02d", start, end)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// cronWeekday converts the cron day of week field to a list of OnCalendar
+// weekday names, or "" when every day matches. Ranges are spelled out,
+// since cron weeks start on Sunday and systemd weeks on Monday.
+func cronWeekday(field string) (string, error) {
+	if field == "*" {
+		return "", nil
+	}
+	var days [7]bool
+	for _, part := range strings.Split(field, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := cronDay(from)
+		if err != nil {
+			return "", err
+		}
+		end := start
+		if isRange {
+			if end, err = cronDay(to); err != nil {
+				return "", err
+			}
+			if end < start {
+				return "", fmt.Errorf("invalid range %q", part)
+			}
+		}
+		for d := start; d <= end; d++ {
+			days[d%7] = true
+		}
+	}
+
+	var names []string
+	for d, set := range days {
+		if set {
+			names = append(names, weekdays[d])
+		}
+	}
+	if len(names) == len(days) {
+		return "", nil
+	}
+	return strings.Join(names, ","), nil
+}
+
+// cronDay parses a day of week, as a number from 0 to 7 or a name
+func cronDay(s string) (int, error) {
+	for i, name := range weekdays[:7] {
+		if strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	return cronNumber(s, 0, 7)
+}
+
+// cronNumber parses a cron field value within [lo, hi]
+func cronNumber(s string, lo, hi int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%q is not a number from %d to %d", s, lo, hi)
+	}
+	return n, nil
+}
@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/lock"
+)
+
+// TagCommand handles the tag subcommand
+func TagCommand(args []string) int {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	note := fs.String("note", "", "Set the backup's note")
+	passwordFile := fs.String("password-file", "", "Path to file containing the password")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper tag [--note TEXT] <backup-name> [tag...]\n\n")
+		fmt.Fprintf(os.Stderr, "Add tags to a backup, or set its note. Labels are authenticated with\n")
+		fmt.Fprintf(os.Stderr, "the backup's key, so changing them takes the password.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+	if fs.NArg() < 1 || (fs.NArg() == 1 && *note == "") {
+		fmt.Fprintf(os.Stderr, "Error: backup name and at least one tag or --note required\n")
+		fs.Usage()
+		return 1
+	}
+	tags := fs.Args()[1:]
+	for _, tag := range tags {
+		if err := backup.ValidateTag(tag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	return updateLabels("tag", fs.Arg(0), *passwordFile, func(b backup.Info, password string) (backup.Info, error) {
+		return backup.Tag(b, password, tags, *note)
+	})
+}
+
+// UntagCommand handles the untag subcommand
+func UntagCommand(args []string) int {
+	fs := flag.NewFlagSet("untag", flag.ExitOnError)
+	passwordFile := fs.String("password-file", "", "Path to file containing the password")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper untag [options] <backup-name> <tag>...\n\n")
+		fmt.Fprintf(os.Stderr, "Remove tags from a backup.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+	if fs.NArg() < 2 {
+		fmt.Fprintf(os.Stderr, "Error: backup name and at least one tag required\n")
+		fs.Usage()
+		return 1
+	}
+
+	tags := fs.Args()[1:]
+	return updateLabels("untag", fs.Arg(0), *passwordFile, func(b backup.Info, password string) (backup.Info, error) {
+		return backup.Untag(b, password, tags)
+	})
+}
+
+// PinCommand handles the pin subcommand
+func PinCommand(args []string) int {
+	return pinCommand("pin", true, args)
+}
+
+// UnpinCommand handles the unpin subcommand
+func UnpinCommand(args []string) int {
+	return pinCommand("unpin", false, args)
+}
+
+func pinCommand(name string, pinned bool, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	passwordFile := fs.String("password-file", "", "Path to file containing the password")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dotkeeper %s [options] <backup-name>\n\n", name)
+		if pinned {
+			fmt.Fprintf(os.Stderr, "Pin a backup so that it cannot be deleted.\n\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "Unpin a backup so that it can be deleted again.\n\n")
+		}
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: backup name required\n")
+		fs.Usage()
+		return 1
+	}
+
+	return updateLabels(name, fs.Arg(0), *passwordFile, func(b backup.Info, password string) (backup.Info, error) {
+		return backup.SetPinned(b, password, pinned)
+	})
+}
+
+// updateLabels applies update to the named backup with the password under
+// the backup directory lock and prints the labels it ends up with
+func updateLabels(operation, name, passwordFile string, update func(backup.Info, string) (backup.Info, error)) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+
+	password, err := getPassword(passwordFile, cfg.Keyring)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting password: %v\n", err)
+		return 1
+	}
+
+	l, err := cfg.Lock(lock.Exclusive, operation)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer l.Release()
+
+	target, err := backup.Resolve(cfg.BackupDir, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	updated, err := update(target, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error updating %s: %v\n", target.Name, err)
+		return 1
+	}
+
+	fmt.Printf("%s: %s\n", updated.Name, formatLabels(updated.Labels))
+	if updated.Note != "" {
+		fmt.Printf("  Note: %s\n", updated.Note)
+	}
+	return 0
+}
+
+// formatLabels renders a backup's pin and tags for listings
+func formatLabels(labels backup.Labels) string {
+	parts := make([]string, 0, len(labels.Tags)+1)
+	if labels.Pinned {
+		parts = append(parts, "[pinned]")
+	}
+	parts = append(parts, labels.Tags...)
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tagFlag collects repeated --tag TAG flags
+type tagFlag []string
+
+func (f *tagFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *tagFlag) Set(value string) error {
+	if err := backup.ValidateTag(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
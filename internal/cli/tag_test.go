@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestTagAndPinCommands(t *testing.T) {
+	tmpDir := setupDeleteTest(t)
+	source := filepath.Join(t.TempDir(), "bashrc")
+	if err := os.WriteFile(source, []byte("export EDITOR=vim\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := backup.Backup(context.Background(), &config.Config{BackupDir: tmpDir, Files: []string{source}}, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimSuffix(result.BackupName, ".tar.gz.enc")
+	backupPath := result.BackupPath
+
+	var exit int
+	_, stderr := captureStdoutStderr(t, func() {
+		exit = TagCommand([]string{"--note", "last X11 setup", name, "before-hyprland", "x11"})
+	})
+	if exit != 0 {
+		t.Fatalf("tag exit = %d, stderr=%s", exit, stderr)
+	}
+	_, stderr = captureStdoutStderr(t, func() {
+		exit = UntagCommand([]string{name, "x11"})
+	})
+	if exit != 0 {
+		t.Fatalf("untag exit = %d, stderr=%s", exit, stderr)
+	}
+
+	stdout, _ := captureStdoutStderr(t, func() {
+		exit = ListCommand([]string{"--tag", "before-hyprland"})
+	})
+	if exit != 0 || !strings.Contains(stdout, name) || !strings.Contains(stdout, "last X11 setup") {
+		t.Errorf("list --tag before-hyprland: exit %d\n%s", exit, stdout)
+	}
+	if strings.Contains(stdout, "x11") {
+		t.Errorf("removed tag still listed:\n%s", stdout)
+	}
+	stdout, _ = captureStdoutStderr(t, func() {
+		exit = ListCommand([]string{"--tag", "x11"})
+	})
+	if !strings.Contains(stdout, "No backups tagged x11") {
+		t.Errorf("list --tag x11:\n%s", stdout)
+	}
+
+	_, stderr = captureStdoutStderr(t, func() {
+		exit = TagCommand([]string{name, "two words"})
+	})
+	if exit == 0 || !strings.Contains(stderr, "whitespace") {
+		t.Errorf("invalid tag accepted: exit %d, stderr=%s", exit, stderr)
+	}
+
+	// Pinned backups cannot be deleted
+	captureStdoutStderr(t, func() {
+		exit = PinCommand([]string{name})
+	})
+	if exit != 0 {
+		t.Fatalf("pin exit = %d", exit)
+	}
+	_, stderr = captureStdoutStderr(t, func() {
+		exit = DeleteCommand([]string{"--force", name})
+	})
+	if exit == 0 || !strings.Contains(stderr, "pinned") {
+		t.Errorf("pinned backup deleted: exit %d, stderr=%s", exit, stderr)
+	}
+	if _, err := os.Stat(backupPath); err != nil {
+		t.Fatalf("pinned backup removed: %v", err)
+	}
+
+	t.Setenv("DOTKEEPER_PASSWORD", "wrong")
+	captureStdoutStderr(t, func() {
+		exit = UnpinCommand([]string{name})
+	})
+	if exit == 0 {
+		t.Error("unpinned with a wrong password")
+	}
+	t.Setenv("DOTKEEPER_PASSWORD", "pw")
+	captureStdoutStderr(t, func() {
+		exit = UnpinCommand([]string{name})
+	})
+	if exit != 0 {
+		t.Fatalf("unpin exit = %d", exit)
+	}
+	captureStdoutStderr(t, func() {
+		exit = DeleteCommand([]string{"--force", name})
+	})
+	if exit != 0 {
+		t.Errorf("unpinned backup not deleted: exit %d", exit)
+	}
+}
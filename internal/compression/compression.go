@@ -0,0 +1,157 @@
+// Package compression selects the compression applied to backup archives.
+// A setting is written as an algorithm with an optional level, such as
+// "zstd", "zstd:19", "gzip:9" or "none".
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported algorithms
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+	None = "none"
+)
+
+// Default is used when no compression is configured, and for backups whose
+// metadata predates the setting
+var Default = Setting{Algorithm: Gzip}
+
+// Setting is an algorithm and level. A zero level means the algorithm's
+// default level.
+type Setting struct {
+	Algorithm string
+	Level     int
+}
+
+// Parse parses a setting such as "zstd:19". An empty string gives Default.
+func Parse(s string) (Setting, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Default, nil
+	}
+
+	name, levelStr, hasLevel := strings.Cut(s, ":")
+	setting := Setting{Algorithm: strings.ToLower(name)}
+	if hasLevel {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil {
+			return Setting{}, fmt.Errorf("invalid compression level %q", levelStr)
+		}
+		setting.Level = level
+	}
+
+	if err := setting.validate(); err != nil {
+		return Setting{}, err
+	}
+	return setting, nil
+}
+
+// validate checks the algorithm and that the level is in its range
+func (s Setting) validate() error {
+	var lo, hi int
+	switch s.Algorithm {
+	case Gzip:
+		lo, hi = gzip.BestSpeed, gzip.BestCompression
+	case Zstd:
+		lo, hi = 1, 22
+	case None:
+		if s.Level != 0 {
+			return fmt.Errorf("compression %q does not take a level", None)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown compression %q (use zstd, gzip or none)", s.Algorithm)
+	}
+
+	if s.Level != 0 && (s.Level < lo || s.Level > hi) {
+		return fmt.Errorf("%s compression level must be between %d and %d", s.Algorithm, lo, hi)
+	}
+	return nil
+}
+
+// String formats the setting the way Parse reads it
+func (s Setting) String() string {
+	if s.Level == 0 {
+		return s.Algorithm
+	}
+	return fmt.Sprintf("%s:%d", s.Algorithm, s.Level)
+}
+
+// Ext returns the file extension of an encrypted tar archive compressed
+// with the algorithm
+func Ext(algorithm string) string {
+	switch algorithm {
+	case Zstd:
+		return ".tar.zst.enc"
+	case None:
+		return ".tar.enc"
+	default:
+		return ".tar.gz.enc"
+	}
+}
+
+// TrimExt removes any known archive extension from a file name
+func TrimExt(name string) string {
+	for _, algorithm := range []string{Gzip, Zstd, None} {
+		if trimmed, ok := strings.CutSuffix(name, Ext(algorithm)); ok {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// NewWriter returns a writer compressing to w. Closing it flushes the
+// compressed stream but does not close w.
+func NewWriter(w io.Writer, s Setting) (io.WriteCloser, error) {
+	switch s.Algorithm {
+	case Gzip, "":
+		level := s.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		level := zstd.SpeedDefault
+		if s.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(s.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case None:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", s.Algorithm)
+	}
+}
+
+// NewReader returns a reader decompressing r. An empty algorithm is read
+// as gzip, which every backup used before the setting existed.
+func NewReader(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case Gzip, "":
+		return gzip.NewReader(r)
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case None:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,112 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Setting
+		wantErr bool
+	}{
+		{"", Default, false},
+		{"zstd", Setting{Algorithm: Zstd}, false},
+		{"ZSTD:19", Setting{Algorithm: Zstd, Level: 19}, false},
+		{"gzip:9", Setting{Algorithm: Gzip, Level: 9}, false},
+		{"none", Setting{Algorithm: None}, false},
+		{"gzip:0", Setting{Algorithm: Gzip}, false},
+		{"zstd:23", Setting{}, true},
+		{"gzip:10", Setting{}, true},
+		{"none:1", Setting{}, true},
+		{"zstd:high", Setting{}, true},
+		{"brotli", Setting{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettingString(t *testing.T) {
+	for _, s := range []string{"zstd", "zstd:3", "gzip:9", "none"} {
+		setting, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if setting.String() != s {
+			t.Errorf("String() = %q, want %q", setting.String(), s)
+		}
+	}
+}
+
+func TestExt(t *testing.T) {
+	tests := map[string]string{
+		Gzip: ".tar.gz.enc",
+		Zstd: ".tar.zst.enc",
+		None: ".tar.enc",
+		"":   ".tar.gz.enc",
+	}
+	for algorithm, want := range tests {
+		if got := Ext(algorithm); got != want {
+			t.Errorf("Ext(%q) = %q, want %q", algorithm, got, want)
+		}
+		if got := TrimExt("backup-1" + want); got != "backup-1" {
+			t.Errorf("TrimExt(%q) = %q", "backup-1"+want, got)
+		}
+	}
+	if got := TrimExt("notes.txt"); got != "notes.txt" {
+		t.Errorf("TrimExt should leave unknown names alone, got %q", got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("dotfiles compress well "), 1000)
+
+	for _, s := range []string{"gzip", "gzip:1", "zstd", "zstd:19", "none"} {
+		t.Run(s, func(t *testing.T) {
+			setting, err := Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, setting)
+			if err != nil {
+				t.Fatalf("NewWriter failed: %v", err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if setting.Algorithm != None && buf.Len() >= len(data) {
+				t.Errorf("%s output is not smaller: %d >= %d", s, buf.Len(), len(data))
+			}
+
+			r, err := NewReader(&buf, setting.Algorithm)
+			if err != nil {
+				t.Fatalf("NewReader failed: %v", err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("round trip mismatch")
+			}
+		})
+	}
+}
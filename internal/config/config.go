@@ -5,7 +5,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/diogo/dotkeeper/internal/capture"
+	"github.com/diogo/dotkeeper/internal/compression"
+	"github.com/diogo/dotkeeper/internal/hooks"
+	"github.com/diogo/dotkeeper/internal/lock"
+	"github.com/diogo/dotkeeper/internal/pathutil"
+	"github.com/diogo/dotkeeper/internal/secrets"
 	"gopkg.in/yaml.v3"
 )
 
@@ -20,6 +27,38 @@ type Config struct {
 	Exclude         []string `yaml:"exclude,omitempty"`
 	DisabledFiles   []string `yaml:"disabled_files,omitempty"`
 	DisabledFolders []string `yaml:"disabled_folders,omitempty"`
+	// Symlinks sets how symlinks are stored under each file or folder;
+	// paths not listed preserve them as links
+	Symlinks SymlinkPolicies `yaml:"symlinks,omitempty"`
+	// SQLite lists patterns of SQLite databases stored as consistent
+	// snapshots, on top of those recognised by their header
+	SQLite []string `yaml:"sqlite,omitempty"`
+	// Repository stores backups as deduplicated snapshots in a
+	// content-addressed repository instead of standalone archives
+	Repository bool `yaml:"repository,omitempty"`
+	// Compression selects the archive compression as an algorithm and
+	// optional level: zstd, gzip or none, e.g. "zstd:19". Empty means gzip.
+	Compression string `yaml:"compression,omitempty"`
+	// Keyring names the keyring entry holding the backup password. Empty
+	// means the default entry.
+	Keyring string `yaml:"keyring,omitempty"`
+	// Hooks are commands run before and after backups and restores
+	Hooks hooks.Config `yaml:"hooks,omitempty"`
+	// Captures are commands whose output is backed up as a virtual file
+	Captures []capture.Capture `yaml:"captures,omitempty"`
+	// Secrets configures the scanner that checks files for credentials
+	// before they are backed up
+	Secrets secrets.Config `yaml:"secrets,omitempty"`
+	// LockWait is how long an operation waits for another one holding the
+	// backup directory, e.g. "5m". Empty means it fails at once.
+	LockWait string `yaml:"lock_wait,omitempty"`
+	// Profiles are named sets of dotfiles selected with --profile
+	Profiles map[string]*Profile `yaml:"profiles,omitempty"`
+
+	// profile is the name of the applied profile and base the config it was
+	// applied to; saving writes the profile's settings back into base
+	profile string
+	base    *Config
 }
 
 // GetConfigDir returns the XDG config directory for dotkeeper
@@ -61,13 +100,21 @@ func LoadFromPath(path string) (*Config, error) {
 	return cfg, nil
 }
 
-// Load loads config from the default XDG location
+// Load loads config from the default XDG location and applies the
+// selected profile, if any
 func Load() (*Config, error) {
 	configPath, err := GetConfigPath()
 	if err != nil {
 		return nil, err
 	}
-	return LoadFromPath(configPath)
+	cfg, err := LoadFromPath(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if name := SelectedProfile(); name != "" {
+		return cfg.UseProfile(name)
+	}
+	return cfg, nil
 }
 
 // SaveToPath saves config to a specific path
@@ -79,7 +126,7 @@ func (c *Config) SaveToPath(path string) error {
 	}
 
 	// Marshal config to YAML
-	data, err := yaml.Marshal(c)
+	data, err := yaml.Marshal(c.root())
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -92,23 +139,78 @@ func (c *Config) SaveToPath(path string) error {
 	return nil
 }
 
-// Save saves config to the default XDG location
+// Save saves config to the default XDG location. It takes the lock on the
+// backup directory so the config is not changed under a running operation:
+// like other operations it waits up to LockWait for one to finish, and with
+// no lock_wait set it fails at once while one runs.
 func (c *Config) Save() error {
 	configPath, err := GetConfigPath()
 	if err != nil {
 		return err
 	}
+
+	// A backup directory that does not exist yet has nothing running on it
+	if info, err := os.Stat(pathutil.ExpandHome(c.BackupDir)); err == nil && info.IsDir() {
+		l, err := c.Lock(lock.Exclusive, "config")
+		if err != nil {
+			return err
+		}
+		defer l.Release()
+	}
 	return c.SaveToPath(configPath)
 }
 
+// Lock takes the lock on the backup directory for operation, waiting up
+// to LockWait for other operations to finish
+func (c *Config) Lock(mode lock.Mode, operation string) (*lock.Lock, error) {
+	wait, _ := time.ParseDuration(c.LockWait)
+	return lock.Acquire(pathutil.ExpandHome(c.BackupDir), mode, operation, wait)
+}
+
 // Validate validates the config
 func (c *Config) Validate() error {
 	if c.BackupDir == "" {
 		return fmt.Errorf("backup_dir is required")
 	}
 
-	if len(c.Files) == 0 && len(c.Folders) == 0 {
-		return fmt.Errorf("at least one file or folder must be specified")
+	if len(c.Files) == 0 && len(c.Folders) == 0 && len(c.Captures) == 0 {
+		return fmt.Errorf("at least one file, folder or capture must be specified")
+	}
+
+	if err := pathutil.ValidatePatterns(c.Exclude); err != nil {
+		return err
+	}
+
+	if err := pathutil.ValidatePatterns(c.SQLite); err != nil {
+		return fmt.Errorf("invalid sqlite patterns: %w", err)
+	}
+
+	if err := c.Symlinks.Validate(); err != nil {
+		return err
+	}
+
+	if err := capture.Validate(c.Captures); err != nil {
+		return fmt.Errorf("invalid captures: %w", err)
+	}
+
+	if err := c.Secrets.Validate(); err != nil {
+		return fmt.Errorf("invalid secrets config: %w", err)
+	}
+
+	if _, err := compression.Parse(c.Compression); err != nil {
+		return fmt.Errorf("invalid compression: %w", err)
+	}
+
+	for name := range c.Profiles {
+		if err := ValidateProfileName(name); err != nil {
+			return err
+		}
+	}
+
+	if c.LockWait != "" {
+		if wait, err := time.ParseDuration(c.LockWait); err != nil || wait < 0 {
+			return fmt.Errorf("invalid lock_wait %q: use a duration such as 30s or 5m", c.LockWait)
+		}
 	}
 
 	return nil
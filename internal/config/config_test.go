@@ -1,9 +1,12 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/diogo/dotkeeper/internal/lock"
 )
 
 // TestConfigStruct verifies the Config struct has all required fields
@@ -154,6 +157,24 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid symlink policy",
+			cfg: &Config{
+				BackupDir: "/tmp/backup",
+				Folders:   []string{".config"},
+				Symlinks:  SymlinkPolicies{".config": "dereference"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid sqlite pattern",
+			cfg: &Config{
+				BackupDir: "/tmp/backup",
+				Folders:   []string{".config"},
+				SQLite:    []string{"[*.db"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "empty git remote",
 			cfg: &Config{
@@ -178,6 +199,42 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "zstd compression with level",
+			cfg: &Config{
+				BackupDir:   "/tmp/backup",
+				Files:       []string{".bashrc"},
+				Compression: "zstd:19",
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown compression",
+			cfg: &Config{
+				BackupDir:   "/tmp/backup",
+				Files:       []string{".bashrc"},
+				Compression: "lz4",
+			},
+			wantErr: true,
+		},
+		{
+			name: "lock wait",
+			cfg: &Config{
+				BackupDir: "/tmp/backup",
+				Files:     []string{".bashrc"},
+				LockWait:  "5m",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid lock wait",
+			cfg: &Config{
+				BackupDir: "/tmp/backup",
+				Files:     []string{".bashrc"},
+				LockWait:  "soon",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -481,3 +538,28 @@ func TestSave_GetConfigPathFlow(t *testing.T) {
 		t.Fatalf("Config file not created at default location: %v", err)
 	}
 }
+
+func TestSave_WaitsForLock(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	backupDir := t.TempDir()
+	cfg := &Config{BackupDir: backupDir, Files: []string{".bashrc"}}
+
+	held, err := lock.Acquire(backupDir, lock.Exclusive, "backup", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Save(); !errors.Is(err, lock.ErrLocked) {
+		t.Fatalf("Save() error = %v, want ErrLocked while a backup runs", err)
+	}
+
+	held.Release()
+	if err := cfg.Save(); err != nil {
+		t.Errorf("Save() after release error = %v", err)
+	}
+
+	// Saving a config whose backup directory does not exist yet needs no lock
+	cfg.BackupDir = filepath.Join(backupDir, "new")
+	if err := cfg.Save(); err != nil {
+		t.Errorf("Save() with a new backup directory error = %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/diogo/dotkeeper/internal/lock"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// ErrUnknownProfile is returned when a profile is not defined in the config
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// Profile is a named set of dotfiles with its own destination, schedule and
+// password. Files, folders, excludes and symlink policies are the profile's
+// own; an empty backup_dir means a directory named after the profile inside
+// the top-level backup_dir, and an empty schedule means the top-level
+// schedule.
+type Profile struct {
+	BackupDir       string   `yaml:"backup_dir,omitempty"`
+	Files           []string `yaml:"files,omitempty"`
+	Folders         []string `yaml:"folders,omitempty"`
+	Schedule        string   `yaml:"schedule,omitempty"`
+	Exclude         []string `yaml:"exclude,omitempty"`
+	DisabledFiles   []string `yaml:"disabled_files,omitempty"`
+	DisabledFolders []string `yaml:"disabled_folders,omitempty"`
+	// Symlinks sets how symlinks are stored under the profile's paths
+	Symlinks SymlinkPolicies `yaml:"symlinks,omitempty"`
+	// Keyring names the keyring entry holding the profile's password.
+	// Empty means "backup-password-<profile>".
+	Keyring string `yaml:"keyring,omitempty"`
+}
+
+// reservedProfileNames are the subdirectories every backup directory may
+// hold, which the default backup directory of a profile must not be
+var reservedProfileNames = []string{repository.DirName, lock.Dir}
+
+// ValidateProfileName checks that a profile name can name its default
+// backup directory and its systemd units: letters, digits, '-', '_' and
+// '.', not starting with '.', and none of the reserved directory names
+func ValidateProfileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("profile name must not be empty")
+	}
+	if name[0] == '.' {
+		return fmt.Errorf("invalid profile name %q: must not start with '.'", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("invalid profile name %q: use letters, digits, '-', '_' and '.'", name)
+		}
+	}
+	if slices.Contains(reservedProfileNames, name) {
+		return fmt.Errorf("invalid profile name %q: reserved for the backup directory's %s/ subdirectory", name, name)
+	}
+	return nil
+}
+
+// selectedProfile is the profile Load applies, set from the --profile flag
+var selectedProfile string
+
+// SelectProfile makes Load apply the named profile. An empty name falls
+// back to the DOTKEEPER_PROFILE environment variable.
+func SelectProfile(name string) {
+	selectedProfile = name
+}
+
+// SelectedProfile returns the name of the profile Load applies, or "" when
+// the top-level settings are used
+func SelectedProfile() string {
+	if selectedProfile != "" {
+		return selectedProfile
+	}
+	return os.Getenv("DOTKEEPER_PROFILE")
+}
+
+// ProfileNames returns the names of the configured profiles in order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the name of the profile applied to the config, or "" for
+// the top-level settings
+func (c *Config) Profile() string {
+	return c.profile
+}
+
+// AddProfile defines an empty profile unless one with the name exists
+func (c *Config) AddProfile(name string) {
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*Profile)
+	}
+	if c.Profiles[name] == nil {
+		c.Profiles[name] = &Profile{}
+	}
+}
+
+// UseProfile returns the config with the named profile applied: its files,
+// folders, excludes, symlink policies, backup directory, schedule and keyring entry replace
+// the top-level ones. Saving the returned config writes those settings back
+// into the profile and leaves the top-level ones untouched.
+func (c *Config) UseProfile(name string) (*Config, error) {
+	if err := ValidateProfileName(name); err != nil {
+		return nil, err
+	}
+	root := c.root()
+	p, ok := root.Profiles[name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+
+	cfg := *root
+	cfg.profile = name
+	cfg.base = root
+	cfg.BackupDir = p.BackupDir
+	if cfg.BackupDir == "" {
+		cfg.BackupDir = root.profileBackupDir(name)
+	}
+	cfg.Schedule = p.Schedule
+	if cfg.Schedule == "" {
+		cfg.Schedule = root.Schedule
+	}
+	cfg.Keyring = p.Keyring
+	if cfg.Keyring == "" {
+		cfg.Keyring = profileKeyring(name)
+	}
+	cfg.Files = slices.Clone(p.Files)
+	cfg.Folders = slices.Clone(p.Folders)
+	cfg.Exclude = slices.Clone(p.Exclude)
+	cfg.DisabledFiles = slices.Clone(p.DisabledFiles)
+	cfg.DisabledFolders = slices.Clone(p.DisabledFolders)
+	cfg.Symlinks = maps.Clone(p.Symlinks)
+	return &cfg, nil
+}
+
+// WithoutProfile returns the top-level config. Changes made to an applied
+// profile are carried over into its profile entry.
+func (c *Config) WithoutProfile() *Config {
+	return c.root()
+}
+
+// root returns the config as stored on disk. For a config with a profile
+// applied, the profile's settings are first copied back into it.
+func (c *Config) root() *Config {
+	if c.base == nil {
+		return c
+	}
+
+	root := c.base
+	root.GitRemote = c.GitRemote
+	root.Notifications = c.Notifications
+	root.Repository = c.Repository
+	root.Compression = c.Compression
+	root.Hooks = c.Hooks
+	root.Captures = c.Captures
+	root.Secrets = c.Secrets
+	root.SQLite = c.SQLite
+	root.LockWait = c.LockWait
+	root.AddProfile(c.profile)
+
+	p := root.Profiles[c.profile]
+	p.BackupDir = inherited(c.BackupDir, root.profileBackupDir(c.profile))
+	p.Schedule = inherited(c.Schedule, root.Schedule)
+	p.Keyring = inherited(c.Keyring, profileKeyring(c.profile))
+	p.Files = c.Files
+	p.Folders = c.Folders
+	p.Exclude = c.Exclude
+	p.DisabledFiles = c.DisabledFiles
+	p.DisabledFolders = c.DisabledFolders
+	p.Symlinks = c.Symlinks
+	return root
+}
+
+// profileBackupDir is where a profile without a backup_dir stores backups
+func (c *Config) profileBackupDir(name string) string {
+	if c.BackupDir == "" {
+		return ""
+	}
+	return filepath.Join(c.BackupDir, name)
+}
+
+// profileKeyring is the keyring entry of a profile without one configured
+func profileKeyring(name string) string {
+	return "backup-password-" + name
+}
+
+// inherited returns value, or "" when it is the value the profile would
+// inherit anyway, so unchanged defaults are not written into the profile
+func inherited(value, fallback string) string {
+	if value == fallback {
+		return ""
+	}
+	return value
+}
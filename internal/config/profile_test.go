@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const profileYAML = `backup_dir: /backups
+schedule: "0 2 * * *"
+files:
+  - .bashrc
+profiles:
+  work:
+    backup_dir: /work-backups
+    files:
+      - .kube/config
+    folders:
+      - .config/vpn
+    exclude:
+      - "*.log"
+    keyring: work-password
+  personal:
+    files:
+      - .gitconfig
+`
+
+func writeProfileConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(profileYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUseProfile(t *testing.T) {
+	cfg, err := LoadFromPath(writeProfileConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cfg.ProfileNames(); !reflect.DeepEqual(got, []string{"personal", "work"}) {
+		t.Errorf("ProfileNames = %v", got)
+	}
+
+	tests := []struct {
+		name     string
+		profile  string
+		dir      string
+		schedule string
+		keyring  string
+		files    []string
+		folders  []string
+		exclude  []string
+	}{
+		{
+			name:     "own settings",
+			profile:  "work",
+			dir:      "/work-backups",
+			schedule: "0 2 * * *",
+			keyring:  "work-password",
+			files:    []string{".kube/config"},
+			folders:  []string{".config/vpn"},
+			exclude:  []string{"*.log"},
+		},
+		{
+			name:     "defaults",
+			profile:  "personal",
+			dir:      filepath.Join("/backups", "personal"),
+			schedule: "0 2 * * *",
+			keyring:  "backup-password-personal",
+			files:    []string{".gitconfig"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := cfg.UseProfile(tt.profile)
+			if err != nil {
+				t.Fatalf("UseProfile failed: %v", err)
+			}
+			if p.Profile() != tt.profile {
+				t.Errorf("Profile = %q", p.Profile())
+			}
+			if p.BackupDir != tt.dir || p.Schedule != tt.schedule || p.Keyring != tt.keyring {
+				t.Errorf("got dir=%q schedule=%q keyring=%q", p.BackupDir, p.Schedule, p.Keyring)
+			}
+			if !reflect.DeepEqual(p.Files, tt.files) || !reflect.DeepEqual(p.Folders, tt.folders) || !reflect.DeepEqual(p.Exclude, tt.exclude) {
+				t.Errorf("got files=%v folders=%v exclude=%v", p.Files, p.Folders, p.Exclude)
+			}
+		})
+	}
+
+	if _, err := cfg.UseProfile("missing"); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+}
+
+func TestUseProfile_SaveWritesProfile(t *testing.T) {
+	path := writeProfileConfig(t)
+	cfg, err := LoadFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	personal, err := cfg.UseProfile("personal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	personal.Files = append(personal.Files, ".vimrc")
+	personal.Notifications = true
+	if err := personal.SaveToPath(path); err != nil {
+		t.Fatalf("SaveToPath failed: %v", err)
+	}
+
+	saved, err := LoadFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Files, []string{".bashrc"}) {
+		t.Errorf("top-level files changed: %v", saved.Files)
+	}
+	if !saved.Notifications {
+		t.Error("expected shared settings to be written to the top level")
+	}
+
+	p := saved.Profiles["personal"]
+	if !reflect.DeepEqual(p.Files, []string{".gitconfig", ".vimrc"}) {
+		t.Errorf("profile files = %v", p.Files)
+	}
+	// Inherited values are not copied into the profile
+	if p.BackupDir != "" || p.Schedule != "" || p.Keyring != "" {
+		t.Errorf("expected inherited settings to stay empty, got %+v", p)
+	}
+}
+
+func TestLoad_SelectedProfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+	t.Cleanup(func() { SelectProfile("") })
+
+	configDir := filepath.Join(tmpDir, "dotkeeper")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.yaml"), []byte(profileYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DOTKEEPER_PROFILE", "personal")
+	cfg, err := Load()
+	if err != nil || cfg.Profile() != "personal" {
+		t.Fatalf("Load with DOTKEEPER_PROFILE = %v, %v", cfg, err)
+	}
+
+	// The flag wins over the environment
+	SelectProfile("work")
+	cfg, err = Load()
+	if err != nil || cfg.BackupDir != "/work-backups" {
+		t.Fatalf("Load with --profile = %v, %v", cfg, err)
+	}
+
+	top := cfg.WithoutProfile()
+	if top.Profile() != "" || top.BackupDir != "/backups" {
+		t.Errorf("WithoutProfile = %q %q", top.Profile(), top.BackupDir)
+	}
+
+	SelectProfile("missing")
+	if _, err := Load(); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+}
+
+func TestValidateProfileName(t *testing.T) {
+	for _, name := range []string{"work", "home-laptop", "v2.1", "my_profile"} {
+		if err := ValidateProfileName(name); err != nil {
+			t.Errorf("ValidateProfileName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "repository", "locks", ".hidden", "a/b", "..", "two words"} {
+		if err := ValidateProfileName(name); err == nil {
+			t.Errorf("ValidateProfileName(%q) succeeded, want an error", name)
+		}
+	}
+
+	cfg := &Config{BackupDir: "/backups", Files: []string{".bashrc"}}
+	cfg.AddProfile("repository")
+	if _, err := cfg.UseProfile("repository"); err == nil {
+		t.Error("UseProfile accepted a reserved name")
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted a reserved profile name")
+	}
+}
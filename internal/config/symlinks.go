@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/diogo/dotkeeper/internal/pathutil"
+)
+
+// SymlinkPolicy says how the symlinks under a backed up path are stored
+type SymlinkPolicy string
+
+// Symlink policies
+const (
+	// SymlinksPreserve stores symlinks as links; it is the default
+	SymlinksPreserve SymlinkPolicy = "preserve"
+	// SymlinksFollow stores what symlinks point to as regular files and
+	// directories under the link's name
+	SymlinksFollow SymlinkPolicy = "follow"
+	// SymlinksFollowExternal follows symlinks pointing outside the backed
+	// up paths, such as into a GNU stow or Nix store, and preserves those
+	// pointing inside them
+	SymlinksFollowExternal SymlinkPolicy = "follow-external"
+)
+
+// SymlinkPolicies maps files and folders, as written in files or folders,
+// to the policy for the symlinks under them
+type SymlinkPolicies map[string]SymlinkPolicy
+
+// For returns the policy of a configured file or folder. Paths are matched
+// as written or once expanded; unlisted paths preserve their symlinks.
+func (p SymlinkPolicies) For(path string) SymlinkPolicy {
+	if policy, ok := p[path]; ok {
+		return policy
+	}
+	expanded := filepath.Clean(pathutil.ExpandPath(path))
+	for key, policy := range p {
+		if filepath.Clean(pathutil.ExpandPath(key)) == expanded {
+			return policy
+		}
+	}
+	return SymlinksPreserve
+}
+
+// Validate checks that every policy is known
+func (p SymlinkPolicies) Validate() error {
+	for path, policy := range p {
+		switch policy {
+		case SymlinksPreserve, SymlinksFollow, SymlinksFollowExternal:
+		default:
+			return fmt.Errorf("invalid symlink policy %q for %s (use preserve, follow or follow-external)", policy, path)
+		}
+	}
+	return nil
+}
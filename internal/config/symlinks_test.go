@@ -0,0 +1,43 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkPolicies_For(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	policies := SymlinkPolicies{
+		"~/.config":  SymlinksFollowExternal,
+		"/etc/nixos": SymlinksFollow,
+	}
+
+	tests := []struct {
+		path string
+		want SymlinkPolicy
+	}{
+		{"~/.config", SymlinksFollowExternal},
+		{filepath.Join(home, ".config") + "/", SymlinksFollowExternal},
+		{"/etc/nixos", SymlinksFollow},
+		{"~/.bashrc", SymlinksPreserve},
+	}
+	for _, tt := range tests {
+		if got := policies.For(tt.path); got != tt.want {
+			t.Errorf("For(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSymlinkPolicies_Validate(t *testing.T) {
+	valid := SymlinkPolicies{"a": SymlinksPreserve, "b": SymlinksFollow, "c": SymlinksFollowExternal}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+
+	invalid := SymlinkPolicies{"~/.config": "dereference"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected an unknown symlink policy to fail validation")
+	}
+}
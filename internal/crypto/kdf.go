@@ -1,20 +1,56 @@
 package crypto
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
 	"io"
 
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
 )
 
+// KDFParams are the Argon2id costs a key is derived with
+type KDFParams struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+// DefaultKDFParams are the costs new backups are encrypted with
+var DefaultKDFParams = KDFParams{Time: Argon2Time, Memory: Argon2Memory, Threads: Argon2Threads}
+
+// maxKDFMemory bounds the memory a stored parameter set may ask for, so a
+// crafted backup cannot exhaust memory before its password is checked
+const maxKDFMemory = 4 << 20 // 4 GiB
+
+// Validate reports parameters that cannot or should not be used
+func (p KDFParams) Validate() error {
+	if p.Time == 0 || p.Threads == 0 || p.Memory == 0 {
+		return fmt.Errorf("invalid key derivation parameters: time %d, memory %d KiB, threads %d", p.Time, p.Memory, p.Threads)
+	}
+	if p.Memory > maxKDFMemory {
+		return fmt.Errorf("key derivation memory too large: %d KiB", p.Memory)
+	}
+	return nil
+}
+
 // DeriveKey derives a 32-byte key from password and salt using Argon2id
+// with the default costs
 func DeriveKey(password string, salt []byte) []byte {
+	return DeriveKeyWithParams(password, salt, DefaultKDFParams)
+}
+
+// DeriveKeyWithParams derives a 32-byte key from password and salt using
+// Argon2id with the given costs
+func DeriveKeyWithParams(password string, salt []byte, p KDFParams) []byte {
 	return argon2.IDKey(
 		[]byte(password),
 		salt,
-		Argon2Time,
-		Argon2Memory,
-		Argon2Threads,
+		p.Time,
+		p.Memory,
+		p.Threads,
 		Argon2KeyLen,
 	)
 }
@@ -27,3 +63,32 @@ func GenerateSalt() ([]byte, error) {
 	}
 	return salt, nil
 }
+
+// DeriveSubkey derives an independent 32-byte key for purpose from a master
+// key using HKDF-SHA256, so one password-derived key can serve several roles
+func DeriveSubkey(masterKey []byte, purpose string) ([]byte, error) {
+	subkey := make([]byte, Argon2KeyLen)
+	r := hkdf.New(sha256.New, masterKey, nil, []byte(purpose))
+	if _, err := io.ReadFull(r, subkey); err != nil {
+		return nil, fmt.Errorf("failed to derive subkey: %w", err)
+	}
+	return subkey, nil
+}
+
+// MAC authenticates data with HMAC-SHA256 under the subkey of key derived
+// for purpose
+func MAC(key []byte, purpose string, data []byte) ([]byte, error) {
+	subkey, err := DeriveSubkey(key, purpose)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, subkey)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// VerifyMAC reports whether sum is the MAC of data under key and purpose
+func VerifyMAC(key []byte, purpose string, data, sum []byte) bool {
+	expected, err := MAC(key, purpose, data)
+	return err == nil && hmac.Equal(expected, sum)
+}
@@ -162,3 +162,53 @@ func TestGenerateSaltMultiple(t *testing.T) {
 		}
 	}
 }
+
+func TestDeriveSubkey(t *testing.T) {
+	master := bytes.Repeat([]byte{7}, Argon2KeyLen)
+
+	a, err := DeriveSubkey(master, "purpose-a")
+	if err != nil {
+		t.Fatalf("DeriveSubkey failed: %v", err)
+	}
+	if len(a) != Argon2KeyLen {
+		t.Errorf("subkey length: got %d, want %d", len(a), Argon2KeyLen)
+	}
+
+	again, _ := DeriveSubkey(master, "purpose-a")
+	if !bytes.Equal(a, again) {
+		t.Error("same master key and purpose should derive the same subkey")
+	}
+
+	b, _ := DeriveSubkey(master, "purpose-b")
+	if bytes.Equal(a, b) {
+		t.Error("different purposes should derive different subkeys")
+	}
+	if bytes.Equal(a, master) {
+		t.Error("subkey should differ from the master key")
+	}
+}
+
+func TestMAC(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, Argon2KeyLen)
+	data := []byte(`{"pinned":true}`)
+
+	sum, err := MAC(key, "purpose-a", data)
+	if err != nil {
+		t.Fatalf("MAC failed: %v", err)
+	}
+	if !VerifyMAC(key, "purpose-a", data, sum) {
+		t.Error("MAC does not verify")
+	}
+	if VerifyMAC(key, "purpose-a", []byte(`{}`), sum) {
+		t.Error("MAC verifies other data")
+	}
+	if VerifyMAC(key, "purpose-b", data, sum) {
+		t.Error("MAC verifies under another purpose")
+	}
+	if VerifyMAC(bytes.Repeat([]byte{8}, Argon2KeyLen), "purpose-a", data, sum) {
+		t.Error("MAC verifies under another key")
+	}
+	if VerifyMAC(key, "purpose-a", data, nil) {
+		t.Error("a missing MAC verifies")
+	}
+}
@@ -0,0 +1,184 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Key slot types: what a slot's secret is
+const (
+	SlotPassword = "password"
+	SlotKeyfile  = "keyfile"
+	SlotRecovery = "recovery"
+)
+
+// ErrNoKeySlot is returned when a secret opens none of the key slots
+var ErrNoKeySlot = errors.New("no key slot opens with this password")
+
+// keyWrapPurpose names the subkey a slot wraps its key with
+const keyWrapPurpose = "dotkeeper key slot"
+
+// recoveryKeyLength is the number of random bytes in a recovery key
+const recoveryKeyLength = 20
+
+// KeySlot holds a key wrapped by a key derived from one secret: a password,
+// the content of a keyfile or a recovery key. Any slot opens the key, so
+// secrets can be added, removed and changed by rewriting the slots alone.
+type KeySlot struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	KDFTime    int    `json:"kdf_time"`
+	KDFMemory  int    `json:"kdf_memory"`
+	KDFThreads int    `json:"kdf_threads"`
+	// Wrapped is the key sealed with AES-256-GCM, prefixed by its nonce
+	Wrapped []byte `json:"wrapped"`
+}
+
+// GenerateKey returns a random 256-bit key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, AESKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// NewKeySlot wraps key in a new slot of slotType opened by secret
+func NewKeySlot(slotType, secret string, key []byte) (KeySlot, error) {
+	switch slotType {
+	case SlotPassword, SlotKeyfile, SlotRecovery:
+	default:
+		return KeySlot{}, fmt.Errorf("unknown key slot type: %s", slotType)
+	}
+	if secret == "" {
+		return KeySlot{}, fmt.Errorf("%s must not be empty", slotType)
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		return KeySlot{}, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	id := make([]byte, 4)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return KeySlot{}, fmt.Errorf("failed to generate slot id: %w", err)
+	}
+
+	slot := KeySlot{
+		ID:         hex.EncodeToString(id),
+		Type:       slotType,
+		KDF:        "Argon2id",
+		Salt:       salt,
+		KDFTime:    Argon2Time,
+		KDFMemory:  Argon2Memory,
+		KDFThreads: Argon2Threads,
+	}
+	wrapping, err := slot.wrappingKey(secret)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	if slot.Wrapped, err = SealKey(wrapping, key); err != nil {
+		return KeySlot{}, err
+	}
+	return slot, nil
+}
+
+// Open unwraps the slot's key with secret
+func (s KeySlot) Open(secret string) ([]byte, error) {
+	wrapping, err := s.wrappingKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	return OpenKey(wrapping, s.Wrapped)
+}
+
+// wrappingKey derives the key that wraps the slot's key from secret
+func (s KeySlot) wrappingKey(secret string) ([]byte, error) {
+	if s.KDF != "Argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation: %s", s.KDF)
+	}
+	params := KDFParams{Time: uint32(s.KDFTime), Memory: uint32(s.KDFMemory), Threads: uint8(s.KDFThreads)}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if s.Type == SlotRecovery {
+		secret = normalizeRecoveryKey(secret)
+	}
+	return DeriveSubkey(DeriveKeyWithParams(secret, s.Salt, params), keyWrapPurpose)
+}
+
+// OpenKeySlots tries secret on every slot and returns the key of the first
+// one it opens and that slot's index
+func OpenKeySlots(slots []KeySlot, secret string) ([]byte, int, error) {
+	for i, slot := range slots {
+		if key, err := slot.Open(secret); err == nil {
+			return key, i, nil
+		}
+	}
+	return nil, -1, ErrNoKeySlot
+}
+
+// SealKey wraps key with wrapping using AES-256-GCM
+func SealKey(wrapping, key []byte) ([]byte, error) {
+	aead, err := newGCM(wrapping)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// OpenKey unwraps a key sealed by SealKey
+func OpenKey(wrapping, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(wrapping)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong password or corrupted data): %w", err)
+	}
+	return key, nil
+}
+
+// GenerateRecoveryKey returns a random recovery key, in groups of four
+// characters so that it can be written down
+func GenerateRecoveryKey() (string, error) {
+	raw := make([]byte, recoveryKeyLength)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	var groups []string
+	for len(encoded) > 0 {
+		n := min(4, len(encoded))
+		groups = append(groups, encoded[:n])
+		encoded = encoded[n:]
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// normalizeRecoveryKey drops the separators and case a recovery key may be
+// typed with
+func normalizeRecoveryKey(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(key) {
+		if r != '-' && r != ' ' && r != '\n' && r != '\t' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password, err := NewKeySlot(SlotPassword, "pw", key)
+	if err != nil {
+		t.Fatalf("NewKeySlot failed: %v", err)
+	}
+	recovery, err := GenerateRecoveryKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoverySlot, err := NewKeySlot(SlotRecovery, recovery, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password.ID == recoverySlot.ID || bytes.Equal(password.Salt, recoverySlot.Salt) {
+		t.Error("slots share an id or salt")
+	}
+
+	opened, err := password.Open("pw")
+	if err != nil || !bytes.Equal(opened, key) {
+		t.Errorf("Open = %x, %v; want the key", opened, err)
+	}
+	if _, err := password.Open("wrong"); err == nil {
+		t.Error("expected a wrong password to fail")
+	}
+
+	slots := []KeySlot{password, recoverySlot}
+	opened, i, err := OpenKeySlots(slots, strings.ToLower(recovery))
+	if err != nil || i != 1 || !bytes.Equal(opened, key) {
+		t.Errorf("OpenKeySlots(recovery key) = %x, %d, %v", opened, i, err)
+	}
+	if _, _, err := OpenKeySlots(slots, "wrong"); !errors.Is(err, ErrNoKeySlot) {
+		t.Errorf("OpenKeySlots(wrong) err = %v, want ErrNoKeySlot", err)
+	}
+
+	if _, err := NewKeySlot("token", "x", key); err == nil {
+		t.Error("expected an unknown slot type to fail")
+	}
+	if _, err := NewKeySlot(SlotPassword, "", key); err == nil {
+		t.Error("expected an empty password to fail")
+	}
+}
+
+func TestGenerateRecoveryKey(t *testing.T) {
+	key, err := GenerateRecoveryKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := strings.Split(key, "-")
+	if len(groups) != 8 {
+		t.Errorf("recovery key %q has %d groups, want 8", key, len(groups))
+	}
+	for _, g := range groups {
+		if len(g) != 4 {
+			t.Errorf("recovery key %q has group %q", key, g)
+		}
+	}
+	if other, _ := GenerateRecoveryKey(); other == key {
+		t.Error("recovery keys repeat")
+	}
+}
@@ -0,0 +1,249 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Streaming format (StreamVersion):
+//
+//	[version(1)][salt(16)][noncePrefix(7)][chunk]...[final chunk]
+//
+// Every chunk holds up to StreamChunkSize bytes of plaintext sealed with
+// AES-256-GCM. The 12-byte nonce of a chunk is noncePrefix || counter(4, big
+// endian) || last(1), so chunks cannot be reordered, dropped or truncated
+// without failing authentication. Only the final chunk may be shorter than
+// StreamChunkSize (it may also be empty).
+
+const (
+	// StreamVersion is the format version of chunked, streaming encryption
+	StreamVersion = 2
+	// StreamChunkSize is the amount of plaintext sealed per chunk
+	StreamChunkSize = 64 * 1024
+
+	streamNoncePrefixSize = 7
+	streamTagSize         = 16
+	streamHeaderSize      = 1 + SaltLength + streamNoncePrefixSize
+)
+
+// ErrStreamTruncated is returned when an encrypted stream ends before its final chunk
+var ErrStreamTruncated = errors.New("encrypted stream is truncated")
+
+// StreamWriter encrypts everything written to it in fixed-size chunks.
+// Close must be called to seal the final chunk; it does not close the
+// underlying writer.
+type StreamWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	buf     []byte
+	out     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewEncryptWriter writes the stream header to w and returns a writer that
+// encrypts plaintext with key. The salt is stored in the header so the key
+// can be re-derived when reading.
+func NewEncryptWriter(w io.Writer, key []byte, salt []byte) (*StreamWriter, error) {
+	if len(salt) != SaltLength {
+		return nil, fmt.Errorf("invalid salt length: %d", len(salt))
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, streamNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := make([]byte, 0, streamHeaderSize)
+	header = append(header, byte(StreamVersion))
+	header = append(header, salt...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write stream header: %w", err)
+	}
+
+	return &StreamWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, StreamChunkSize),
+		out:    make([]byte, 0, StreamChunkSize+streamTagSize),
+	}, nil
+}
+
+// Write buffers p and seals every full chunk. A full chunk is only flushed
+// once more data arrives, so the final chunk is always sealed by Close.
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write to closed stream")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == StreamChunkSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):StreamChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the final chunk
+func (s *StreamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *StreamWriter) flush(last bool) error {
+	if s.counter == math.MaxUint32 {
+		return errors.New("encrypted stream too long")
+	}
+	nonce := streamNonce(s.prefix, s.counter, last)
+	s.out = s.aead.Seal(s.out[:0], nonce, s.buf, nil)
+	if _, err := s.w.Write(s.out); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	s.counter++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// StreamReader decrypts a stream produced by StreamWriter
+type StreamReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	in      []byte
+	plain   []byte
+	pos     int
+	counter uint32
+	done    bool
+}
+
+// ReadStreamHeader reads the version and salt at the start of an encrypted
+// stream without consuming anything beyond the header.
+func ReadStreamHeader(r io.Reader) (version int, salt []byte, err error) {
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	return int(header[0]), header[1 : 1+SaltLength], nil
+}
+
+// NewDecryptReader reads the stream header from r and returns a reader that
+// yields the authenticated plaintext. Data is only returned after its chunk
+// has been verified.
+func NewDecryptReader(r io.Reader, key []byte) (*StreamReader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	if header[0] != byte(StreamVersion) {
+		return nil, fmt.Errorf("unsupported stream version: %d", header[0])
+	}
+
+	return &StreamReader{
+		r:      bufio.NewReaderSize(r, StreamChunkSize+streamTagSize),
+		aead:   aead,
+		prefix: append([]byte(nil), header[1+SaltLength:]...),
+		in:     make([]byte, StreamChunkSize+streamTagSize),
+	}, nil
+}
+
+// Read returns decrypted plaintext
+func (s *StreamReader) Read(p []byte) (int, error) {
+	for s.pos == len(s.plain) {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+func (s *StreamReader) next() error {
+	n, err := io.ReadFull(s.r, s.in)
+	last := false
+	switch {
+	case err == io.EOF:
+		return ErrStreamTruncated
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return fmt.Errorf("failed to read encrypted chunk: %w", err)
+	default:
+		if _, peekErr := s.r.Peek(1); peekErr == io.EOF {
+			last = true
+		} else if peekErr != nil {
+			return fmt.Errorf("failed to read encrypted chunk: %w", peekErr)
+		}
+	}
+
+	if n < streamTagSize {
+		return ErrStreamTruncated
+	}
+
+	nonce := streamNonce(s.prefix, s.counter, last)
+	plain, err := s.aead.Open(s.plain[:0], nonce, s.in[:n], nil)
+	if err != nil {
+		return fmt.Errorf("decryption failed (wrong password or corrupted data): %w", err)
+	}
+	s.plain = plain
+	s.pos = 0
+	s.counter++
+	s.done = last
+	return nil
+}
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, AESNonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], counter)
+	if last {
+		nonce[AESNonceSize-1] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
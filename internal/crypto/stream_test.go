@@ -0,0 +1,167 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encryptStream(t *testing.T, plaintext []byte, key, salt []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key, salt)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter failed: %v", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(ciphertext []byte, key []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundtrip(t *testing.T) {
+	key := make([]byte, AESKeySize)
+	salt := make([]byte, SaltLength)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"small", 100},
+		{"exactly one chunk", StreamChunkSize},
+		{"one chunk plus one", StreamChunkSize + 1},
+		{"several chunks", 3*StreamChunkSize + 17},
+		{"exact multiple", 4 * StreamChunkSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := make([]byte, tt.size)
+			for i := range plaintext {
+				plaintext[i] = byte(i % 251)
+			}
+
+			ciphertext := encryptStream(t, plaintext, key, salt)
+			if ciphertext[0] != byte(StreamVersion) {
+				t.Fatalf("version byte: got %d, want %d", ciphertext[0], StreamVersion)
+			}
+
+			decrypted, err := decryptStream(ciphertext, key)
+			if err != nil {
+				t.Fatalf("decrypt failed: %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Fatal("decrypted data does not match plaintext")
+			}
+		})
+	}
+}
+
+func TestStreamSmallWrites(t *testing.T) {
+	key := make([]byte, AESKeySize)
+	salt := make([]byte, SaltLength)
+	plaintext := bytes.Repeat([]byte("dotkeeper"), StreamChunkSize/4)
+
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(plaintext); i += 7 {
+		end := min(i+7, len(plaintext))
+		if _, err := w.Write(plaintext[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted, err := decryptStream(buf.Bytes(), key)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted data does not match plaintext")
+	}
+}
+
+func TestStreamWrongKey(t *testing.T) {
+	key := make([]byte, AESKeySize)
+	wrongKey := bytes.Repeat([]byte{1}, AESKeySize)
+	ciphertext := encryptStream(t, []byte("secret"), key, make([]byte, SaltLength))
+
+	if _, err := decryptStream(ciphertext, wrongKey); err == nil {
+		t.Fatal("decryption with wrong key should fail")
+	}
+}
+
+func TestStreamTamperingDetected(t *testing.T) {
+	key := make([]byte, AESKeySize)
+	salt := make([]byte, SaltLength)
+	plaintext := make([]byte, 3*StreamChunkSize)
+	ciphertext := encryptStream(t, plaintext, key, salt)
+	chunk := StreamChunkSize + streamTagSize
+
+	t.Run("truncated at chunk boundary", func(t *testing.T) {
+		truncated := ciphertext[:streamHeaderSize+2*chunk]
+		if _, err := decryptStream(truncated, key); err == nil {
+			t.Fatal("truncated stream should fail")
+		}
+	})
+
+	t.Run("header only", func(t *testing.T) {
+		_, err := decryptStream(ciphertext[:streamHeaderSize], key)
+		if !errors.Is(err, ErrStreamTruncated) {
+			t.Fatalf("expected ErrStreamTruncated, got %v", err)
+		}
+	})
+
+	t.Run("swapped chunks", func(t *testing.T) {
+		swapped := append([]byte(nil), ciphertext...)
+		first := swapped[streamHeaderSize : streamHeaderSize+chunk]
+		second := append([]byte(nil), swapped[streamHeaderSize+chunk:streamHeaderSize+2*chunk]...)
+		copy(swapped[streamHeaderSize+chunk:], first)
+		copy(swapped[streamHeaderSize:], second)
+		if _, err := decryptStream(swapped, key); err == nil {
+			t.Fatal("reordered chunks should fail")
+		}
+	})
+
+	t.Run("flipped bit", func(t *testing.T) {
+		flipped := append([]byte(nil), ciphertext...)
+		flipped[streamHeaderSize+chunk+10] ^= 0x01
+		if _, err := decryptStream(flipped, key); err == nil {
+			t.Fatal("modified chunk should fail")
+		}
+	})
+}
+
+func TestReadStreamHeader(t *testing.T) {
+	salt := bytes.Repeat([]byte{7}, SaltLength)
+	ciphertext := encryptStream(t, []byte("data"), make([]byte, AESKeySize), salt)
+
+	version, gotSalt, err := ReadStreamHeader(bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("ReadStreamHeader failed: %v", err)
+	}
+	if version != StreamVersion {
+		t.Errorf("version: got %d, want %d", version, StreamVersion)
+	}
+	if !bytes.Equal(gotSalt, salt) {
+		t.Error("salt mismatch")
+	}
+}
@@ -29,6 +29,31 @@ type EncryptionMetadata struct {
 	KDFThreads   int       `json:"kdf_threads"`
 	Timestamp    time.Time `json:"timestamp"`
 	OriginalSize int64     `json:"original_size"`
+	ChunkSize    int       `json:"chunk_size,omitempty"`
+	// Compression is the archive's compression algorithm; empty means gzip
+	Compression      string `json:"compression,omitempty"`
+	CompressionLevel int    `json:"compression_level,omitempty"`
+	// Incremental backups only hold entries that changed since Parent;
+	// DependsOn lists every backup holding content they refer to
+	Incremental bool     `json:"incremental,omitempty"`
+	Parent      string   `json:"parent,omitempty"`
+	DependsOn   []string `json:"depends_on,omitempty"`
+	// Tags, Note and Pinned label the backup. They are kept in plaintext
+	// so that they can be listed without the password, and authenticated
+	// by LabelsMAC in the sidecar of a legacy archive.
+	Tags      []string `json:"tags,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	Pinned    bool     `json:"pinned,omitempty"`
+	LabelsMAC []byte   `json:"labels_mac,omitempty"`
+}
+
+// KDFParams returns the key derivation costs recorded in the metadata, or
+// the defaults for metadata that does not record them
+func (m EncryptionMetadata) KDFParams() KDFParams {
+	if m.KDFTime == 0 && m.KDFMemory == 0 && m.KDFThreads == 0 {
+		return DefaultKDFParams
+	}
+	return KDFParams{Time: uint32(m.KDFTime), Memory: uint32(m.KDFMemory), Threads: uint8(m.KDFThreads)}
 }
 
 // DefaultMetadata returns a new metadata with default values
@@ -0,0 +1,122 @@
+// Package fsmeta captures and re-applies the file metadata a plain copy
+// loses: ownership, extended attributes and modification times. POSIX ACLs
+// are covered by the extended attributes, since Linux stores them as the
+// system.posix_acl_access and system.posix_acl_default attributes.
+package fsmeta
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// xattrPrefix is the PAX record prefix for extended attributes, as written
+// by GNU tar and bsdtar
+const xattrPrefix = "SCHILY.xattr."
+
+// Metadata is the ownership and extended attributes of a file
+type Metadata struct {
+	UID    int               `json:"uid,omitempty"`
+	GID    int               `json:"gid,omitempty"`
+	Uname  string            `json:"uname,omitempty"`
+	Gname  string            `json:"gname,omitempty"`
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+}
+
+// Read returns the metadata of path, which is not followed if it is a
+// symlink. info is the result of an Lstat of path. Attributes that cannot be
+// read, or that the platform does not have, are left empty.
+func Read(path string, info fs.FileInfo) Metadata {
+	m := ownership(info)
+	m.Xattrs = readXattrs(path)
+	return m
+}
+
+// HasOwner reports whether ownership was recorded. Backups made before
+// ownership was recorded read as root:root with no names, which is never
+// what a real root-owned file looks like, so those are left alone.
+func (m Metadata) HasOwner() bool {
+	return m.UID != 0 || m.GID != 0 || m.Uname != "" || m.Gname != ""
+}
+
+// WithOwnerOf returns the metadata with the owner replaced by the owner of
+// path. When path cannot be read, no owner is set, so Apply leaves
+// ownership alone.
+func (m Metadata) WithOwnerOf(path string) Metadata {
+	owner := Metadata{}
+	if info, err := os.Stat(path); err == nil {
+		owner = ownership(info)
+	}
+	m.UID, m.GID, m.Uname, m.Gname = owner.UID, owner.GID, owner.Uname, owner.Gname
+	return m
+}
+
+// SetHeader records the metadata in a tar header as PAX records
+func (m Metadata) SetHeader(header *tar.Header) {
+	header.Format = tar.FormatPAX
+	header.Uid = m.UID
+	header.Gid = m.GID
+	header.Uname = m.Uname
+	header.Gname = m.Gname
+	if len(m.Xattrs) == 0 {
+		return
+	}
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string, len(m.Xattrs))
+	}
+	for name, value := range m.Xattrs {
+		header.PAXRecords[xattrPrefix+name] = string(value)
+	}
+}
+
+// FromHeader returns the metadata recorded in a tar header
+func FromHeader(header *tar.Header) Metadata {
+	m := Metadata{
+		UID:   header.Uid,
+		GID:   header.Gid,
+		Uname: header.Uname,
+		Gname: header.Gname,
+	}
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, xattrPrefix); ok {
+			if m.Xattrs == nil {
+				m.Xattrs = make(map[string][]byte)
+			}
+			m.Xattrs[name] = []byte(value)
+		}
+	}
+	return m
+}
+
+// Apply re-applies the metadata and modification time to path, which is
+// not followed if it is a symlink. It is best-effort: every step is tried
+// and the returned errors describe the ones that failed, such as changing
+// ownership without root or setting attributes the filesystem does not
+// support.
+func (m Metadata) Apply(path string, modTime time.Time) []error {
+	var errs []error
+
+	if m.HasOwner() {
+		if err := chown(path, m); err != nil {
+			errs = append(errs, fmt.Errorf("ownership: %w", err))
+		}
+	}
+
+	for name, value := range m.Xattrs {
+		if err := setXattr(path, name, value); err != nil {
+			errs = append(errs, fmt.Errorf("xattr %s: %w", name, err))
+		}
+	}
+
+	// Last, so nothing above bumps the time again
+	if !modTime.IsZero() {
+		if err := lchtimes(path, modTime); err != nil {
+			errs = append(errs, fmt.Errorf("mtime: %w", err))
+		}
+	}
+
+	return errs
+}
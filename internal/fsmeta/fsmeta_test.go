@@ -0,0 +1,98 @@
+package fsmeta
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeaderRoundtrip(t *testing.T) {
+	want := Metadata{
+		UID:    1000,
+		GID:    100,
+		Uname:  "alice",
+		Gname:  "users",
+		Xattrs: map[string][]byte{"user.origin": []byte("laptop"), "system.posix_acl_access": {2, 0, 0, 0, 1, 0}},
+	}
+
+	header := &tar.Header{Name: "f", Mode: 0644, Size: 1, ModTime: time.Unix(1700000000, 0)}
+	want.SetHeader(header)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := tar.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FromHeader(read); !reflect.DeepEqual(got, want) {
+		t.Errorf("FromHeader = %+v, want %+v", got, want)
+	}
+}
+
+func TestHasOwner(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Metadata
+		want bool
+	}{
+		{"legacy header", Metadata{}, false},
+		{"root by name", Metadata{Uname: "root", Gname: "root"}, true},
+		{"numeric only", Metadata{UID: 1000, GID: 1000}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.HasOwner(); got != tt.want {
+				t.Errorf("HasOwner = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply_ModTime(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "file")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmpDir, "link")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+
+	fileTime := time.Unix(1600000000, 0)
+	linkTime := time.Unix(1500000000, 0)
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Re-applying the file's own metadata must succeed unprivileged
+	if errs := Read(path, info).Apply(path, fileTime); len(errs) > 0 {
+		t.Fatalf("Apply file: %v", errs)
+	}
+	if errs := (Metadata{}).Apply(link, linkTime); len(errs) > 0 {
+		t.Fatalf("Apply symlink: %v", errs)
+	}
+
+	if info, _ := os.Stat(path); !info.ModTime().Equal(fileTime) {
+		t.Errorf("file mtime = %v, want %v", info.ModTime(), fileTime)
+	}
+	// The symlink's own time is set, not its target's
+	if info, _ := os.Lstat(link); !info.ModTime().Equal(linkTime) {
+		t.Errorf("symlink mtime = %v, want %v", info.ModTime(), linkTime)
+	}
+}
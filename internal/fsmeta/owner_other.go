@@ -0,0 +1,32 @@
+//go:build !unix
+
+package fsmeta
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ownership is not recorded on platforms without unix owners
+func ownership(info fs.FileInfo) Metadata {
+	return Metadata{}
+}
+
+func chown(path string, m Metadata) error {
+	return errors.ErrUnsupported
+}
+
+// lchtimes sets the modification time of path. Symlinks are left alone, as
+// their own times cannot be set here.
+func lchtimes(path string, t time.Time) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chtimes(path, t, t)
+}
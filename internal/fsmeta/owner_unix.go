@@ -0,0 +1,103 @@
+//go:build unix
+
+package fsmeta
+
+import (
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// names caches uid and gid lookups, which read the passwd and group
+// databases and are repeated for nearly every file
+var names struct {
+	sync.Mutex
+	users  map[int]string
+	groups map[int]string
+}
+
+// ownership returns the owner recorded in info along with its names
+func ownership(info fs.FileInfo) Metadata {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Metadata{}
+	}
+	uid, gid := int(stat.Uid), int(stat.Gid)
+	return Metadata{UID: uid, GID: gid, Uname: userName(uid), Gname: groupName(gid)}
+}
+
+func userName(uid int) string {
+	names.Lock()
+	defer names.Unlock()
+	if name, ok := names.users[uid]; ok {
+		return name
+	}
+	var name string
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		name = u.Username
+	}
+	if names.users == nil {
+		names.users = make(map[int]string)
+	}
+	names.users[uid] = name
+	return name
+}
+
+func groupName(gid int) string {
+	names.Lock()
+	defer names.Unlock()
+	if name, ok := names.groups[gid]; ok {
+		return name
+	}
+	var name string
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		name = g.Name
+	}
+	if names.groups == nil {
+		names.groups = make(map[int]string)
+	}
+	names.groups[gid] = name
+	return name
+}
+
+// chown changes the owner of path, preferring the recorded names over the
+// numeric ids the way tar does, since ids differ between machines
+func chown(path string, m Metadata) error {
+	uid, gid := m.UID, m.GID
+	if m.Uname != "" {
+		if u, err := user.Lookup(m.Uname); err == nil {
+			if id, err := strconv.Atoi(u.Uid); err == nil {
+				uid = id
+			}
+		}
+	}
+	if m.Gname != "" {
+		if g, err := user.LookupGroup(m.Gname); err == nil {
+			if id, err := strconv.Atoi(g.Gid); err == nil {
+				gid = id
+			}
+		}
+	}
+
+	// Changing to the current owner needs no privileges, but skip the call
+	// so unprivileged restores of one's own files report nothing
+	if info, err := os.Lstat(path); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) == uid && int(stat.Gid) == gid {
+			return nil
+		}
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// lchtimes sets the access and modification times of path without
+// following symlinks
+func lchtimes(path string, t time.Time) error {
+	ts := unix.NsecToTimespec(t.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package fsmeta
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path, not following
+// symlinks. Errors, including filesystems without xattr support, give nil.
+func readXattrs(path string) map[string][]byte {
+	list, err := xattrCall(func(buf []byte) (int, error) {
+		return unix.Llistxattr(path, buf)
+	})
+	if err != nil || len(list) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string][]byte)
+	for _, name := range bytes.Split(bytes.TrimRight(list, "\x00"), []byte{0}) {
+		value, err := xattrCall(func(buf []byte) (int, error) {
+			return unix.Lgetxattr(path, string(name), buf)
+		})
+		if err != nil {
+			continue
+		}
+		attrs[string(name)] = value
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+// xattrCall runs a list or get call, growing the buffer when the value
+// changes size between the size query and the read
+func xattrCall(call func(buf []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := call(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func setXattr(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
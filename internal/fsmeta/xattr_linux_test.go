@@ -0,0 +1,45 @@
+//go:build linux
+
+package fsmeta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestXattrs(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	for _, p := range []string{src, dst} {
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setXattr(src, "user.dotkeeper", []byte("kept")); err != nil {
+		t.Skipf("filesystem does not support user xattrs: %v", err)
+	}
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Read(src, info)
+	if string(m.Xattrs["user.dotkeeper"]) != "kept" {
+		t.Fatalf("Read xattrs = %v", m.Xattrs)
+	}
+
+	if errs := m.Apply(dst, info.ModTime()); len(errs) > 0 {
+		t.Fatalf("Apply: %v", errs)
+	}
+	if got := readXattrs(dst); string(got["user.dotkeeper"]) != "kept" {
+		t.Errorf("restored xattrs = %v", got)
+	}
+
+	// Attributes in a namespace that cannot be written are reported
+	bad := Metadata{Xattrs: map[string][]byte{"bogus.name": []byte("x")}}
+	if errs := bad.Apply(dst, info.ModTime()); len(errs) != 1 {
+		t.Errorf("expected one error for an invalid namespace, got %v", errs)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fsmeta
+
+import "errors"
+
+// readXattrs records no extended attributes outside Linux
+func readXattrs(path string) map[string][]byte {
+	return nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	return errors.ErrUnsupported
+}
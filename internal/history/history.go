@@ -3,6 +3,7 @@ package history
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/hooks"
 	"github.com/diogo/dotkeeper/internal/restore"
 )
 
@@ -25,6 +27,8 @@ type HistoryEntry struct {
 	BackupPath string    `json:"backup_path,omitempty"`
 	BackupName string    `json:"backup_name,omitempty"`
 	Error      string    `json:"error,omitempty"`
+	// Hooks holds the exit codes and captured output of the hooks that ran
+	Hooks []hooks.Result `json:"hooks,omitempty"`
 }
 
 // Store manages reading and writing operation history in JSONL format.
@@ -152,16 +156,23 @@ func (s *Store) ReadByType(opType string, limit int) ([]HistoryEntry, error) {
 }
 
 // EntryFromBackupResult creates a HistoryEntry from a successful backup result.
+// A backup skipped because nothing changed is recorded as "unchanged", with
+// the name of the backup that already holds the files.
 func EntryFromBackupResult(result *backup.BackupResult) HistoryEntry {
+	status := "success"
+	if result.Unchanged {
+		status = "unchanged"
+	}
 	return HistoryEntry{
 		Timestamp:  time.Now().UTC(),
 		Operation:  "backup",
-		Status:     "success",
+		Status:     status,
 		FileCount:  result.FileCount,
 		TotalSize:  result.TotalSize,
 		DurationMs: result.Duration.Milliseconds(),
 		BackupPath: result.BackupPath,
 		BackupName: result.BackupName,
+		Hooks:      result.Hooks,
 	}
 }
 
@@ -172,6 +183,7 @@ func EntryFromBackupError(err error) HistoryEntry {
 		Operation: "backup",
 		Status:    "error",
 		Error:     err.Error(),
+		Hooks:     hookResults(err),
 	}
 }
 
@@ -183,6 +195,7 @@ func EntryFromRestoreResult(result *restore.RestoreResult, backupPath string) Hi
 		Status:     "success",
 		FileCount:  result.FilesRestored,
 		BackupPath: backupPath,
+		Hooks:      result.Hooks,
 	}
 }
 
@@ -194,5 +207,16 @@ func EntryFromRestoreError(err error, backupPath string) HistoryEntry {
 		Status:     "error",
 		BackupPath: backupPath,
 		Error:      err.Error(),
+		Hooks:      hookResults(err),
+	}
+}
+
+// hookResults returns the hook results carried by an error from a hook that
+// aborted the operation
+func hookResults(err error) []hooks.Result {
+	var hookErr *hooks.Error
+	if errors.As(err, &hookErr) {
+		return hookErr.Results
 	}
+	return nil
 }
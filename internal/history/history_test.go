@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/hooks"
 	"github.com/diogo/dotkeeper/internal/restore"
 )
 
@@ -359,6 +361,17 @@ func TestEntryFromBackupResult(t *testing.T) {
 	}
 }
 
+func TestEntryFromBackupResult_Unchanged(t *testing.T) {
+	entry := EntryFromBackupResult(&backup.BackupResult{
+		BackupName: "backup-2025-01-01-120000.tar.gz.enc",
+		FileCount:  3,
+		Unchanged:  true,
+	})
+	if entry.Status != "unchanged" || entry.BackupName != "backup-2025-01-01-120000.tar.gz.enc" {
+		t.Errorf("entry = %+v, want status unchanged with the existing backup", entry)
+	}
+}
+
 func TestEntryFromBackupError(t *testing.T) {
 	err := fmt.Errorf("encryption failed: key too short")
 	entry := EntryFromBackupError(err)
@@ -592,3 +605,26 @@ func TestReadByType_ErrorPropagation(t *testing.T) {
 		t.Error("ReadByType should propagate Read errors")
 	}
 }
+
+func TestEntryHooks(t *testing.T) {
+	ran := []hooks.Result{{Stage: hooks.PostBackup, Command: "sync", Output: "done\n"}}
+	entry := EntryFromBackupResult(&backup.BackupResult{Hooks: ran})
+	if len(entry.Hooks) != 1 || entry.Hooks[0].Output != "done\n" {
+		t.Errorf("backup entry Hooks = %+v", entry.Hooks)
+	}
+
+	failed := []hooks.Result{{Stage: hooks.PreRestore, Command: "check", ExitCode: 1, Error: "exit status 1"}}
+	err := fmt.Errorf("restore aborted: %w", &hooks.Error{Results: failed})
+	entry = EntryFromRestoreError(err, "/backups/backup.tar.gz.enc")
+	if len(entry.Hooks) != 1 || entry.Hooks[0].ExitCode != 1 {
+		t.Errorf("restore error entry Hooks = %+v", entry.Hooks)
+	}
+
+	data, err := json.Marshal(EntryFromBackupError(fmt.Errorf("no files to backup")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hooks") {
+		t.Errorf("entry without hooks should omit them: %s", data)
+	}
+}
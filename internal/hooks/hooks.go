@@ -0,0 +1,300 @@
+// Package hooks runs user commands around backups and restores. Hooks are
+// shell commands that receive the details of the operation through
+// DOTKEEPER_* environment variables. A failing pre hook aborts the
+// operation; the output of every hook is captured for the history.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/pathutil"
+	"gopkg.in/yaml.v3"
+)
+
+// Hook stages
+const (
+	PreBackup   = "pre_backup"
+	PostBackup  = "post_backup"
+	PreRestore  = "pre_restore"
+	PostRestore = "post_restore"
+	// PathRestore hooks run after files under their path were restored
+	PathRestore = "post_restore_path"
+)
+
+// RestoredFilesEnv names the variable holding the path of a file that
+// lists the restored files, one per line. The list is passed in a file
+// because it can outgrow the size limit of the environment.
+const RestoredFilesEnv = "DOTKEEPER_RESTORED_FILES_LIST"
+
+// DefaultTimeout bounds hooks that do not set a timeout
+const DefaultTimeout = time.Minute
+
+// maxOutput is how much of a hook's output is kept; longer output keeps
+// the end, where errors usually are
+const maxOutput = 16 << 10
+
+// Config holds the hooks of each stage
+type Config struct {
+	PreBackup   []Hook `yaml:"pre_backup,omitempty"`
+	PostBackup  []Hook `yaml:"post_backup,omitempty"`
+	PreRestore  []Hook `yaml:"pre_restore,omitempty"`
+	PostRestore []Hook `yaml:"post_restore,omitempty"`
+	// PostRestorePaths maps a file or directory to hooks that run once
+	// after anything under it was restored, e.g. ~/.config/tmux to
+	// "tmux source-file ~/.config/tmux/tmux.conf"
+	PostRestorePaths map[string][]Hook `yaml:"post_restore_paths,omitempty"`
+}
+
+// Hook is a shell command run with sh -c. In the config it is either the
+// command alone or a mapping with command and timeout.
+type Hook struct {
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// UnmarshalYAML accepts a plain command string as well as the full form
+func (h *Hook) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&h.Command)
+	}
+
+	var raw struct {
+		Command string `yaml:"command"`
+		Timeout string `yaml:"timeout"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	h.Command = raw.Command
+	if raw.Timeout != "" {
+		timeout, err := time.ParseDuration(raw.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid hook timeout %q: %w", raw.Timeout, err)
+		}
+		h.Timeout = timeout
+	}
+	return nil
+}
+
+// MarshalYAML writes hooks without a timeout as plain commands
+func (h Hook) MarshalYAML() (interface{}, error) {
+	if h.Timeout == 0 {
+		return h.Command, nil
+	}
+	return map[string]string{"command": h.Command, "timeout": h.Timeout.String()}, nil
+}
+
+// Result is the outcome of one hook
+type Result struct {
+	Stage      string `json:"stage"`
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Failed reports whether the hook did not complete successfully
+func (r Result) Failed() bool {
+	return r.Error != ""
+}
+
+// Error is returned when a hook fails. It carries the results of the hooks
+// that ran, including the failed one.
+type Error struct {
+	Results []Result
+}
+
+func (e *Error) Error() string {
+	for _, r := range e.Results {
+		if r.Failed() {
+			return fmt.Sprintf("%s hook %q failed: %s", r.Stage, r.Command, r.Error)
+		}
+	}
+	return "hook failed"
+}
+
+// Run runs the hooks of a stage in order with env added to the
+// environment, stopping at the first one that fails. The results of the
+// hooks that ran are returned; on failure the error is an *Error.
+func Run(stage string, hooks []Hook, env map[string]string) ([]Result, error) {
+	environ := append(os.Environ(), "DOTKEEPER_HOOK="+stage)
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		environ = append(environ, key+"="+env[key])
+	}
+
+	var results []Result
+	for _, hook := range hooks {
+		result := run(stage, hook, environ)
+		results = append(results, result)
+		if result.Failed() {
+			return results, &Error{Results: results}
+		}
+	}
+	return results, nil
+}
+
+// RunRestored runs the hooks of a stage like Run, with RestoredFilesEnv
+// added to env. The list file is removed once the hooks are done.
+func RunRestored(stage string, hooks []Hook, restored []string, env map[string]string) ([]Result, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+
+	list, err := writeFileList(restored)
+	if err != nil {
+		results := []Result{{Stage: stage, Command: hooks[0].Command, ExitCode: -1, Error: err.Error()}}
+		return results, &Error{Results: results}
+	}
+	defer os.Remove(list)
+
+	listEnv := make(map[string]string, len(env)+1)
+	for key, value := range env {
+		listEnv[key] = value
+	}
+	listEnv[RestoredFilesEnv] = list
+	return Run(stage, hooks, listEnv)
+}
+
+// writeFileList writes files one per line to a temporary file readable
+// only by the current user and returns its path
+func writeFileList(files []string) (string, error) {
+	f, err := os.CreateTemp("", "dotkeeper-restored-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the restored file list: %w", err)
+	}
+	var buf bytes.Buffer
+	for _, file := range files {
+		buf.WriteString(file)
+		buf.WriteByte('\n')
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write the restored file list: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// run runs a single hook and captures its combined output
+func run(stage string, hook Hook, environ []string) Result {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var output tailBuffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Env = environ
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Don't wait forever for background children holding the output open
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	result := Result{
+		Stage:      stage,
+		Command:    hook.Command,
+		Output:     output.String(),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		result.Error = fmt.Sprintf("exit status %d", result.ExitCode)
+	default:
+		result.ExitCode = -1
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// tailBuffer keeps the last maxOutput bytes written to it
+type tailBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf.Write(p)
+	if over := t.buf.Len() - maxOutput; over > 0 {
+		t.buf.Next(over)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	if t.truncated {
+		return "[output truncated]\n" + t.buf.String()
+	}
+	return t.buf.String()
+}
+
+// RunPathHooks runs the PostRestorePaths hooks of every configured path
+// that one of the restored files lies under. Each path's hooks run once,
+// in path order, with DOTKEEPER_HOOK_PATH and RestoredFilesEnv, listing
+// the files under the path, added to env. A failure does not stop the hooks of
+// other paths; the error reports every hook that ran.
+func (c Config) RunPathHooks(restored []string, env map[string]string) ([]Result, error) {
+	paths := make([]string, 0, len(c.PostRestorePaths))
+	for path := range c.PostRestorePaths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var results []Result
+	failed := false
+	for _, path := range paths {
+		root := filepath.Clean(pathutil.FromLogical(pathutil.ExpandHome(path)))
+		var matched []string
+		for _, file := range restored {
+			if _, ok := pathutil.CutPathPrefix(file, root); ok {
+				matched = append(matched, file)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		pathEnv := make(map[string]string, len(env)+1)
+		for key, value := range env {
+			pathEnv[key] = value
+		}
+		pathEnv["DOTKEEPER_HOOK_PATH"] = root
+
+		ran, err := RunRestored(PathRestore, c.PostRestorePaths[path], matched, pathEnv)
+		results = append(results, ran...)
+		failed = failed || err != nil
+	}
+
+	if failed {
+		return results, &Error{Results: results}
+	}
+	return results, nil
+}
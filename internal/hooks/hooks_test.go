@@ -0,0 +1,192 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRun(t *testing.T) {
+	results, err := Run(PreBackup, []Hook{
+		{Command: "echo first"},
+		{Command: `echo "$DOTKEEPER_HOOK $DOTKEEPER_BACKUP_DIR"`},
+	}, map[string]string{"DOTKEEPER_BACKUP_DIR": "/backups"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("len(results) = %d, want 2", len(results))
+	}
+	if results[0].Output != "first\n" {
+		t.Errorf("results[0].Output = %q", results[0].Output)
+	}
+	if results[1].Output != "pre_backup /backups\n" {
+		t.Errorf("results[1].Output = %q", results[1].Output)
+	}
+	for _, r := range results {
+		if r.Failed() || r.ExitCode != 0 || r.Stage != PreBackup {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+}
+
+func TestRun_FailureStops(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	results, err := Run(PreRestore, []Hook{
+		{Command: "echo oops >&2; exit 3"},
+		{Command: "touch " + marker},
+	}, nil)
+
+	var hookErr *Error
+	if !errors.As(err, &hookErr) {
+		t.Fatalf("Run() error = %v, want *Error", err)
+	}
+	if !strings.Contains(err.Error(), `pre_restore hook "echo oops >&2; exit 3" failed: exit status 3`) {
+		t.Errorf("error = %q", err.Error())
+	}
+	if len(results) != 1 || len(hookErr.Results) != 1 {
+		t.Fatalf("results = %+v, want only the failed hook", results)
+	}
+	if results[0].ExitCode != 3 || results[0].Output != "oops\n" {
+		t.Errorf("result = %+v", results[0])
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("hook after the failed one should not run")
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	start := time.Now()
+	results, err := Run(PostBackup, []Hook{{Command: "sleep 5", Timeout: 100 * time.Millisecond}}, nil)
+	if err == nil {
+		t.Fatal("Run() should fail when a hook times out")
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("timeout took %v", time.Since(start))
+	}
+	if results[0].ExitCode != -1 || !strings.Contains(results[0].Error, "timed out after 100ms") {
+		t.Errorf("result = %+v", results[0])
+	}
+}
+
+func TestRun_OutputTruncated(t *testing.T) {
+	results, err := Run(PostBackup, []Hook{{Command: "head -c 40000 /dev/zero | tr '\\0' a; echo END"}}, nil)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	output := results[0].Output
+	if !strings.HasPrefix(output, "[output truncated]\n") || !strings.HasSuffix(output, "END\n") {
+		t.Errorf("output should keep the end after a truncation marker, got %d bytes", len(output))
+	}
+	if len(output) > maxOutput+len("[output truncated]\n") {
+		t.Errorf("len(output) = %d, want at most %d", len(output), maxOutput)
+	}
+}
+
+func TestHook_YAML(t *testing.T) {
+	input := `
+pre_backup:
+  - pg_dump mydb > ~/db.sql
+  - command: sync-notes
+    timeout: 30s
+post_restore_paths:
+  ~/.config/tmux:
+    - tmux source-file ~/.config/tmux/tmux.conf
+`
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(input), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	want := []Hook{
+		{Command: "pg_dump mydb > ~/db.sql"},
+		{Command: "sync-notes", Timeout: 30 * time.Second},
+	}
+	if len(cfg.PreBackup) != len(want) {
+		t.Fatalf("PreBackup = %+v", cfg.PreBackup)
+	}
+	for i := range want {
+		if cfg.PreBackup[i] != want[i] {
+			t.Errorf("PreBackup[%d] = %+v, want %+v", i, cfg.PreBackup[i], want[i])
+		}
+	}
+	if got := cfg.PostRestorePaths["~/.config/tmux"]; len(got) != 1 {
+		t.Errorf("PostRestorePaths = %+v", cfg.PostRestorePaths)
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var again Config
+	if err := yaml.Unmarshal(out, &again); err != nil {
+		t.Fatalf("round trip error = %v\n%s", err, out)
+	}
+	if again.PreBackup[1] != want[1] || again.PreBackup[0] != want[0] {
+		t.Errorf("round trip PreBackup = %+v", again.PreBackup)
+	}
+
+	if err := yaml.Unmarshal([]byte("pre_backup:\n  - command: x\n    timeout: soon\n"), &cfg); err == nil {
+		t.Error("invalid timeout should fail")
+	}
+}
+
+func TestRunPathHooks(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{PostRestorePaths: map[string][]Hook{
+		filepath.Join(dir, "tmux"): {{Command: `printf "%s|" "$DOTKEEPER_HOOK_PATH"; cat "$DOTKEEPER_RESTORED_FILES_LIST"`}},
+		filepath.Join(dir, "vim"):  {{Command: "echo unused"}},
+		filepath.Join(dir, "fail"): {{Command: "exit 1"}},
+	}}
+
+	restored := []string{
+		filepath.Join(dir, "tmux", "tmux.conf"),
+		filepath.Join(dir, "tmux", "plugins.conf"),
+		filepath.Join(dir, "tmuxinator.yml"),
+		filepath.Join(dir, "fail"),
+	}
+	results, err := cfg.RunPathHooks(restored, nil)
+
+	var hookErr *Error
+	if !errors.As(err, &hookErr) {
+		t.Fatalf("RunPathHooks() error = %v, want *Error", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v, want the fail and tmux hooks", results)
+	}
+	want := filepath.Join(dir, "tmux") + "|" + restored[0] + "\n" + restored[1] + "\n"
+	if results[1].Stage != PathRestore || results[1].Output != want {
+		t.Errorf("tmux hook output = %q, want %q", results[1].Output, want)
+	}
+}
+
+func TestRunRestored_ManyFiles(t *testing.T) {
+	// Far more than the environment can hold
+	restored := make([]string, 20000)
+	for i := range restored {
+		restored[i] = fmt.Sprintf("/home/user/.local/share/some/deeply/nested/application/directory/file-%05d.conf", i)
+	}
+	hooks := []Hook{{Command: `wc -l < "$DOTKEEPER_RESTORED_FILES_LIST"; tail -n 1 "$DOTKEEPER_RESTORED_FILES_LIST"; echo "$DOTKEEPER_RESTORED_FILES_LIST"`}}
+
+	results, err := RunRestored(PostRestore, hooks, restored, map[string]string{"DOTKEEPER_OPERATION": "restore"})
+	if err != nil {
+		t.Fatalf("RunRestored() error = %v, output %q", err, results[0].Output)
+	}
+	lines := strings.Fields(results[0].Output)
+	if len(lines) != 3 || lines[0] != "20000" || lines[1] != restored[len(restored)-1] {
+		t.Fatalf("hook output = %q", results[0].Output)
+	}
+	if _, err := os.Stat(lines[2]); !os.IsNotExist(err) {
+		t.Errorf("file list %s left behind: %v", lines[2], err)
+	}
+
+	if results, err := RunRestored(PostRestore, nil, restored, nil); results != nil || err != nil {
+		t.Errorf("RunRestored() without hooks = %v, %v", results, err)
+	}
+}
@@ -10,9 +10,12 @@ import (
 
 const (
 	serviceName = "dotkeeper"
-	userName    = "backup-password"
+	userName    = DefaultEntry
 )
 
+// DefaultEntry is the keyring entry used when none is named
+const DefaultEntry = "backup-password"
+
 var (
 	ErrPasswordNotFound   = errors.New("password not found in keyring")
 	ErrKeyringUnavailable = errors.New("keyring unavailable")
@@ -20,16 +23,32 @@ var (
 
 // Store stores the password in the system keyring
 func Store(password string) error {
-	err := keyring.Set(serviceName, userName, password)
+	return StoreEntry("", password)
+}
+
+// Retrieve retrieves the password from the system keyring
+func Retrieve() (string, error) {
+	return RetrieveEntry("")
+}
+
+// Delete removes the password from the system keyring
+func Delete() error {
+	return DeleteEntry("")
+}
+
+// StoreEntry stores the password under the named keyring entry. An empty
+// name is the default entry used by Store.
+func StoreEntry(name, password string) error {
+	err := keyring.Set(serviceName, entryName(name), password)
 	if err != nil {
 		return fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
 	}
 	return nil
 }
 
-// Retrieve retrieves the password from the system keyring
-func Retrieve() (string, error) {
-	password, err := keyring.Get(serviceName, userName)
+// RetrieveEntry retrieves the password stored under the named keyring entry
+func RetrieveEntry(name string) (string, error) {
+	password, err := keyring.Get(serviceName, entryName(name))
 	if err != nil {
 		if err == keyring.ErrNotFound {
 			return "", ErrPasswordNotFound
@@ -39,9 +58,9 @@ func Retrieve() (string, error) {
 	return password, nil
 }
 
-// Delete removes the password from the system keyring
-func Delete() error {
-	err := keyring.Delete(serviceName, userName)
+// DeleteEntry removes the password stored under the named keyring entry
+func DeleteEntry(name string) error {
+	err := keyring.Delete(serviceName, entryName(name))
 	if err != nil {
 		if err == keyring.ErrNotFound {
 			return nil // Already deleted is fine
@@ -51,6 +70,14 @@ func Delete() error {
 	return nil
 }
 
+// entryName returns the keyring user for an entry, defaulting to userName
+func entryName(name string) string {
+	if name == "" {
+		return userName
+	}
+	return name
+}
+
 // IsAvailable checks if the keyring is available
 func IsAvailable() bool {
 	// Try to store and retrieve a test value
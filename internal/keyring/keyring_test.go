@@ -81,3 +81,35 @@ func TestDeleteNonExistent(t *testing.T) {
 		t.Errorf("Delete non-existent should not error, got %v", err)
 	}
 }
+
+func TestEntriesAreSeparate(t *testing.T) {
+	// Skip if keyring is not available
+	if !IsAvailable() {
+		t.Skip("keyring not available on this system")
+	}
+
+	const entry = "backup-password-test-profile"
+	_ = Delete()
+	_ = DeleteEntry(entry)
+	defer func() {
+		_ = Delete()
+		_ = DeleteEntry(entry)
+	}()
+
+	if err := Store("default-password"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := StoreEntry(entry, "profile-password"); err != nil {
+		t.Fatalf("StoreEntry failed: %v", err)
+	}
+
+	if got, err := Retrieve(); err != nil || got != "default-password" {
+		t.Errorf("Retrieve = %q, %v", got, err)
+	}
+	if got, err := RetrieveEntry(entry); err != nil || got != "profile-password" {
+		t.Errorf("RetrieveEntry = %q, %v", got, err)
+	}
+	if got, err := RetrieveEntry(""); err != nil || got != "default-password" {
+		t.Errorf("RetrieveEntry(\"\") = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,287 @@
+// Package lock coordinates operations on a backup directory between
+// processes: the CLI, the TUI and scheduled runs. Each holder writes a lock
+// file naming its process and host into the locks directory, so a blocked
+// operation can report who is in the way. Backups, deletes and config
+// writes take the lock exclusively; restores share it.
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Dir is the directory inside the backup directory holding lock files
+const Dir = "locks"
+
+// Mode says whether a lock may be held alongside others
+type Mode string
+
+// Lock modes
+const (
+	// Shared locks may be held together, but not with an exclusive lock
+	Shared Mode = "shared"
+	// Exclusive locks are held alone
+	Exclusive Mode = "exclusive"
+)
+
+// How often a held lock is refreshed, and how long a lock may go without
+// a refresh before it is considered stale. Locks of processes on this host
+// are also stale as soon as the process is gone.
+var (
+	RefreshInterval = 5 * time.Minute
+	StaleAfter      = 30 * time.Minute
+)
+
+// pollInterval is how often a waiting operation checks the lock again
+var pollInterval = 250 * time.Millisecond
+
+// ErrLocked is wrapped by the error returned when the lock is held
+var ErrLocked = errors.New("backup directory is locked")
+
+// Holder describes a process holding a lock
+type Holder struct {
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	Operation string    `json:"operation"`
+	Mode      Mode      `json:"mode"`
+	Created   time.Time `json:"created"`
+	Refreshed time.Time `json:"refreshed"`
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("%s (%s, pid %d on %s, since %s)",
+		h.Operation, h.Mode, h.PID, h.Hostname, h.Created.Local().Format("2006-01-02 15:04:05"))
+}
+
+// conflicts reports whether a lock in mode cannot be held alongside h
+func (h Holder) conflicts(mode Mode) bool {
+	return mode == Exclusive || h.Mode == Exclusive
+}
+
+// stale reports whether the holder is gone
+func (h Holder) stale(hostname string, now time.Time) bool {
+	if now.Sub(h.Refreshed) > StaleAfter {
+		return true
+	}
+	return h.Hostname == hostname && !processAlive(h.PID)
+}
+
+// HeldError is returned when other operations hold the lock
+type HeldError struct {
+	Holders []Holder
+}
+
+func (e *HeldError) Error() string {
+	held := make([]string, len(e.Holders))
+	for i, h := range e.Holders {
+		held[i] = h.String()
+	}
+	return fmt.Sprintf("%v by %s", ErrLocked, strings.Join(held, ", "))
+}
+
+func (e *HeldError) Unwrap() error {
+	return ErrLocked
+}
+
+// Lock is a held lock
+type Lock struct {
+	path string
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// Acquire takes the lock on the backup directory dir for operation. While
+// another operation holds a conflicting lock it retries for up to wait;
+// a zero wait fails at once. Stale locks are removed on the way. The
+// error for a held lock is a *HeldError naming the holders.
+func Acquire(dir string, mode Mode, operation string, wait time.Duration) (*Lock, error) {
+	locksDir := filepath.Join(dir, Dir)
+	if err := os.MkdirAll(locksDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	self := Holder{
+		PID:       os.Getpid(),
+		Hostname:  hostname,
+		Operation: operation,
+		Mode:      mode,
+		Created:   now,
+		Refreshed: now,
+	}
+	name, err := lockName()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(locksDir, name)
+
+	deadline := now.Add(wait)
+	for {
+		// The lock file is written before looking for others, so two
+		// processes starting together see each other and both back off
+		if err := writeHolder(path, self, true); err != nil {
+			return nil, fmt.Errorf("failed to write lock file: %w", err)
+		}
+		held, err := conflicting(locksDir, name, mode, hostname)
+		if err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+		if len(held) == 0 {
+			l := &Lock{path: path, stop: make(chan struct{})}
+			l.done.Add(1)
+			go l.refresh(self)
+			return l, nil
+		}
+
+		os.Remove(path)
+		if !time.Now().Before(deadline) {
+			return nil, &HeldError{Holders: held}
+		}
+		time.Sleep(min(jitter(pollInterval), time.Until(deadline)))
+	}
+}
+
+// Release gives the lock up
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	close(l.stop)
+	l.done.Wait()
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+// Holders returns the current, non-stale holders of the lock on dir
+func Holders(dir string) ([]Holder, error) {
+	hostname, _ := os.Hostname()
+	return conflicting(filepath.Join(dir, Dir), "", Shared, hostname)
+}
+
+// refresh keeps the lock file's refresh time current until released
+func (l *Lock) refresh(self Holder) {
+	defer l.done.Done()
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			self.Refreshed = now
+			_ = writeHolder(l.path, self, false)
+		}
+	}
+}
+
+// conflicting returns the holders in locksDir, other than the lock file
+// named self, that conflict with mode. A Shared mode returns only the
+// exclusive holders. Stale lock files are removed.
+func conflicting(locksDir, self string, mode Mode, hostname string) ([]Holder, error) {
+	entries, err := os.ReadDir(locksDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock directory: %w", err)
+	}
+
+	now := time.Now()
+	var held []Holder
+	for _, entry := range entries {
+		if entry.Name() == self || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(locksDir, entry.Name())
+		h, err := readHolder(path)
+		if err != nil {
+			// A lock file being written is empty for a moment; only
+			// an old unreadable file is left over from a crash
+			if info, statErr := entry.Info(); statErr == nil && now.Sub(info.ModTime()) > StaleAfter {
+				os.Remove(path)
+			}
+			continue
+		}
+		if h.stale(hostname, now) {
+			os.Remove(path)
+			continue
+		}
+		if h.conflicts(mode) {
+			held = append(held, h)
+		}
+	}
+	return held, nil
+}
+
+func readHolder(path string) (Holder, error) {
+	var h Holder
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h, err
+	}
+	err = json.Unmarshal(data, &h)
+	return h, err
+}
+
+// writeHolder writes a lock file; create fails if it already exists
+func writeHolder(path string, h Holder, create bool) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_TRUNC
+	if create {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lockName returns a unique lock file name
+func lockName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock name: %w", err)
+	}
+	return hex.EncodeToString(b) + ".json", nil
+}
+
+// jitter returns a random duration between d/2 and d, so that waiting
+// processes do not keep retrying in step
+func jitter(d time.Duration) time.Duration {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(d/2)+1))
+	if err != nil {
+		return d
+	}
+	return d/2 + time.Duration(n.Int64())
+}
+
+// processAlive reports whether a process with pid exists on this host
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,129 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquire_Modes(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := Acquire(dir, Shared, "restore", 0)
+	if err != nil {
+		t.Fatalf("Acquire(shared) error = %v", err)
+	}
+	second, err := Acquire(dir, Shared, "restore", 0)
+	if err != nil {
+		t.Fatalf("shared locks should be held together: %v", err)
+	}
+
+	_, err = Acquire(dir, Exclusive, "backup", 0)
+	var held *HeldError
+	if !errors.As(err, &held) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("Acquire(exclusive) error = %v, want HeldError", err)
+	}
+	if len(held.Holders) != 2 || held.Holders[0].Operation != "restore" || held.Holders[0].PID != os.Getpid() {
+		t.Errorf("holders = %+v, want both restores", held.Holders)
+	}
+	if !strings.Contains(err.Error(), "restore (shared, pid") {
+		t.Errorf("error = %q, want the holder described", err)
+	}
+
+	first.Release()
+	second.Release()
+	exclusive, err := Acquire(dir, Exclusive, "backup", 0)
+	if err != nil {
+		t.Fatalf("Acquire(exclusive) after release error = %v", err)
+	}
+	if _, err := Acquire(dir, Shared, "restore", 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("shared lock next to an exclusive one error = %v, want ErrLocked", err)
+	}
+	if holders, _ := Holders(dir); len(holders) != 1 || holders[0].Mode != Exclusive {
+		t.Errorf("Holders() = %+v", holders)
+	}
+	if err := exclusive.Release(); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, Dir)); len(entries) != 0 {
+		t.Errorf("lock files left after release: %v", entries)
+	}
+}
+
+func TestAcquire_Wait(t *testing.T) {
+	dir := t.TempDir()
+	held, err := Acquire(dir, Exclusive, "backup", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		held.Release()
+	}()
+
+	start := time.Now()
+	l, err := Acquire(dir, Exclusive, "delete", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Acquire() with wait error = %v", err)
+	}
+	defer l.Release()
+	if waited := time.Since(start); waited < 200*time.Millisecond {
+		t.Errorf("Acquire() returned after %v, before the lock was released", waited)
+	}
+
+	start = time.Now()
+	if _, err := Acquire(dir, Exclusive, "backup", 400*time.Millisecond); !errors.Is(err, ErrLocked) {
+		t.Errorf("Acquire() error = %v, want ErrLocked after the wait", err)
+	}
+	if waited := time.Since(start); waited < 400*time.Millisecond {
+		t.Errorf("gave up after %v, want the full wait", waited)
+	}
+}
+
+func TestAcquire_StaleLocks(t *testing.T) {
+	dir := t.TempDir()
+	locksDir := filepath.Join(dir, Dir)
+	if err := os.MkdirAll(locksDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A process that has exited
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	stale := []Holder{
+		{PID: cmd.Process.Pid, Hostname: hostname, Operation: "backup", Mode: Exclusive, Created: now, Refreshed: now},
+		{PID: 1, Hostname: "elsewhere", Operation: "backup", Mode: Exclusive, Created: now.Add(-2 * time.Hour), Refreshed: now.Add(-time.Hour)},
+	}
+	for i, h := range stale {
+		if err := writeHolder(filepath.Join(locksDir, string(rune('a'+i))+".json"), h, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := Acquire(dir, Exclusive, "backup", 0)
+	if err != nil {
+		t.Fatalf("stale locks should be ignored: %v", err)
+	}
+	l.Release()
+	if entries, _ := os.ReadDir(locksDir); len(entries) != 0 {
+		t.Errorf("stale lock files should be removed, found %v", entries)
+	}
+
+	// A recent lock from another host is respected
+	remote := Holder{PID: 1, Hostname: "elsewhere", Operation: "backup", Mode: Exclusive, Created: now, Refreshed: now}
+	if err := writeHolder(filepath.Join(locksDir, "remote.json"), remote, true); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Acquire(dir, Shared, "restore", 0)
+	if err == nil || !strings.Contains(err.Error(), "on elsewhere") {
+		t.Errorf("Acquire() error = %v, want the remote holder", err)
+	}
+}
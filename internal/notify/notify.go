@@ -3,13 +3,23 @@ package notify
 import (
 	"fmt"
 	"os/exec"
+	"strings"
 	"time"
 )
 
 // Send sends a desktop notification with the given title and message.
 // It gracefully handles the case where notify-send is not available.
 func Send(title, message string) error {
-	cmd := exec.Command("notify-send", title, message)
+	return send(title, message)
+}
+
+// SendUrgent sends a desktop notification that stays until dismissed.
+func SendUrgent(title, message string) error {
+	return send(title, message, "--urgency=critical")
+}
+
+func send(title, message string, options ...string) error {
+	cmd := exec.Command("notify-send", append(options, title, message)...)
 	if err := cmd.Run(); err != nil {
 		// Check if the error is due to notify-send not being found
 		if _, ok := err.(*exec.ExitError); ok {
@@ -29,6 +39,14 @@ func SendSuccess(backupName string, duration time.Duration) error {
 	return Send(title, message)
 }
 
+// SendUnconfirmedSecrets sends an urgent notification for files with
+// possible secrets that a backup stored without anyone confirming them.
+func SendUnconfirmedSecrets(paths []string) error {
+	title := "Backup Stored Possible Secrets"
+	message := fmt.Sprintf("Backed up without confirmation: %s", strings.Join(paths, ", "))
+	return SendUrgent(title, message)
+}
+
 // SendError sends an error notification for a failed backup.
 func SendError(err error) error {
 	title := "Backup Failed"
@@ -2,6 +2,7 @@ package pathutil
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -16,12 +17,14 @@ func IsGlobPattern(s string) bool {
 	return strings.ContainsAny(s, "*?[")
 }
 
-// ResolveGlob resolves a glob pattern to matching paths.
+// ResolveGlob resolves a glob pattern to matching paths. ~ and environment
+// variables are expanded first; see ExpandPath.
 // Supports standard filepath.Glob patterns plus ** via doublestar.
 // Results are capped at MaxGlobResults.
-// Exclude patterns filter results using filepath.Match on base names.
+// Matches excluded by the gitignore-style exclude patterns, themselves or
+// through a parent directory, are left out.
 func ResolveGlob(pattern string, exclude []string) ([]string, error) {
-	expanded := ExpandHome(pattern)
+	expanded := ExpandPath(pattern)
 
 	var matches []string
 	var err error
@@ -36,17 +39,12 @@ func ResolveGlob(pattern string, exclude []string) ([]string, error) {
 		return nil, fmt.Errorf("invalid glob pattern: %w", err)
 	}
 
+	matcher := NewMatcher(exclude)
 	var filtered []string
 	for _, m := range matches {
-		baseName := filepath.Base(m)
-		excluded := false
-		for _, ex := range exclude {
-			if matched, _ := filepath.Match(ex, baseName); matched {
-				excluded = true
-				break
-			}
-		}
-		if !excluded {
+		info, err := os.Stat(m)
+		isDir := err == nil && info.IsDir()
+		if !matcher.Excluded(m, isDir) {
 			filtered = append(filtered, m)
 		}
 	}
@@ -57,3 +55,15 @@ func ResolveGlob(pattern string, exclude []string) ([]string, error) {
 
 	return filtered, nil
 }
+
+// Resolve returns the paths a configured file or folder entry currently
+// names. Entries are kept in the config as written, so globs are matched
+// again on every call and pick up files created since the entry was
+// added. Entries without glob characters resolve to their expanded path,
+// whether or not it exists.
+func Resolve(entry string, exclude []string) ([]string, error) {
+	if !IsGlobPattern(entry) {
+		return []string{ExpandPath(entry)}, nil
+	}
+	return ResolveGlob(entry, exclude)
+}
@@ -136,3 +136,61 @@ func TestResolveGlob_InvalidPattern(t *testing.T) {
 		t.Error("expected error for invalid pattern")
 	}
 }
+
+func TestResolveGlob_ExcludeDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a/x.lua", "a/cache/y.lua", "b/z.lua"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The same gitignore rules as the collector: matches under an excluded
+	// directory are left out
+	results, err := ResolveGlob(filepath.Join(tmpDir, "**", "*.lua"), []string{"cache/", filepath.Join(tmpDir, "b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || filepath.Base(results[0]) != "x.lua" {
+		t.Errorf("results = %v, want only a/x.lua", results)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("DOTKEEPER_TEST_DIR", tmpDir)
+	for _, dir := range []string{"code", "zed"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(rel string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(tmpDir, rel), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("code/settings.json")
+
+	pattern := "${DOTKEEPER_TEST_DIR}/*/settings.json"
+	results, err := Resolve(pattern, nil)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Resolve() = %v, %v; want 1 match", results, err)
+	}
+
+	// A file created later is picked up by the next call
+	write("zed/settings.json")
+	results, err = Resolve(pattern, nil)
+	if err != nil || len(results) != 2 {
+		t.Errorf("Resolve() after adding a file = %v, %v; want 2 matches", results, err)
+	}
+
+	results, err = Resolve("$DOTKEEPER_TEST_DIR/missing", nil)
+	if err != nil || len(results) != 1 || results[0] != filepath.Join(tmpDir, "missing") {
+		t.Errorf("Resolve() of a plain entry = %v, %v", results, err)
+	}
+}
@@ -0,0 +1,194 @@
+package pathutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// IgnoreFile is the per-directory file listing paths to leave out of
+// backups, in .gitignore syntax
+const IgnoreFile = ".dotkeeperignore"
+
+// Matcher decides which paths are excluded, following .gitignore rules:
+//
+//   - a pattern without a slash, such as *.log, matches at any depth
+//   - a pattern with a slash, such as nvim/plugin or /build, is anchored to
+//     the directory its rules belong to
+//   - ** matches any number of directories
+//   - a trailing slash only matches directories
+//   - a leading ! re-includes what an earlier pattern excluded
+//
+// The last matching pattern decides. Nothing under an excluded directory
+// can be re-included, just as with git.
+//
+// Exclude patterns from the config belong to the filesystem root, with ~
+// expanded, so ~/.cache and /etc/secret name those exact paths. Patterns
+// read from a .dotkeeperignore belong to its directory.
+type Matcher struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	base    string // slash-separated directory the pattern is relative to
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// NewMatcher returns a matcher for config exclude patterns. Blank lines,
+// comments and invalid patterns are ignored.
+func NewMatcher(patterns []string) *Matcher {
+	m := &Matcher{}
+	m.rules = appendRules(m.rules, "/", patterns, true)
+	return m
+}
+
+// With returns a matcher that also applies patterns relative to dir, as
+// read from a .dotkeeperignore there. The receiver is not modified; a nil
+// receiver is treated as an empty matcher.
+func (m *Matcher) With(dir string, patterns []string) *Matcher {
+	var rules []ignoreRule
+	if m != nil {
+		rules = m.rules
+	}
+	base := filepath.ToSlash(filepath.Clean(dir))
+	return &Matcher{rules: appendRules(rules[:len(rules):len(rules)], base, patterns, false)}
+}
+
+// ValidatePatterns reports the first pattern that is not a valid glob
+func ValidatePatterns(patterns []string) error {
+	for _, line := range patterns {
+		rule, ok := parseRule("/", line, true)
+		if ok && !doublestar.ValidatePattern(rule.pattern) {
+			return fmt.Errorf("invalid exclude pattern %q", line)
+		}
+	}
+	return nil
+}
+
+// ReadIgnoreFile returns the patterns of the .dotkeeperignore in dir, or
+// nil when there is none
+func ReadIgnoreFile(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func appendRules(rules []ignoreRule, base string, patterns []string, expandHome bool) []ignoreRule {
+	for _, line := range patterns {
+		if rule, ok := parseRule(base, line, expandHome); ok && doublestar.ValidatePattern(rule.pattern) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// parseRule parses one line of .gitignore syntax
+func parseRule(base, line string, expandHome bool) (ignoreRule, bool) {
+	// Trailing spaces are ignored unless escaped
+	line = strings.TrimRight(line, " \t")
+	if strings.HasSuffix(line, `\`) {
+		line += " "
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	switch {
+	case strings.HasPrefix(line, "!"):
+		rule.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if expandHome {
+		line = filepath.ToSlash(ExpandHome(line))
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	if strings.Contains(line, "/") {
+		// Anchored to the base directory
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// Match reports whether the path itself is excluded by the rules, without
+// looking at its parent directories. The collector relies on this while
+// walking, since it never descends into excluded directories.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	slashed := filepath.ToSlash(filepath.Clean(path))
+
+	excluded := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, ok := relativeTo(slashed, rule.base)
+		if !ok || rel == "" {
+			continue
+		}
+		if matched, _ := doublestar.Match(rule.pattern, rel); matched {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// Excluded reports whether path is excluded, either itself or because one
+// of its parent directories is
+func (m *Matcher) Excluded(path string, isDir bool) bool {
+	if m == nil || len(m.rules) == 0 {
+		return false
+	}
+	clean := filepath.Clean(path)
+	var parents []string
+	for dir := filepath.Dir(clean); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if m.Match(parents[i], true) {
+			return true
+		}
+	}
+	return m.Match(clean, isDir)
+}
+
+// relativeTo returns path relative to base, both slash-separated
+func relativeTo(path, base string) (string, bool) {
+	if base == "/" {
+		return strings.TrimPrefix(path, "/"), true
+	}
+	return CutPathPrefix(path, base)
+}
@@ -0,0 +1,127 @@
+package pathutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcher_Match(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"base name anywhere", []string{"*.log"}, "/a/b/c.log", false, true},
+		{"no match", []string{"*.log"}, "/a/b/c.txt", false, false},
+		{"double star", []string{"**/cache/**"}, "/a/cache/x/y", false, true},
+		{"double star middle", []string{"/a/**/z.txt"}, "/a/b/c/z.txt", false, true},
+		{"anchored", []string{"/a/b"}, "/a/b", true, true},
+		{"anchored elsewhere", []string{"/a/b"}, "/x/a/b", true, false},
+		{"slash in middle anchors", []string{"a/b"}, "/x/a/b", true, false},
+		{"dir only on dir", []string{"tmp/"}, "/a/tmp", true, true},
+		{"dir only on file", []string{"tmp/"}, "/a/tmp", false, false},
+		{"negation", []string{"*.log", "!keep.log"}, "/a/keep.log", false, false},
+		{"last match wins", []string{"!keep.log", "*.log"}, "/a/keep.log", false, true},
+		{"comment", []string{"# *.log"}, "/a/b.log", false, false},
+		{"escaped hash", []string{`\#notes`}, "/a/#notes", false, true},
+		{"escaped bang", []string{`\!important`}, "/a/!important", false, true},
+		{"trailing spaces", []string{"*.log   "}, "/a/b.log", false, true},
+		{"question mark", []string{"file?.txt"}, "/a/file1.txt", false, true},
+		{"character class", []string{"file[0-9].txt"}, "/a/filex.txt", false, false},
+		{"invalid pattern ignored", []string{"[a"}, "/a/[a", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewMatcher(tt.patterns).Match(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("Match(%q, %v) with %q = %v, want %v", tt.path, tt.isDir, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcher_HomePattern(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	m := NewMatcher([]string{"~/.cache"})
+	if !m.Match(filepath.Join(home, ".cache"), true) {
+		t.Error("~/.cache should match the home cache directory")
+	}
+	if m.Match(filepath.Join(home, "x", ".cache"), true) {
+		t.Error("~/.cache should be anchored to the home directory")
+	}
+}
+
+func TestMatcher_With(t *testing.T) {
+	base := NewMatcher([]string{"*.log"})
+	child := base.With("/repo/nvim", []string{"/lazy", "!debug.log", "plugin/*.lua"})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"/repo/nvim/lazy", true, true},
+		{"/repo/nvim/x/lazy", true, false},
+		{"/repo/nvim/debug.log", false, false},
+		{"/repo/nvim/other.log", false, true},
+		{"/repo/nvim/plugin/a.lua", false, true},
+		{"/repo/nvim/x/plugin/a.lua", false, false},
+		{"/repo/debug.log", false, true},
+	}
+	for _, tt := range tests {
+		if got := child.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// The parent matcher is left alone
+	if base.Match("/repo/nvim/lazy", true) {
+		t.Error("With modified the receiver")
+	}
+	if !(*Matcher)(nil).With("/d", []string{"x"}).Match("/d/x", false) {
+		t.Error("With on a nil matcher should apply the new patterns")
+	}
+}
+
+func TestMatcher_Excluded(t *testing.T) {
+	m := NewMatcher([]string{"node_modules/", "!node_modules/keep.js"})
+	if !m.Excluded("/a/node_modules/keep.js", false) {
+		t.Error("files under an excluded directory cannot be re-included")
+	}
+	if m.Excluded("/a/src/index.js", false) {
+		t.Error("unrelated path excluded")
+	}
+	if (*Matcher)(nil).Excluded("/a", false) {
+		t.Error("nil matcher excluded a path")
+	}
+}
+
+func TestReadIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	lines, err := ReadIgnoreFile(dir)
+	if err != nil || lines != nil {
+		t.Fatalf("ReadIgnoreFile() without a file = %v, %v", lines, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, IgnoreFile), []byte("*.tmp\n\n# comment\n!keep.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lines, err = ReadIgnoreFile(dir)
+	if err != nil || len(lines) != 4 {
+		t.Fatalf("ReadIgnoreFile() = %q, %v", lines, err)
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := ValidatePatterns([]string{"*.log", "!keep.log", "# [comment", "**/cache/"}); err != nil {
+		t.Errorf("ValidatePatterns() error = %v", err)
+	}
+	if err := ValidatePatterns([]string{"*.log", "[a"}); err == nil {
+		t.Error("ValidatePatterns() should reject an unclosed class")
+	}
+}
@@ -20,3 +20,34 @@ func ExpandHome(p string) string {
 	}
 	return p
 }
+
+// xdgDefaults are the XDG base directories, relative to the home
+// directory, used when the variables are unset
+var xdgDefaults = map[string]string{
+	"XDG_CONFIG_HOME": ".config",
+	"XDG_DATA_HOME":   ".local/share",
+	"XDG_STATE_HOME":  ".local/state",
+	"XDG_CACHE_HOME":  ".cache",
+}
+
+// ExpandPath expands ~ like ExpandHome as well as environment variables
+// written as $VAR or ${VAR}. Unset XDG base directories expand to their
+// defaults, such as ~/.config for $XDG_CONFIG_HOME. Other unset variables
+// are left as they are, so a path never silently turns into one outside
+// the intended directory.
+func ExpandPath(p string) string {
+	if strings.Contains(p, "$") {
+		p = os.Expand(p, func(name string) string {
+			if value, ok := os.LookupEnv(name); ok && value != "" {
+				return value
+			}
+			if rel, ok := xdgDefaults[name]; ok {
+				if home, err := os.UserHomeDir(); err == nil {
+					return filepath.Join(home, rel)
+				}
+			}
+			return "${" + name + "}"
+		})
+	}
+	return ExpandHome(p)
+}
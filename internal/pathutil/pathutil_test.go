@@ -68,3 +68,37 @@ func TestExpandHome(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("failed to get home directory: %v", err)
+	}
+	t.Setenv("DOTKEEPER_TEST_DIR", "/srv/dots")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "/data")
+	os.Unsetenv("DOTKEEPER_TEST_UNSET")
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"tilde", "~/.zshrc", filepath.Join(home, ".zshrc")},
+		{"plain variable", "$DOTKEEPER_TEST_DIR/vimrc", "/srv/dots/vimrc"},
+		{"braced variable", "${DOTKEEPER_TEST_DIR}/vimrc", "/srv/dots/vimrc"},
+		{"xdg default", "${XDG_CONFIG_HOME}/nvim", filepath.Join(home, ".config", "nvim")},
+		{"xdg set", "$XDG_DATA_HOME/fonts", "/data/fonts"},
+		{"home variable", "$HOME/.bashrc", filepath.Join(os.Getenv("HOME"), ".bashrc")},
+		{"unset variable kept", "$DOTKEEPER_TEST_UNSET/x", "${DOTKEEPER_TEST_UNSET}/x"},
+		{"glob kept", "$DOTKEEPER_TEST_DIR/*/settings.json", "/srv/dots/*/settings.json"},
+		{"no variables", "/etc/hosts", "/etc/hosts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpandPath(tt.input); got != tt.expected {
+				t.Errorf("ExpandPath(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package pathutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Logical roots that backed up paths are stored relative to, so a backup
+// taken by one user restores into another user's home or config directory
+const (
+	HomeRoot   = "$HOME"
+	ConfigRoot = "$XDG_CONFIG_HOME"
+)
+
+// roots returns the logical roots with the current user's directories,
+// most specific first. Roots that cannot be determined are left out.
+func roots() [][2]string {
+	var result [][2]string
+	home, err := os.UserHomeDir()
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		result = append(result, [2]string{ConfigRoot, filepath.Clean(configHome)})
+	} else if err == nil {
+		result = append(result, [2]string{ConfigRoot, filepath.Join(home, ".config")})
+	}
+	if err == nil {
+		result = append(result, [2]string{HomeRoot, filepath.Clean(home)})
+	}
+	return result
+}
+
+// ToLogical rewrites an absolute path relative to the logical root that
+// contains it, e.g. /home/alice/.zshrc becomes $HOME/.zshrc and
+// /home/alice/.config/nvim/init.lua becomes $XDG_CONFIG_HOME/nvim/init.lua.
+// Paths outside every root, and paths already in logical form, are
+// returned unchanged.
+func ToLogical(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	path = filepath.Clean(path)
+	for _, root := range roots() {
+		if rest, ok := CutPathPrefix(path, root[1]); ok {
+			return joinRoot(root[0], rest)
+		}
+	}
+	return path
+}
+
+// FromLogical resolves a path written by ToLogical against the current
+// user's directories. Absolute paths are returned unchanged.
+func FromLogical(name string) string {
+	for _, root := range roots() {
+		if rest, ok := CutPathPrefix(name, root[0]); ok {
+			return filepath.Join(root[1], rest)
+		}
+	}
+	return name
+}
+
+// CutPathPrefix reports whether path is prefix or lies inside it, and
+// returns the remainder relative to prefix. Unlike strings.CutPrefix it
+// only matches whole path elements, so /home/al is not a prefix of
+// /home/alice.
+func CutPathPrefix(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "", true
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if rest, ok := strings.CutPrefix(path, prefix); ok {
+		return rest, true
+	}
+	return "", false
+}
+
+// joinRoot appends rest to a logical root. filepath.Join is not used so
+// the root itself is kept verbatim.
+func joinRoot(root, rest string) string {
+	if rest == "" {
+		return root
+	}
+	return root + "/" + rest
+}
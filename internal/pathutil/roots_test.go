@@ -0,0 +1,73 @@
+package pathutil
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLogicalRoots(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	tests := []struct {
+		name    string
+		path    string
+		logical string
+	}{
+		{"home file", filepath.Join(home, ".zshrc"), "$HOME/.zshrc"},
+		{"home itself", home, "$HOME"},
+		{"default config dir", filepath.Join(home, ".config", "nvim", "init.lua"), "$XDG_CONFIG_HOME/nvim/init.lua"},
+		{"outside roots", "/etc/hosts", "/etc/hosts"},
+		{"sibling of home", home + "-other/file", home + "-other/file"},
+		{"already logical", "$HOME/.bashrc", "$HOME/.bashrc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ToLogical(tt.path)
+			if got != tt.logical {
+				t.Fatalf("ToLogical(%q) = %q, want %q", tt.path, got, tt.logical)
+			}
+			if back := FromLogical(got); back != filepath.Clean(tt.path) && tt.name != "already logical" {
+				t.Errorf("FromLogical(%q) = %q, want %q", got, back, tt.path)
+			}
+		})
+	}
+}
+
+func TestFromLogical_OtherUser(t *testing.T) {
+	// A backup taken by alice restores into bob's directories
+	bob := t.TempDir()
+	config := filepath.Join(t.TempDir(), "xdg")
+	t.Setenv("HOME", bob)
+	t.Setenv("XDG_CONFIG_HOME", config)
+
+	if got := FromLogical("$HOME/.zshrc"); got != filepath.Join(bob, ".zshrc") {
+		t.Errorf("home entry resolved to %q", got)
+	}
+	if got := FromLogical("$XDG_CONFIG_HOME/git/config"); got != filepath.Join(config, "git", "config") {
+		t.Errorf("config entry resolved to %q", got)
+	}
+	if got := FromLogical("/home/alice/.zshrc"); got != "/home/alice/.zshrc" {
+		t.Errorf("absolute entry resolved to %q", got)
+	}
+}
+
+func TestCutPathPrefix(t *testing.T) {
+	tests := []struct {
+		path, prefix, rest string
+		ok                 bool
+	}{
+		{"/home/alice/.zshrc", "/home/alice", ".zshrc", true},
+		{"/home/alice", "/home/alice", "", true},
+		{"/home/alicea/x", "/home/alice", "", false},
+		{"/etc/hosts", "/", "etc/hosts", true},
+	}
+	for _, tt := range tests {
+		rest, ok := CutPathPrefix(tt.path, tt.prefix)
+		if rest != tt.rest || ok != tt.ok {
+			t.Errorf("CutPathPrefix(%q, %q) = %q, %v", tt.path, tt.prefix, rest, ok)
+		}
+	}
+}
@@ -23,84 +23,6 @@ type ScanResult struct {
 	PathStats   []PathStat
 }
 
-// ScanPaths scans the given files and folders, computing stats.
-// exclude patterns are applied using filepath.Match on base names.
-func ScanPaths(files, folders, exclude []string) ScanResult {
-	result := ScanResult{}
-
-	for _, f := range files {
-		expanded := ExpandHome(f)
-		stat := PathStat{Path: f}
-		info, err := os.Stat(expanded)
-		if err != nil {
-			stat.Exists = false
-			result.BrokenPaths = append(result.BrokenPaths, f)
-		} else {
-			stat.Exists = true
-			stat.IsDir = info.IsDir()
-			stat.Size = info.Size()
-			if !stat.IsDir {
-				result.TotalFiles++
-				result.TotalSize += info.Size()
-			}
-		}
-		result.PathStats = append(result.PathStats, stat)
-	}
-
-	for _, f := range folders {
-		expanded := ExpandHome(f)
-		stat := PathStat{Path: f, IsDir: true}
-		info, err := os.Stat(expanded)
-		if err != nil {
-			stat.Exists = false
-			result.BrokenPaths = append(result.BrokenPaths, f)
-		} else if !info.IsDir() {
-			// It's a file but listed as folder
-			stat.Exists = true
-			stat.IsDir = false
-			stat.Size = info.Size()
-			result.TotalFiles++
-			result.TotalSize += info.Size()
-		} else {
-			stat.Exists = true
-			walkErrors := 0
-			_ = filepath.WalkDir(expanded, func(path string, d os.DirEntry, err error) error {
-				if err != nil {
-					walkErrors++
-					return nil
-				}
-				if !d.IsDir() {
-					baseName := filepath.Base(path)
-					excluded := false
-					for _, pat := range exclude {
-						if matched, _ := filepath.Match(pat, baseName); matched {
-							excluded = true
-							break
-						}
-					}
-					if !excluded {
-						if info, err := d.Info(); err == nil {
-							stat.FileCount++
-							stat.Size += info.Size()
-							result.TotalFiles++
-							result.TotalSize += info.Size()
-						} else {
-							walkErrors++
-						}
-					}
-				}
-				return nil
-			})
-			if walkErrors > 0 {
-				result.BrokenPaths = append(result.BrokenPaths, f)
-			}
-		}
-		result.PathStats = append(result.PathStats, stat)
-	}
-
-	return result
-}
-
 // FormatSize returns a human-readable size string.
 func FormatSize(bytes int64) string {
 	const unit = 1024
@@ -115,9 +37,23 @@ func FormatSize(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-// GetPathDesc returns a brief description of a path's status.
+// GetPathDesc returns a brief description of a path's status. Glob
+// patterns are described by what they currently match.
 func GetPathDesc(path string) string {
-	expanded := ExpandHome(path)
+	if IsGlobPattern(path) {
+		matches, err := ResolveGlob(path, nil)
+		if err != nil {
+			return "INVALID PATTERN"
+		}
+		if len(matches) == 0 {
+			return "NO MATCHES"
+		}
+		if len(matches) == 1 {
+			return "1 match"
+		}
+		return fmt.Sprintf("%d matches", len(matches))
+	}
+	expanded := ExpandPath(path)
 	info, err := os.Stat(expanded)
 	if err != nil {
 		return "NOT FOUND"
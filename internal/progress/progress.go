@@ -0,0 +1,206 @@
+// Package progress describes how far a running backup or restore has got.
+// Operations report Events through a Func as they go: the phase they are
+// in, the file at hand, bytes and files done out of the total, and an
+// estimate of the time left. The TUI draws a progress bar from them and the
+// CLI can print them as JSON lines for wrappers.
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Phase is a stage of an operation
+type Phase string
+
+// Phases, in the order a backup or restore goes through them
+const (
+	// Collecting walks the configured paths
+	Collecting Phase = "collecting"
+	// Scanning looks for secrets in the collected files
+	Scanning Phase = "scanning"
+	// Comparing checks the files against the newest backup
+	Comparing Phase = "comparing"
+	// Writing stores the files in an archive or the repository
+	Writing Phase = "writing"
+	// Restoring puts files from a backup back in place
+	Restoring Phase = "restoring"
+	// Done is reported once when the operation has finished
+	Done Phase = "done"
+)
+
+// Event reports the state of an operation
+type Event struct {
+	Operation string `json:"operation"`
+	Phase     Phase  `json:"phase"`
+	// File is the file being processed, if any
+	File string `json:"file,omitempty"`
+	// Action, when set, is what just happened to File, e.g. "restored"
+	// or "skipped"
+	Action     string `json:"action,omitempty"`
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total"`
+	FilesDone  int    `json:"files_done"`
+	FilesTotal int    `json:"files_total"`
+	// ETA is the estimated time left in the phase, zero while unknown
+	ETA time.Duration `json:"-"`
+}
+
+// Fraction returns how much of the phase is done, between 0 and 1. Bytes
+// are used when the total is known, files otherwise.
+func (e Event) Fraction() float64 {
+	switch {
+	case e.BytesTotal > 0:
+		return min(float64(e.BytesDone)/float64(e.BytesTotal), 1)
+	case e.FilesTotal > 0:
+		return min(float64(e.FilesDone)/float64(e.FilesTotal), 1)
+	default:
+		return 0
+	}
+}
+
+// MarshalJSON encodes the event with the ETA in whole seconds
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	return json.Marshal(struct {
+		event
+		ETA int64 `json:"eta_seconds,omitempty"`
+	}{event(e), int64(e.ETA.Round(time.Second) / time.Second)})
+}
+
+// Func receives progress events. It is called on the goroutine running the
+// operation and should return quickly.
+type Func func(Event)
+
+// minInterval is the least time between events reporting bytes or files
+// done; phase changes, actions and the final event are always reported
+var minInterval = 100 * time.Millisecond
+
+// Tracker counts the work of one operation and reports it as events. A
+// tracker with a nil Func counts without reporting. It is not safe for
+// concurrent use.
+type Tracker struct {
+	fn    Func
+	event Event
+
+	phaseStart time.Time
+	lastEmit   time.Time
+
+	// fileStart and fileSize are the bytes done when the current file
+	// started and its size, so a file that is not read in full is still
+	// counted once it is done
+	fileStart int64
+	fileSize  int64
+}
+
+// NewTracker returns a tracker reporting events for operation to fn
+func NewTracker(operation string, fn Func) *Tracker {
+	return &Tracker{fn: fn, event: Event{Operation: operation}}
+}
+
+// Phase starts a phase with the given totals; zero totals are unknown
+func (t *Tracker) Phase(phase Phase, files int, bytes int64) {
+	t.event = Event{
+		Operation:  t.event.Operation,
+		Phase:      phase,
+		FilesTotal: files,
+		BytesTotal: bytes,
+	}
+	t.phaseStart = time.Now()
+	t.fileStart, t.fileSize = 0, 0
+	t.emit(true)
+}
+
+// Start marks file, of size bytes, as the one being processed
+func (t *Tracker) Start(file string, size int64) {
+	t.event.File = file
+	t.fileStart = t.event.BytesDone
+	t.fileSize = size
+	t.emit(false)
+}
+
+// Add counts n bytes of the current file as done
+func (t *Tracker) Add(n int64) {
+	t.event.BytesDone += n
+	t.emit(false)
+}
+
+// Note reports that action happened to file
+func (t *Tracker) Note(file, action string) {
+	t.event.File = file
+	t.event.Action = action
+	t.emit(true)
+	t.event.Action = ""
+}
+
+// Done counts the current file as done, including any of its bytes that
+// were not read
+func (t *Tracker) Done() {
+	t.event.FilesDone++
+	t.event.BytesDone = max(t.event.BytesDone, t.fileStart+t.fileSize)
+	t.fileStart, t.fileSize = t.event.BytesDone, 0
+	t.emit(t.event.FilesTotal > 0 && t.event.FilesDone == t.event.FilesTotal)
+}
+
+// Finish reports the Done phase with the counts of the last phase
+func (t *Tracker) Finish() {
+	t.event.Phase = Done
+	t.event.File = ""
+	t.event.ETA = 0
+	if t.fn != nil {
+		t.fn(t.event)
+	}
+}
+
+// Reader returns a reader counting what is read from r as done. Reads fail
+// with the context's error once ctx is done.
+func (t *Tracker) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, t: t}
+}
+
+// emit reports the current state, unless the last report was too recent
+// and force is unset
+func (t *Tracker) emit(force bool) {
+	if t.fn == nil {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(t.lastEmit) < minInterval {
+		return
+	}
+	t.lastEmit = now
+	t.event.ETA = t.eta(now)
+	t.fn(t.event)
+}
+
+// eta extrapolates the time left from the rate so far
+func (t *Tracker) eta(now time.Time) time.Duration {
+	done, total := t.event.BytesDone, t.event.BytesTotal
+	if total <= 0 {
+		done, total = int64(t.event.FilesDone), int64(t.event.FilesTotal)
+	}
+	if done <= 0 || total <= done {
+		return 0
+	}
+	elapsed := now.Sub(t.phaseStart)
+	return time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *Tracker
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.Add(int64(n))
+	}
+	return n, err
+}
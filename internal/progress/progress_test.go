@@ -0,0 +1,109 @@
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracker_Counts(t *testing.T) {
+	var events []Event
+	tr := NewTracker("backup", func(e Event) { events = append(events, e) })
+
+	tr.Phase(Writing, 2, 10)
+	tr.Start("/a", 4)
+	if _, err := io.Copy(io.Discard, tr.Reader(context.Background(), strings.NewReader("abcd"))); err != nil {
+		t.Fatal(err)
+	}
+	tr.Done()
+	// A file that is never read still counts in full once done
+	tr.Start("/b", 6)
+	tr.Note("/b", "skipped")
+	tr.Done()
+	tr.Finish()
+
+	last := events[len(events)-1]
+	if last.Phase != Done || last.Operation != "backup" {
+		t.Errorf("last event = %+v, want the done phase", last)
+	}
+	if last.FilesDone != 2 || last.BytesDone != 10 {
+		t.Errorf("last event = %+v, want 2 files and 10 bytes done", last)
+	}
+
+	var noted bool
+	for _, e := range events {
+		if e.Action == "skipped" && e.File == "/b" {
+			noted = true
+		}
+	}
+	if !noted {
+		t.Errorf("events = %+v, want the skipped action", events)
+	}
+}
+
+func TestTracker_Throttles(t *testing.T) {
+	var events []Event
+	tr := NewTracker("restore", func(e Event) { events = append(events, e) })
+
+	tr.Phase(Restoring, 0, 1000)
+	for range 100 {
+		tr.Add(1)
+	}
+	// Only the phase change gets through within minInterval
+	if len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+}
+
+func TestTracker_ETA(t *testing.T) {
+	tr := NewTracker("backup", nil)
+	tr.Phase(Writing, 0, 100)
+	tr.phaseStart = time.Now().Add(-10 * time.Second)
+	tr.Add(25)
+
+	eta := tr.eta(time.Now())
+	if eta < 29*time.Second || eta > 31*time.Second {
+		t.Errorf("eta = %v, want about 30s", eta)
+	}
+	if got := (Event{BytesDone: 25, BytesTotal: 100}).Fraction(); got != 0.25 {
+		t.Errorf("Fraction() = %v, want 0.25", got)
+	}
+	if got := (Event{FilesDone: 1, FilesTotal: 4}).Fraction(); got != 0.25 {
+		t.Errorf("Fraction() by files = %v, want 0.25", got)
+	}
+}
+
+func TestReader_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tr := NewTracker("backup", nil)
+	_, err := io.ReadAll(tr.Reader(ctx, strings.NewReader("data")))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadAll() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestEvent_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Event{
+		Operation:  "backup",
+		Phase:      Writing,
+		File:       "/a",
+		BytesDone:  5,
+		BytesTotal: 10,
+		FilesDone:  1,
+		FilesTotal: 2,
+		ETA:        1500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"operation":"backup","phase":"writing","file":"/a","bytes_done":5,"bytes_total":10,"files_done":1,"files_total":2,"eta_seconds":2}`
+	if string(data) != want {
+		t.Errorf("json = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Content-defined chunking parameters. Cut points depend on the data rather
+// than on offsets, so an insertion near the start of a large file only
+// changes the chunks around it.
+const (
+	MinChunkSize = 64 * 1024
+	MaxChunkSize = 1024 * 1024
+	// chunkMask gives an average chunk size of roughly MinChunkSize + 256 KiB
+	chunkMask = 1<<18 - 1
+)
+
+// gearTable holds the per-byte values of the rolling gear hash
+type gearTable [256]uint64
+
+// newGearTable derives the gear hash table from a repository key. Keying the
+// table keeps chunk boundaries, and therefore chunk sizes, from revealing
+// anything about known file contents.
+func newGearTable(key []byte) *gearTable {
+	var table gearTable
+	mac := hmac.New(sha256.New, key)
+	for i := range table {
+		mac.Reset()
+		mac.Write([]byte{byte(i)})
+		table[i] = binary.BigEndian.Uint64(mac.Sum(nil))
+	}
+	return &table
+}
+
+// chunker splits a stream into content-defined chunks
+type chunker struct {
+	r     io.Reader
+	gear  *gearTable
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+// newChunker returns a chunker reading from r. It buffers at most
+// MaxChunkSize bytes.
+func newChunker(r io.Reader, gear *gearTable) *chunker {
+	return &chunker{
+		r:    r,
+		gear: gear,
+		buf:  make([]byte, MaxChunkSize),
+	}
+}
+
+// Next returns the next chunk, or io.EOF when the stream is exhausted. The
+// returned slice is only valid until the next call.
+func (c *chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	data := c.buf[c.start:c.end]
+	cut := len(data)
+	if cut > MinChunkSize {
+		var h uint64
+		for i := MinChunkSize; i < len(data); i++ {
+			h = (h << 1) + c.gear[data[i]]
+			if h&chunkMask == 0 {
+				cut = i + 1
+				break
+			}
+		}
+	}
+
+	c.start += cut
+	return data[:cut], nil
+}
+
+// fill tops the buffer up to MaxChunkSize bytes or until the end of the stream
+func (c *chunker) fill() error {
+	if c.eof || c.end-c.start == len(c.buf) {
+		return nil
+	}
+
+	n := copy(c.buf, c.buf[c.start:c.end])
+	c.start, c.end = 0, n
+
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func chunkAll(t *testing.T, data []byte, gear *gearTable) [][]byte {
+	t.Helper()
+	c := newChunker(bytes.NewReader(data), gear)
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+	return chunks
+}
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestChunker_Reassembles(t *testing.T) {
+	gear := newGearTable([]byte("test-key"))
+
+	sizes := []int{0, 1, MinChunkSize - 1, MinChunkSize, MaxChunkSize, 3*MaxChunkSize + 17}
+	for _, size := range sizes {
+		data := randomData(int64(size), size)
+		chunks := chunkAll(t, data, gear)
+
+		if size == 0 && len(chunks) != 0 {
+			t.Errorf("size 0: expected no chunks, got %d", len(chunks))
+		}
+
+		var joined []byte
+		for i, chunk := range chunks {
+			if len(chunk) > MaxChunkSize {
+				t.Errorf("size %d: chunk %d is %d bytes, above the maximum", size, i, len(chunk))
+			}
+			if i < len(chunks)-1 && len(chunk) < MinChunkSize {
+				t.Errorf("size %d: chunk %d is %d bytes, below the minimum", size, i, len(chunk))
+			}
+			joined = append(joined, chunk...)
+		}
+		if !bytes.Equal(joined, data) {
+			t.Errorf("size %d: chunks do not reassemble to the input", size)
+		}
+	}
+}
+
+func TestChunker_BoundariesSurviveInsertion(t *testing.T) {
+	gear := newGearTable([]byte("test-key"))
+	data := randomData(1, 8*MaxChunkSize)
+
+	// Insert a few bytes near the start: only the first chunks should change
+	shifted := append(append(append([]byte(nil), data[:1000]...), []byte("inserted")...), data[1000:]...)
+
+	original := make(map[string]bool)
+	for _, chunk := range chunkAll(t, data, gear) {
+		original[string(chunk)] = true
+	}
+
+	chunks := chunkAll(t, shifted, gear)
+	shared := 0
+	for _, chunk := range chunks {
+		if original[string(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(chunks)-2 {
+		t.Errorf("expected all but the first chunks to be shared, got %d of %d", shared, len(chunks))
+	}
+}
@@ -0,0 +1,484 @@
+// Package repository implements a content-addressed, deduplicated backup
+// store. Files are split into content-defined chunks; every chunk is
+// encrypted and stored once under its keyed hash, and each snapshot is a
+// small encrypted manifest that references chunks.
+//
+// Layout under the repository directory:
+//
+//	config.json                  key slots (or salt and KDF parameters) and
+//	                             a key check
+//	chunks/ab/abcdef...          encrypted chunks, fanned out by ID prefix
+//	snapshots/<name>.snap        encrypted snapshot manifest
+//	snapshots/<name>.refs.json   plaintext index: creation time, sizes and
+//	                             referenced chunk IDs
+//
+// The plaintext index lets snapshots be listed, deleted and garbage-collected
+// without the password.
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+)
+
+// DirName is the name of the repository directory inside the backup directory
+const DirName = "repository"
+
+// FormatVersion is the version of the repository layout. Version 1
+// repositories derive their master key from the password; from version 2
+// on, key slots wrap it.
+const FormatVersion = 2
+
+const keyCheckPlaintext = "dotkeeper-repository"
+
+// Chunk payload encodings, stored as the first byte of the decrypted chunk
+const (
+	chunkRaw  byte = 0
+	chunkGzip byte = 1
+)
+
+// ErrNotFound is returned when a repository does not exist yet
+var ErrNotFound = errors.New("repository not found")
+
+// Config is the plaintext repository configuration stored in config.json
+type Config struct {
+	Version    int       `json:"version"`
+	Created    time.Time `json:"created"`
+	KDF        string    `json:"kdf"`
+	Salt       []byte    `json:"salt"`
+	KDFTime    int       `json:"kdf_time"`
+	KDFMemory  int       `json:"kdf_memory"`
+	KDFThreads int       `json:"kdf_threads"`
+	KeyCheck   []byte    `json:"key_check"`
+	// KeySlots wrap the master key of the backup directory. The repository
+	// master key is that key, or WrappedKey sealed with it for repositories
+	// whose master key was derived from the password before slots existed.
+	KeySlots   []crypto.KeySlot `json:"key_slots,omitempty"`
+	WrappedKey []byte           `json:"wrapped_key,omitempty"`
+}
+
+// MasterKey returns the repository master key from the key its slots open
+func (c *Config) MasterKey(slotKey []byte) ([]byte, error) {
+	if len(c.WrappedKey) == 0 {
+		return slotKey, nil
+	}
+	return crypto.OpenKey(slotKey, c.WrappedKey)
+}
+
+// Repository is an open repository with its keys derived
+type Repository struct {
+	dir         string
+	config      Config
+	master      []byte
+	chunkKey    []byte
+	idKey       []byte
+	snapshotKey []byte
+	gear        *gearTable
+}
+
+// Dir returns the repository directory inside a backup directory
+func Dir(backupDir string) string {
+	return filepath.Join(backupDir, DirName)
+}
+
+// ConfigPath returns the path of the configuration of the repository in dir
+func ConfigPath(dir string) string {
+	return filepath.Join(dir, "config.json")
+}
+
+// Exists reports whether a repository has been initialised in dir
+func Exists(dir string) bool {
+	_, err := os.Stat(ConfigPath(dir))
+	return err == nil
+}
+
+// Init creates a new repository in dir protected by password
+func Init(dir, password string) (*Repository, error) {
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return initRepository(dir, Config{
+		Version:    1,
+		Created:    time.Now(),
+		KDF:        "Argon2id",
+		Salt:       salt,
+		KDFTime:    crypto.Argon2Time,
+		KDFMemory:  crypto.Argon2Memory,
+		KDFThreads: crypto.Argon2Threads,
+	}, crypto.DeriveKey(password, salt))
+}
+
+// InitWithKeys creates a new repository in dir whose master key is master,
+// wrapped by slots
+func InitWithKeys(dir string, master []byte, slots []crypto.KeySlot) (*Repository, error) {
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return initRepository(dir, Config{
+		Version:  FormatVersion,
+		Created:  time.Now(),
+		Salt:     salt,
+		KeySlots: slots,
+	}, master)
+}
+
+// initRepository creates the repository directories and writes config
+// with a key check for master
+func initRepository(dir string, config Config, master []byte) (*Repository, error) {
+	if Exists(dir) {
+		return nil, fmt.Errorf("repository already exists: %s", dir)
+	}
+
+	for _, sub := range []string{"chunks", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create repository directory: %w", err)
+		}
+	}
+
+	repo := &Repository{dir: dir, config: config}
+	if err := repo.deriveKeys(master); err != nil {
+		return nil, err
+	}
+
+	var err error
+	repo.config.KeyCheck, err = crypto.Encrypt([]byte(keyCheckPlaintext), repo.snapshotKey, config.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key check: %w", err)
+	}
+
+	data, err := json.MarshalIndent(repo.config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal repository config: %w", err)
+	}
+	if err := writeFileAtomic(ConfigPath(dir), data); err != nil {
+		return nil, fmt.Errorf("failed to write repository config: %w", err)
+	}
+
+	return repo, nil
+}
+
+// Open opens an existing repository and verifies the password, which may
+// also be a keyfile or recovery key when the repository has key slots
+func Open(dir, password string) (*Repository, error) {
+	config, err := LoadConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.KeySlots) == 0 {
+		return OpenWithKey(dir, crypto.DeriveKey(password, config.Salt))
+	}
+
+	slotKey, _, err := crypto.OpenKeySlots(config.KeySlots, password)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong password?): %w", err)
+	}
+	master, err := config.MasterKey(slotKey)
+	if err != nil {
+		return nil, err
+	}
+	return OpenWithKey(dir, master)
+}
+
+// OpenWithKey opens an existing repository with its master key, already
+// unwrapped or derived from the password and the repository salt, and
+// verifies it
+func OpenWithKey(dir string, master []byte) (*Repository, error) {
+	config, err := LoadConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &Repository{dir: dir, config: *config}
+	if err := repo.deriveKeys(master); err != nil {
+		return nil, err
+	}
+
+	check, err := crypto.Decrypt(repo.config.KeyCheck, repo.snapshotKey)
+	if err != nil || string(check) != keyCheckPlaintext {
+		return nil, fmt.Errorf("decryption failed (wrong password?)")
+	}
+
+	return repo, nil
+}
+
+// LoadConfig reads the plaintext configuration of the repository in dir
+func LoadConfig(dir string) (*Config, error) {
+	data, err := os.ReadFile(ConfigPath(dir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read repository config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse repository config: %w", err)
+	}
+	if config.Version < 1 || config.Version > FormatVersion {
+		return nil, fmt.Errorf("unsupported repository version: %d", config.Version)
+	}
+	return &config, nil
+}
+
+// OpenOrInit opens the repository in dir, creating it on first use
+func OpenOrInit(dir, password string) (*Repository, error) {
+	repo, err := Open(dir, password)
+	if errors.Is(err, ErrNotFound) {
+		return Init(dir, password)
+	}
+	return repo, err
+}
+
+// KeySlotConfig returns the repository configuration with its master key
+// wrapped by slots instead, for the caller to write to ConfigPath. slotKey
+// is the key the slots open.
+func (r *Repository) KeySlotConfig(slots []crypto.KeySlot, slotKey []byte) ([]byte, error) {
+	config := r.config
+	config.Version = FormatVersion
+	config.KDF, config.KDFTime, config.KDFMemory, config.KDFThreads = "", 0, 0, 0
+	config.KeySlots = slots
+	config.WrappedKey = nil
+	if !bytes.Equal(r.master, slotKey) {
+		wrapped, err := crypto.SealKey(slotKey, r.master)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap repository key: %w", err)
+		}
+		config.WrappedKey = wrapped
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal repository config: %w", err)
+	}
+	return data, nil
+}
+
+// deriveKeys derives the per-purpose keys from the master key
+func (r *Repository) deriveKeys(master []byte) error {
+	r.master = master
+	var err error
+	if r.chunkKey, err = crypto.DeriveSubkey(master, "dotkeeper chunk encryption"); err != nil {
+		return err
+	}
+	if r.idKey, err = crypto.DeriveSubkey(master, "dotkeeper chunk id"); err != nil {
+		return err
+	}
+	if r.snapshotKey, err = crypto.DeriveSubkey(master, "dotkeeper snapshot encryption"); err != nil {
+		return err
+	}
+	chunkerKey, err := crypto.DeriveSubkey(master, "dotkeeper chunker")
+	if err != nil {
+		return err
+	}
+	r.gear = newGearTable(chunkerKey)
+	return nil
+}
+
+// Path returns the repository directory
+func (r *Repository) Path() string {
+	return r.dir
+}
+
+// chunkID returns the keyed hash that identifies a chunk. Keying the hash
+// stops anyone without the password from confirming known file contents.
+func (r *Repository) chunkID(data []byte) string {
+	mac := hmac.New(sha256.New, r.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Repository) chunkPath(id string) string {
+	return filepath.Join(r.dir, "chunks", id[:2], id)
+}
+
+// WriteFile splits content into chunks and stores the ones the repository
+// does not have yet. It returns the chunk IDs in order and the number of
+// encrypted bytes newly written.
+func (r *Repository) WriteFile(content io.Reader) (ids []string, added int64, err error) {
+	c := newChunker(content, r.gear)
+	for {
+		data, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read content: %w", err)
+		}
+
+		id := r.chunkID(data)
+		n, err := r.storeChunk(id, data)
+		if err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+		added += n
+	}
+	return ids, added, nil
+}
+
+// storeChunk encrypts and writes a chunk unless it is already stored
+func (r *Repository) storeChunk(id string, data []byte) (int64, error) {
+	path := r.chunkPath(id)
+	if _, err := os.Stat(path); err == nil {
+		return 0, nil
+	}
+
+	payload := encodeChunk(data)
+	encrypted, err := crypto.Encrypt(payload, r.chunkKey, r.config.Salt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt chunk: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+	if err := writeFileAtomic(path, encrypted); err != nil {
+		return 0, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	return int64(len(encrypted)), nil
+}
+
+// ReadChunk reads, decrypts and verifies a chunk
+func (r *Repository) ReadChunk(id string) ([]byte, error) {
+	if len(id) < 2 {
+		return nil, fmt.Errorf("invalid chunk id: %q", id)
+	}
+
+	encrypted, err := os.ReadFile(r.chunkPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", id, err)
+	}
+
+	payload, err := crypto.Decrypt(encrypted, r.chunkKey)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", id, err)
+	}
+
+	data, err := decodeChunk(payload)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", id, err)
+	}
+
+	if !hmac.Equal([]byte(r.chunkID(data)), []byte(id)) {
+		return nil, fmt.Errorf("chunk %s is corrupted", id)
+	}
+	return data, nil
+}
+
+// OpenFile returns a reader that streams a file's content chunk by chunk
+func (r *Repository) OpenFile(chunks []string) io.Reader {
+	return &chunkReader{repo: r, chunks: chunks}
+}
+
+// chunkReader concatenates chunks, loading one at a time
+type chunkReader struct {
+	repo   *Repository
+	chunks []string
+	cur    []byte
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.cur) == 0 {
+		if len(cr.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := cr.repo.ReadChunk(cr.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		cr.chunks = cr.chunks[1:]
+		cr.cur = data
+	}
+	n := copy(p, cr.cur)
+	cr.cur = cr.cur[n:]
+	return n, nil
+}
+
+// encodeChunk gzips a chunk when that makes it smaller
+func encodeChunk(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(chunkGzip)
+	gzw := gzip.NewWriter(&buf)
+	if _, err := gzw.Write(data); err == nil && gzw.Close() == nil && buf.Len() < len(data)+1 {
+		return buf.Bytes()
+	}
+
+	payload := make([]byte, 0, len(data)+1)
+	payload = append(payload, chunkRaw)
+	return append(payload, data...)
+}
+
+// decodeChunk reverses encodeChunk
+func decodeChunk(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty chunk payload")
+	}
+
+	switch payload[0] {
+	case chunkRaw:
+		return payload[1:], nil
+	case chunkGzip:
+		gzr, err := gzip.NewReader(bytes.NewReader(payload[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+		}
+		defer gzr.Close()
+		data, err := io.ReadAll(gzr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown chunk encoding: %d", payload[0])
+	}
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".dotkeeper-repo-*")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	defer func() {
+		if tempPath != "" {
+			os.Remove(tempPath)
+		}
+	}()
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Chmod(0600); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return err
+	}
+	tempPath = ""
+	return nil
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+)
+
+func TestOpenOrInit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+
+	if _, err := Open(dir, "pw"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	repo, err := OpenOrInit(dir, "pw")
+	if err != nil {
+		t.Fatalf("OpenOrInit failed: %v", err)
+	}
+	if !Exists(dir) {
+		t.Fatal("expected repository to exist after init")
+	}
+
+	if _, err := Init(dir, "pw"); err == nil {
+		t.Error("expected Init to refuse an existing repository")
+	}
+
+	reopened, err := Open(dir, "pw")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if !bytes.Equal(repo.idKey, reopened.idKey) {
+		t.Error("reopened repository should derive the same keys")
+	}
+
+	if _, err := Open(dir, "wrong"); err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Errorf("expected wrong password error, got %v", err)
+	}
+}
+
+func TestOpenWithKey(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	opened, err := OpenWithKey(dir, crypto.DeriveKey("pw", config.Salt))
+	if err != nil {
+		t.Fatalf("OpenWithKey failed: %v", err)
+	}
+	if !bytes.Equal(repo.chunkKey, opened.chunkKey) {
+		t.Error("opening with the derived key should give the same keys")
+	}
+
+	if _, err := OpenWithKey(dir, crypto.DeriveKey("wrong", config.Salt)); err == nil {
+		t.Error("expected a wrong key to be rejected")
+	}
+}
+
+func TestWriteFile_Deduplicates(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := randomData(42, 2*MaxChunkSize+123)
+
+	ids, added, err := repo.WriteFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if len(ids) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(ids))
+	}
+	if added == 0 {
+		t.Error("expected first write to add data")
+	}
+
+	again, added, err := repo.WriteFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if added != 0 {
+		t.Errorf("expected identical content to add nothing, added %d bytes", added)
+	}
+	if strings.Join(ids, ",") != strings.Join(again, ",") {
+		t.Error("identical content should produce identical chunk IDs")
+	}
+
+	got, err := io.ReadAll(repo.OpenFile(ids))
+	if err != nil {
+		t.Fatalf("OpenFile read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("content read back does not match")
+	}
+}
+
+func TestWriteFile_ChunksAreEncrypted(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("export API_TOKEN=very-secret-value")
+	ids, _, err := repo.WriteFile(bytes.NewReader(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(repo.chunkPath(ids[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("very-secret-value")) {
+		t.Error("chunk is stored in plaintext")
+	}
+}
+
+func TestReadChunk_DetectsTampering(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, _, err := repo.WriteFile(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := repo.chunkPath(ids[0])
+	raw, _ := os.ReadFile(path)
+	raw[len(raw)-1] ^= 0xff
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.ReadChunk(ids[0]); err == nil {
+		t.Error("expected error reading a tampered chunk")
+	}
+}
+
+func TestEncodeChunk(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding byte
+	}{
+		{"compressible", bytes.Repeat([]byte("a"), 4096), chunkGzip},
+		{"random", randomData(7, 4096), chunkRaw},
+		{"empty", []byte{}, chunkRaw},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := encodeChunk(tt.data)
+			if payload[0] != tt.encoding {
+				t.Errorf("encoding = %d, want %d", payload[0], tt.encoding)
+			}
+			got, err := decodeChunk(payload)
+			if err != nil {
+				t.Fatalf("decodeChunk failed: %v", err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Error("decoded chunk does not match")
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/fsmeta"
+)
+
+// SnapshotExt is the file extension of encrypted snapshot manifests
+const SnapshotExt = ".snap"
+
+const refsExt = ".refs.json"
+
+// Snapshot is the decrypted manifest of one backup
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Files   []File    `json:"files"`
+	// Tags and Note label the snapshot. They are only kept in its index,
+	// in plaintext behind a MAC made with the repository key.
+	Tags []string `json:"-"`
+	Note string   `json:"-"`
+}
+
+// File describes a file, symlink or directory in a snapshot
+type File struct {
+	Path       string   `json:"path"`
+	Size       int64    `json:"size"`
+	Mode       int64    `json:"mode"`
+	ModTime    int64    `json:"mtime"`
+	SHA256     string   `json:"sha256,omitempty"`
+	LinkTarget string   `json:"link_target,omitempty"`
+	Dir        bool     `json:"dir,omitempty"`
+	Chunks     []string `json:"chunks,omitempty"`
+	// Meta holds ownership and extended attributes
+	Meta fsmeta.Metadata `json:"meta,omitzero"`
+}
+
+// SnapshotInfo is the plaintext index of a snapshot
+type SnapshotInfo struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"-"`
+	Created      time.Time `json:"created"`
+	FileCount    int       `json:"file_count"`
+	OriginalSize int64     `json:"original_size"`
+	AddedSize    int64     `json:"added_size"`
+	Chunks       []string  `json:"chunks"`
+	Tags         []string  `json:"tags,omitempty"`
+	Note         string    `json:"note,omitempty"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	// LabelsMAC authenticates the name, tags, note and pin, so that they
+	// cannot be changed without the password
+	LabelsMAC []byte `json:"labels_mac,omitempty"`
+}
+
+// snapshotLabelsPurpose names the subkey the labels of snapshots are
+// authenticated with
+const snapshotLabelsPurpose = "dotkeeper snapshot labels"
+
+// IsSnapshotPath reports whether path names a snapshot manifest
+func IsSnapshotPath(path string) bool {
+	return strings.HasSuffix(path, SnapshotExt)
+}
+
+// DirFromSnapshot returns the repository directory a snapshot manifest belongs to
+func DirFromSnapshot(snapshotPath string) string {
+	return filepath.Dir(filepath.Dir(snapshotPath))
+}
+
+// SaveSnapshot encrypts and stores a snapshot manifest together with its
+// plaintext index. addedSize is the number of bytes the snapshot added to
+// the repository. It returns the manifest path and a checksum of the
+// plaintext manifest.
+func (r *Repository) SaveSnapshot(snap *Snapshot, addedSize int64) (string, string, error) {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	encrypted, err := crypto.Encrypt(data, r.snapshotKey, r.config.Salt)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt snapshot: %w", err)
+	}
+
+	info := SnapshotInfo{
+		Name:      snap.Name,
+		Created:   snap.Created,
+		AddedSize: addedSize,
+		Tags:      snap.Tags,
+		Note:      snap.Note,
+	}
+	seen := make(map[string]bool)
+	for _, f := range snap.Files {
+		if !f.Dir {
+			info.FileCount++
+		}
+		info.OriginalSize += f.Size
+		for _, id := range f.Chunks {
+			if !seen[id] {
+				seen[id] = true
+				info.Chunks = append(info.Chunks, id)
+			}
+		}
+	}
+	sort.Strings(info.Chunks)
+	if info.LabelsMAC, err = r.labelsMAC(info); err != nil {
+		return "", "", err
+	}
+
+	infoJSON, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal snapshot index: %w", err)
+	}
+
+	snapDir := filepath.Join(r.dir, "snapshots")
+	if err := os.MkdirAll(snapDir, 0700); err != nil {
+		return "", "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	// The index is written first so its chunks are never garbage-collected
+	// while the manifest that needs them exists
+	snapPath := filepath.Join(snapDir, snap.Name+SnapshotExt)
+	if err := writeFileAtomic(filepath.Join(snapDir, snap.Name+refsExt), infoJSON); err != nil {
+		return "", "", fmt.Errorf("failed to write snapshot index: %w", err)
+	}
+	if err := writeFileAtomic(snapPath, encrypted); err != nil {
+		return "", "", fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	return snapPath, hex.EncodeToString(sum[:]), nil
+}
+
+// LoadSnapshot reads and decrypts the snapshot manifest at path
+func (r *Repository) LoadSnapshot(path string) (*Snapshot, error) {
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	data, err := crypto.Decrypt(encrypted, r.snapshotKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// ListSnapshots returns the snapshots in the repository at dir, oldest first.
+// It does not need the password.
+func ListSnapshots(dir string) ([]SnapshotInfo, error) {
+	snapDir := filepath.Join(dir, "snapshots")
+	entries, err := os.ReadDir(snapDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var snapshots []SnapshotInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, SnapshotExt) {
+			continue
+		}
+
+		base := strings.TrimSuffix(name, SnapshotExt)
+		path := filepath.Join(snapDir, name)
+		info := SnapshotInfo{Name: base}
+		if data, err := os.ReadFile(filepath.Join(snapDir, base+refsExt)); err == nil {
+			json.Unmarshal(data, &info)
+		}
+		info.Path = path
+		if info.Created.IsZero() {
+			if st, err := os.Stat(path); err == nil {
+				info.Created = st.ModTime()
+			}
+		}
+		snapshots = append(snapshots, info)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// ReadSnapshotInfo reads the plaintext index of the named snapshot
+func (r *Repository) ReadSnapshotInfo(name string) (*SnapshotInfo, error) {
+	if name == "" || filepath.Base(name) != name {
+		return nil, fmt.Errorf("invalid snapshot name: %q", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.dir, "snapshots", name+refsExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot index: %w", err)
+	}
+	var info SnapshotInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot index: %w", err)
+	}
+	return &info, nil
+}
+
+// UpdateSnapshotInfo rewrites the plaintext index of a snapshot with update
+// applied to it and its labels authenticated again; it is meant for the
+// labels. The index lists the chunks the snapshot needs, so a snapshot
+// without one is refused rather than given an index that would leave its
+// chunks to garbage collection.
+func (r *Repository) UpdateSnapshotInfo(name string, update func(*SnapshotInfo)) error {
+	info, err := r.ReadSnapshotInfo(name)
+	if err != nil {
+		return err
+	}
+
+	update(info)
+	if info.LabelsMAC, err = r.labelsMAC(*info); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot index: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(r.dir, "snapshots", name+refsExt), data); err != nil {
+		return fmt.Errorf("failed to write snapshot index: %w", err)
+	}
+	return nil
+}
+
+// LabelsAuthentic reports whether the labels in a snapshot index were set
+// with the repository key. Indexes edited without it, or written before
+// labels were authenticated, fail the check.
+func (r *Repository) LabelsAuthentic(info SnapshotInfo) bool {
+	data, err := snapshotLabels(info)
+	return err == nil && crypto.VerifyMAC(r.master, snapshotLabelsPurpose, data, info.LabelsMAC)
+}
+
+// labelsMAC returns the MAC of the labels in a snapshot index
+func (r *Repository) labelsMAC(info SnapshotInfo) ([]byte, error) {
+	data, err := snapshotLabels(info)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.MAC(r.master, snapshotLabelsPurpose, data)
+}
+
+// snapshotLabels returns the bytes a snapshot's labels MAC covers. The
+// name binds the labels to their snapshot, since every snapshot of the
+// repository shares the key.
+func snapshotLabels(info SnapshotInfo) ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Name   string   `json:"name"`
+		Tags   []string `json:"tags,omitempty"`
+		Note   string   `json:"note,omitempty"`
+		Pinned bool     `json:"pinned,omitempty"`
+	}{info.Name, info.Tags, info.Note, info.Pinned})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot labels: %w", err)
+	}
+	return data, nil
+}
+
+// DeleteSnapshot removes a snapshot and garbage-collects the chunks no
+// remaining snapshot references. It returns the number of chunks removed.
+func DeleteSnapshot(dir, name string) (int, error) {
+	if name == "" || filepath.Base(name) != name {
+		return 0, fmt.Errorf("invalid snapshot name: %q", name)
+	}
+
+	snapDir := filepath.Join(dir, "snapshots")
+	snapPath := filepath.Join(snapDir, name+SnapshotExt)
+	if _, err := os.Stat(snapPath); err != nil {
+		return 0, fmt.Errorf("snapshot not found: %s", name)
+	}
+
+	if err := os.Remove(snapPath); err != nil {
+		return 0, fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	if err := os.Remove(filepath.Join(snapDir, name+refsExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to delete snapshot index: %w", err)
+	}
+
+	return GC(dir)
+}
+
+// GC removes every chunk that no snapshot index references and returns the
+// number of chunks removed. Indexes without a manifest still protect their
+// chunks, since they may belong to a snapshot that is being written.
+func GC(dir string) (int, error) {
+	snapDir := filepath.Join(dir, "snapshots")
+	indexes, err := filepath.Glob(filepath.Join(snapDir, "*"+refsExt))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list snapshot indexes: %w", err)
+	}
+
+	live := make(map[string]bool)
+	for _, path := range indexes {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read snapshot index: %w", err)
+		}
+		var info SnapshotInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			// Never delete chunks based on an index that cannot be read
+			return 0, fmt.Errorf("failed to parse snapshot index %s: %w", filepath.Base(path), err)
+		}
+		for _, id := range info.Chunks {
+			live[id] = true
+		}
+	}
+
+	chunkDir := filepath.Join(dir, "chunks")
+	prefixes, err := os.ReadDir(chunkDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read chunk directory: %w", err)
+	}
+
+	removed := 0
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		prefixDir := filepath.Join(chunkDir, prefix.Name())
+		chunks, err := os.ReadDir(prefixDir)
+		if err != nil {
+			return removed, fmt.Errorf("failed to read chunk directory: %w", err)
+		}
+		for _, chunk := range chunks {
+			if live[chunk.Name()] || strings.HasPrefix(chunk.Name(), ".") {
+				continue
+			}
+			if err := os.Remove(filepath.Join(prefixDir, chunk.Name())); err != nil {
+				return removed, fmt.Errorf("failed to remove chunk: %w", err)
+			}
+			removed++
+		}
+	}
+
+	return removed, nil
+}
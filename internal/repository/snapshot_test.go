@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func saveTestSnapshot(t *testing.T, repo *Repository, name string, contents ...[]byte) string {
+	t.Helper()
+	snap := &Snapshot{Name: name, Created: time.Now()}
+	var added int64
+	for i, content := range contents {
+		ids, n, err := repo.WriteFile(bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		added += n
+		snap.Files = append(snap.Files, File{
+			Path:   filepath.Join("/home/user", string(rune('a'+i))),
+			Size:   int64(len(content)),
+			Mode:   0644,
+			Chunks: ids,
+		})
+	}
+	path, checksum, err := repo.SaveSnapshot(snap, added)
+	if err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	if checksum == "" {
+		t.Error("expected checksum")
+	}
+	return path
+}
+
+func countChunks(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	filepath.WalkDir(filepath.Join(dir, "chunks"), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func TestSnapshot_SaveLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := saveTestSnapshot(t, repo, "backup-1", []byte("one"), []byte("two"))
+	if !IsSnapshotPath(path) {
+		t.Errorf("expected snapshot path, got %s", path)
+	}
+	if DirFromSnapshot(path) != dir {
+		t.Errorf("DirFromSnapshot = %s, want %s", DirFromSnapshot(path), dir)
+	}
+
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte("/home/user")) {
+		t.Error("snapshot manifest is stored in plaintext")
+	}
+
+	snap, err := repo.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if len(snap.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(snap.Files))
+	}
+	got, _ := io.ReadAll(repo.OpenFile(snap.Files[1].Chunks))
+	if string(got) != "two" {
+		t.Errorf("file content = %q, want %q", got, "two")
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+
+	snapshots, err := ListSnapshots(dir)
+	if err != nil || len(snapshots) != 0 {
+		t.Fatalf("expected no snapshots for missing repository, got %v, %v", snapshots, err)
+	}
+
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestSnapshot(t, repo, "backup-1", []byte("one"))
+	saveTestSnapshot(t, repo, "backup-2", []byte("one"), []byte("two"))
+
+	snapshots, err = ListSnapshots(dir)
+	if err != nil {
+		t.Fatalf("ListSnapshots failed: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(snapshots))
+	}
+	if snapshots[1].Name != "backup-2" || snapshots[1].FileCount != 2 || snapshots[1].OriginalSize != 6 {
+		t.Errorf("unexpected snapshot info: %+v", snapshots[1])
+	}
+	if snapshots[1].Path == "" {
+		t.Error("expected snapshot path")
+	}
+}
+
+func TestDeleteSnapshot_CollectsUnreferencedChunks(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared := randomData(1, 1000)
+	only1 := randomData(2, 1000)
+	only2 := randomData(3, 1000)
+
+	saveTestSnapshot(t, repo, "backup-1", shared, only1)
+	path2 := saveTestSnapshot(t, repo, "backup-2", shared, only2)
+
+	if n := countChunks(t, dir); n != 3 {
+		t.Fatalf("expected 3 stored chunks, got %d", n)
+	}
+
+	removed, err := DeleteSnapshot(dir, "backup-1")
+	if err != nil {
+		t.Fatalf("DeleteSnapshot failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 chunk collected, got %d", removed)
+	}
+
+	snap, err := repo.LoadSnapshot(path2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range snap.Files {
+		if _, err := io.ReadAll(repo.OpenFile(f.Chunks)); err != nil {
+			t.Errorf("remaining snapshot lost data for %s: %v", f.Path, err)
+		}
+	}
+
+	if _, err := DeleteSnapshot(dir, "backup-2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(t, dir); n != 0 {
+		t.Errorf("expected all chunks collected, %d left", n)
+	}
+
+	if _, err := DeleteSnapshot(dir, "backup-2"); err == nil {
+		t.Error("expected error deleting a missing snapshot")
+	}
+	if _, err := DeleteSnapshot(dir, "../config"); err == nil {
+		t.Error("expected error for an invalid snapshot name")
+	}
+}
+
+func TestSnapshotLabels(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), DirName)
+	repo, err := Init(dir, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestSnapshot(t, repo, "backup-1", []byte("one"))
+	saveTestSnapshot(t, repo, "backup-2", []byte("two"))
+
+	info, err := repo.ReadSnapshotInfo("backup-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repo.LabelsAuthentic(*info) {
+		t.Error("labels of a new snapshot do not verify")
+	}
+
+	err = repo.UpdateSnapshotInfo("backup-1", func(info *SnapshotInfo) {
+		info.Tags, info.Pinned = []string{"keep"}, true
+	})
+	if err != nil {
+		t.Fatalf("UpdateSnapshotInfo failed: %v", err)
+	}
+	pinned, err := repo.ReadSnapshotInfo("backup-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pinned.Pinned || !repo.LabelsAuthentic(*pinned) {
+		t.Errorf("updated labels = %+v, want pinned and authentic", pinned)
+	}
+
+	// Labels edited without the key, or moved to another snapshot, fail
+	edited := *pinned
+	edited.Pinned = false
+	if repo.LabelsAuthentic(edited) {
+		t.Error("unpinned labels verify with the pinned MAC")
+	}
+	moved := *pinned
+	moved.Name = "backup-2"
+	if repo.LabelsAuthentic(moved) {
+		t.Error("labels verify for another snapshot")
+	}
+	other, err := Init(filepath.Join(t.TempDir(), DirName), "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.LabelsAuthentic(*pinned) {
+		t.Error("labels verify with another repository's key")
+	}
+
+	if _, err := repo.ReadSnapshotInfo("../config"); err == nil {
+		t.Error("expected an invalid name to be refused")
+	}
+}
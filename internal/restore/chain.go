@@ -0,0 +1,64 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// walkChain rebuilds the full tree of an incremental backup. The manifest
+// lists every entry of the tree and the backup in the chain that holds its
+// content, so each archive in the chain is read once and only the entries
+// it is responsible for are passed to fn. Deleted paths are not in the
+// manifest and are never restored.
+func (s *Session) walkChain(fn func(header *tar.Header, body io.Reader) error) error {
+	entries, err := s.Entries()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	wanted := make(map[string]map[string]bool)
+	var order []string
+	for _, e := range entries {
+		source := s.path
+		if e.Source != "" {
+			source = filepath.Join(dir, e.Source)
+		}
+		if wanted[source] == nil {
+			wanted[source] = make(map[string]bool)
+			order = append(order, source)
+		}
+		wanted[source][e.Path] = true
+	}
+
+	for _, source := range order {
+		if _, err := os.Stat(source); err != nil {
+			return fmt.Errorf("backup chain is broken: %s is missing", filepath.Base(source))
+		}
+	}
+
+	for _, source := range order {
+		stopped := false
+		err := s.walkArchive(source, func(header *tar.Header, body io.Reader) error {
+			if !wanted[source][header.Name] {
+				return nil
+			}
+			err := fn(header, body)
+			if errors.Is(err, errStopWalk) {
+				stopped = true
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
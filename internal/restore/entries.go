@@ -0,0 +1,119 @@
+package restore
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/capture"
+	"github.com/diogo/dotkeeper/internal/pathutil"
+)
+
+// ListEntries returns the entries of a backup's tree without reading any
+// file content. Archives and snapshots carry a manifest that is decrypted
+// on its own; archives made before manifests existed are scanned header by
+// header instead.
+func ListEntries(backupPath, password string) ([]backup.ManifestEntry, error) {
+	return newSession(backupPath, password).Entries()
+}
+
+// Captures returns the names of the command captures stored in the backup
+func (s *Session) Captures() ([]string, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if name, ok := capture.Name(e.Path); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// ReadEntry reads a single entry of a backup, matched by full path or base
+// name. Snapshot entries are read straight from their chunks; archive
+// entries are read from the archive in the chain that holds them, stopping
+// as soon as the entry has been read.
+func ReadEntry(backupPath, password, path string) (FileEntry, error) {
+	return newSession(backupPath, password).ReadEntry(path)
+}
+
+// ReadEntry reads a single entry of the backup, matched by full path or
+// base name
+func (s *Session) ReadEntry(path string) (FileEntry, error) {
+	var entry FileEntry
+	found := false
+	err := s.findEntry(path, func(header *tar.Header, body io.Reader) error {
+		var err error
+		entry, err = readEntry(header, body)
+		found = err == nil
+		return err
+	})
+	if err != nil {
+		return FileEntry{}, err
+	}
+	if !found {
+		return FileEntry{}, fmt.Errorf("file %s not found in backup", path)
+	}
+	return entry, nil
+}
+
+// findEntry calls fn for the entry matching path, without reading the rest
+// of the backup where the format allows it. fn is not called when the
+// entry does not exist.
+func (s *Session) findEntry(path string, fn func(header *tar.Header, body io.Reader) error) error {
+	entries, err := s.Entries()
+	if err != nil {
+		return err
+	}
+	e, ok := lookupEntry(entries, path)
+	if !ok {
+		return nil
+	}
+
+	if s.snap != nil {
+		for _, f := range s.snap.Files {
+			if f.Path == e.Path {
+				header, body := snapshotHeader(s.repo, f)
+				return fn(header, body)
+			}
+		}
+		return nil
+	}
+
+	// The index names the archive in the chain holding the entry
+	target := s.path
+	if e.Source != "" {
+		target = filepath.Join(filepath.Dir(s.path), e.Source)
+	}
+	return s.walkArchive(target, func(header *tar.Header, body io.Reader) error {
+		if header.Name != e.Path {
+			return nil
+		}
+		if err := fn(header, body); err != nil {
+			return err
+		}
+		return errStopWalk
+	})
+}
+
+// lookupEntry finds a file or symlink entry by stored name or by the
+// absolute path it restores to, falling back to the base name
+func lookupEntry(entries []backup.ManifestEntry, path string) (backup.ManifestEntry, bool) {
+	logical := pathutil.ToLogical(path)
+	for _, e := range entries {
+		if !e.Dir && (e.Path == path || e.Path == logical) {
+			return e, true
+		}
+	}
+	for _, e := range entries {
+		if !e.Dir && filepath.Base(e.Path) == filepath.Base(path) {
+			return e, true
+		}
+	}
+	return backup.ManifestEntry{}, false
+}
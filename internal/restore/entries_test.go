@@ -0,0 +1,187 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestListEntries(t *testing.T) {
+	t.Run("archive reads only the manifest", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+			"one.txt": "first",
+			"two.txt": "second file",
+		})
+
+		// The archive body is never read: damaged, it no longer decrypts.
+		// It starts behind the labels, which end the plaintext parts.
+		data, err := os.ReadFile(backupPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels := bytes.Index(data, []byte(`{"mac":"`))
+		if labels < 0 {
+			t.Fatal("labels not found")
+		}
+		payload := labels + bytes.IndexByte(data[labels:], '}') + 1
+		data[payload+30] ^= 0xff
+		if err := os.WriteFile(backupPath, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := ListEntries(backupPath, password)
+		if err != nil {
+			t.Fatalf("ListEntries failed: %v", err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 entries, got %d", len(entries))
+		}
+		for _, e := range entries {
+			if e.SHA256 == "" || e.ModTime == 0 || e.Mode == 0 {
+				t.Errorf("entry %s is missing metadata: %+v", e.Path, e)
+			}
+			if filepath.Base(e.Path) == "two.txt" && e.Size != int64(len("second file")) {
+				t.Errorf("two.txt size = %d", e.Size)
+			}
+		}
+
+		if _, err := ListEntries(backupPath, "wrong"); err == nil {
+			t.Error("expected wrong password to fail")
+		}
+	})
+
+	t.Run("legacy archive without manifest", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		backupPath := writeLegacyBackup(t, tmpDir, "pw", map[string]string{
+			"/home/user/.bashrc": "export A=1",
+		})
+
+		entries, err := ListEntries(backupPath, "pw")
+		if err != nil {
+			t.Fatalf("ListEntries failed: %v", err)
+		}
+		if len(entries) != 1 || entries[0].Path != "/home/user/.bashrc" || entries[0].Size != 10 {
+			t.Errorf("unexpected entries: %+v", entries)
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		src := filepath.Join(tmpDir, "src")
+		if err := os.MkdirAll(src, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("snapshot"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("a.txt", filepath.Join(src, "link")); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := &config.Config{
+			BackupDir:  filepath.Join(tmpDir, "backups"),
+			Folders:    []string{src},
+			Repository: true,
+		}
+		result, err := backup.Backup(context.Background(), cfg, "pw")
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+
+		entries, err := ListEntries(result.BackupPath, "pw")
+		if err != nil {
+			t.Fatalf("ListEntries failed: %v", err)
+		}
+		// The folder itself comes first
+		if len(entries) != 3 {
+			t.Fatalf("expected 3 entries, got %d", len(entries))
+		}
+		if !entries[0].Dir || entries[0].Mode != 0755 {
+			t.Errorf("unexpected directory entry: %+v", entries[0])
+		}
+		for _, e := range entries {
+			switch filepath.Base(e.Path) {
+			case "a.txt":
+				if e.SHA256 == "" || e.Size != 8 {
+					t.Errorf("unexpected file entry: %+v", e)
+				}
+			case "link":
+				if e.LinkTarget != "a.txt" {
+					t.Errorf("link target = %q", e.LinkTarget)
+				}
+			}
+		}
+	})
+}
+
+func TestReadEntry(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Folders: []string{src}}
+	opts := backup.BackupOptions{Incremental: true}
+
+	write("kept.txt", "unchanged content")
+	write("edited.txt", "v1")
+	first, err := backup.BackupWithOptions(context.Background(), cfg, "pw", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	write("edited.txt", "version two")
+	last, err := backup.BackupWithOptions(context.Background(), cfg, "pw", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Incremental {
+		t.Fatal("expected an incremental backup")
+	}
+
+	entry, err := ReadEntry(last.BackupPath, "pw", filepath.Join(src, "edited.txt"))
+	if err != nil {
+		t.Fatalf("ReadEntry failed: %v", err)
+	}
+	if string(entry.Content) != "version two" {
+		t.Errorf("edited.txt = %q", entry.Content)
+	}
+
+	// Unchanged entries come from the parent archive, matched by base name
+	entry, err = ReadEntry(last.BackupPath, "pw", "kept.txt")
+	if err != nil {
+		t.Fatalf("ReadEntry failed: %v", err)
+	}
+	if string(entry.Content) != "unchanged content" {
+		t.Errorf("kept.txt = %q", entry.Content)
+	}
+
+	// Reading an entry held by the incremental itself does not need the parent
+	if err := os.Rename(first.BackupPath, first.BackupPath+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEntry(last.BackupPath, "pw", "edited.txt"); err != nil {
+		t.Errorf("ReadEntry without parent failed: %v", err)
+	}
+
+	_, err = ReadEntry(last.BackupPath, "pw", "missing.txt")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
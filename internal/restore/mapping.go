@@ -0,0 +1,91 @@
+package restore
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/diogo/dotkeeper/internal/pathutil"
+)
+
+// Mapping redirects entries under one path prefix to another at restore
+// time. From may be a logical root such as $HOME or an absolute path, and
+// is matched against both the stored name and the path it resolves to.
+type Mapping struct {
+	From string
+	To   string
+}
+
+// ParseMapping parses a FROM=TO mapping. A leading ~ in TO is expanded.
+func ParseMapping(s string) (Mapping, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" || to == "" {
+		return Mapping{}, fmt.Errorf("invalid mapping %q (use FROM=TO)", s)
+	}
+	return Mapping{
+		From: cleanPrefix(from),
+		To:   filepath.Clean(pathutil.ExpandHome(to)),
+	}, nil
+}
+
+// String formats the mapping the way ParseMapping reads it
+func (m Mapping) String() string {
+	return m.From + "=" + m.To
+}
+
+// apply rewrites path if it lies under From
+func (m Mapping) apply(path string) (string, bool) {
+	rest, ok := pathutil.CutPathPrefix(path, m.From)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(m.To, rest), true
+}
+
+// cleanPrefix cleans a mapping prefix, keeping logical roots intact
+func cleanPrefix(prefix string) string {
+	if strings.HasPrefix(prefix, "$") {
+		return strings.TrimSuffix(prefix, "/")
+	}
+	return filepath.Clean(prefix)
+}
+
+// targetRoot returns the directory that takes the place of the root an
+// entry was backed up under: the target of the mapping that moves it, or
+// the current user's directory for its logical root. Entries restored at
+// their recorded absolute path have none and return "".
+func targetRoot(name string, mappings []Mapping) string {
+	resolved := pathutil.FromLogical(name)
+	for _, path := range []string{name, resolved} {
+		for _, m := range mappings {
+			if _, ok := m.apply(path); ok {
+				return m.To
+			}
+		}
+	}
+	for _, root := range []string{pathutil.ConfigRoot, pathutil.HomeRoot} {
+		if _, ok := pathutil.CutPathPrefix(name, root); ok {
+			return pathutil.FromLogical(root)
+		}
+	}
+	return ""
+}
+
+// resolvePath returns where an entry stored under name is restored: the
+// first matching mapping applied to the stored name or, failing that, to
+// the path the name resolves to under the current user's roots
+func resolvePath(name string, mappings []Mapping) string {
+	for _, m := range mappings {
+		if mapped, ok := m.apply(name); ok {
+			return pathutil.FromLogical(mapped)
+		}
+	}
+
+	resolved := pathutil.FromLogical(name)
+	for _, m := range mappings {
+		if mapped, ok := m.apply(resolved); ok {
+			return mapped
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,149 @@
+package restore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Mapping
+		wantErr bool
+	}{
+		{"/home/alice=/home/bob", Mapping{From: "/home/alice", To: "/home/bob"}, false},
+		{"$HOME/=/mnt/home/", Mapping{From: "$HOME", To: "/mnt/home"}, false},
+		{"/etc=/tmp/etc=x", Mapping{From: "/etc", To: "/tmp/etc=x"}, false},
+		{"/etc", Mapping{}, true},
+		{"=/tmp", Mapping{}, true},
+		{"/etc=", Mapping{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMapping(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMapping(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMapping(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	tests := []struct {
+		name     string
+		entry    string
+		mappings []string
+		want     string
+	}{
+		{"home root", "$HOME/.zshrc", nil, filepath.Join(home, ".zshrc")},
+		{"config root", "$XDG_CONFIG_HOME/git/config", nil, filepath.Join(home, ".config", "git", "config")},
+		{"absolute", "/etc/hosts", nil, "/etc/hosts"},
+		{"logical mapping", "$HOME/.zshrc", []string{"$HOME=/mnt/bob"}, "/mnt/bob/.zshrc"},
+		{"mapping of resolved path", "$HOME/.zshrc", []string{home + "=/mnt/bob"}, "/mnt/bob/.zshrc"},
+		{"legacy absolute entry", "/home/alice/.zshrc", []string{"/home/alice=" + home}, filepath.Join(home, ".zshrc")},
+		{"no partial element match", "/home/alicea/.zshrc", []string{"/home/alice=/x"}, "/home/alicea/.zshrc"},
+		{"first mapping wins", "/etc/hosts", []string{"/etc=/a", "/etc/hosts=/b"}, "/a/hosts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mappings []Mapping
+			for _, s := range tt.mappings {
+				m, err := ParseMapping(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mappings = append(mappings, m)
+			}
+			if got := resolvePath(tt.entry, mappings); got != tt.want {
+				t.Errorf("resolvePath(%q) = %q, want %q", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetRoot(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	tests := []struct {
+		entry    string
+		mappings []Mapping
+		want     string
+	}{
+		{"$HOME/.zshrc", nil, home},
+		{"$XDG_CONFIG_HOME/git/config", nil, filepath.Join(home, ".config")},
+		{"/etc/hosts", nil, ""},
+		{"$HOME/.zshrc", []Mapping{{From: "$HOME", To: "/mnt/bob"}}, "/mnt/bob"},
+		{"$HOME/.zshrc", []Mapping{{From: home, To: "/mnt/bob"}}, "/mnt/bob"},
+		{"/etc/hosts", []Mapping{{From: "/etc", To: "/tmp/etc"}}, "/tmp/etc"},
+	}
+	for _, tt := range tests {
+		if got := targetRoot(tt.entry, tt.mappings); got != tt.want {
+			t.Errorf("targetRoot(%q, %v) = %q, want %q", tt.entry, tt.mappings, got, tt.want)
+		}
+	}
+}
+
+func TestRestore_OtherUser(t *testing.T) {
+	tmpDir := t.TempDir()
+	alice := filepath.Join(tmpDir, "alice")
+	bob := filepath.Join(tmpDir, "bob")
+	for _, dir := range []string{alice, bob} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	// alice backs up her dotfiles
+	t.Setenv("HOME", alice)
+	if err := os.WriteFile(filepath.Join(alice, ".zshrc"), []byte("alice zsh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		BackupDir: filepath.Join(tmpDir, "backups"),
+		Files:     []string{"~/.zshrc"},
+	}
+	result, err := backup.Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	entries, err := ListEntries(result.BackupPath, "pw")
+	if err != nil || len(entries) != 1 || entries[0].Path != "$HOME/.zshrc" {
+		t.Fatalf("entries = %+v, %v", entries, err)
+	}
+
+	// bob restores it into his own home
+	t.Setenv("HOME", bob)
+	if _, err := Restore(context.Background(), result.BackupPath, "pw", RestoreOptions{Force: true}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(bob, ".zshrc")); err != nil || string(got) != "alice zsh" {
+		t.Errorf("bob's .zshrc = %q, %v", got, err)
+	}
+
+	// or anywhere else with an explicit mapping
+	elsewhere := filepath.Join(tmpDir, "elsewhere")
+	opts := RestoreOptions{Force: true, Mappings: []Mapping{{From: "$HOME", To: elsewhere}}}
+	if _, err := Restore(context.Background(), result.BackupPath, "pw", opts); err != nil {
+		t.Fatalf("Restore with mapping failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, ".zshrc")); err != nil {
+		t.Errorf("mapped restore missing: %v", err)
+	}
+}
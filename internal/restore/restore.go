@@ -3,54 +3,230 @@ package restore
 import (
 	"archive/tar"
 	"bytes"
-	"compress/gzip"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/capture"
+	"github.com/diogo/dotkeeper/internal/compression"
 	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/fsmeta"
+	"github.com/diogo/dotkeeper/internal/hooks"
+	"github.com/diogo/dotkeeper/internal/pathutil"
+	"github.com/diogo/dotkeeper/internal/progress"
+	"github.com/diogo/dotkeeper/internal/repository"
 )
 
-// Restore restores files from an encrypted backup archive
-func Restore(backupPath, password string, opts RestoreOptions) (*RestoreResult, error) {
+// maxDiffSize is the largest entry that is buffered to produce a diff
+const maxDiffSize = 1 << 20
+
+// errStopWalk stops a walk early without reporting an error
+var errStopWalk = errors.New("stop walk")
+
+// Restore restores files from an encrypted backup archive.
+// Entries are streamed from the archive to disk one at a time, so memory use
+// does not depend on the size of the backup.
+func Restore(ctx context.Context, backupPath, password string, opts RestoreOptions) (*RestoreResult, error) {
+	return newSession(backupPath, password).Restore(ctx, opts)
+}
+
+// Restore restores files from the backup, streaming entries to disk one at
+// a time. Unless it is a dry run, the pre_restore hooks run first and abort
+// the restore if one fails; the per-path and post_restore hooks run after.
+//
+// Cancelling ctx stops the restore between files or in the middle of one.
+// The file being written is left as it was; files already restored stay
+// in place and the post_restore hooks do not run.
+func (s *Session) Restore(ctx context.Context, opts RestoreOptions) (*RestoreResult, error) {
 	result := &RestoreResult{
 		RestoredFiles: []string{},
 		SkippedFiles:  []string{},
 		BackupFiles:   []string{},
 		DiffResults:   make(map[string]string),
+		Captures:      make(map[string]string),
+	}
+
+	runHooks := !opts.DryRun
+	env := map[string]string{
+		"DOTKEEPER_OPERATION":   "restore",
+		"DOTKEEPER_BACKUP_PATH": s.path,
+	}
+	for k, v := range opts.HookEnv {
+		env[k] = v
+	}
+	if runHooks {
+		pre, err := hooks.Run(hooks.PreRestore, opts.Hooks.PreRestore, env)
+		result.Hooks = pre
+		if err != nil {
+			return nil, fmt.Errorf("restore aborted: %w", err)
+		}
+	}
+
+	selected := selectionSet(opts.SelectedFiles)
+
+	t := progress.NewTracker("restore", opts.Progress)
+	if opts.Progress != nil {
+		files, bytes := s.totals(selected)
+		t.Phase(progress.Restoring, files, bytes)
 	}
 
-	// Read and decrypt the backup
-	entries, err := decryptAndExtract(backupPath, password)
+	// Directories are created before anything is written into them, but
+	// only get their final mode and metadata once everything in them has
+	// been. The walk of an incremental chain may reach a directory after
+	// its files, so they are taken from the index.
+	dirs := make(map[string]*pendingDir)
+	var dirOrder []*pendingDir
+	var entries []backup.ManifestEntry
+	if !opts.DryRun {
+		var err error
+		if entries, err = s.Entries(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.TargetDir == "" {
+		for _, e := range entries {
+			if !e.Dir || !dirSelected(e.Path, selected) {
+				continue
+			}
+			dir, err := restoreDir(e, opts, t)
+			if err != nil {
+				return nil, err
+			}
+			if dir != nil {
+				dirs[e.Path] = dir
+				dirOrder = append(dirOrder, dir)
+			}
+		}
+	}
+
+	// The logs of a restored SQLite database are only kept in place when
+	// they are restored with it
+	inBackup := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		inBackup[e.Path] = true
+	}
+	restoring := func(name string) bool {
+		return inBackup[name] && (len(selected) == 0 || isSelected(name, selected))
+	}
+
+	err := s.walk(func(header *tar.Header, body io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeDir {
+			if dir, ok := dirs[header.Name]; ok {
+				dir.header = header
+			}
+			return nil
+		}
+
+		// Captures are not files to put back in place; they are only
+		// extracted when selected by name
+		if _, ok := capture.Name(header.Name); ok && !selected[header.Name] {
+			return nil
+		}
+		result.TotalFiles++
+
+		if len(selected) > 0 && !isSelected(header.Name, selected) {
+			return nil
+		}
+
+		t.Start(header.Name, header.Size)
+		if err := restoreEntry(header, t.Reader(ctx, body), opts, restoring, result, t); err != nil {
+			return err
+		}
+		t.Done()
+		return nil
+	})
+	for i := len(dirOrder) - 1; i >= 0; i-- {
+		dirOrder[i].finish(result, t)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt and extract backup: %w", err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("restore cancelled: %w", ctxErr)
+		}
+		return nil, err
 	}
 
-	result.TotalFiles = len(entries)
+	if runHooks {
+		// Files are already in place, so failing hooks are only recorded
+		paths, _ := opts.Hooks.RunPathHooks(result.RestoredFiles, env)
+		result.Hooks = append(result.Hooks, paths...)
 
-	// Filter files if SelectedFiles is specified
-	if len(opts.SelectedFiles) > 0 {
-		entries = filterEntries(entries, opts.SelectedFiles)
+		post, _ := hooks.RunRestored(hooks.PostRestore, opts.Hooks.PostRestore, result.RestoredFiles, env)
+		result.Hooks = append(result.Hooks, post...)
 	}
 
-	// Process each file
-	for _, entry := range entries {
-		targetPath := entry.Path
-		if opts.TargetDir != "" {
-			targetPath = filepath.Join(opts.TargetDir, filepath.Base(entry.Path))
+	t.Finish()
+	return result, nil
+}
+
+// totals returns the number and combined size of the entries a restore of
+// selected writes. They are taken from the index, so they are zero when it
+// cannot be read.
+func (s *Session) totals(selected map[string]bool) (int, int64) {
+	entries, err := s.Entries()
+	if err != nil {
+		return 0, 0
+	}
+	var files int
+	var bytes int64
+	for _, e := range entries {
+		if e.Dir {
+			continue
+		}
+		if _, ok := capture.Name(e.Path); ok && !selected[e.Path] {
+			continue
+		}
+		if len(selected) > 0 && !isSelected(e.Path, selected) {
+			continue
+		}
+		files++
+		bytes += e.Size
+	}
+	return files, bytes
+}
+
+// restoreEntry restores a single archive entry, streaming its content from
+// body, and notes what happened to it on t. restoring reports which other
+// entries this restore writes.
+func restoreEntry(header *tar.Header, body io.Reader, opts RestoreOptions, restoring func(name string) bool, result *RestoreResult, t *progress.Tracker) error {
+	captureName, isCapture := capture.Name(header.Name)
+	targetPath := resolvePath(header.Name, opts.Mappings)
+	root := targetRoot(header.Name, opts.Mappings)
+	switch {
+	case isCapture:
+		targetPath = filepath.Join(opts.captureDir(), captureName)
+		root = opts.captureDir()
+	case opts.TargetDir != "":
+		targetPath = filepath.Join(opts.TargetDir, filepath.Base(header.Name))
+		root = opts.TargetDir
+	}
+	isSymlink := header.Typeflag == tar.TypeSymlink
+
+	// Generate diff if requested
+	if opts.ShowDiff && !isSymlink {
+		content, err := readForDiff(header, body)
+		if err != nil {
+			return err
 		}
+		if content == nil {
+			t.Note(targetPath, "diff-skipped")
+		} else {
+			// The content has been consumed, keep restoring from the buffer
+			body = bytes.NewReader(content)
 
-		// Generate diff if requested
-		if opts.ShowDiff {
-			diffResult, err := GenerateDiff(entry.Content, targetPath)
+			diffResult, err := GenerateDiff(content, targetPath)
 			if err != nil {
 				// Log but continue
-				if opts.ProgressCallback != nil {
-					opts.ProgressCallback(targetPath, "diff-error")
-				}
+				t.Note(targetPath, "diff-error")
 			} else if diffResult.HasDifference {
 				result.DiffResults[targetPath] = diffResult.Diff
 				if opts.DiffWriter != nil {
@@ -58,152 +234,379 @@ func Restore(backupPath, password string, opts RestoreOptions) (*RestoreResult,
 				}
 			}
 		}
+	}
 
-		// In dry run mode, just report what would happen
-		if opts.DryRun {
-			if HasConflict(targetPath) {
-				result.FilesConflict++
-				if opts.ProgressCallback != nil {
-					opts.ProgressCallback(targetPath, "would-backup")
-				}
-			}
-			result.SkippedFiles = append(result.SkippedFiles, targetPath)
-			result.FilesSkipped++
-			continue
+	// In dry run mode, just report what would happen
+	if opts.DryRun {
+		if HasConflict(targetPath) {
+			result.FilesConflict++
+			t.Note(targetPath, "would-backup")
 		}
+		result.SkippedFiles = append(result.SkippedFiles, targetPath)
+		result.FilesSkipped++
+		return nil
+	}
 
-		// Handle conflict
-		action := ResolveConflict(targetPath, opts)
-		var backupCreated string
+	// Handle conflict
+	action := ResolveConflict(targetPath, opts)
 
-		switch action {
-		case ActionSkip:
-			result.SkippedFiles = append(result.SkippedFiles, targetPath)
-			result.FilesSkipped++
-			if opts.ProgressCallback != nil {
-				opts.ProgressCallback(targetPath, "skipped")
-			}
-			continue
+	switch action {
+	case ActionSkip:
+		result.SkippedFiles = append(result.SkippedFiles, targetPath)
+		result.FilesSkipped++
+		t.Note(targetPath, "skipped")
+		return nil
 
-		case ActionBackup:
-			backupCreated, err = BackupExisting(targetPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to backup %s: %w", targetPath, err)
-			}
-			if backupCreated != "" {
-				result.BackupFiles = append(result.BackupFiles, backupCreated)
-				result.FilesConflict++
-				if opts.ProgressCallback != nil {
-					opts.ProgressCallback(targetPath, "backed-up")
-				}
-			}
+	case ActionBackup:
+		backupCreated, err := BackupExisting(targetPath)
+		if err != nil {
+			return fmt.Errorf("failed to backup %s: %w", targetPath, err)
+		}
+		if backupCreated != "" {
+			result.BackupFiles = append(result.BackupFiles, backupCreated)
+			result.FilesConflict++
+			t.Note(targetPath, "backed-up")
+		}
 
-		case ActionOverwrite:
-			// No backup needed
+	case ActionOverwrite:
+		// No backup needed
+	}
+
+	if isSymlink {
+		if err := restoreSymlink(targetPath, header.Linkname); err != nil {
+			return fmt.Errorf("failed to restore symlink %s: %w", targetPath, err)
+		}
+	} else if err := restoreFileAtomicFrom(targetPath, body, header.Mode); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", targetPath, err)
+	} else if !isCapture {
+		if err := clearSQLiteLogs(targetPath, header.Name, restoring, opts, result, t); err != nil {
+			return err
 		}
+	}
 
-		if entry.LinkTarget != "" {
-			if err := restoreSymlink(targetPath, entry.LinkTarget); err != nil {
-				return nil, fmt.Errorf("failed to restore symlink %s: %w", targetPath, err)
-			}
-		} else if err := restoreFileAtomic(targetPath, entry.Content, entry.Mode); err != nil {
-			return nil, fmt.Errorf("failed to restore %s: %w", targetPath, err)
+	// Ownership, xattrs and mtime are best-effort; report what was missed
+	if errs := restoredMetadata(header, root).Apply(targetPath, header.ModTime); len(errs) > 0 {
+		for _, err := range errs {
+			result.MetadataErrors = append(result.MetadataErrors, fmt.Sprintf("%s: %v", targetPath, err))
 		}
+		t.Note(targetPath, "metadata-partial")
+	}
 
-		result.RestoredFiles = append(result.RestoredFiles, targetPath)
-		result.FilesRestored++
-		if opts.ProgressCallback != nil {
-			opts.ProgressCallback(targetPath, "restored")
+	result.RestoredFiles = append(result.RestoredFiles, targetPath)
+	result.FilesRestored++
+	if isCapture {
+		result.Captures[captureName] = targetPath
+	}
+	t.Note(targetPath, "restored")
+	return nil
+}
+
+// pendingDir is a restored directory waiting for its final mode, metadata
+// and mtime
+type pendingDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+	// header carries ownership and extended attributes once the walk
+	// has reached the directory's entry
+	header *tar.Header
+	// root is the directory that took the place of the entry's root
+	root string
+}
+
+// restoredMetadata returns the metadata to apply to an entry restored
+// under root, the directory that took the place of the root it was backed
+// up under. Such entries take the owner of root rather than the recorded
+// one: a backup of one user's home restored into another's belongs to the
+// other user, and an unprivileged restore does not fail to chown every
+// file. Entries restored at their recorded path keep the recorded owner.
+func restoredMetadata(header *tar.Header, root string) fsmeta.Metadata {
+	m := fsmeta.FromHeader(header)
+	if root == "" {
+		return m
+	}
+	return m.WithOwnerOf(root)
+}
+
+// restoreDir creates the directory of a directory entry. A new directory
+// is only accessible to its owner until finish applies the stored mode. An
+// existing directory keeps its permissions where they are stricter than
+// the stored ones, so a restore never loosens them. Anything else in the
+// way, including a symlink to a directory, is left alone and nil is
+// returned.
+func restoreDir(e backup.ManifestEntry, opts RestoreOptions, t *progress.Tracker) (*pendingDir, error) {
+	path := resolvePath(e.Path, opts.Mappings)
+	mode := os.FileMode(e.Mode).Perm()
+
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.IsDir():
+		mode &= info.Mode().Perm()
+	case err == nil:
+		t.Note(path, "skipped")
+		return nil, nil
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to restore directory %s: %w", path, err)
+		}
+		if err := os.Mkdir(path, 0700); err != nil {
+			return nil, fmt.Errorf("failed to restore directory %s: %w", path, err)
 		}
+	default:
+		return nil, fmt.Errorf("failed to restore directory %s: %w", path, err)
 	}
+	return &pendingDir{path: path, mode: mode, modTime: time.Unix(e.ModTime, 0), root: targetRoot(e.Path, opts.Mappings)}, nil
+}
 
-	return result, nil
+// finish applies the directory's mode, ownership, extended attributes and
+// mtime. Like for files, failures are reported rather than returned.
+func (d *pendingDir) finish(result *RestoreResult, t *progress.Tracker) {
+	var errs []error
+	if d.header != nil {
+		errs = restoredMetadata(d.header, d.root).Apply(d.path, time.Time{})
+	}
+	if err := os.Chmod(d.path, d.mode); err != nil {
+		errs = append(errs, fmt.Errorf("mode: %w", err))
+	}
+	// Last, so nothing above bumps the time again
+	if err := os.Chtimes(d.path, d.modTime, d.modTime); err != nil {
+		errs = append(errs, fmt.Errorf("mtime: %w", err))
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			result.MetadataErrors = append(result.MetadataErrors, fmt.Sprintf("%s: %v", d.path, err))
+		}
+		t.Note(d.path, "metadata-partial")
+	}
 }
 
-// decryptAndExtract decrypts the backup and extracts all files
-func decryptAndExtract(backupPath, password string) ([]FileEntry, error) {
-	// Read encrypted data
-	encryptedData, err := os.ReadFile(backupPath)
+// dirSelected reports whether a directory entry is restored with the
+// selection: when nothing is selected, or when the directory or anything
+// in it is
+func dirSelected(name string, selected map[string]bool) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for path := range selected {
+		if _, inside := pathutil.CutPathPrefix(path, name); inside {
+			return true
+		}
+	}
+	return false
+}
+
+// readForDiff buffers an entry for diffing. It returns nil content when the
+// entry is too large to diff.
+func readForDiff(header *tar.Header, body io.Reader) ([]byte, error) {
+	if header.Size > maxDiffSize {
+		return nil, nil
+	}
+	content, err := io.ReadAll(body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read backup file: %w", err)
+		return nil, fmt.Errorf("failed to read file %s: %w", header.Name, err)
+	}
+	return content, nil
+}
+
+// openArchive opens a backup and returns a reader over the decrypted,
+// still compressed tar stream and the compression recorded in the
+// metadata. Single-file and streaming backups are decrypted chunk by
+// chunk; backups in the original single-shot format are decrypted in
+// memory.
+func (s *Session) openArchive(backupPath string) (io.Reader, io.Closer, string, error) {
+	if backup.IsContainer(backupPath) {
+		return s.openContainer(backupPath)
 	}
 
-	// Read metadata to get salt
-	metadataPath := backupPath + ".meta.json"
-	metadataBytes, err := os.ReadFile(metadataPath)
+	metadata, err := backup.ReadMetadata(backupPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read metadata file: %w", err)
+		return nil, nil, "", err
+	}
+	key, err := s.backupKey(metadata)
+	if err != nil {
+		return nil, nil, "", err
 	}
 
-	var metadata crypto.EncryptionMetadata
-	if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
-		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	f, err := os.Open(backupPath)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	var version [1]byte
+	if _, err := io.ReadFull(f, version[:]); err != nil {
+		f.Close()
+		return nil, nil, "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, "", fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	if int(version[0]) == crypto.StreamVersion {
+		r, err := crypto.NewDecryptReader(f, key)
+		if err != nil {
+			f.Close()
+			return nil, nil, "", fmt.Errorf("decryption failed (wrong password?): %w", err)
+		}
+		return r, f, metadata.Compression, nil
 	}
 
-	// Derive key and decrypt
-	key := crypto.DeriveKey(password, metadata.Salt)
+	// Legacy single-shot format
+	encryptedData, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to read backup file: %w", err)
+	}
 	decrypted, err := crypto.Decrypt(encryptedData, key)
 	if err != nil {
-		return nil, fmt.Errorf("decryption failed (wrong password?): %w", err)
+		return nil, nil, "", fmt.Errorf("decryption failed (wrong password?): %w", err)
 	}
+	return bytes.NewReader(decrypted), io.NopCloser(nil), metadata.Compression, nil
+}
 
-	// Extract tar.gz archive
-	return extractTarGz(decrypted)
+// openContainer opens the payload of a single-file backup once its
+// metadata block authenticates
+func (s *Session) openContainer(backupPath string) (io.Reader, io.Closer, string, error) {
+	c, err := backup.OpenContainer(backupPath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	key, err := s.containerKey(c)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	metadata, _, err := c.Decrypt(key)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	r, closer, err := c.OpenPayload(key)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return r, closer, metadata.Compression, nil
 }
 
-// extractTarGz extracts files from a tar.gz archive in memory
-func extractTarGz(data []byte) ([]FileEntry, error) {
-	gzr, err := gzip.NewReader(bytes.NewReader(data))
+// walk decrypts the backup and calls fn for every file, symlink and
+// directory entry of the tree it represents, each directory ahead of its
+// entries: the entries of an archive, the files of a
+// repository snapshot, or the tree rebuilt from an incremental chain. body
+// streams the entry content and is only valid during the call. fn may
+// return errStopWalk to end the walk early.
+func (s *Session) walk(fn func(header *tar.Header, body io.Reader) error) error {
+	if repository.IsSnapshotPath(s.path) {
+		return s.walkSnapshot(fn)
+	}
+	if metadata, err := backup.ReadMetadata(s.path); err == nil && metadata.Incremental {
+		return s.walkChain(fn)
+	}
+	return s.walkArchive(s.path, fn)
+}
+
+// walkArchive calls fn for every entry physically stored in a backup archive
+func (s *Session) walkArchive(backupPath string, fn func(header *tar.Header, body io.Reader) error) error {
+	archive, closer, algorithm, err := s.openArchive(backupPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		return fmt.Errorf("failed to decrypt and extract backup: %w", err)
 	}
-	defer gzr.Close()
+	defer closer.Close()
 
-	tr := tar.NewReader(gzr)
-	var entries []FileEntry
+	if err := walkTar(archive, algorithm, fn); err != nil {
+		if errors.Is(err, errStopWalk) {
+			return nil
+		}
+		if strings.Contains(err.Error(), "decryption failed") {
+			return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// walkTar reads a compressed tar stream entry by entry
+func walkTar(r io.Reader, algorithm string, fn func(header *tar.Header, body io.Reader) error) error {
+	cr, err := compression.NewReader(r, algorithm)
+	if err != nil {
+		return fmt.Errorf("failed to create decompressor: %w", err)
+	}
+	defer cr.Close()
 
+	tr := tar.NewReader(cr)
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("tar read error: %w", err)
+			return fmt.Errorf("tar read error: %w", err)
 		}
 
+		// Directory names are compared with manifest paths, which carry
+		// no trailing slash
 		if header.Typeflag == tar.TypeDir {
-			continue
+			header.Name = strings.TrimSuffix(header.Name, "/")
 		}
 
-		if header.Typeflag == tar.TypeSymlink {
-			entries = append(entries, FileEntry{
-				Path:       header.Name,
-				Mode:       header.Mode,
-				ModTime:    header.ModTime.Unix(),
-				LinkTarget: header.Linkname,
-			})
-			continue
+		if err := fn(header, tr); err != nil {
+			return err
 		}
+	}
+
+	// Drain the stream so that truncation and tampering after the last
+	// entry are still detected by the authenticated decryption
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return fmt.Errorf("tar read error: %w", err)
+	}
 
-		content, err := io.ReadAll(tr)
+	return nil
+}
+
+// decryptAndExtract decrypts the backup and extracts all files into memory
+func decryptAndExtract(backupPath, password string) ([]FileEntry, error) {
+	var entries []FileEntry
+	err := newSession(backupPath, password).walk(func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag == tar.TypeDir {
+			return nil
+		}
+		entry, err := readEntry(header, body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file %s: %w", header.Name, err)
+			return err
 		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
 
-		entries = append(entries, FileEntry{
-			Path:    header.Name,
-			Content: content,
-			Mode:    header.Mode,
-			ModTime: header.ModTime.Unix(),
-		})
+// readEntry converts a tar entry into a FileEntry, reading its content
+func readEntry(header *tar.Header, body io.Reader) (FileEntry, error) {
+	if header.Typeflag == tar.TypeSymlink {
+		return FileEntry{
+			Path:       header.Name,
+			Mode:       header.Mode,
+			ModTime:    header.ModTime.Unix(),
+			LinkTarget: header.Linkname,
+		}, nil
 	}
 
-	return entries, nil
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return FileEntry{}, fmt.Errorf("failed to read file %s: %w", header.Name, err)
+	}
+
+	return FileEntry{
+		Path:    header.Name,
+		Content: content,
+		Mode:    header.Mode,
+		ModTime: header.ModTime.Unix(),
+	}, nil
 }
 
 func restoreSymlink(path, target string) error {
 	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
@@ -217,9 +620,16 @@ func restoreSymlink(path, target string) error {
 
 // restoreFileAtomic writes a file atomically using temp file + rename
 func restoreFileAtomic(path string, content []byte, mode int64) error {
-	// Ensure parent directory exists
+	return restoreFileAtomicFrom(path, bytes.NewReader(content), mode)
+}
+
+// restoreFileAtomicFrom streams content into a temp file and renames it into place
+func restoreFileAtomicFrom(path string, content io.Reader, mode int64) error {
+	// Ensure parent directory exists. Directories stored in the backup
+	// are restored ahead of their files with their own mode; any others
+	// are created for the owner only.
 	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
@@ -238,7 +648,7 @@ func restoreFileAtomic(path string, content []byte, mode int64) error {
 	}()
 
 	// Write content
-	if _, err := tempFile.Write(content); err != nil {
+	if _, err := io.Copy(tempFile, content); err != nil {
 		tempFile.Close()
 		return fmt.Errorf("failed to write content: %w", err)
 	}
@@ -271,22 +681,11 @@ func filterEntries(entries []FileEntry, selected []string) []FileEntry {
 		return entries
 	}
 
-	// Create a set for O(1) lookup
-	selectedSet := make(map[string]bool)
-	for _, s := range selected {
-		selectedSet[s] = true
-		// Also add normalized path
-		selectedSet[filepath.Clean(s)] = true
-	}
+	selectedSet := selectionSet(selected)
 
 	var filtered []FileEntry
 	for _, entry := range entries {
-		if selectedSet[entry.Path] || selectedSet[filepath.Clean(entry.Path)] {
-			filtered = append(filtered, entry)
-			continue
-		}
-		// Check if base name matches
-		if selectedSet[filepath.Base(entry.Path)] {
+		if isSelected(entry.Path, selectedSet) {
 			filtered = append(filtered, entry)
 		}
 	}
@@ -294,7 +693,26 @@ func filterEntries(entries []FileEntry, selected []string) []FileEntry {
 	return filtered
 }
 
-// ListBackupContents returns a list of files in the backup without restoring
+// selectionSet builds a set for O(1) lookup of selected paths
+func selectionSet(selected []string) map[string]bool {
+	selectedSet := make(map[string]bool)
+	for _, s := range selected {
+		selectedSet[s] = true
+		// Also add normalized path
+		selectedSet[filepath.Clean(s)] = true
+		// and the stored name of an absolute path
+		selectedSet[pathutil.ToLogical(s)] = true
+	}
+	return selectedSet
+}
+
+// isSelected reports whether path matches the selection by full or base name
+func isSelected(path string, selectedSet map[string]bool) bool {
+	return selectedSet[path] || selectedSet[filepath.Clean(path)] || selectedSet[filepath.Base(path)]
+}
+
+// ListBackupContents returns a list of files in the backup without restoring.
+// File contents are loaded into memory.
 func ListBackupContents(backupPath, password string) ([]FileEntry, error) {
 	return decryptAndExtract(backupPath, password)
 }
@@ -303,37 +721,61 @@ func ListBackupContents(backupPath, password string) ([]FileEntry, error) {
 func PreviewRestore(backupPath, password string, opts RestoreOptions) (*RestoreResult, error) {
 	opts.DryRun = true
 	opts.ShowDiff = true
-	return Restore(backupPath, password, opts)
+	return Restore(context.Background(), backupPath, password, opts)
 }
 
 // RestoreFile restores a single file from the backup
 func RestoreFile(backupPath, password, filePath string, opts RestoreOptions) error {
 	opts.SelectedFiles = []string{filePath}
-	_, err := Restore(backupPath, password, opts)
+	_, err := Restore(context.Background(), backupPath, password, opts)
 	return err
 }
 
-// GetFileDiff returns the diff for a specific file without restoring
+// GetFileDiff returns the diff for a specific file without restoring.
+// Only the requested entry is read: the manifest points to the archive
+// holding it, and snapshot entries are read straight from their chunks.
 func GetFileDiff(backupPath, password, filePath string) (string, error) {
-	entries, err := decryptAndExtract(backupPath, password)
+	return newSession(backupPath, password).Diff(filePath)
+}
+
+// Diff returns the diff between a backed up file and its current version
+// on disk
+func (s *Session) Diff(filePath string) (string, error) {
+	var diff string
+	found := false
+
+	err := s.findEntry(filePath, func(header *tar.Header, body io.Reader) error {
+		found = true
+
+		if header.Typeflag == tar.TypeSymlink {
+			diff = fmt.Sprintf("symlink → %s", header.Linkname)
+			return nil
+		}
+
+		content, err := readForDiff(header, body)
+		if err != nil {
+			return err
+		}
+		if content == nil {
+			diff = fmt.Sprintf("[File too large to diff: %d bytes]", header.Size)
+			return nil
+		}
+
+		result, err := GenerateDiff(content, pathutil.FromLogical(header.Name))
+		if err != nil {
+			return err
+		}
+		diff = result.Diff
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
-
-	for _, entry := range entries {
-		if entry.Path == filePath || filepath.Base(entry.Path) == filepath.Base(filePath) {
-			if entry.LinkTarget != "" {
-				return fmt.Sprintf("symlink → %s", entry.LinkTarget), nil
-			}
-			result, err := GenerateDiff(entry.Content, filePath)
-			if err != nil {
-				return "", err
-			}
-			return result.Diff, nil
-		}
+	if !found {
+		return "", fmt.Errorf("file %s not found in backup", filePath)
 	}
 
-	return "", fmt.Errorf("file %s not found in backup", filePath)
+	return diff, nil
 }
 
 // ValidateBackup checks if a backup file is valid and decryptable
@@ -343,14 +785,20 @@ func ValidateBackup(backupPath, password string) error {
 		return fmt.Errorf("backup file not found: %w", err)
 	}
 
-	// Check metadata file exists
-	metadataPath := backupPath + ".meta.json"
-	if _, err := os.Stat(metadataPath); err != nil {
-		return fmt.Errorf("metadata file not found: %w", err)
+	// Check metadata file exists (snapshots keep theirs in the repository,
+	// single-file backups in the file itself)
+	if !repository.IsSnapshotPath(backupPath) && !backup.IsContainer(backupPath) {
+		metadataPath := backupPath + backup.MetadataExt
+		if _, err := os.Stat(metadataPath); err != nil {
+			return fmt.Errorf("metadata file not found: %w", err)
+		}
 	}
 
-	// Try to decrypt and extract (validates password)
-	_, err := decryptAndExtract(backupPath, password)
+	// Stream through the whole backup (validates password and integrity)
+	err := newSession(backupPath, password).walk(func(header *tar.Header, body io.Reader) error {
+		_, err := io.Copy(io.Discard, body)
+		return err
+	})
 	if err != nil {
 		if strings.Contains(err.Error(), "decryption failed") {
 			return fmt.Errorf("invalid password or corrupted backup")
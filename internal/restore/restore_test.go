@@ -1,14 +1,27 @@
 package restore
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/capture"
 	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/hooks"
+	"github.com/diogo/dotkeeper/internal/progress"
 )
 
 // createTestBackup creates a test backup for use in restore tests
@@ -42,7 +55,7 @@ func createTestBackup(t *testing.T, tmpDir string, files map[string]string) (str
 
 	password := "test-password-123"
 
-	result, err := backup.Backup(cfg, password)
+	result, err := backup.Backup(context.Background(), cfg, password)
 	if err != nil {
 		t.Fatalf("Failed to create test backup: %v", err)
 	}
@@ -70,7 +83,7 @@ func TestRestore_Basic(t *testing.T) {
 		TargetDir: restoreDir,
 	}
 
-	result, err := Restore(backupPath, password, opts)
+	result, err := Restore(context.Background(), backupPath, password, opts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
@@ -111,7 +124,7 @@ func TestRestore_DryRun(t *testing.T) {
 		DryRun:    true,
 	}
 
-	result, err := Restore(backupPath, password, opts)
+	result, err := Restore(context.Background(), backupPath, password, opts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
@@ -155,7 +168,7 @@ func TestRestore_WithConflict(t *testing.T) {
 		TargetDir: restoreDir,
 	}
 
-	result, err := Restore(backupPath, password, opts)
+	result, err := Restore(context.Background(), backupPath, password, opts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
@@ -223,7 +236,7 @@ func TestRestore_WithForce(t *testing.T) {
 		Force:     true,
 	}
 
-	result, err := Restore(backupPath, password, opts)
+	result, err := Restore(context.Background(), backupPath, password, opts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
@@ -271,7 +284,7 @@ func TestRestore_ShowDiff(t *testing.T) {
 		DryRun:     true,
 	}
 
-	result, err := Restore(backupPath, password, opts)
+	result, err := Restore(context.Background(), backupPath, password, opts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
@@ -307,7 +320,7 @@ func TestRestore_SelectedFiles(t *testing.T) {
 		SelectedFiles: []string{"file1.txt", "file3.txt"},
 	}
 
-	result, err := Restore(backupPath, password, opts)
+	result, err := Restore(context.Background(), backupPath, password, opts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
@@ -338,7 +351,7 @@ func TestRestore_WrongPassword(t *testing.T) {
 		TargetDir: restoreDir,
 	}
 
-	_, err := Restore(backupPath, "wrong-password", opts)
+	_, err := Restore(context.Background(), backupPath, "wrong-password", opts)
 	if err == nil {
 		t.Error("Expected error with wrong password")
 	}
@@ -354,7 +367,7 @@ func TestRestore_MissingBackupFile(t *testing.T) {
 
 	opts := RestoreOptions{}
 
-	_, err := Restore(backupPath, "password", opts)
+	_, err := Restore(context.Background(), backupPath, "password", opts)
 	if err == nil {
 		t.Error("Expected error with missing backup file")
 	}
@@ -496,11 +509,12 @@ func TestFilterEntries(t *testing.T) {
 	}
 }
 
-func TestProgressCallback(t *testing.T) {
+func TestRestore_Progress(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	files := map[string]string{
 		"file1.txt": "content1",
+		"file2.txt": "content22",
 	}
 	backupPath, password := createTestBackup(t, tmpDir, files)
 
@@ -509,31 +523,801 @@ func TestProgressCallback(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var callbackCalls []string
+	var events []progress.Event
 	opts := RestoreOptions{
 		TargetDir: restoreDir,
-		ProgressCallback: func(file, action string) {
-			callbackCalls = append(callbackCalls, action)
+		Progress: func(e progress.Event) {
+			events = append(events, e)
 		},
 	}
 
-	_, err := Restore(backupPath, password, opts)
+	_, err := Restore(context.Background(), backupPath, password, opts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
 
-	if len(callbackCalls) == 0 {
-		t.Error("Expected progress callback to be called")
+	restored := 0
+	for _, e := range events {
+		if e.Action == "restored" {
+			restored++
+		}
+	}
+	if restored != 2 {
+		t.Errorf("got %d 'restored' events, want 2", restored)
+	}
+
+	last := events[len(events)-1]
+	if last.Phase != progress.Done || last.Operation != "restore" {
+		t.Errorf("last event = %+v, want the done phase of a restore", last)
+	}
+	if last.FilesDone != 2 || last.FilesTotal != 2 || last.BytesDone != 17 || last.BytesTotal != 17 {
+		t.Errorf("last event = %+v, want 2/2 files and 17/17 bytes", last)
+	}
+}
+
+func TestRestore_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+		"a.txt": "a",
+		"b.txt": "b",
+	})
+	restoreDir := filepath.Join(tmpDir, "restore")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := RestoreOptions{
+		TargetDir: restoreDir,
+		Progress: func(e progress.Event) {
+			// Cancel as soon as the first file is in place
+			if e.Action == "restored" {
+				cancel()
+			}
+		},
+	}
+
+	_, err := Restore(ctx, backupPath, password, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Restore() error = %v, want context.Canceled", err)
+	}
+
+	entries, _ := os.ReadDir(restoreDir)
+	if len(entries) != 1 {
+		t.Errorf("restore dir holds %d entries, want only the first file and no temp files", len(entries))
+	}
+}
+
+func TestRestore_StreamsLargeFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Larger than several encryption chunks
+	large := strings.Repeat("0123456789abcdef", 40000)
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+		"large.txt": large,
+		"small.txt": "small",
+	})
+
+	restoreDir := filepath.Join(tmpDir, "restore")
+	result, err := Restore(context.Background(), backupPath, password, RestoreOptions{TargetDir: restoreDir})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.FilesRestored != 2 {
+		t.Errorf("Expected 2 restored files, got %d", result.FilesRestored)
+	}
+
+	content, err := os.ReadFile(filepath.Join(restoreDir, "large.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != large {
+		t.Error("Large file content mismatch")
+	}
+}
+
+func TestRestore_TruncatedBackup(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+		"file.txt": strings.Repeat("x", 200000),
+	})
+
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backupPath, data[:len(data)-100], 0600); err != nil {
+		t.Fatal(err)
 	}
 
-	foundRestored := false
-	for _, call := range callbackCalls {
-		if call == "restored" {
-			foundRestored = true
+	if err := ValidateBackup(backupPath, password); err == nil {
+		t.Error("Expected truncated backup to fail validation")
+	}
+}
+
+// writeLegacyBackup writes a backup in the original single-shot format
+func writeLegacyBackup(t *testing.T, dir string, password string, files map[string]string) string {
+	t.Helper()
+
+	var archive bytes.Buffer
+	gzw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
 		}
 	}
+	tw.Close()
+	gzw.Close()
 
-	if !foundRestored {
-		t.Error("Expected 'restored' callback")
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := crypto.Encrypt(archive.Bytes(), crypto.DeriveKey(password, salt), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath := filepath.Join(dir, "backup-legacy.tar.gz.enc")
+	if err := os.WriteFile(backupPath, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	metadata := crypto.DefaultMetadata()
+	metadata.Salt = salt
+	metadataJSON, _ := json.Marshal(metadata)
+	if err := os.WriteFile(backupPath+".meta.json", metadataJSON, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return backupPath
+}
+
+func TestRestore_LegacyFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	password := "legacy-password"
+	backupPath := writeLegacyBackup(t, tmpDir, password, map[string]string{
+		filepath.Join(tmpDir, "src", "legacy.txt"): "legacy content",
+	})
+
+	restoreDir := filepath.Join(tmpDir, "restore")
+	result, err := Restore(context.Background(), backupPath, password, RestoreOptions{TargetDir: restoreDir})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.FilesRestored != 1 {
+		t.Fatalf("Expected 1 restored file, got %d", result.FilesRestored)
+	}
+
+	content, err := os.ReadFile(filepath.Join(restoreDir, "legacy.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "legacy content" {
+		t.Errorf("Content mismatch: got %q", content)
+	}
+}
+
+// splitBackup rewrites a single-file backup in the two-file layout that
+// streaming backups used before: the stream encrypted with a key derived
+// from the password, next to a plaintext metadata sidecar and an encrypted
+// manifest sidecar
+func splitBackup(t *testing.T, backupPath, password string) {
+	t.Helper()
+	c, err := backup.OpenContainer(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, err := c.Unlock(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, manifest, err := c.Decrypt(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, f, err := c.OpenPayload(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.DeriveKey(password, salt)
+	metadata.KDF, metadata.Salt = "Argon2id", salt
+	metadata.KDFTime, metadata.KDFMemory, metadata.KDFThreads = crypto.Argon2Time, crypto.Argon2Memory, crypto.Argon2Threads
+
+	var stream bytes.Buffer
+	w, err := crypto.NewEncryptWriter(&stream, key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	manifestJSON, _ := json.Marshal(manifest)
+	encryptedManifest, err := crypto.Encrypt(manifestJSON, key, metadata.Salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataJSON, _ := json.Marshal(metadata)
+
+	for path, data := range map[string][]byte{
+		backupPath:                      stream.Bytes(),
+		backup.ManifestPath(backupPath): encryptedManifest,
+		backupPath + backup.MetadataExt: metadataJSON,
+	} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRestore_TwoFileLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+		"two-file.txt": "sidecar content",
+	})
+	splitBackup(t, backupPath, password)
+
+	if err := ValidateBackup(backupPath, password); err != nil {
+		t.Fatalf("ValidateBackup failed: %v", err)
+	}
+	entries, err := ListEntries(backupPath, password)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ListEntries = %+v, %v", entries, err)
+	}
+
+	restoreDir := filepath.Join(tmpDir, "restore")
+	if _, err := Restore(context.Background(), backupPath, password, RestoreOptions{TargetDir: restoreDir}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(restoreDir, "two-file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "sidecar content" {
+		t.Errorf("Content mismatch: got %q", content)
+	}
+}
+
+func TestRestore_RepositorySnapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 200*1024)
+	files := map[string][]byte{
+		"small.txt": []byte("small content"),
+		"large.bin": large,
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(sourceDir, name)
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	cfg := &config.Config{
+		BackupDir:  filepath.Join(tmpDir, "backups"),
+		Files:      paths,
+		Repository: true,
+	}
+	result, err := backup.Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	if _, err := Restore(context.Background(), result.BackupPath, "wrong", RestoreOptions{TargetDir: t.TempDir()}); err == nil {
+		t.Error("expected wrong password to fail")
+	}
+
+	restoreDir := filepath.Join(tmpDir, "restore")
+	res, err := Restore(context.Background(), result.BackupPath, "pw", RestoreOptions{TargetDir: restoreDir})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if res.FilesRestored != 2 {
+		t.Errorf("expected 2 restored files, got %d", res.FilesRestored)
+	}
+
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(restoreDir, name))
+		if err != nil {
+			t.Fatalf("failed to read restored %s: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("restored %s does not match", name)
+		}
+	}
+
+	if err := ValidateBackup(result.BackupPath, "pw"); err != nil {
+		t.Errorf("ValidateBackup failed: %v", err)
+	}
+
+	diff, err := GetFileDiff(result.BackupPath, "pw", filepath.Join(sourceDir, "small.txt"))
+	if err != nil {
+		t.Fatalf("GetFileDiff failed: %v", err)
+	}
+	if diff != "" {
+		t.Errorf("expected no diff against the unchanged source, got %q", diff)
+	}
+}
+
+func TestRestore_IncrementalChain(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Folders: []string{src}}
+	opts := backup.BackupOptions{Incremental: true}
+
+	write("a.txt", "a1")
+	write("b.txt", "b1")
+	write("c.txt", "c1")
+	if _, err := backup.BackupWithOptions(context.Background(), cfg, "pw", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	write("b.txt", "b2-longer")
+	if err := os.Remove(filepath.Join(src, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.BackupWithOptions(context.Background(), cfg, "pw", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	write("d.txt", "d3")
+	last, err := backup.BackupWithOptions(context.Background(), cfg, "pw", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.Incremental || last.ChangedFiles != 1 {
+		t.Fatalf("expected an incremental storing 1 file, got %+v", last)
+	}
+
+	restoreDir := filepath.Join(tmpDir, "restore")
+	result, err := Restore(context.Background(), last.BackupPath, "pw", RestoreOptions{TargetDir: restoreDir})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.TotalFiles != 3 || result.FilesRestored != 3 {
+		t.Errorf("expected 3 files in the rebuilt tree, got total=%d restored=%d", result.TotalFiles, result.FilesRestored)
+	}
+
+	want := map[string]string{"a.txt": "a1", "b.txt": "b2-longer", "d.txt": "d3"}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(restoreDir, name))
+		if err != nil {
+			t.Errorf("%s not restored: %v", name, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(restoreDir, "c.txt")); !os.IsNotExist(err) {
+		t.Error("deleted file should not be restored")
+	}
+
+	entries, err := ListBackupContents(last.BackupPath, "pw")
+	if err != nil {
+		t.Fatalf("ListBackupContents failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected 3 entries, got %d", len(entries))
+	}
+
+	if _, err := Restore(context.Background(), last.BackupPath, "wrong", RestoreOptions{TargetDir: t.TempDir()}); err == nil {
+		t.Error("expected wrong password to fail")
+	}
+
+	// A missing link in the chain is reported rather than silently skipped
+	if err := os.Remove(filepath.Join(cfg.BackupDir, last.Parent)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Restore(context.Background(), last.BackupPath, "pw", RestoreOptions{TargetDir: t.TempDir()})
+	if err == nil || !strings.Contains(err.Error(), "chain is broken") {
+		t.Errorf("expected broken chain error, got %v", err)
+	}
+}
+
+func TestRestore_Compression(t *testing.T) {
+	for _, setting := range []string{"zstd:3", "gzip:9", "none"} {
+		t.Run(setting, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			src := filepath.Join(tmpDir, "config.txt")
+			content := strings.Repeat("setting = value\n", 100)
+			if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &config.Config{
+				BackupDir:   filepath.Join(tmpDir, "backups"),
+				Files:       []string{src},
+				Compression: setting,
+			}
+			result, err := backup.Backup(context.Background(), cfg, "pw")
+			if err != nil {
+				t.Fatalf("Backup failed: %v", err)
+			}
+
+			backups, err := backup.List(cfg.BackupDir)
+			if err != nil || len(backups) != 1 {
+				t.Fatalf("List = %v, %v", backups, err)
+			}
+			if backups[0].ID() != strings.Split(result.BackupName, ".")[0] {
+				t.Errorf("ID = %q for %s", backups[0].ID(), result.BackupName)
+			}
+
+			restoreDir := filepath.Join(tmpDir, "restore")
+			if _, err := Restore(context.Background(), result.BackupPath, "pw", RestoreOptions{TargetDir: restoreDir}); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(restoreDir, "config.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content {
+				t.Error("restored content does not match")
+			}
+		})
+	}
+}
+
+func TestRestore_Metadata(t *testing.T) {
+	for _, repo := range []bool{false, true} {
+		t.Run(fmt.Sprintf("repository=%t", repo), func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("XDG_CONFIG_HOME", "")
+			tmpDir := t.TempDir()
+			src := filepath.Join(tmpDir, "Makefile")
+			if err := os.WriteFile(src, []byte("all:\n"), 0640); err != nil {
+				t.Fatal(err)
+			}
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			if err := os.Chtimes(src, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			// As root, hand the file to another user to check ownership
+			// survives; otherwise it stays with the current user
+			if os.Getuid() == 0 {
+				if err := os.Chown(src, 65534, 65534); err != nil {
+					t.Fatal(err)
+				}
+			}
+			srcInfo, err := os.Stat(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &config.Config{
+				BackupDir:  filepath.Join(tmpDir, "backups"),
+				Files:      []string{src},
+				Repository: repo,
+			}
+			backupResult, err := backup.Backup(context.Background(), cfg, "pw")
+			if err != nil {
+				t.Fatalf("Backup failed: %v", err)
+			}
+
+			checkMetadata := func(path string, owner os.FileInfo) {
+				t.Helper()
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.ModTime().Equal(mtime) {
+					t.Errorf("mtime = %v, want %v", info.ModTime(), mtime)
+				}
+				if info.Mode().Perm() != 0640 {
+					t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+				}
+				gotStat, ok1 := info.Sys().(*syscall.Stat_t)
+				wantStat, ok2 := owner.Sys().(*syscall.Stat_t)
+				if ok1 && ok2 && (gotStat.Uid != wantStat.Uid || gotStat.Gid != wantStat.Gid) {
+					t.Errorf("owner = %d:%d, want %d:%d", gotStat.Uid, gotStat.Gid, wantStat.Uid, wantStat.Gid)
+				}
+			}
+
+			// Restored in place, the file keeps its recorded owner
+			if err := os.Remove(src); err != nil {
+				t.Fatal(err)
+			}
+			result, err := Restore(context.Background(), backupResult.BackupPath, "pw", RestoreOptions{})
+			if err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			if len(result.MetadataErrors) > 0 {
+				t.Errorf("unexpected metadata errors: %v", result.MetadataErrors)
+			}
+			checkMetadata(src, srcInfo)
+
+			// Restored under another root, it takes the owner of that root
+			for _, opts := range []RestoreOptions{
+				{TargetDir: filepath.Join(tmpDir, "restore")},
+				{Mappings: []Mapping{{From: tmpDir, To: filepath.Join(tmpDir, "mapped")}}},
+			} {
+				result, err := Restore(context.Background(), backupResult.BackupPath, "pw", opts)
+				if err != nil {
+					t.Fatalf("Restore failed: %v", err)
+				}
+				if len(result.MetadataErrors) > 0 {
+					t.Errorf("unexpected metadata errors: %v", result.MetadataErrors)
+				}
+				root := opts.TargetDir
+				if root == "" {
+					root = opts.Mappings[0].To
+				}
+				rootInfo, err := os.Stat(root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkMetadata(filepath.Join(root, "Makefile"), rootInfo)
+			}
+		})
+	}
+}
+
+func TestRestore_Hooks(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+		"tmux.conf": "set -g mouse on",
+	})
+	restoreDir := filepath.Join(tmpDir, "restore")
+	postLog := filepath.Join(tmpDir, "post.log")
+
+	opts := RestoreOptions{
+		TargetDir: restoreDir,
+		Hooks: hooks.Config{
+			PreRestore:  []hooks.Hook{{Command: "mkdir -p " + restoreDir}},
+			PostRestore: []hooks.Hook{{Command: `echo "$DOTKEEPER_OPERATION $(cat "$DOTKEEPER_RESTORED_FILES_LIST")" > ` + postLog}},
+			PostRestorePaths: map[string][]hooks.Hook{
+				restoreDir: {{Command: "echo reloaded"}},
+			},
+		},
+	}
+
+	// Dry runs skip the hooks
+	opts.DryRun = true
+	result, err := Restore(context.Background(), backupPath, password, opts)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(result.Hooks) != 0 {
+		t.Errorf("dry run ran hooks: %+v", result.Hooks)
+	}
+
+	opts.DryRun = false
+	result, err = Restore(context.Background(), backupPath, password, opts)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(result.Hooks) != 3 || result.Hooks[1].Stage != hooks.PathRestore || result.Hooks[1].Output != "reloaded\n" {
+		t.Errorf("Hooks = %+v", result.Hooks)
+	}
+	logged, err := os.ReadFile(postLog)
+	if err != nil {
+		t.Fatalf("post_restore hook did not run: %v", err)
+	}
+	if want := "restore " + filepath.Join(restoreDir, "tmux.conf") + "\n"; string(logged) != want {
+		t.Errorf("post_restore hook saw %q, want %q", logged, want)
+	}
+
+	// A failing pre_restore hook aborts before anything is written
+	otherDir := filepath.Join(tmpDir, "other")
+	opts.TargetDir = otherDir
+	opts.Hooks.PreRestore = []hooks.Hook{{Command: "exit 2"}}
+	if _, err := Restore(context.Background(), backupPath, password, opts); err == nil || !strings.Contains(err.Error(), "restore aborted") {
+		t.Fatalf("Restore() error = %v, want abort", err)
+	}
+	if _, err := os.Stat(otherDir); !os.IsNotExist(err) {
+		t.Error("aborted restore should not write files")
+	}
+}
+
+func TestRestore_Captures(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceFile := filepath.Join(tmpDir, "source", "file.txt")
+	if err := os.MkdirAll(filepath.Dir(sourceFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sourceFile, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		BackupDir: filepath.Join(tmpDir, "backups"),
+		Files:     []string{sourceFile},
+		Captures:  []capture.Capture{{Name: "crontab", Command: "echo '0 * * * * true'"}},
+	}
+	password := "test-password-123"
+	created, err := backup.Backup(context.Background(), cfg, password)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	session, err := OpenSession(created.BackupPath, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := session.Captures()
+	if err != nil || len(names) != 1 || names[0] != "crontab" {
+		t.Fatalf("Captures() = %v, %v", names, err)
+	}
+
+	// A plain restore leaves captures alone
+	restoreDir := filepath.Join(tmpDir, "restore")
+	result, err := session.Restore(context.Background(), RestoreOptions{TargetDir: restoreDir})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.FilesRestored != 1 || len(result.Captures) != 0 {
+		t.Errorf("restored %d files and captures %v, want only the file", result.FilesRestored, result.Captures)
+	}
+
+	// Selected captures are written into the capture directory
+	captureDir := filepath.Join(tmpDir, "captures")
+	result, err = session.Restore(context.Background(), RestoreOptions{
+		SelectedFiles: []string{capture.Prefix + "crontab"},
+		CaptureDir:    captureDir,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	want := filepath.Join(captureDir, "crontab")
+	if result.FilesRestored != 1 || result.Captures["crontab"] != want {
+		t.Fatalf("result = %+v", result)
+	}
+	if got, err := os.ReadFile(want); err != nil || string(got) != "0 * * * * true\n" {
+		t.Errorf("capture content = %q, %v", got, err)
+	}
+}
+
+func TestRestore_Directories(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	for dir, mode := range map[string]os.FileMode{"": 0755, ".ssh": 0700, "empty": 0750, "public": 0755} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(src, dir), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, ".ssh", "id_ed25519"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "public", "index.html"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, repo := range []bool{false, true} {
+		t.Run(fmt.Sprintf("repository=%v", repo), func(t *testing.T) {
+			cfg := &config.Config{
+				BackupDir:  filepath.Join(t.TempDir(), "backups"),
+				Folders:    []string{src},
+				Repository: repo,
+			}
+			created, err := backup.Backup(context.Background(), cfg, "pw")
+			if err != nil {
+				t.Fatalf("Backup failed: %v", err)
+			}
+			if created.FileCount != 2 {
+				t.Errorf("FileCount = %d, want 2", created.FileCount)
+			}
+
+			// A directory already stricter on disk stays that way
+			dest := filepath.Join(t.TempDir(), "dest")
+			if err := os.MkdirAll(filepath.Join(dest, "public"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(filepath.Join(dest, "public"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			opts := RestoreOptions{Force: true, Mappings: []Mapping{{From: src, To: dest}}}
+			result, err := Restore(context.Background(), created.BackupPath, "pw", opts)
+			if err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			if result.FilesRestored != 2 {
+				t.Errorf("FilesRestored = %d, want 2", result.FilesRestored)
+			}
+
+			for dir, want := range map[string]os.FileMode{"": 0755, ".ssh": 0700, "empty": 0750, "public": 0700} {
+				info, err := os.Stat(filepath.Join(dest, dir))
+				if err != nil {
+					t.Errorf("%s not restored: %v", dir, err)
+					continue
+				}
+				if !info.IsDir() || info.Mode().Perm() != want {
+					t.Errorf("%s mode = %v, want %v", dir, info.Mode(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestRestore_SQLiteLogs(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	db := filepath.Join(src, "history.db")
+	if err := os.WriteFile(db, []byte("SQLite format 3\x00pages"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(db+"-wal", []byte("frames"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Folders: []string{src}}
+	created, err := backup.Backup(context.Background(), cfg, "pw")
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	// Logs left by the application would be applied to the restored
+	// database; the ones not in the backup are moved aside
+	dest := filepath.Join(tmpDir, "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(dest, "history.db")
+	for suffix, content := range map[string]string{"-wal": "stale frames", "-journal": "stale", "-shm": "index"} {
+		if err := os.WriteFile(restored+suffix, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := RestoreOptions{Mappings: []Mapping{{From: src, To: dest}}}
+	result, err := Restore(context.Background(), created.BackupPath, "pw", opts)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if content, _ := os.ReadFile(restored + "-wal"); string(content) != "frames" {
+		t.Errorf("log restored as %q", content)
+	}
+	for _, suffix := range []string{"-journal", "-shm"} {
+		if _, err := os.Stat(restored + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s left next to the restored database", suffix)
+		}
+	}
+	var journalKept bool
+	for _, path := range result.BackupFiles {
+		journalKept = journalKept || strings.HasPrefix(filepath.Base(path), "history.db-journal.bak.")
+	}
+	if !journalKept {
+		t.Errorf("stale journal not moved aside: %v", result.BackupFiles)
+	}
+
+	// Restoring the database alone removes its log with Force
+	opts.SelectedFiles = []string{db}
+	opts.Force = true
+	if _, err := Restore(context.Background(), created.BackupPath, "pw", opts); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(restored + "-wal"); !os.IsNotExist(err) {
+		t.Error("log of another state left next to the restored database")
 	}
 }
@@ -0,0 +1,228 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// Session is a backup opened for reading. Keys are derived once per salt
+// and the entry index is read once, so listing entries, previewing diffs
+// and restoring reuse them instead of repeating the Argon2id derivation
+// and a full decrypt for every call.
+//
+// A Session returned by OpenSession or OpenSessionWithKey has its index
+// loaded and may be used from several goroutines.
+type Session struct {
+	path     string
+	password string
+	// key replaces key derivation and key slots for sessions opened with
+	// a key
+	key []byte
+
+	mu   sync.Mutex
+	keys map[string][]byte // derived keys by salt, unwrapped keys by slot set
+
+	indexed bool
+	entries []backup.ManifestEntry
+	repo    *repository.Repository
+	snap    *repository.Snapshot
+}
+
+// OpenSession opens a backup with a password. The password is verified and
+// the entry index is loaded before returning.
+func OpenSession(backupPath, password string) (*Session, error) {
+	s := newSession(backupPath, password)
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenSessionWithKey opens a backup with the master key its key slots
+// open, or for backups without key slots, a key already derived from the
+// password and the backup's salt. The key is used for every archive the
+// session reads, so incremental chains only open when all their archives
+// share it.
+func OpenSessionWithKey(backupPath string, key []byte) (*Session, error) {
+	s := newSession(backupPath, "")
+	s.key = key
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newSession returns a session that loads its index on first use
+func newSession(backupPath, password string) *Session {
+	return &Session{
+		path:     backupPath,
+		password: password,
+		keys:     make(map[string][]byte),
+	}
+}
+
+// Path returns the path of the open backup
+func (s *Session) Path() string {
+	return s.path
+}
+
+// Entries returns the entries of the backup's tree, directories included,
+// without reading any file content. The slice is shared by the session and must not be
+// modified.
+func (s *Session) Entries() ([]backup.ManifestEntry, error) {
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	return s.entries, nil
+}
+
+// archiveKey returns the data key of an archive
+func (s *Session) archiveKey(backupPath string) ([]byte, error) {
+	if backup.IsContainer(backupPath) {
+		c, err := backup.OpenContainer(backupPath)
+		if err != nil {
+			return nil, err
+		}
+		return s.containerKey(c)
+	}
+
+	metadata, err := backup.ReadMetadata(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	return s.backupKey(metadata)
+}
+
+// containerKey returns the data key of a single-file backup: unwrapped
+// with the master key its slots open, or derived from the password for
+// backups without key slots
+func (s *Session) containerKey(c *backup.Container) ([]byte, error) {
+	if len(c.Slots()) == 0 {
+		return s.backupKey(c.Metadata())
+	}
+	master, err := s.unlockSlots(c.Slots())
+	if err != nil {
+		return nil, err
+	}
+	return c.DataKey(master)
+}
+
+// unlockSlots returns the key a set of key slots wraps, opening the slots
+// only the first time the set is seen: the backups of a directory share
+// their slots
+func (s *Session) unlockSlots(slots []crypto.KeySlot) ([]byte, error) {
+	if s.key != nil {
+		return s.key, nil
+	}
+
+	id := "slots"
+	for _, slot := range slots {
+		id += ":" + string(slot.Salt)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[id]; ok {
+		return key, nil
+	}
+	key, _, err := crypto.OpenKeySlots(slots, s.password)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong password?): %w", err)
+	}
+	s.keys[id] = key
+	return key, nil
+}
+
+// backupKey returns the key of an archive without key slots, derived with
+// the costs its metadata records
+func (s *Session) backupKey(metadata *crypto.EncryptionMetadata) ([]byte, error) {
+	params := metadata.KDFParams()
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return s.deriveKeyWithParams(metadata.Salt, params), nil
+}
+
+// deriveKey returns the key for a salt derived with the default costs
+func (s *Session) deriveKey(salt []byte) []byte {
+	return s.deriveKeyWithParams(salt, crypto.DefaultKDFParams)
+}
+
+// deriveKeyWithParams returns the key for a salt, running the key
+// derivation only the first time the salt is seen
+func (s *Session) deriveKeyWithParams(salt []byte, params crypto.KDFParams) []byte {
+	if s.key != nil {
+		return s.key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[string(salt)]; ok {
+		return key
+	}
+	key := crypto.DeriveKeyWithParams(s.password, salt, params)
+	s.keys[string(salt)] = key
+	return key
+}
+
+// loadIndex reads the entry index: the snapshot for repository backups,
+// the manifest for archives, or a header scan for archives made before
+// manifests existed
+func (s *Session) loadIndex() error {
+	if s.indexed {
+		return nil
+	}
+
+	if repository.IsSnapshotPath(s.path) {
+		if err := s.openSnapshot(); err != nil {
+			return err
+		}
+		s.entries = make([]backup.ManifestEntry, 0, len(s.snap.Files))
+		for _, f := range s.snap.Files {
+			s.entries = append(s.entries, snapshotEntry(f))
+		}
+		s.indexed = true
+		return nil
+	}
+
+	key, err := s.archiveKey(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+	}
+
+	manifest, err := backup.ReadManifestWithKey(s.path, key)
+	switch {
+	case err == nil:
+		s.entries = manifest.Entries
+	case errors.Is(err, os.ErrNotExist):
+		var entries []backup.ManifestEntry
+		err := s.walkArchive(s.path, func(header *tar.Header, body io.Reader) error {
+			entries = append(entries, backup.ManifestEntry{
+				Path:       header.Name,
+				Size:       header.Size,
+				Mode:       header.Mode,
+				ModTime:    header.ModTime.Unix(),
+				LinkTarget: header.Linkname,
+				Dir:        header.Typeflag == tar.TypeDir,
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.entries = entries
+	default:
+		return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+	}
+
+	s.indexed = true
+	return nil
+}
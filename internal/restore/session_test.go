@@ -0,0 +1,178 @@
+package restore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/crypto"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+func TestOpenSession(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupPath, password := createTestBackup(t, tmpDir, map[string]string{
+		"one.txt": "first",
+		"two.txt": "second",
+	})
+
+	if _, err := OpenSession(backupPath, "wrong"); err == nil || !strings.Contains(err.Error(), "decryption failed") {
+		t.Fatalf("expected wrong password error, got %v", err)
+	}
+
+	s, err := OpenSession(backupPath, password)
+	if err != nil {
+		t.Fatalf("OpenSession failed: %v", err)
+	}
+
+	entries, err := s.Entries()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Entries = %d entries, %v", len(entries), err)
+	}
+
+	for _, name := range []string{"one.txt", "two.txt", "one.txt"} {
+		diff, err := s.Diff(filepath.Join(tmpDir, "source", name))
+		if err != nil {
+			t.Fatalf("Diff(%s) failed: %v", name, err)
+		}
+		if diff != "" {
+			t.Errorf("expected no diff for unchanged %s, got %q", name, diff)
+		}
+	}
+
+	restoreDir := filepath.Join(tmpDir, "restore")
+	result, err := s.Restore(context.Background(), RestoreOptions{TargetDir: restoreDir})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if result.FilesRestored != 2 {
+		t.Errorf("expected 2 restored files, got %d", result.FilesRestored)
+	}
+
+	// Every operation reused the key derived when the session was opened
+	if len(s.keys) != 1 {
+		t.Errorf("expected 1 derived key, got %d", len(s.keys))
+	}
+}
+
+func TestOpenSession_Chain(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{BackupDir: filepath.Join(tmpDir, "backups"), Folders: []string{src}}
+	opts := backup.BackupOptions{Incremental: true}
+
+	write("a.txt", "a1")
+	if _, err := backup.BackupWithOptions(context.Background(), cfg, "pw", opts); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	write("b.txt", "b2")
+	last, err := backup.BackupWithOptions(context.Background(), cfg, "pw", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenSession(last.BackupPath, "pw")
+	if err != nil {
+		t.Fatalf("OpenSession failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Restore(context.Background(), RestoreOptions{TargetDir: t.TempDir()}); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+	}
+
+	// One unwrap for the chain, whose archives share their key slots,
+	// however often it is read
+	if len(s.keys) != 1 {
+		t.Errorf("expected 1 derived key, got %d", len(s.keys))
+	}
+}
+
+func TestOpenSessionWithKey(t *testing.T) {
+	t.Run("archive", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		backupPath, password := createTestBackup(t, tmpDir, map[string]string{"k.txt": "keyed"})
+
+		c, err := backup.OpenContainer(backupPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		master, _, err := crypto.OpenKeySlots(c.Slots(), password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := OpenSessionWithKey(backupPath, master)
+		if err != nil {
+			t.Fatalf("OpenSessionWithKey failed: %v", err)
+		}
+		entry, err := s.ReadEntry("k.txt")
+		if err != nil {
+			t.Fatalf("ReadEntry failed: %v", err)
+		}
+		if string(entry.Content) != "keyed" {
+			t.Errorf("content = %q", entry.Content)
+		}
+
+		wrong, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenSessionWithKey(backupPath, wrong); err == nil {
+			t.Error("expected a wrong key to fail")
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "s.txt")
+		if err := os.WriteFile(path, []byte("snapshot"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &config.Config{
+			BackupDir:  filepath.Join(tmpDir, "backups"),
+			Files:      []string{path},
+			Repository: true,
+		}
+		result, err := backup.Backup(context.Background(), cfg, "pw")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		repoConfig, err := repository.LoadConfig(repository.Dir(cfg.BackupDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		master, _, err := crypto.OpenKeySlots(repoConfig.KeySlots, "pw")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := OpenSessionWithKey(result.BackupPath, master)
+		if err != nil {
+			t.Fatalf("OpenSessionWithKey failed: %v", err)
+		}
+		entry, err := s.ReadEntry(path)
+		if err != nil {
+			t.Fatalf("ReadEntry failed: %v", err)
+		}
+		if string(entry.Content) != "snapshot" {
+			t.Errorf("content = %q", entry.Content)
+		}
+	})
+}
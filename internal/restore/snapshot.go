@@ -0,0 +1,105 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/repository"
+)
+
+// walkSnapshot calls fn for every file, symlink and directory a repository
+// snapshot references, streaming file content chunk by chunk
+func (s *Session) walkSnapshot(fn func(header *tar.Header, body io.Reader) error) error {
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+
+	for _, f := range s.snap.Files {
+		header, body := snapshotHeader(s.repo, f)
+		if err := fn(header, body); err != nil {
+			if errors.Is(err, errStopWalk) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// openSnapshot opens the repository the snapshot belongs to and decrypts
+// the snapshot manifest
+func (s *Session) openSnapshot() error {
+	dir := repository.DirFromSnapshot(s.path)
+	config, err := repository.LoadConfig(dir)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+	}
+
+	var master []byte
+	if len(config.KeySlots) > 0 {
+		slotKey, err := s.unlockSlots(config.KeySlots)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+		}
+		if master, err = config.MasterKey(slotKey); err != nil {
+			return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+		}
+	} else {
+		master = s.deriveKey(config.Salt)
+	}
+
+	repo, err := repository.OpenWithKey(dir, master)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+	}
+
+	snap, err := repo.LoadSnapshot(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt and extract backup: %w", err)
+	}
+	s.repo, s.snap = repo, snap
+	return nil
+}
+
+// snapshotEntry converts a snapshot file to a manifest entry
+func snapshotEntry(f repository.File) backup.ManifestEntry {
+	return backup.ManifestEntry{
+		Path:       f.Path,
+		Size:       f.Size,
+		Mode:       f.Mode,
+		ModTime:    f.ModTime,
+		SHA256:     f.SHA256,
+		LinkTarget: f.LinkTarget,
+		Dir:        f.Dir,
+	}
+}
+
+// snapshotHeader builds a tar header for a snapshot file and a reader
+// streaming its content
+func snapshotHeader(repo *repository.Repository, f repository.File) (*tar.Header, io.Reader) {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     f.Path,
+		Size:     f.Size,
+		Mode:     f.Mode,
+		ModTime:  time.Unix(f.ModTime, 0),
+	}
+	f.Meta.SetHeader(header)
+	if f.Dir {
+		header.Typeflag = tar.TypeDir
+		header.Size = 0
+		return header, bytes.NewReader(nil)
+	}
+	if f.LinkTarget != "" {
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = f.LinkTarget
+		header.Size = 0
+		return header, bytes.NewReader(nil)
+	}
+	return header, repo.OpenFile(f.Chunks)
+}
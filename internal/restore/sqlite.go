@@ -0,0 +1,61 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/progress"
+)
+
+// sqliteLogs are the files SQLite applies to a database when opening it
+var sqliteLogs = []string{"-wal", "-journal"}
+
+// clearSQLiteLogs moves the logs left next to a restored SQLite database
+// out of the way, the same way conflicting files are, unless this restore
+// writes them too: SQLite would apply them to the restored database and
+// corrupt it. The shared-memory index is rebuilt by SQLite and removed.
+// Files that are not databases are left alone.
+func clearSQLiteLogs(path, name string, restoring func(name string) bool, opts RestoreOptions, result *RestoreResult, t *progress.Tracker) error {
+	if !isSQLiteFile(path) {
+		return nil
+	}
+	if err := os.Remove(path + "-shm"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s-shm: %w", path, err)
+	}
+	for _, suffix := range sqliteLogs {
+		log := path + suffix
+		if restoring(name + suffix) {
+			continue
+		}
+		switch ResolveConflict(log, opts) {
+		case ActionOverwrite:
+			if err := os.Remove(log); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", log, err)
+			}
+		case ActionBackup:
+			backupCreated, err := BackupExisting(log)
+			if err != nil {
+				return fmt.Errorf("failed to backup %s: %w", log, err)
+			}
+			if backupCreated != "" {
+				result.BackupFiles = append(result.BackupFiles, backupCreated)
+				t.Note(log, "backed-up")
+			}
+		}
+	}
+	return nil
+}
+
+// isSQLiteFile reports whether the file at path is a SQLite database
+func isSQLiteFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 16)
+	n, _ := io.ReadFull(f, header)
+	return backup.IsSQLite(header[:n])
+}
@@ -1,6 +1,11 @@
 package restore
 
-import "io"
+import (
+	"io"
+
+	"github.com/diogo/dotkeeper/internal/hooks"
+	"github.com/diogo/dotkeeper/internal/progress"
+)
 
 // RestoreOptions configures the restore operation
 type RestoreOptions struct {
@@ -16,14 +21,31 @@ type RestoreOptions struct {
 	// TargetDir overrides the original paths (useful for restore to different location)
 	TargetDir string
 
-	// SelectedFiles limits restore to specific files (empty = all)
+	// Mappings redirect entries under a path prefix to another location,
+	// e.g. $HOME=/mnt/home/bob or /home/alice=/home/bob
+	Mappings []Mapping
+
+	// SelectedFiles limits restore to specific files (empty = all).
+	// Captures are only restored when selected, as captures/<name>.
 	SelectedFiles []string
 
+	// CaptureDir is where selected captures are written, as files named
+	// after the capture (defaults to TargetDir, then the current directory)
+	CaptureDir string
+
 	// DiffWriter is where diff output is written (defaults to os.Stdout)
 	DiffWriter io.Writer
 
-	// ProgressCallback is called for each file restored
-	ProgressCallback func(file string, action string)
+	// Progress receives progress events as the restore runs. Events with
+	// an Action report what happened to a file: restored, skipped,
+	// backed-up, would-backup, metadata-partial, diff-skipped or diff-error.
+	Progress progress.Func
+
+	// Hooks run before and after the restore; they are skipped on dry runs
+	Hooks hooks.Config
+
+	// HookEnv is added to the environment of the hooks
+	HookEnv map[string]string
 }
 
 // RestoreResult contains information about a completed restore
@@ -32,10 +54,17 @@ type RestoreResult struct {
 	SkippedFiles  []string          // Files that were skipped
 	BackupFiles   []string          // .bak files created
 	DiffResults   map[string]string // Diffs for each file
-	TotalFiles    int
-	FilesRestored int
-	FilesSkipped  int
-	FilesConflict int
+	// MetadataErrors lists ownership, xattr and mtime changes that could
+	// not be applied to restored files, one "path: problem" line each
+	MetadataErrors []string
+	TotalFiles     int
+	FilesRestored  int
+	FilesSkipped   int
+	FilesConflict  int
+	// Hooks holds the results of the restore hooks that ran
+	Hooks []hooks.Result
+	// Captures maps the names of restored captures to the files written
+	Captures map[string]string
 }
 
 // FileEntry represents a file extracted from backup
@@ -54,3 +83,14 @@ type DiffResult struct {
 	BackupPath    string
 	CurrentPath   string
 }
+
+// captureDir is the directory selected captures are written to
+func (o RestoreOptions) captureDir() string {
+	if o.CaptureDir != "" {
+		return o.CaptureDir
+	}
+	if o.TargetDir != "" {
+		return o.TargetDir
+	}
+	return "."
+}
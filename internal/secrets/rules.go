@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"math"
+	"regexp"
+)
+
+// Rule detects one kind of secret, by file content, file path or both
+type Rule struct {
+	Name        string
+	Description string
+	// Action is what happens to matching files unless the config
+	// overrides it
+	Action Action
+	// Path matches the slash-separated file path
+	Path *regexp.Regexp
+	// Content matches a single line of the file
+	Content *regexp.Regexp
+	// MinEntropy, when set, only counts content matches whose first
+	// submatch has at least this Shannon entropy in bits per character,
+	// so that placeholders like "changeme" are not reported
+	MinEntropy float64
+}
+
+// DefaultRules are the built-in rules. Credentials that grant access on
+// their own need confirmation; tokens and high-entropy values only warn.
+var DefaultRules = []Rule{
+	{
+		Name:        "private-key",
+		Description: "private key",
+		Action:      Confirm,
+		Content:     regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`),
+	},
+	{
+		Name:        "aws-credentials",
+		Description: "AWS credentials file",
+		Action:      Confirm,
+		Path:        regexp.MustCompile(`(?:^|/)\.aws/credentials$`),
+	},
+	{
+		Name:        "aws-access-key",
+		Description: "AWS access key",
+		Action:      Confirm,
+		Content:     regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]|\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	{
+		Name:        "gcp-service-account",
+		Description: "Google Cloud service account key",
+		Action:      Confirm,
+		Content:     regexp.MustCompile(`"type"\s*:\s*"service_account"`),
+	},
+	{
+		Name:        "github-token",
+		Description: "GitHub token",
+		Action:      Warn,
+		Content:     regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`),
+	},
+	{
+		Name:        "slack-token",
+		Description: "Slack token",
+		Action:      Warn,
+		Content:     regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`),
+	},
+	{
+		Name:        "dotenv",
+		Description: "environment file",
+		Action:      Warn,
+		Path:        regexp.MustCompile(`(?:^|/)\.env(?:\.[^/]*)?$`),
+	},
+	{
+		Name:        "high-entropy",
+		Description: "high-entropy secret value",
+		Action:      Warn,
+		Content:     regexp.MustCompile(`(?i)(?:key|secret|token|passw(?:or)?d|credential)[a-z0-9_.-]*["']?\s*[:=]\s*["']?([A-Za-z0-9+/=_.~-]{16,})`),
+		MinEntropy:  3.5,
+	},
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len([]rune(s)))
+	var bits float64
+	for _, c := range counts {
+		p := float64(c) / n
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
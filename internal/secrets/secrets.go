@@ -0,0 +1,277 @@
+// Package secrets detects credentials in files before they enter a backup.
+// Built-in rules look for private keys, cloud credentials, tokens and
+// high-entropy values; each rule warns, excludes the file or asks for
+// confirmation, and the config can change the action of any rule.
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/diogo/dotkeeper/internal/pathutil"
+)
+
+// Action is what happens to a file a rule matches
+type Action string
+
+// Actions, from weakest to strongest
+const (
+	// Off disables a rule
+	Off Action = "off"
+	// Warn backs the file up and reports the finding
+	Warn Action = "warn"
+	// Confirm backs the file up only when the user agrees
+	Confirm Action = "confirm"
+	// Exclude leaves the file out of the backup
+	Exclude Action = "exclude"
+)
+
+// strength orders actions so the strongest finding decides
+func (a Action) strength() int {
+	switch a {
+	case Warn:
+		return 1
+	case Confirm:
+		return 2
+	case Exclude:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// maxScanSize is how much of a file is scanned; secrets in dotfiles sit
+// well within it
+const maxScanSize = 1 << 20
+
+// binarySniff is how much of a file is checked for NUL bytes before
+// deciding it is binary and skipping its content
+const binarySniff = 8000
+
+// Config configures the scanner
+type Config struct {
+	// Disabled turns the scanner off
+	Disabled bool `yaml:"disabled,omitempty"`
+	// Rules overrides the action of built-in rules by name, e.g.
+	// private-key: exclude, or dotenv: off
+	Rules map[string]Action `yaml:"rules,omitempty"`
+	// Allow lists paths (globs, ~ expanded) that are never scanned
+	Allow []string `yaml:"allow,omitempty"`
+	// Unattended is what happens to files needing confirmation when
+	// nobody can be asked, as in scheduled backups: warn (the default)
+	// backs them up and reports them, exclude leaves them out and confirm
+	// fails the backup
+	Unattended Action `yaml:"unattended,omitempty"`
+}
+
+// UnattendedAction returns the action taken on files needing confirmation
+// when nobody can confirm them
+func (c Config) UnattendedAction() Action {
+	if c.Unattended == "" {
+		return Warn
+	}
+	return c.Unattended
+}
+
+// Validate checks that the overrides name known rules and actions
+func (c Config) Validate() error {
+	for name, action := range c.Rules {
+		if _, ok := findRule(name); !ok {
+			return fmt.Errorf("unknown secret rule %q", name)
+		}
+		switch action {
+		case Off, Warn, Confirm, Exclude:
+		default:
+			return fmt.Errorf("invalid action %q for secret rule %s (use warn, exclude, confirm or off)", action, name)
+		}
+	}
+	switch c.Unattended {
+	case "", Warn, Confirm, Exclude:
+	default:
+		return fmt.Errorf("invalid unattended action %q (use warn, exclude or confirm)", c.Unattended)
+	}
+	for _, pattern := range c.Allow {
+		if !doublestar.ValidatePattern(filepath.ToSlash(pattern)) {
+			return fmt.Errorf("invalid allow pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+func findRule(name string) (Rule, bool) {
+	for _, r := range DefaultRules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Finding is a rule matching a file
+type Finding struct {
+	Path        string `json:"path"`
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+	// Line is the first matching line, or 0 when the path matched
+	Line   int    `json:"line,omitempty"`
+	Action Action `json:"action"`
+}
+
+func (f Finding) String() string {
+	location := f.Path
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", f.Path, f.Line)
+	}
+	return fmt.Sprintf("%s: %s (%s, %s)", location, f.Description, f.Rule, f.Action)
+}
+
+// Strongest returns the strongest action among findings, or Off when
+// there are none
+func Strongest(findings []Finding) Action {
+	action := Off
+	for _, f := range findings {
+		if f.Action.strength() > action.strength() {
+			action = f.Action
+		}
+	}
+	return action
+}
+
+// Scanner checks files against the rules
+type Scanner struct {
+	rules []Rule
+	allow []string
+}
+
+// New returns a scanner with the config's overrides applied. A disabled
+// config gives a scanner that finds nothing.
+func New(cfg Config) *Scanner {
+	s := &Scanner{}
+	if cfg.Disabled {
+		return s
+	}
+	for _, rule := range DefaultRules {
+		if action, ok := cfg.Rules[rule.Name]; ok {
+			rule.Action = action
+		}
+		if rule.Action != Off {
+			s.rules = append(s.rules, rule)
+		}
+	}
+	for _, pattern := range cfg.Allow {
+		s.allow = append(s.allow, filepath.ToSlash(pathutil.ExpandHome(pattern)))
+	}
+	return s
+}
+
+// allowed reports whether path matches an allow pattern, by full path or
+// base name
+func (s *Scanner) allowed(path string) bool {
+	slashed := filepath.ToSlash(path)
+	base := filepath.Base(path)
+	for _, pattern := range s.allow {
+		if ok, _ := doublestar.Match(pattern, slashed); ok {
+			return true
+		}
+		if ok, _ := doublestar.Match(pattern, base); ok {
+			return true
+		}
+		if strings.HasPrefix(slashed, strings.TrimSuffix(pattern, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanFile scans the file at path. Only the first maxScanSize bytes are
+// read, and the content of binary files is skipped.
+func (s *Scanner) ScanFile(path string) ([]Finding, error) {
+	if len(s.rules) == 0 || s.allowed(path) {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	return s.Scan(path, f)
+}
+
+// Scan scans content read from r as the file at path. Each rule is
+// reported at most once per file, at its first match.
+func (s *Scanner) Scan(path string, r io.Reader) ([]Finding, error) {
+	if len(s.rules) == 0 || s.allowed(path) {
+		return nil, nil
+	}
+
+	var findings []Finding
+	matched := make(map[string]bool)
+	report := func(rule Rule, line int) {
+		if matched[rule.Name] {
+			return
+		}
+		matched[rule.Name] = true
+		findings = append(findings, Finding{
+			Path:        path,
+			Rule:        rule.Name,
+			Description: rule.Description,
+			Line:        line,
+			Action:      rule.Action,
+		})
+	}
+
+	slashed := filepath.ToSlash(path)
+	for _, rule := range s.rules {
+		if rule.Path != nil && rule.Path.MatchString(slashed) {
+			report(rule, 0)
+		}
+	}
+
+	content, err := io.ReadAll(io.LimitReader(r, maxScanSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if bytes.IndexByte(content[:min(len(content), binarySniff)], 0) >= 0 {
+		return findings, nil
+	}
+
+	lines := bufio.NewScanner(bytes.NewReader(content))
+	lines.Buffer(make([]byte, 0, 64<<10), maxScanSize)
+	for n := 1; lines.Scan(); n++ {
+		line := lines.Text()
+		for _, rule := range s.rules {
+			if rule.Content == nil || matched[rule.Name] {
+				continue
+			}
+			if contentMatches(rule, line) {
+				report(rule, n)
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Action.strength() > findings[j].Action.strength()
+	})
+	return findings, nil
+}
+
+// contentMatches reports whether a rule matches a line, applying the
+// rule's entropy threshold to its first submatch
+func contentMatches(rule Rule, line string) bool {
+	if rule.MinEntropy == 0 {
+		return rule.Content.MatchString(line)
+	}
+	for _, m := range rule.Content.FindAllStringSubmatch(line, -1) {
+		if len(m) > 1 && entropy(m[1]) >= rule.MinEntropy {
+			return true
+		}
+	}
+	return false
+}
@@ -120,7 +120,7 @@ func (p PathCompleter) View() string {
 func (p PathCompleter) completeCmd() tea.Cmd {
 	value := p.Input.Value()
 	return func() tea.Msg {
-		expanded := pathutil.ExpandHome(value)
+		expanded := pathutil.ExpandPath(value)
 		dir := filepath.Dir(expanded)
 		base := filepath.Base(expanded)
 
@@ -0,0 +1,90 @@
+package components
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/pathutil"
+	"github.com/diogo/dotkeeper/internal/progress"
+	"github.com/diogo/dotkeeper/internal/tui/styles"
+)
+
+// Bar widths, in cells, clamped to the available width
+const (
+	minProgressBarWidth = 10
+	maxProgressBarWidth = 50
+)
+
+// ProgressBar is a stateless renderer for the progress of a backup or
+// restore
+type ProgressBar struct {
+	styles styles.Styles
+}
+
+// NewProgressBar creates a new ProgressBar
+func NewProgressBar(s styles.Styles) ProgressBar {
+	return ProgressBar{styles: s}
+}
+
+// View renders the event as a bar with the files, bytes and time left
+// below it, followed by the current file. Phases without totals only show
+// their name.
+func (pb ProgressBar) View(e progress.Event, width int) string {
+	var s strings.Builder
+	phase := phaseLabel(e.Phase)
+	if e.FilesTotal == 0 && e.BytesTotal == 0 {
+		s.WriteString(pb.styles.Label.Render(phase+"...") + "\n")
+		return s.String()
+	}
+
+	fraction := e.Fraction()
+	barWidth := min(max(width-len(phase)-8, minProgressBarWidth), maxProgressBarWidth)
+	filled := int(fraction * float64(barWidth))
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+	s.WriteString(fmt.Sprintf("%s %s %3.0f%%\n",
+		pb.styles.Label.Render(phase), pb.styles.Value.Render(bar), fraction*100))
+
+	parts := []string{fmt.Sprintf("%d/%d files", e.FilesDone, e.FilesTotal)}
+	if e.BytesTotal > 0 {
+		parts = append(parts, fmt.Sprintf("%s of %s", pathutil.FormatSize(e.BytesDone), pathutil.FormatSize(e.BytesTotal)))
+	}
+	if e.ETA > 0 {
+		parts = append(parts, fmt.Sprintf("about %s left", e.ETA.Round(time.Second)))
+	}
+	s.WriteString(pb.styles.Hint.Render(strings.Join(parts, " · ")) + "\n")
+
+	if e.File != "" {
+		s.WriteString(pb.styles.Hint.Render(truncateLeft(e.File, width)) + "\n")
+	}
+	return s.String()
+}
+
+// phaseLabel returns the display name of a phase
+func phaseLabel(p progress.Phase) string {
+	switch p {
+	case progress.Collecting:
+		return "Collecting files"
+	case progress.Scanning:
+		return "Scanning for secrets"
+	case progress.Comparing:
+		return "Comparing with the last backup"
+	case progress.Writing:
+		return "Writing"
+	case progress.Restoring:
+		return "Restoring"
+	case progress.Done:
+		return "Finishing"
+	default:
+		return "Starting"
+	}
+}
+
+// truncateLeft shortens s to width runes, keeping its end
+func truncateLeft(s string, width int) string {
+	r := []rune(s)
+	if width <= 1 || len(r) <= width {
+		return s
+	}
+	return "…" + string(r[len(r)-width+1:])
+}
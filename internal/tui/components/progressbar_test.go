@@ -0,0 +1,43 @@
+package components
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diogo/dotkeeper/internal/progress"
+	"github.com/diogo/dotkeeper/internal/tui/styles"
+)
+
+func TestProgressBarView(t *testing.T) {
+	pb := NewProgressBar(styles.DefaultStyles())
+
+	out := stripANSI(pb.View(progress.Event{
+		Phase:      progress.Writing,
+		File:       "/home/user/.config/nvim/init.lua",
+		BytesDone:  512,
+		BytesTotal: 2048,
+		FilesDone:  1,
+		FilesTotal: 4,
+		ETA:        3 * time.Second,
+	}, 80))
+	for _, want := range []string{"Writing", "25%", "1/4 files", "512 B of 2.0 KB", "about 3s left", "init.lua", "█", "░"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("View() = %q, missing %q", out, want)
+		}
+	}
+
+	out = stripANSI(pb.View(progress.Event{Phase: progress.Collecting}, 80))
+	if !strings.Contains(out, "Collecting files...") || strings.Contains(out, "█") {
+		t.Errorf("View() without totals = %q, want only the phase", out)
+	}
+}
+
+func TestTruncateLeft(t *testing.T) {
+	if got := truncateLeft("/a/long/path", 6); got != "…/path" {
+		t.Errorf("truncateLeft() = %q", got)
+	}
+	if got := truncateLeft("short", 10); got != "short" {
+		t.Errorf("truncateLeft() = %q", got)
+	}
+}
@@ -58,6 +58,7 @@ func globalHelp() []views.HelpEntry {
 		{Key: "Tab", Description: "Next view"},
 		{Key: "Shift+Tab", Description: "Previous view"},
 		{Key: "1-5", Description: "Go to view"},
+		{Key: "p", Description: "Switch profile"},
 		{Key: "q", Description: "Quit"},
 		{Key: "?", Description: "Toggle help"},
 	}
@@ -8,6 +8,7 @@ type AppKeyMap struct {
 	Tab      key.Binding
 	ShiftTab key.Binding
 	Help     key.Binding
+	Profile  key.Binding
 }
 
 // DefaultKeyMap returns the default key bindings
@@ -29,6 +30,10 @@ func DefaultKeyMap() AppKeyMap {
 			key.WithKeys("?"),
 			key.WithHelp("?", "help"),
 		),
+		Profile: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "switch profile"),
+		),
 	}
 }
 
@@ -43,6 +48,7 @@ func (k AppKeyMap) ShortHelp() []key.Binding {
 func (k AppKeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Tab, k.ShiftTab},
+		{k.Profile},
 		{k.Help, k.Quit},
 	}
 }
@@ -0,0 +1,72 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/tui/components"
+	"github.com/diogo/dotkeeper/internal/tui/views"
+)
+
+// nextProfile returns the profile the switcher moves to from the config's
+// current one. It cycles through the top-level settings ("") and then each
+// profile in name order.
+func nextProfile(cfg *config.Config) string {
+	names := cfg.ProfileNames()
+	i := slices.Index(names, cfg.Profile())
+	if i+1 < len(names) {
+		return names[i+1]
+	}
+	return ""
+}
+
+// switchProfile applies the next profile and rebuilds the views on it
+func (m *Model) switchProfile() tea.Cmd {
+	cfg := m.GetConfig()
+	if cfg == nil || len(cfg.Profiles) == 0 {
+		return m.toast.Show("No profiles configured", components.ToastInfo)
+	}
+
+	name := nextProfile(cfg)
+	next := cfg.WithoutProfile()
+	if name != "" {
+		var err error
+		next, err = next.UseProfile(name)
+		if err != nil {
+			return m.toast.Show(fmt.Sprintf("Failed to switch profile: %v", err), components.ToastError)
+		}
+	}
+	config.SelectProfile(name)
+
+	m.cfg = next
+	m.ctx.Config = next
+	m.dashboard = views.NewDashboard(m.ctx)
+	m.backupList = views.NewBackupList(m.ctx)
+	m.restore = views.NewRestore(m.ctx)
+	m.settings = views.NewSettings(m.ctx)
+	m.logs = views.NewLogs(m.ctx)
+
+	cmds := []tea.Cmd{
+		m.dashboard.Init(),
+		m.backupList.Init(),
+		m.restore.Init(),
+		m.settings.Init(),
+		m.logs.Init(),
+		m.toast.Show("Profile: "+profileLabel(name), components.ToastInfo),
+	}
+	if m.width > 0 && m.height > 0 {
+		cmds = append(cmds, m.propagateWindowSize(tea.WindowSizeMsg{Width: m.width, Height: m.height}))
+	}
+	return tea.Batch(cmds...)
+}
+
+// profileLabel names a profile for display, "default" being the top-level
+// settings
+func profileLabel(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
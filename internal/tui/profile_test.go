@@ -0,0 +1,59 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/diogo/dotkeeper/internal/config"
+)
+
+func TestSwitchProfile(t *testing.T) {
+	t.Cleanup(func() { config.SelectProfile("") })
+
+	cfg := testConfig()
+	cfg.Profiles = map[string]*config.Profile{
+		"work":     {BackupDir: "/tmp/work", Files: []string{"~/.kube/config"}},
+		"personal": {Files: []string{"~/.gitconfig"}},
+	}
+	m := NewModelForTest(cfg, nil)
+
+	press := func() {
+		t.Helper()
+		updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")})
+		m = updated.(Model)
+	}
+
+	for _, want := range []string{"personal", "work", ""} {
+		press()
+		got := m.GetConfig()
+		if got.Profile() != want {
+			t.Fatalf("profile = %q, want %q", got.Profile(), want)
+		}
+		if config.SelectedProfile() != want {
+			t.Errorf("selected profile = %q, want %q", config.SelectedProfile(), want)
+		}
+	}
+
+	press()
+	press()
+	if got := m.GetConfig(); got.BackupDir != "/tmp/work" || got.Files[0] != "~/.kube/config" {
+		t.Errorf("work profile not applied: %+v", got)
+	}
+	if view := stripANSITest(m.View()); !strings.Contains(view, "[work]") {
+		t.Error("expected the title to show the active profile")
+	}
+}
+
+func TestSwitchProfile_NoProfiles(t *testing.T) {
+	m := NewModelForTest(testConfig(), nil)
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")})
+	m = updated.(Model)
+
+	if m.GetConfig().Profile() != "" {
+		t.Error("expected no profile to be applied")
+	}
+	if !strings.Contains(m.toast.Message, "No profiles") {
+		t.Errorf("toast = %q", m.toast.Message)
+	}
+}
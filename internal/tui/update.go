@@ -193,6 +193,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Batch(cmds...)
 		}
 
+		if key.Matches(msg, m.keys.Profile) && !m.isInputActive() {
+			return m, m.switchProfile()
+		}
+
 		// Number key navigation (only when not in input-consuming state)
 		if !m.isInputActive() {
 			var targetIdx int = -1
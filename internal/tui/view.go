@@ -37,7 +37,11 @@ func (m Model) View() string {
 
 	s := m.ctx.Styles
 
-	b.WriteString(s.AppTitle.Render("DotKeeper - Dotfiles Backup Manager"))
+	title := "DotKeeper - Dotfiles Backup Manager"
+	if cfg := m.GetConfig(); cfg != nil && cfg.Profile() != "" {
+		title += " [" + cfg.Profile() + "]"
+	}
+	b.WriteString(s.AppTitle.Render(title))
 	b.WriteString("\n")
 
 	tabBar := components.NewTabBar(s)
@@ -1,9 +1,10 @@
 package views
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
@@ -13,7 +14,9 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/diogo/dotkeeper/internal/backup"
 	"github.com/diogo/dotkeeper/internal/history"
+	"github.com/diogo/dotkeeper/internal/lock"
 	"github.com/diogo/dotkeeper/internal/pathutil"
+	"github.com/diogo/dotkeeper/internal/progress"
 	"github.com/diogo/dotkeeper/internal/tui/components"
 	"github.com/diogo/dotkeeper/internal/tui/styles"
 )
@@ -38,6 +41,16 @@ type BackupListModel struct {
 	backupError      string
 	spinner          spinner.Model
 	loading          bool
+	// run is the backup in progress and progress its latest event
+	run      *operationRun
+	progress progress.Event
+	// question is the secret finding the running backup waits on the
+	// user to approve
+	question *secretQuestion
+	// items holds every loaded backup; the list only shows those carrying
+	// tagFilter, when set
+	items     []list.Item
+	tagFilter string
 }
 
 const backupListViewChromeHeight = 5
@@ -75,25 +88,39 @@ func (m *BackupListModel) Refresh() tea.Cmd {
 
 func (m *BackupListModel) runBackup(password string) tea.Cmd {
 	m.loading = true
+	run := newOperationRun()
+	m.run = run
+	m.progress = progress.Event{}
 	return tea.Batch(
 		func() tea.Msg {
+			defer run.finish()
 			if m.ctx.Config == nil {
 				return BackupErrorMsg{Source: "backup", Err: fmt.Errorf("missing config")}
 			}
 			cfg := m.ctx.Config
 			cfg.BackupDir = pathutil.ExpandHome(cfg.BackupDir)
 
-			result, err := backup.Backup(cfg, password)
+			l, err := cfg.Lock(lock.Exclusive, "backup")
+			if err != nil {
+				return BackupErrorMsg{Source: "backup", Err: err}
+			}
+			defer l.Release()
+
+			result, err := backup.BackupWithOptions(run.ctx, cfg, password, backup.BackupOptions{
+				Progress:      run.report,
+				ConfirmSecret: run.confirmSecret,
+			})
 			if err != nil {
 				return BackupErrorMsg{Source: "backup", Err: err}
 			}
 			return BackupSuccessMsg{Result: result}
 		},
+		run.wait(),
 		m.spinner.Tick,
 	)
 }
 
-func (m *BackupListModel) deleteBackup(name string) tea.Cmd {
+func (m *BackupListModel) deleteBackup(name, password string) tea.Cmd {
 	m.loading = true
 	return tea.Batch(
 		func() tea.Msg {
@@ -101,13 +128,20 @@ func (m *BackupListModel) deleteBackup(name string) tea.Cmd {
 				return ErrorMsg{Source: "backup-delete", Err: fmt.Errorf("missing config")}
 			}
 			dir := pathutil.ExpandHome(m.ctx.Config.BackupDir)
-			encPath := filepath.Join(dir, name+".tar.gz.enc")
-			metaPath := encPath + ".meta.json"
+			target, err := backup.Resolve(dir, name)
+			if err != nil {
+				return ErrorMsg{Source: "backup-delete", Err: err}
+			}
 
-			if err := os.Remove(encPath); err != nil {
-				return ErrorMsg{Source: "backup-delete", Err: fmt.Errorf("delete %s: %w", filepath.Base(encPath), err)}
+			l, err := m.ctx.Config.Lock(lock.Exclusive, "delete")
+			if err != nil {
+				return ErrorMsg{Source: "backup-delete", Err: err}
+			}
+			defer l.Release()
+
+			if _, err := backup.Delete(target, password); err != nil {
+				return ErrorMsg{Source: "backup-delete", Err: fmt.Errorf("delete %s: %w", target.Name, err)}
 			}
-			os.Remove(metaPath)
 			return backupDeletedMsg{name: name}
 		},
 		m.spinner.Tick,
@@ -143,13 +177,46 @@ func (m BackupListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case backupsLoadedMsg:
 		m.loading = false
-		m.list.SetItems([]list.Item(msg))
+		m.items = []list.Item(msg)
+		if !slices.Contains(backupTags(m.items), m.tagFilter) {
+			m.tagFilter = ""
+		}
+		m.list.SetItems(m.filteredItems())
+		return m, nil
+
+	case progressMsg:
+		if msg.run != m.run {
+			return m, nil
+		}
+		m.progress = msg.event
+		return m, msg.run.wait()
+
+	case secretQuestionMsg:
+		if msg.run != m.run {
+			msg.question.answer <- false
+			return m, nil
+		}
+		m.question = &msg.question
 		return m, nil
 
 	case BackupSuccessMsg:
 		m.creatingBackup = false
 		m.loading = false
+		m.run = nil
+		m.question = nil
 		m.backupStatus = fmt.Sprintf("✓ Backup created: %s (%d files)", msg.Result.BackupName, msg.Result.FileCount)
+		if msg.Result.Unchanged {
+			m.backupStatus = fmt.Sprintf("✓ Nothing changed since %s; no backup created", msg.Result.BackupName)
+		}
+		if n := len(msg.Result.ExcludedSecrets); n > 0 {
+			m.backupStatus += fmt.Sprintf(", %d left out for secrets (see dotkeeper scan)", n)
+		}
+		if n := len(msg.Result.Snapshots); n > 0 {
+			m.backupStatus += fmt.Sprintf(", %d SQLite snapshots", n)
+		}
+		if n := len(msg.Result.ChangedDuringBackup); n > 0 {
+			m.backupStatus += fmt.Sprintf(", %d changed during backup", n)
+		}
 		m.backupError = ""
 		m.passwordInput.SetValue("")
 		if m.ctx.Store != nil {
@@ -161,8 +228,14 @@ func (m BackupListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.Source == "backup" {
 			m.creatingBackup = false
 			m.loading = false
+			m.run = nil
+			m.question = nil
 			m.backupStatus = ""
 			m.backupError = fmt.Sprintf("✗ Backup failed: %v", msg.Err)
+			if errors.Is(msg.Err, context.Canceled) {
+				m.backupStatus = "Backup cancelled; nothing was written"
+				m.backupError = ""
+			}
 			m.passwordInput.SetValue("")
 			if m.ctx.Store != nil {
 				_ = m.ctx.Store.Append(history.EntryFromBackupError(msg.Err))
@@ -186,14 +259,38 @@ func (m BackupListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		if m.confirmingDelete {
 			switch msg.String() {
-			case "y", "Y":
+			case "enter":
+				password := m.passwordInput.Value()
+				if password == "" {
+					return m, nil
+				}
 				m.loading = true
-				return m, m.deleteBackup(m.deleteTarget)
-			default:
+				m.passwordInput.SetValue("")
+				m.passwordInput.Blur()
+				return m, m.deleteBackup(m.deleteTarget, password)
+			case "esc":
 				m.confirmingDelete = false
 				m.deleteTarget = ""
+				m.passwordInput.SetValue("")
+				m.passwordInput.Blur()
 				return m, nil
 			}
+
+			var cmd tea.Cmd
+			m.passwordInput, cmd = m.passwordInput.Update(msg)
+			return m, cmd
+		}
+
+		if m.run != nil {
+			if m.question != nil {
+				return m.answerSecret(msg.String())
+			}
+			// Keys only cancel a running backup
+			if msg.String() == "esc" {
+				m.run.cancel()
+				m.backupStatus = "Cancelling backup..."
+			}
+			return m, nil
 		}
 
 		if m.creatingBackup {
@@ -231,10 +328,16 @@ func (m BackupListModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.deleteTarget = selected.name
 				m.backupStatus = ""
 				m.backupError = ""
-				return m, nil
+				m.passwordInput.Focus()
+				return m, textinput.Blink
 			}
 		case "r":
 			return m, tea.Batch(m.Refresh(), m.spinner.Tick)
+		case "t":
+			m.tagFilter = nextTag(backupTags(m.items), m.tagFilter)
+			m.list.SetItems(m.filteredItems())
+			m.list.ResetSelected()
+			return m, nil
 		}
 	}
 
@@ -263,6 +366,25 @@ func (m BackupListModel) View() string {
 	if m.confirmingDelete {
 		s.WriteString(st.Title.Render("Delete Backup") + "\n\n")
 		s.WriteString(fmt.Sprintf("Are you sure you want to delete %s?\n\n", st.Value.Render(m.deleteTarget)))
+		s.WriteString("Enter encryption password to confirm:\n\n")
+		s.WriteString(m.passwordInput.View() + "\n")
+		return s.String()
+	}
+
+	if m.run != nil && m.question != nil {
+		s.WriteString(st.Title.Render("Possible Secrets") + "\n\n")
+		s.WriteString(fmt.Sprintf("%s may contain secrets:\n\n", st.Value.Render(m.question.path)))
+		for _, f := range m.question.findings {
+			s.WriteString(fmt.Sprintf("  - %s\n", f))
+		}
+		s.WriteString("\nBack it up anyway?\n")
+		return s.String()
+	}
+
+	if m.run != nil {
+		s.WriteString(st.Title.Render("Creating Backup") + "\n\n")
+		s.WriteString(components.NewProgressBar(st).View(m.progress, m.ctx.Width) + "\n")
+		s.WriteString(RenderStatusBar(m.ctx.Width, m.backupStatus, m.backupError, "", st))
 		return s.String()
 	}
 
@@ -274,7 +396,11 @@ func (m BackupListModel) View() string {
 		return s.String()
 	}
 
-	s.WriteString(st.Title.Render("Backups") + "\n\n")
+	title := "Backups"
+	if m.tagFilter != "" {
+		title += " tagged " + m.tagFilter
+	}
+	s.WriteString(st.Title.Render(title) + "\n\n")
 	s.WriteString(m.list.View())
 	s.WriteString("\n")
 
@@ -284,10 +410,22 @@ func (m BackupListModel) View() string {
 }
 
 func (m BackupListModel) HelpBindings() []HelpEntry {
+	if m.run != nil && m.question != nil {
+		return []HelpEntry{
+			{"y", "Back up the file"},
+			{"Esc", "Cancel backup"},
+			{"any", "Leave the file out"},
+		}
+	}
+	if m.run != nil {
+		return []HelpEntry{
+			{"Esc", "Cancel backup"},
+		}
+	}
 	if m.confirmingDelete {
 		return []HelpEntry{
-			{"y", "Confirm delete"},
-			{"any", "Cancel"},
+			{"Enter", "Confirm delete"},
+			{"Esc", "Cancel"},
 		}
 	}
 	if m.creatingBackup {
@@ -300,18 +438,84 @@ func (m BackupListModel) HelpBindings() []HelpEntry {
 		{"n/c", "New backup"},
 		{"d", "Delete backup"},
 		{"r", "Refresh list"},
+		{"t", "Filter by tag"},
 		{"↑/↓", "Navigate"},
 	}
 }
 
 func (m BackupListModel) StatusHelpText() string {
+	if m.run != nil && m.question != nil {
+		return "y: back up the file | Esc: cancel backup | any other key: leave it out"
+	}
+	if m.run != nil {
+		return "Esc: cancel backup"
+	}
 	if m.confirmingDelete {
-		return "y: confirm | any other key: cancel"
+		return "Enter the password and press Enter to delete, Esc to cancel"
 	}
 	if m.creatingBackup {
 		return "Press Enter to create backup, Esc to cancel"
 	}
-	return "n: new backup | d: delete | r: refresh | ↑/↓: navigate"
+	return "n: new backup | d: delete | r: refresh | t: filter by tag | ↑/↓: navigate"
+}
+
+// answerSecret answers the running backup's question with the pressed key:
+// y backs the file up, Esc cancels the backup and anything else leaves the
+// file out. The view then waits on the backup again.
+func (m BackupListModel) answerSecret(key string) (tea.Model, tea.Cmd) {
+	q := m.question
+	m.question = nil
+	switch key {
+	case "y", "Y":
+		q.answer <- true
+	case "esc":
+		m.run.cancel()
+		m.backupStatus = "Cancelling backup..."
+	default:
+		q.answer <- false
+	}
+	return m, m.run.wait()
+}
+
+// filteredItems returns the loaded backups carrying the tag filter, or all
+// of them when there is none
+func (m BackupListModel) filteredItems() []list.Item {
+	if m.tagFilter == "" {
+		return m.items
+	}
+	var items []list.Item
+	for _, item := range m.items {
+		if item.(backupItem).labels.HasTag(m.tagFilter) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// backupTags returns the tags of items, sorted and without duplicates
+func backupTags(items []list.Item) []string {
+	var tags []string
+	for _, item := range items {
+		tags = append(tags, item.(backupItem).labels.Tags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// nextTag returns the tag after current in tags, cycling back to no filter
+// after the last one
+func nextTag(tags []string, current string) string {
+	if current == "" {
+		if len(tags) == 0 {
+			return ""
+		}
+		return tags[0]
+	}
+	i := slices.Index(tags, current)
+	if i < 0 || i == len(tags)-1 {
+		return ""
+	}
+	return tags[i+1]
 }
 
 func (m BackupListModel) IsCreating() bool {
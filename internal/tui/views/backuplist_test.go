@@ -1,6 +1,8 @@
 package views
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -8,7 +10,10 @@ import (
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/diogo/dotkeeper/internal/backup"
 	"github.com/diogo/dotkeeper/internal/config"
+	"github.com/diogo/dotkeeper/internal/progress"
+	"github.com/diogo/dotkeeper/internal/secrets"
 )
 
 func TestNewBackupList(t *testing.T) {
@@ -30,6 +35,9 @@ func TestNewBackupList(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create dummy file %s: %v", b, err)
 		}
+		if strings.HasSuffix(b, ".enc") {
+			os.WriteFile(path+".meta.json", []byte("{}"), 0644)
+		}
 		os.Chtimes(path, time.Now(), time.Now())
 	}
 
@@ -130,7 +138,7 @@ func TestBackupListModel_Delete(t *testing.T) {
 		t.Errorf("Expected deleteTarget 'backup-20231026-100000', got %q", model.deleteTarget)
 	}
 
-	updated, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	updated, _ = model.Update(tea.KeyMsg{Type: tea.KeyEsc})
 	model = updated.(BackupListModel)
 
 	if model.confirmingDelete {
@@ -140,7 +148,15 @@ func TestBackupListModel_Delete(t *testing.T) {
 	updated, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'d'}})
 	model = updated.(BackupListModel)
 
-	updated, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	// Deleting takes the password, to check the backup is not pinned
+	updated, cmd := model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	model = updated.(BackupListModel)
+	if !model.confirmingDelete || model.loading {
+		t.Fatal("Expected Enter without a password to be ignored")
+	}
+	updated, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("pw")})
+	model = updated.(BackupListModel)
+	updated, cmd = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
 	model = updated.(BackupListModel)
 
 	if cmd == nil {
@@ -178,6 +194,7 @@ func TestBackupListModel_Delete(t *testing.T) {
 func TestBackupListModel_DeleteBlocksTabNavigation(t *testing.T) {
 	tempDir := t.TempDir()
 	os.WriteFile(filepath.Join(tempDir, "backup-20231026-100000.tar.gz.enc"), []byte("x"), 0600)
+	os.WriteFile(filepath.Join(tempDir, "backup-20231026-100000.tar.gz.enc.meta.json"), []byte("{}"), 0600)
 
 	cfg := &config.Config{BackupDir: tempDir}
 	model := NewBackupList(NewProgramContext(cfg, nil))
@@ -225,3 +242,107 @@ func TestBackupListStatusHelpText(t *testing.T) {
 		t.Errorf("StatusHelpText should contain 'navigate', got: %s", helpText)
 	}
 }
+
+func TestBackupListModel_ProgressAndCancel(t *testing.T) {
+	model := NewBackupList(NewProgramContext(&config.Config{BackupDir: t.TempDir()}, nil))
+	model.creatingBackup = true
+	run := newOperationRun()
+	model.run = run
+
+	run.report(progress.Event{Operation: "backup", Phase: progress.Writing, FilesDone: 1, FilesTotal: 4, BytesTotal: 100, BytesDone: 50})
+	updated, cmd := model.Update(run.wait()())
+	model = updated.(BackupListModel)
+	if cmd == nil {
+		t.Error("a progress message should wait for the next event")
+	}
+	view := stripANSI(model.View())
+	if !strings.Contains(view, "1/4 files") || !strings.Contains(view, "50%") {
+		t.Errorf("view should show the progress bar:\n%s", view)
+	}
+	if !strings.Contains(model.StatusHelpText(), "cancel") {
+		t.Errorf("status help = %q, want the cancel key", model.StatusHelpText())
+	}
+
+	updated, _ = model.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	model = updated.(BackupListModel)
+	if run.ctx.Err() == nil {
+		t.Error("Esc should cancel the running backup")
+	}
+
+	updated, _ = model.Update(BackupErrorMsg{Source: "backup", Err: fmt.Errorf("backup cancelled: %w", context.Canceled)})
+	model = updated.(BackupListModel)
+	if model.run != nil || model.backupError != "" || !strings.Contains(model.backupStatus, "cancelled") {
+		t.Errorf("after cancelling: status=%q error=%q", model.backupStatus, model.backupError)
+	}
+}
+
+func TestBackupListModel_ConfirmSecret(t *testing.T) {
+	model := NewBackupList(NewProgramContext(&config.Config{BackupDir: t.TempDir()}, nil))
+	model.creatingBackup = true
+	run := newOperationRun()
+	model.run = run
+	defer run.cancel()
+
+	findings := []secrets.Finding{{Path: "/home/u/.ssh/id_ed25519", Rule: "private-key", Description: "private key", Line: 1, Action: secrets.Confirm}}
+	answers := make(chan bool, 2)
+	go func() {
+		answers <- run.confirmSecret("/home/u/.ssh/id_ed25519", findings)
+		answers <- run.confirmSecret("/home/u/.netrc", nil)
+	}()
+
+	// The backup waits on the answer while the view shows the question
+	updated, _ := model.Update(run.wait()())
+	model = updated.(BackupListModel)
+	view := stripANSI(model.View())
+	if !strings.Contains(view, "id_ed25519 may contain secrets") || !strings.Contains(view, "private-key") {
+		t.Errorf("view should show the question:\n%s", view)
+	}
+	if !strings.Contains(model.StatusHelpText(), "y: back up") {
+		t.Errorf("status help = %q", model.StatusHelpText())
+	}
+
+	updated, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	model = updated.(BackupListModel)
+	if ok := <-answers; !ok || model.question != nil || cmd == nil {
+		t.Fatalf("y should approve the file and wait on the backup again: answer %v", ok)
+	}
+
+	updated, _ = model.Update(cmd())
+	model = updated.(BackupListModel)
+	updated, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+	model = updated.(BackupListModel)
+	if ok := <-answers; ok {
+		t.Error("n should leave the file out")
+	}
+
+	// A cancelled backup approves nothing
+	run.cancel()
+	if run.confirmSecret("/home/u/.aws/credentials", nil) {
+		t.Error("a cancelled backup should not approve files")
+	}
+}
+
+func TestBackupListModel_TagFilter(t *testing.T) {
+	model := NewBackupList(NewProgramContext(&config.Config{BackupDir: "."}, nil))
+	updated, _ := model.Update(backupsLoadedMsg{
+		backupItem{name: "backup-1", labels: backup.Labels{Tags: []string{"x11"}}},
+		backupItem{name: "backup-2", labels: backup.Labels{Tags: []string{"hyprland", "x11"}, Pinned: true}},
+		backupItem{name: "backup-3"},
+	})
+
+	// t cycles through the tags in order, then back to every backup
+	for _, want := range []struct {
+		tag   string
+		count int
+	}{{"hyprland", 1}, {"x11", 2}, {"", 3}} {
+		updated, _ = updated.(BackupListModel).Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("t")})
+		m := updated.(BackupListModel)
+		if m.tagFilter != want.tag || len(m.list.Items()) != want.count {
+			t.Errorf("filter %q shows %d backups, want %q showing %d", m.tagFilter, len(m.list.Items()), want.tag, want.count)
+		}
+	}
+
+	if desc := (backupItem{date: "2025-01-01 12:00", size: 10, labels: backup.Labels{Tags: []string{"x11"}, Pinned: true}}).Description(); desc != "2025-01-01 12:00 - 10 bytes - pinned - x11" {
+		t.Errorf("Description() = %q", desc)
+	}
+}
@@ -2,13 +2,12 @@ package views
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/diogo/dotkeeper/internal/backup"
 	"github.com/diogo/dotkeeper/internal/pathutil"
 	"github.com/diogo/dotkeeper/internal/tui/styles"
 )
@@ -194,16 +193,12 @@ func (m *DashboardModel) refreshStatus() tea.Cmd {
 			return statusMsg{}
 		}
 
-		result := pathutil.ScanPaths(m.ctx.Config.ActiveFiles(), m.ctx.Config.ActiveFolders(), m.ctx.Config.Exclude)
+		result := backup.Scan(m.ctx.Config.ActiveFiles(), m.ctx.Config.ActiveFolders(), m.ctx.Config.Exclude, m.ctx.Config.Symlinks)
 
 		var lastBackup time.Time
 		dir := pathutil.ExpandHome(m.ctx.Config.BackupDir)
-		backups, _ := filepath.Glob(filepath.Join(dir, "backup-*.tar.gz.enc"))
-		if len(backups) > 0 {
-			info, _ := os.Stat(backups[len(backups)-1])
-			if info != nil {
-				lastBackup = info.ModTime()
-			}
+		if backups, _ := backup.List(dir); len(backups) > 0 {
+			lastBackup = backups[0].Created
 		}
 
 		return statusMsg{
@@ -4,48 +4,57 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/diogo/dotkeeper/internal/backup"
 	"github.com/diogo/dotkeeper/internal/pathutil"
 	"github.com/diogo/dotkeeper/internal/tui/styles"
 )
 
 // backupItem represents a backup entry in lists
 type backupItem struct {
-	name string
-	size int64
-	date string
+	name   string
+	path   string
+	size   int64
+	date   string
+	labels backup.Labels
 }
 
-func (i backupItem) Title() string       { return i.name }
-func (i backupItem) Description() string { return fmt.Sprintf("%s - %d bytes", i.date, i.size) }
+func (i backupItem) Title() string { return i.name }
+func (i backupItem) Description() string {
+	desc := fmt.Sprintf("%s - %d bytes", i.date, i.size)
+	if i.labels.Pinned {
+		desc += " - pinned"
+	}
+	if len(i.labels.Tags) > 0 {
+		desc += " - " + strings.Join(i.labels.Tags, ", ")
+	}
+	if i.labels.Note != "" {
+		desc += " - " + i.labels.Note
+	}
+	return desc
+}
 func (i backupItem) FilterValue() string { return i.name }
 
 // backupsLoadedMsg carries loaded backup items to the view.
 type backupsLoadedMsg []list.Item
 
-// LoadBackupItems scans a backup directory and returns backup items sorted newest-first.
+// LoadBackupItems scans a backup directory for archives and repository
+// snapshots and returns backup items sorted newest-first.
 func LoadBackupItems(backupDir string) []list.Item {
-	dir := pathutil.ExpandHome(backupDir)
-	paths, _ := filepath.Glob(filepath.Join(dir, "backup-*.tar.gz.enc"))
-
-	for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
-		paths[i], paths[j] = paths[j], paths[i]
-	}
-
-	items := make([]list.Item, 0, len(paths))
-	for _, p := range paths {
-		if info, err := os.Stat(p); err == nil && info != nil {
-			name := strings.TrimSuffix(filepath.Base(p), ".tar.gz.enc")
-			items = append(items, backupItem{
-				name: name,
-				size: info.Size(),
-				date: info.ModTime().Format("2006-01-02 15:04"),
-			})
-		}
+	backups, _ := backup.List(pathutil.ExpandHome(backupDir))
+
+	items := make([]list.Item, 0, len(backups))
+	for _, b := range backups {
+		items = append(items, backupItem{
+			name:   b.ID(),
+			path:   b.Path,
+			size:   b.Size,
+			date:   b.Created.Format("2006-01-02 15:04"),
+			labels: b.Labels,
+		})
 	}
 	return items
 }
@@ -62,7 +71,7 @@ type PathValidationResult struct {
 
 func ValidatePath(path string) PathValidationResult {
 	result := PathValidationResult{
-		ExpandedPath: pathutil.ExpandHome(path),
+		ExpandedPath: pathutil.ExpandPath(path),
 	}
 
 	// Check if path exists
@@ -134,6 +143,51 @@ func ValidateFolderPath(path string) (string, error) {
 	return result.ExpandedPath, nil
 }
 
+// configEntry returns what to store in the config for a validated path.
+// Paths written with environment variables are kept as typed so they
+// follow the variables; other paths are stored expanded.
+func configEntry(value, expanded string) string {
+	if strings.Contains(value, "$") {
+		return value
+	}
+	return expanded
+}
+
+// maxPreviewMatches is how many matches a glob preview lists
+const maxPreviewMatches = 5
+
+// globPreview describes what a glob entry matches right now, or returns
+// "" when value is not a glob. Globs are stored as typed and matched again
+// on every backup, so the matches are only a preview.
+func globPreview(value string, exclude []string) string {
+	value = strings.TrimSpace(value)
+	if !pathutil.IsGlobPattern(value) {
+		return ""
+	}
+	matches, err := pathutil.ResolveGlob(value, exclude)
+	if err != nil {
+		return err.Error()
+	}
+	if len(matches) == 0 {
+		return "No matches yet; new files are picked up on every backup"
+	}
+
+	var b strings.Builder
+	if len(matches) == 1 {
+		b.WriteString("Currently matches 1 path:")
+	} else {
+		fmt.Fprintf(&b, "Currently matches %d paths:", len(matches))
+	}
+	for i, match := range matches {
+		if i == maxPreviewMatches {
+			fmt.Fprintf(&b, "\n  … and %d more", len(matches)-i)
+			break
+		}
+		b.WriteString("\n  • " + match)
+	}
+	return b.String()
+}
+
 // HelpEntry represents a single keyboard shortcut entry
 type HelpEntry struct {
 	Key         string
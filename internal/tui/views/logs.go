@@ -33,8 +33,11 @@ type logItem struct {
 
 func (i logItem) Title() string {
 	status := "✓"
-	if i.entry.Status == "error" {
+	switch i.entry.Status {
+	case "error":
 		status = "✗"
+	case "unchanged":
+		status = "= unchanged"
 	}
 	// Local time "2006-01-02 15:04"
 	ts := i.entry.Timestamp.Local().Format("2006-01-02 15:04")
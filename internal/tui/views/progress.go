@@ -0,0 +1,106 @@
+package views
+
+import (
+	"context"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/diogo/dotkeeper/internal/progress"
+	"github.com/diogo/dotkeeper/internal/secrets"
+)
+
+// progressMsg carries the latest progress event of a running operation
+type progressMsg struct {
+	run   *operationRun
+	event progress.Event
+}
+
+// secretQuestion asks whether to back up a file with possible secrets;
+// the view sends the user's answer on answer
+type secretQuestion struct {
+	path     string
+	findings []secrets.Finding
+	answer   chan bool
+}
+
+// secretQuestionMsg carries a question of a running backup to its view
+type secretQuestionMsg struct {
+	run      *operationRun
+	question secretQuestion
+}
+
+// operationRun connects a backup or restore running in a command to its
+// view: the view cancels it, receives its progress events and answers its
+// questions.
+type operationRun struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	events    chan progress.Event
+	questions chan secretQuestion
+}
+
+func newOperationRun() *operationRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &operationRun{
+		ctx:    ctx,
+		cancel: cancel,
+		// Only the latest event is kept, so a slow redraw never holds
+		// the operation up
+		events:    make(chan progress.Event, 1),
+		questions: make(chan secretQuestion),
+	}
+}
+
+// report is the operation's progress.Func; it replaces an event the view
+// has not picked up yet
+func (r *operationRun) report(e progress.Event) {
+	for {
+		select {
+		case r.events <- e:
+			return
+		default:
+		}
+		select {
+		case <-r.events:
+		default:
+		}
+	}
+}
+
+// confirmSecret is the backup's ConfirmSecret: it asks the view and waits
+// for the user's answer. Nothing is approved once the run is cancelled.
+func (r *operationRun) confirmSecret(path string, findings []secrets.Finding) bool {
+	q := secretQuestion{path: path, findings: findings, answer: make(chan bool, 1)}
+	select {
+	case r.questions <- q:
+	case <-r.ctx.Done():
+		return false
+	}
+	select {
+	case ok := <-q.answer:
+		return ok
+	case <-r.ctx.Done():
+		return false
+	}
+}
+
+// finish is called by the operation when it returns
+func (r *operationRun) finish() {
+	r.cancel()
+	close(r.events)
+}
+
+// wait returns a command delivering the next progress event or question;
+// it delivers nothing once the operation has finished
+func (r *operationRun) wait() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case e, ok := <-r.events:
+			if !ok {
+				return nil
+			}
+			return progressMsg{run: r, event: e}
+		case q := <-r.questions:
+			return secretQuestionMsg{run: r, question: q}
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package views
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,8 +14,11 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/diogo/dotkeeper/internal/backup"
+	"github.com/diogo/dotkeeper/internal/capture"
 	"github.com/diogo/dotkeeper/internal/history"
-	"github.com/diogo/dotkeeper/internal/pathutil"
+	"github.com/diogo/dotkeeper/internal/lock"
+	"github.com/diogo/dotkeeper/internal/progress"
 	"github.com/diogo/dotkeeper/internal/restore"
 	"github.com/diogo/dotkeeper/internal/tui/components"
 	"github.com/diogo/dotkeeper/internal/tui/styles"
@@ -40,7 +45,7 @@ type RestoreModel struct {
 	backupList       list.Model
 	phase            restorePhase
 	selectedBackup   string
-	password         string // validated password for restore
+	session          *restore.Session // backup opened with the validated password
 	passwordInput    textinput.Model
 	fileList         list.Model
 	selectedFiles    map[string]bool
@@ -53,15 +58,20 @@ type RestoreModel struct {
 	restoreResult    *restore.RestoreResult // result of restore operation
 	spinner          spinner.Model
 	loading          bool
+	// run is the restore in progress and progress its latest event
+	run      *operationRun
+	progress progress.Event
 }
 
-type passwordValidMsg struct{}
+type passwordValidMsg struct {
+	session *restore.Session
+}
 
 // passwordInvalidMsg is consolidated to ErrorMsg with Source="restore-password".
 type passwordInvalidMsg = ErrorMsg
 
 type filesLoadedMsg struct {
-	files []restore.FileEntry
+	files []backup.ManifestEntry
 }
 
 type diffLoadedMsg struct {
@@ -144,17 +154,20 @@ func (m RestoreModel) refreshBackups() tea.Cmd {
 
 func (m RestoreModel) validatePassword(backupPath, password string) tea.Cmd {
 	return func() tea.Msg {
-		err := restore.ValidateBackup(backupPath, password)
+		// Opening the session derives the key once and reads the entry
+		// index, which proves the password without reading the archive
+		session, err := restore.OpenSession(backupPath, password)
 		if err != nil {
 			return passwordInvalidMsg{Source: "restore-password", Err: err}
 		}
-		return passwordValidMsg{}
+		return passwordValidMsg{session: session}
 	}
 }
 
-func (m RestoreModel) loadFiles(backupPath, password string) tea.Cmd {
+func (m RestoreModel) loadFiles() tea.Cmd {
+	session := m.session
 	return func() tea.Msg {
-		entries, err := restore.ListBackupContents(backupPath, password)
+		entries, err := session.Entries()
 		if err != nil {
 			return passwordInvalidMsg{Source: "restore-password", Err: fmt.Errorf("failed to load files: %w", err)}
 		}
@@ -164,7 +177,7 @@ func (m RestoreModel) loadFiles(backupPath, password string) tea.Cmd {
 
 func (m RestoreModel) loadDiff(filePath string) tea.Cmd {
 	return func() tea.Msg {
-		diff, err := restore.GetFileDiff(m.selectedBackup, m.password, filePath)
+		diff, err := m.session.Diff(filePath)
 		if err != nil {
 			if _, statErr := os.Stat(filePath); os.IsNotExist(statErr) {
 				return diffLoadedMsg{
@@ -184,13 +197,28 @@ func (m RestoreModel) loadDiff(filePath string) tea.Cmd {
 	}
 }
 
-func (m RestoreModel) runRestore() tea.Cmd {
+func (m RestoreModel) runRestore(run *operationRun) tea.Cmd {
 	return func() tea.Msg {
+		defer run.finish()
 		opts := restore.RestoreOptions{
 			SelectedFiles: m.getSelectedFilePaths(),
+			Progress:      run.report,
+		}
+		if m.ctx.Config != nil {
+			opts.Hooks = m.ctx.Config.Hooks
+			opts.HookEnv = map[string]string{
+				"DOTKEEPER_BACKUP_DIR": m.ctx.Config.BackupDir,
+				"DOTKEEPER_PROFILE":    m.ctx.Config.Profile(),
+			}
+
+			l, err := m.ctx.Config.Lock(lock.Shared, "restore")
+			if err != nil {
+				return ErrorMsg{Source: "restore", Err: err}
+			}
+			defer l.Release()
 		}
 
-		result, err := restore.Restore(m.selectedBackup, m.password, opts)
+		result, err := m.session.Restore(run.ctx, opts)
 		if err != nil {
 			return ErrorMsg{Source: "restore", Err: err}
 		}
@@ -238,8 +266,7 @@ func (m RestoreModel) handleBackupListKey(msg tea.KeyMsg) (RestoreModel, tea.Cmd
 				m.restoreError = "Missing config"
 				return m, nil
 			}
-			backupPath := filepath.Join(pathutil.ExpandHome(m.ctx.Config.BackupDir), selected.name+".tar.gz.enc")
-			m.selectedBackup = backupPath
+			m.selectedBackup = selected.path
 			m.passwordInput.SetValue("")
 			m.restoreError = ""
 			m.phase = phasePassword
@@ -317,12 +344,14 @@ func (m RestoreModel) handleFileSelectKey(msg tea.KeyMsg) (RestoreModel, tea.Cmd
 			m.phase = phaseRestoring
 			m.restoreStatus = fmt.Sprintf("Restoring %d files...", selectedCount)
 			m.restoreError = ""
-			return m, m.runRestore()
+			m.run = newOperationRun()
+			m.progress = progress.Event{}
+			return m, tea.Batch(m.runRestore(m.run), m.run.wait())
 		}
 	case "esc":
 		m.phase = phaseBackupList
 		m.selectedFiles = make(map[string]bool)
-		m.password = ""
+		m.session = nil
 		m.restoreError = ""
 		m.loading = false
 		m.passwordInput.SetValue("")
@@ -335,6 +364,14 @@ func (m RestoreModel) handleFileSelectKey(msg tea.KeyMsg) (RestoreModel, tea.Cmd
 	return m, nil
 }
 
+func (m RestoreModel) handleRestoringKey(msg tea.KeyMsg) (RestoreModel, tea.Cmd) {
+	if msg.String() == "esc" && m.run != nil {
+		m.run.cancel()
+		m.restoreStatus = "Cancelling restore..."
+	}
+	return m, nil
+}
+
 func (m RestoreModel) handleDiffPreviewKey(msg tea.KeyMsg) (RestoreModel, tea.Cmd) {
 	switch msg.String() {
 	case "j", "down":
@@ -363,7 +400,7 @@ func (m RestoreModel) handleResultsKey() (RestoreModel, tea.Cmd) {
 	m.phase = phaseBackupList
 	m.restoreResult = nil
 	m.selectedFiles = make(map[string]bool)
-	m.password = ""
+	m.session = nil
 	m.restoreError = ""
 	m.restoreStatus = ""
 	m.passwordInput.SetValue("")
@@ -419,12 +456,12 @@ func (m RestoreModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case passwordValidMsg:
-		m.password = m.passwordInput.Value()
+		m.session = msg.session
 		m.phase = phaseFileSelect
 		m.restoreStatus = "Loading files..."
 		m.restoreError = ""
 		m.passwordAttempts = 0
-		return m, m.loadFiles(m.selectedBackup, m.password)
+		return m, m.loadFiles()
 
 	case passwordInvalidMsg:
 		if msg.Source == "restore-password" {
@@ -449,7 +486,11 @@ func (m RestoreModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		} else if msg.Source == "restore" {
 			m.loading = false
+			m.run = nil
 			m.restoreError = fmt.Sprintf("Restore failed: %v", msg.Err)
+			if errors.Is(msg.Err, context.Canceled) {
+				m.restoreError = "Restore cancelled; files restored so far were kept"
+			}
 			m.restoreResult = nil
 			m.phase = phaseResults
 			m.restoreStatus = ""
@@ -461,18 +502,23 @@ func (m RestoreModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case filesLoadedMsg:
 		m.loading = false
-		items := make([]list.Item, len(msg.files))
+		items := make([]list.Item, 0, len(msg.files))
 		m.selectedFiles = make(map[string]bool)
-		for i, entry := range msg.files {
-			items[i] = fileItem{
+		for _, entry := range msg.files {
+			// Command captures are extracted with the CLI, not restored
+			// in place, and directories come along with their files
+			if _, ok := capture.Name(entry.Path); ok || entry.Dir {
+				continue
+			}
+			items = append(items, fileItem{
 				path:     entry.Path,
-				size:     int64(len(entry.Content)),
+				size:     entry.Size,
 				selected: false,
-			}
+			})
 			m.selectedFiles[entry.Path] = false
 		}
 		m.fileList.SetItems(items)
-		m.restoreStatus = fmt.Sprintf("Loaded %d files", len(msg.files))
+		m.restoreStatus = fmt.Sprintf("Loaded %d files", len(items))
 		m.restoreError = ""
 		return m, nil
 
@@ -487,8 +533,16 @@ func (m RestoreModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.restoreError = ""
 		return m, nil
 
+	case progressMsg:
+		if msg.run != m.run {
+			return m, nil
+		}
+		m.progress = msg.event
+		return m, msg.run.wait()
+
 	case restoreCompleteMsg:
 		m.loading = false
+		m.run = nil
 		m.restoreResult = msg.result
 		m.phase = phaseResults
 		m.restoreStatus = ""
@@ -510,6 +564,9 @@ func (m RestoreModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case phaseFileSelect:
 			m, cmd = m.handleFileSelectKey(msg)
 			return m, cmd
+		case phaseRestoring:
+			m, cmd = m.handleRestoringKey(msg)
+			return m, cmd
 		case phaseDiffPreview:
 			m, cmd = m.handleDiffPreviewKey(msg)
 			return m, cmd
@@ -582,11 +639,12 @@ func (m RestoreModel) renderFileSelect() string {
 
 // renderRestoring renders the restoring in progress phase
 func (m RestoreModel) renderRestoring() string {
-	return lipgloss.JoinVertical(lipgloss.Center,
-		"\n",
-		m.spinner.View(),
-		"\n"+m.restoreStatus+"...",
-	)
+	st := m.ctx.Styles
+	var s strings.Builder
+	s.WriteString(st.Title.Render("Restoring") + "\n\n")
+	s.WriteString(components.NewProgressBar(st).View(m.progress, m.ctx.Width) + "\n")
+	s.WriteString(RenderStatusBar(m.ctx.Width, m.restoreStatus, m.restoreError, "", st))
+	return s.String()
 }
 
 // renderDiffPreview renders the diff preview phase
@@ -630,6 +688,9 @@ func (m RestoreModel) renderResults() string {
 		if m.restoreResult.FilesSkipped > 0 {
 			s.WriteString(fmt.Sprintf("  %d files skipped\n", m.restoreResult.FilesSkipped))
 		}
+		if len(m.restoreResult.MetadataErrors) > 0 {
+			s.WriteString(st.Error.Render(fmt.Sprintf("  %d metadata changes could not be applied", len(m.restoreResult.MetadataErrors))) + "\n")
+		}
 
 		s.WriteString("\n")
 
@@ -695,6 +756,10 @@ func (m RestoreModel) HelpBindings() []HelpEntry {
 			{"Enter", "Restore"},
 			{"Esc", "Back"},
 		}
+	case phaseRestoring:
+		return []HelpEntry{
+			{"Esc", "Cancel restore"},
+		}
 	case phaseDiffPreview:
 		return []HelpEntry{
 			{"j/k", "Scroll"},
@@ -719,7 +784,7 @@ func (m RestoreModel) StatusHelpText() string {
 	case phaseFileSelect:
 		return "Space: toggle | a: all | n: none | d: diff | Enter: restore | Esc: back"
 	case phaseRestoring:
-		return "Please wait..."
+		return "Esc: cancel restore"
 	case phaseDiffPreview:
 		return "j/k or ↑/↓: scroll | g/G: top/bottom | Esc: back"
 	case phaseResults:
@@ -730,5 +795,5 @@ func (m RestoreModel) StatusHelpText() string {
 }
 
 func (m RestoreModel) IsInputActive() bool {
-	return m.phase == phasePassword
+	return m.phase == phasePassword || m.phase == phaseRestoring
 }
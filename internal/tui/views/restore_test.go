@@ -63,6 +63,11 @@ func TestRestoreBackupListLoad(t *testing.T) {
 		if err := os.WriteFile(path, []byte("dummy"), 0644); err != nil {
 			t.Fatalf("Failed to create file: %v", err)
 		}
+		if strings.HasSuffix(b, ".enc") {
+			if err := os.WriteFile(path+".meta.json", []byte("{}"), 0644); err != nil {
+				t.Fatalf("Failed to create metadata: %v", err)
+			}
+		}
 		os.Chtimes(path, time.Now(), time.Now())
 	}
 
@@ -259,7 +264,7 @@ func TestRestoreIsInputActive(t *testing.T) {
 	cfg := &config.Config{BackupDir: "."}
 	model := NewRestore(NewProgramContext(cfg, nil))
 
-	// Only phasePassword (phase 1) should be input active
+	// phasePassword (phase 1) and phaseRestoring (phase 3) are input active
 	const phasePassword = 1
 
 	// Test phase 0 - not input active
@@ -280,10 +285,10 @@ func TestRestoreIsInputActive(t *testing.T) {
 		t.Error("Phase 2 should not be input active (file list navigation)")
 	}
 
-	// Test phase 3 - restoring
+	// Test phase 3 - restoring keeps keys so Esc cancels the restore
 	model.phase = 3
-	if model.IsInputActive() {
-		t.Error("Phase 3 should not be input active (restoring)")
+	if !model.IsInputActive() {
+		t.Error("Phase 3 should be input active (restoring can be cancelled)")
 	}
 }
 
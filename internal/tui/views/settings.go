@@ -70,6 +70,8 @@ type SettingsModel struct {
 	status        string
 	errMsg        string
 	pathDescs     map[string]string
+	// preview lists what a glob being typed currently matches
+	preview string
 
 	inspecting  bool
 	inspectInfo string
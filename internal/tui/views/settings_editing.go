@@ -41,6 +41,7 @@ func (m SettingsModel) handleEditingSubItemInput(msg tea.KeyMsg) (tea.Model, tea
 		m.state = m.subEditParent
 		m.pathCompleter.Input.Blur()
 		m.pathCompleter.Input.SetValue("")
+		m.preview = ""
 		m.resizeLists()
 		return m, nil
 
@@ -52,23 +53,10 @@ func (m SettingsModel) handleEditingSubItemInput(msg tea.KeyMsg) (tea.Model, tea
 				m.errMsg = err.Error()
 				return m, nil
 			}
-			if m.subEditParent == stateBrowsingFiles {
-				m.ctx.Config.Files = append(m.ctx.Config.Files, results...)
-			} else {
-				m.ctx.Config.Folders = append(m.ctx.Config.Folders, results...)
-			}
-			m.status = fmt.Sprintf("Added %d paths from glob", len(results))
-			m.refreshPathList(pathListFiles)
-			m.refreshPathList(pathListFolders)
-			m.refreshMainList()
-			m.state = m.subEditParent
-			m.pathCompleter.Input.Blur()
-			m.pathCompleter.Input.SetValue("")
-			m.resizeLists()
-			m.loading = true
-			return m, m.scanPathDescs()
+			m.status = fmt.Sprintf("Saved pattern, currently matching %d paths", len(results))
 		}
 		m.saveFieldValue(value)
+		m.preview = ""
 		m.refreshPathList(pathListFiles)
 		m.refreshPathList(pathListFolders)
 		m.refreshMainList()
@@ -82,6 +70,7 @@ func (m SettingsModel) handleEditingSubItemInput(msg tea.KeyMsg) (tea.Model, tea
 	default:
 		var cmd tea.Cmd
 		m.pathCompleter, cmd = m.pathCompleter.Update(msg)
+		m.preview = globPreview(m.pathCompleter.Input.Value(), m.ctx.Config.Exclude)
 		return m, cmd
 	}
 }
@@ -117,38 +106,44 @@ func (m *SettingsModel) saveFieldValue(value string) {
 		if value == "" {
 			return
 		}
-		// Validate file path
-		expandedPath, err := ValidateFilePath(value)
-		if err != nil {
-			m.errMsg = err.Error()
-			m.status = ""
-			return
+		// Globs are stored as typed; other paths must name a file
+		entry := value
+		if !pathutil.IsGlobPattern(value) {
+			expandedPath, err := ValidateFilePath(value)
+			if err != nil {
+				m.errMsg = err.Error()
+				m.status = ""
+				return
+			}
+			entry = configEntry(value, expandedPath)
 		}
-		// Clear error and save expanded path
 		m.errMsg = ""
 		if m.subEditIndex < len(m.ctx.Config.Files) {
-			m.ctx.Config.Files[m.subEditIndex] = expandedPath
+			m.ctx.Config.Files[m.subEditIndex] = entry
 		} else {
-			m.ctx.Config.Files = append(m.ctx.Config.Files, expandedPath)
+			m.ctx.Config.Files = append(m.ctx.Config.Files, entry)
 		}
 	} else if m.state == stateEditingSubItem && m.subEditParent == stateBrowsingFolders {
 		// Check if empty
 		if value == "" {
 			return
 		}
-		// Validate folder path
-		expandedPath, err := ValidateFolderPath(value)
-		if err != nil {
-			m.errMsg = err.Error()
-			m.status = ""
-			return
+		// Globs are stored as typed; other paths must name a folder
+		entry := value
+		if !pathutil.IsGlobPattern(value) {
+			expandedPath, err := ValidateFolderPath(value)
+			if err != nil {
+				m.errMsg = err.Error()
+				m.status = ""
+				return
+			}
+			entry = configEntry(value, expandedPath)
 		}
-		// Clear error and save expanded path
 		m.errMsg = ""
 		if m.subEditIndex < len(m.ctx.Config.Folders) {
-			m.ctx.Config.Folders[m.subEditIndex] = expandedPath
+			m.ctx.Config.Folders[m.subEditIndex] = entry
 		} else {
-			m.ctx.Config.Folders = append(m.ctx.Config.Folders, expandedPath)
+			m.ctx.Config.Folders = append(m.ctx.Config.Folders, entry)
 		}
 	} else {
 		switch m.editingFieldIndex {
@@ -253,7 +253,7 @@ func (m *SettingsModel) saveConfig() {
 }
 
 func getInspectInfo(path string) string {
-	expanded := pathutil.ExpandHome(path)
+	expanded := pathutil.ExpandPath(path)
 	info, err := os.Stat(expanded)
 	if err != nil {
 		return fmt.Sprintf("Path: %s\nStatus: NOT FOUND", path)
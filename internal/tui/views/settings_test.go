@@ -1,6 +1,9 @@
 package views
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -201,3 +204,27 @@ func TestSettingsInspect(t *testing.T) {
 		t.Fatal("expected inspecting to be false after dismiss")
 	}
 }
+
+func TestSettingsEditSubItem_GlobKeptAsPattern(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.json", "b.json"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := testSettingsConfig()
+	model := NewSettings(NewProgramContext(cfg, nil))
+	model.startEditingSubItem(stateBrowsingFiles, len(cfg.Files), "")
+
+	pattern := filepath.Join(tmpDir, "*.json")
+	model.pathCompleter.Input.SetValue(pattern[:len(pattern)-1])
+	model = sendKey(t, model, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	if !strings.Contains(model.preview, "Currently matches 2 paths") {
+		t.Errorf("preview = %q, want the current matches", model.preview)
+	}
+
+	model = sendKey(t, model, tea.KeyMsg{Type: tea.KeyEnter})
+	if got := cfg.Files[len(cfg.Files)-1]; got != pattern || len(cfg.Files) != 3 {
+		t.Errorf("Files = %v, want the pattern %s appended", cfg.Files, pattern)
+	}
+}
@@ -42,8 +42,11 @@ func (m SettingsModel) View() string {
 			listView = m.foldersList.View()
 		}
 		b.WriteString(st.Subtitle.Render(title) + "\n")
-		b.WriteString("Value: " + m.pathCompleter.View() + "\n\n")
-		b.WriteString(listView)
+		b.WriteString("Value: " + m.pathCompleter.View() + "\n")
+		if m.preview != "" {
+			b.WriteString(st.Hint.Render(m.preview) + "\n")
+		}
+		b.WriteString("\n" + listView)
 	case stateFilePickerActive:
 		title := "Browse Files"
 		if m.filePickerParent == stateBrowsingFolders {
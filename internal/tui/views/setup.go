@@ -61,6 +61,8 @@ type SetupModel struct {
 	addedFolders  []string
 	err           error
 	validationErr string
+	// preview lists what a glob being typed currently matches
+	preview string
 }
 
 // NewSetup creates a new setup wizard model
@@ -204,6 +206,9 @@ func (m SetupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.step == StepBackupDir || m.step == StepGitRemote || m.step == StepAddFiles || m.step == StepAddFolders {
 			var cmd tea.Cmd
 			m.pathCompleter, cmd = m.pathCompleter.Update(msg)
+			if m.step == StepAddFiles || m.step == StepAddFolders {
+				m.preview = globPreview(m.pathCompleter.Input.Value(), nil)
+			}
 			return m, cmd
 		}
 	}
@@ -303,18 +308,19 @@ func (m SetupModel) handleAddFilesEnter() (tea.Model, tea.Cmd) {
 		if err != nil {
 			m.validationErr = err.Error()
 		} else {
-			m.validationErr = fmt.Sprintf("Added %d paths from glob", len(results))
-			m.addedFiles = append(m.addedFiles, results...)
+			m.validationErr = fmt.Sprintf("Added pattern, currently matching %d paths", len(results))
+			m.addedFiles = append(m.addedFiles, value)
 			m.pathCompleter.Input.SetValue("")
+			m.preview = ""
 		}
 		return m, nil
 	}
-	expandedPath, err := ValidateFilePath(pathutil.ExpandHome(value))
+	expandedPath, err := ValidateFilePath(value)
 	if err != nil {
 		m.validationErr = err.Error()
 	} else {
 		m.validationErr = ""
-		m.addedFiles = append(m.addedFiles, expandedPath)
+		m.addedFiles = append(m.addedFiles, configEntry(value, expandedPath))
 		m.pathCompleter.Input.SetValue("")
 	}
 	return m, nil
@@ -333,18 +339,19 @@ func (m SetupModel) handleAddFoldersEnter() (tea.Model, tea.Cmd) {
 		if err != nil {
 			m.validationErr = err.Error()
 		} else {
-			m.validationErr = fmt.Sprintf("Added %d paths from glob", len(results))
-			m.addedFolders = append(m.addedFolders, results...)
+			m.validationErr = fmt.Sprintf("Added pattern, currently matching %d paths", len(results))
+			m.addedFolders = append(m.addedFolders, value)
 			m.pathCompleter.Input.SetValue("")
+			m.preview = ""
 		}
 		return m, nil
 	}
-	expandedPath, err := ValidateFolderPath(pathutil.ExpandHome(value))
+	expandedPath, err := ValidateFolderPath(value)
 	if err != nil {
 		m.validationErr = err.Error()
 	} else {
 		m.validationErr = ""
-		m.addedFolders = append(m.addedFolders, expandedPath)
+		m.addedFolders = append(m.addedFolders, configEntry(value, expandedPath))
 		m.pathCompleter.Input.SetValue("")
 	}
 	return m, nil
@@ -354,6 +361,7 @@ func (m SetupModel) handleAddFoldersEnter() (tea.Model, tea.Cmd) {
 func (m *SetupModel) resetInput() {
 	m.pathCompleter.Input.SetValue("")
 	m.pathCompleter.Input.Blur()
+	m.preview = ""
 }
 
 // applyResize updates all dimension-dependent fields from a terminal resize.
@@ -467,6 +475,9 @@ func (m SetupModel) View() string {
 			}
 
 			s.WriteString(m.pathCompleter.View() + "\n")
+			if m.preview != "" {
+				s.WriteString(st.Hint.Render(m.preview) + "\n")
+			}
 			helpText = "Enter: add/continue | b: Browse | Esc: back"
 		}
 
@@ -498,6 +509,9 @@ func (m SetupModel) View() string {
 			}
 
 			s.WriteString(m.pathCompleter.View() + "\n")
+			if m.preview != "" {
+				s.WriteString(st.Hint.Render(m.preview) + "\n")
+			}
 			helpText = "Enter: add/continue | b: Browse | Esc: back"
 		}
 
@@ -3,6 +3,7 @@ package views
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -231,3 +232,28 @@ func TestSetupBrowseMode(t *testing.T) {
 		t.Fatal("expected browsing to be false after Esc")
 	}
 }
+
+func TestSetupAddFiles_GlobKeptAsPattern(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.conf"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	model := navigateToAddFiles(NewSetup(NewProgramContext(nil, nil)))
+
+	pattern := filepath.Join(tmpDir, "*.conf")
+	model.pathCompleter.Input.SetValue(pattern[:len(pattern)-1])
+	m, _ := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'f'}})
+	model = m.(SetupModel)
+	if !strings.Contains(model.preview, "Currently matches 1 path:") {
+		t.Errorf("preview = %q, want the current matches", model.preview)
+	}
+
+	m, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	model = m.(SetupModel)
+	if len(model.addedFiles) != 1 || model.addedFiles[0] != pattern {
+		t.Errorf("addedFiles = %v, want the pattern %s", model.addedFiles, pattern)
+	}
+	if model.preview != "" {
+		t.Errorf("preview should be cleared after adding, got %q", model.preview)
+	}
+}